@@ -2,13 +2,16 @@ package gui
 
 import (
 	"strconv"
+	"strings"
 	"time"
 	"github.com/ElectricNoodle/prometheus-midi-generator/graph"
 	"github.com/ElectricNoodle/prometheus-midi-generator/fractals"
 	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+	"github.com/ElectricNoodle/prometheus-midi-generator/midiinput"
 	"github.com/ElectricNoodle/prometheus-midi-generator/midioutput"
 	"github.com/ElectricNoodle/prometheus-midi-generator/processor"
 	"github.com/ElectricNoodle/prometheus-midi-generator/prometheus"
+	"github.com/ElectricNoodle/prometheus-midi-generator/smf"
 
 	"github.com/go-gl/gl/v3.2-core/gl"
 	"github.com/inkyblackness/imgui-go/v4"
@@ -60,6 +63,20 @@ var logText = ""
 var autoScroll = true
 var consoleEnabled = false
 var midiDevicesPos int32
+var tuningPos int32
+var recordFile = "session.mid"
+
+/*routeRow The text fields of a route being edited in the front end. */
+type routeRow struct {
+	port     string
+	channels string
+	remap    string
+}
+
+var routeRows []routeRow
+
+var learnControlPos int32
+var learnValue string
 
 var prometheusPollRatePos int32
 var prometheusPollRate = 4000
@@ -70,31 +87,79 @@ var prometheusModePos int32
 var prometheusMode = prometheus.Live
 var prometheusModes = []string{"Live", "Playback"}
 
+var velocityMetric = ""
+
+/* One week in seconds, how far back the anomaly baseline is taken from. */
+const baselineOffset = 7 * 24 * 60 * 60
+
 var prometheusStartDate = "2022-06-20 00:00"
 var prometheusEndDate = "2022-06-20 23:59"
 
 var bpmStr string
+var tempoFollowPos int32
+var tempoRamp int32
+var tempoLogFile = "tempo.csv"
+
 var processorKeysPos int32
 var processorModePos int32
+var processorVoicingPos int32
+var processorProgressionPos int32
+
+var velocityModePos int32
+var velocityMode string
+var fixedVelocity int32 = 100
+var minVelocity int32 = 40
+var maxVelocity int32 = 110
+
+var arpPatternPos int32
+var arpRatePos int32 = 1
+var arpOctaves int32 = 1
+var arpGate int32 = 50
+
+var melodyRhythmPos int32
+var chordRhythmPos int32
+
+var drumsEnabled bool
+var drumPatternPos int32
+var kickModePos int32
+
+/*trackRange Holds the GUI state of a track's pitch range. */
+type trackRange struct {
+	label       string
+	track       processor.Track
+	low         int32
+	high        int32
+	octavePos   int32
+	strategyPos int32
+}
+
+var trackRanges = []*trackRange{{label: "Melody", track: processor.MelodyTrack}, {label: "Chord", track: processor.ChordTrack}}
+
+var melodyModePos int32
+var markovMIDIFile = ""
+var seedStr string
 
-var processorGenMode = "Single Note"
-var processorGenModes = []string{"Single Note", "Major Chords", "Minor Chords", "Asc Major", "Asc Minor"}
+var anomaliesEnabled bool
+
+var modulationNames []string
+var modulationsEnabled []bool
+
+var ruleNames []string
+var rulesEnabled []bool
 
 var processorGenerationTypePos int32
-var processorGenerationType = "Chromatic"
-var processorGenerationTypes = []string{"Modulus(Ch1)", "ModulusPlus(Ch1)", "ModulusChords(Ch1)", "ModulusPlusChords(Ch1)", "Binary Arp(Ch1)", "Modulus(Ch1) + BinaryArp(Ch2)", "ModulusPlus(Ch1) + BinaryArp(Ch2)"}
 
 //used for windows
 var open = true
 
 /*Run Main GUI Loop that handles rendering of interface and at some point fractals... */
-func Run(p Platform, r Renderer, logIn *logging.Logger, scraper *prometheus.Scraper, procInfo *processor.ProcInfo, midiEmitter *midioutput.MIDIEmitter, fractalRenderer *fractals.FractalRenderer, graphRenderer *graph.GraphRenderer) {
+func Run(p Platform, r Renderer, logIn *logging.Logger, scraper *prometheus.Scraper, velocityScraper *prometheus.Scraper, baselineScraper *prometheus.Scraper, procInfo *processor.ProcInfo, midiEmitter *midioutput.MIDIEmitter, midiInput *midiinput.MIDIInput, fractalRenderer *fractals.FractalRenderer, graphRenderer *graph.GraphRenderer) {
 
 	imgui.CurrentIO().SetClipboard(clipboard{platform: p})
 
 	log = logIn
 	go loggingThread(log)
-	bpmStr = "60"
+	initializeSelections(procInfo)
 
 	currentTime := time.Now()
 	startTime := currentTime.Add(-time.Hour * 24)
@@ -115,6 +180,11 @@ func Run(p Platform, r Renderer, logIn *logging.Logger, scraper *prometheus.Scra
 		p.NewFrame()
 		imgui.NewFrame()
 
+		/* Settings changed from a MIDI controller are shown in the processor controls too. */
+		if midiInput.Changed() {
+			initializeSelections(procInfo)
+		}
+
 		if consoleEnabled {
 			renderConsoleWindow()
 		}
@@ -135,7 +205,15 @@ func Run(p Platform, r Renderer, logIn *logging.Logger, scraper *prometheus.Scra
 			imgui.Separator()
 
 			if imgui.CollapsingHeader("MIDI Options") {
-				renderMIDIOptions(midiEmitter)
+				renderMIDIOptions(midiEmitter, procInfo)
+			}
+
+			if imgui.CollapsingHeader("MIDI Routing") {
+				renderRoutingOptions(midiEmitter)
+			}
+
+			if imgui.CollapsingHeader("MIDI Input") {
+				renderMIDIInputOptions(midiInput)
 			}
 
 			if imgui.CollapsingHeader("Prometheus Options") {
@@ -143,7 +221,7 @@ func Run(p Platform, r Renderer, logIn *logging.Logger, scraper *prometheus.Scra
 			}
 			if imgui.CollapsingHeader("Processor Options") {
 				//	if imgui.CollapsingHeader("Processor1") {
-				renderProcessorOptions(procInfo)
+				renderProcessorOptions(procInfo, scraper)
 				//	}
 				//	if imgui.Button("+") {
 				//				}
@@ -153,7 +231,7 @@ func Run(p Platform, r Renderer, logIn *logging.Logger, scraper *prometheus.Scra
 				//}
 			}
 
-			renderStartStopButtons(scraper, procInfo)
+			renderStartStopButtons(scraper, velocityScraper, baselineScraper, procInfo, midiEmitter)
 
 			imgui.End()
 		}
@@ -174,6 +252,43 @@ func Run(p Platform, r Renderer, logIn *logging.Logger, scraper *prometheus.Scra
 	}
 }
 
+/*initializeSelections Makes the processor controls start with the key, scale, BPM and modes the processor was configured with. */
+func initializeSelections(procInfo *processor.ProcInfo) {
+
+	settings := procInfo.GetSettings()
+
+	bpmStr = strconv.Itoa(settings.BPM)
+	processorKeysPos = int32(settings.Key)
+	processorModePos = indexOf(procInfo.GetModeNames(), settings.Scale)
+	processorGenerationTypePos = indexOf(procInfo.GetGenerationModes(), settings.ChordMode)
+	processorVoicingPos = indexOf(procInfo.GetVoicingModes(), settings.Voicing)
+	velocityModePos = indexOf(procInfo.GetVelocityModes(), settings.VelocityMode)
+	velocityMode = settings.VelocityMode
+	melodyModePos = indexOf(procInfo.GetMelodyModes(), settings.MelodyMode)
+	seedStr = strconv.FormatInt(settings.Seed, 10)
+	anomaliesEnabled = settings.Anomalies
+	tempoFollowPos = indexOf(procInfo.GetTempoFollows(), settings.TempoFollow)
+	tempoRamp = int32(settings.TempoRamp)
+
+	for i, r := range settings.Ranges {
+		trackRanges[i].low = int32(r.Low)
+		trackRanges[i].high = int32(r.High)
+		trackRanges[i].octavePos = indexOf(procInfo.GetOctaveModes(), r.Octave)
+		trackRanges[i].strategyPos = indexOf(procInfo.GetRangeStrategies(), r.Strategy)
+	}
+}
+
+func indexOf(values []string, value string) int32 {
+
+	for i, v := range values {
+		if v == value {
+			return int32(i)
+		}
+	}
+
+	return 0
+}
+
 func loggingThread(log *logging.Logger) {
 	for {
 
@@ -203,10 +318,13 @@ func renderConsoleWindow() {
 	imgui.End()
 }
 
-func renderMIDIOptions(midiEmitter *midioutput.MIDIEmitter) {
+func renderMIDIOptions(midiEmitter *midioutput.MIDIEmitter, procInfo *processor.ProcInfo) {
 
 	imgui.Text("MIDI Configuration:")
 	imgui.Text("\t")
+
+	device, status := midiEmitter.GetDeviceStatus()
+	imgui.Text("Device: " + device + " (" + status + ")")
 	imgui.Text("Select Device: ")
 
 	if imgui.ListBoxV("", &midiDevicesPos, midiEmitter.GetDeviceNames(), 2) {
@@ -216,9 +334,182 @@ func renderMIDIOptions(midiEmitter *midioutput.MIDIEmitter) {
 	}
 
 	imgui.Text("\t")
+	imgui.Text("Tuning: ")
+
+	if imgui.ListBoxV("                 ", &tuningPos, midiEmitter.GetTuningNames(), 2) {
+
+		tuningName := midiEmitter.GetTuningNames()[tuningPos]
+		midiEmitter.Control <- midioutput.ControlMessage{Type: midioutput.SetTuning, Value: tuningName}
+
+		/* Each tuning has a scale of the same name stepping through all of its degrees, switch to it so the two line up. */
+		for i, mode := range procInfo.GetModeNames() {
+			if mode == tuningName {
+				processorModePos = int32(i)
+				procInfo.Control <- processor.ControlMessage{Type: processor.SetMode, ValueNum: 0, ValueString: mode}
+			}
+		}
+	}
+
+	imgui.Text("\t")
+	imgui.Text("Record To MIDI File: ")
+	imgui.InputText("                           ", &recordFile)
+	imgui.SameLine()
+
+	if !midiEmitter.IsRecording() {
+
+		if imgui.Button("Record") && recordFile != "" {
+			midiEmitter.StartRecording()
+		}
+
+	} else if imgui.Button("Stop Recording") {
+		exportRecording(midiEmitter.StopRecording(), procInfo)
+	}
+
+	imgui.Text("\t")
 
 }
 
+/*renderRoutingOptions displays the status of every port and an editor for the routing table. */
+func renderRoutingOptions(midiEmitter *midioutput.MIDIEmitter) {
+
+	if routeRows == nil {
+
+		for _, route := range midiEmitter.GetRouting() {
+			channels, remap := midioutput.FormatRoute(route)
+			routeRows = append(routeRows, routeRow{port: route.Port, channels: channels, remap: remap})
+		}
+	}
+
+	imgui.Text("Ports:")
+
+	names, statuses := midiEmitter.GetPortStatus()
+
+	for i, name := range names {
+		imgui.Text("\t" + name + ": " + statuses[i])
+	}
+
+	imgui.Text("\t")
+	imgui.Text("Routes (leave the port empty for the selected device, channels empty for all of them, remap as 10:1):")
+	imgui.Text("OSC destinations are ports named osc://host:port.")
+
+	for i := 0; i < len(routeRows); i++ {
+
+		id := "##route" + strconv.Itoa(i)
+
+		imgui.InputText("Port"+id, &routeRows[i].port)
+		imgui.InputText("Channels"+id, &routeRows[i].channels)
+		imgui.InputText("Remap"+id, &routeRows[i].remap)
+
+		if imgui.Button("Remove Route" + id) {
+			routeRows = append(routeRows[:i], routeRows[i+1:]...)
+			i--
+		}
+
+		imgui.Separator()
+	}
+
+	if imgui.Button("Add Route") {
+		routeRows = append(routeRows, routeRow{})
+	}
+
+	imgui.SameLine()
+
+	if imgui.Button("Apply Routes") {
+		applyRoutes(midiEmitter)
+	}
+
+	imgui.Text("\t")
+}
+
+/*applyRoutes Parses the routes being edited and replaces the routing table with them, nothing changes if any are invalid. */
+func applyRoutes(midiEmitter *midioutput.MIDIEmitter) {
+
+	routing := midioutput.Routing{}
+
+	for i, row := range routeRows {
+
+		route, err := midioutput.ParseRoute(row.port, row.channels, row.remap)
+
+		if err != nil {
+			log.Printf("Route %d invalid: %v\n", i+1, err)
+			return
+		}
+
+		routing = append(routing, route)
+	}
+
+	if err := midiEmitter.SetRouting(routing); err != nil {
+		log.Printf("Routing invalid: %v\n", err)
+	}
+}
+
+/*renderMIDIInputOptions displays the input ports, the bindings and MIDI learn, which binds the next note or controller to the chosen control. */
+func renderMIDIInputOptions(midiInput *midiinput.MIDIInput) {
+
+	imgui.Text("Input Ports:")
+
+	names, statuses := midiInput.GetPortStatus()
+
+	if len(names) == 0 {
+		imgui.Text("\tNone, add them to midi_input in the config.")
+	}
+
+	for i, name := range names {
+		imgui.Text("\t" + name + ": " + statuses[i])
+	}
+
+	imgui.Text("\t")
+	imgui.Text("Bindings:")
+
+	for i, binding := range midiInput.GetBindings() {
+
+		imgui.Text("\t" + binding)
+		imgui.SameLine()
+
+		if imgui.Button("Remove##binding" + strconv.Itoa(i)) {
+			midiInput.Control <- midiinput.ControlMessage{Type: midiinput.RemoveBinding, Index: i}
+		}
+	}
+
+	imgui.Text("\t")
+	imgui.Text("MIDI Learn (pick a control, press Learn, then play a note or move a controller):")
+
+	controls := midiInput.GetControlNames()
+	imgui.ListBoxV("                            ", &learnControlPos, controls, 3)
+	imgui.InputText("Value (what a pad sets, optional)", &learnValue)
+
+	if learning, control := midiInput.IsLearning(); learning {
+
+		imgui.Text("Waiting for a note or controller to bind to " + control + "...")
+
+		if imgui.Button("Cancel Learn") {
+			midiInput.Control <- midiinput.ControlMessage{Type: midiinput.StopLearning}
+		}
+
+	} else if imgui.Button("Learn") {
+		midiInput.Control <- midiinput.ControlMessage{Type: midiinput.StartLearning, Control: controls[learnControlPos], Value: learnValue}
+	}
+
+	imgui.Text("\t")
+}
+
+/*exportRecording Writes a recording to the record file with the tempo map, key and track names from the processor. */
+func exportRecording(recording *midioutput.Recording, procInfo *processor.ProcInfo) {
+
+	if recording == nil {
+		return
+	}
+
+	song := recording.Song(metric, procInfo.GetTempoMap(recording.Start), procInfo.GetKeySignature(), procInfo.GetTrackNames())
+
+	if err := smf.WriteFile(recordFile, song); err != nil {
+		log.Printf("Failed to export recording (%v)\n", err)
+		return
+	}
+
+	log.Printf("Exported recording to %s\n", recordFile)
+}
+
 func renderPrometheusOptions(scraper *prometheus.Scraper) {
 
 	imgui.Text("Prometheus Configuration:")
@@ -266,7 +557,7 @@ func parseDateString(dateString string) float64 {
 }
 
 /*renderProcessorOptions displays all the configurable options for sound generation. */
-func renderProcessorOptions(procInfo *processor.ProcInfo) {
+func renderProcessorOptions(procInfo *processor.ProcInfo, scraper *prometheus.Scraper) {
 
 	imgui.Text("\t")
 	imgui.InputText("         ", &bpmStr)
@@ -282,6 +573,9 @@ func renderProcessorOptions(procInfo *processor.ProcInfo) {
 		}
 
 	}
+
+	renderTempoOptions(procInfo)
+
 	imgui.Text("\t")
 
 	imgui.Text("Mode:")
@@ -293,6 +587,53 @@ func renderProcessorOptions(procInfo *processor.ProcInfo) {
 
 	}
 
+	imgui.Text("\t")
+	imgui.Text("Progression:")
+
+	if imgui.ListBoxV("       ", &processorProgressionPos, procInfo.GetProgressionNames(), 3) {
+
+		message := processor.ControlMessage{Type: processor.SetProgression, ValueNum: 0, ValueString: procInfo.GetProgressionNames()[processorProgressionPos]}
+		procInfo.Control <- message
+
+	}
+
+	imgui.Text("\t")
+	imgui.Text("Voicing:")
+
+	if imgui.ListBoxV("      ", &processorVoicingPos, procInfo.GetVoicingModes(), 3) {
+
+		message := processor.ControlMessage{Type: processor.SetVoicing, ValueNum: 0, ValueString: procInfo.GetVoicingModes()[processorVoicingPos]}
+		procInfo.Control <- message
+
+	}
+
+	imgui.Text("\t")
+	renderMelodyOptions(procInfo, scraper)
+
+	imgui.Text("\t")
+	renderVelocityOptions(procInfo)
+
+	imgui.Text("\t")
+	renderArpeggiatorOptions(procInfo)
+
+	imgui.Text("\t")
+	renderRhythmOptions(procInfo)
+
+	imgui.Text("\t")
+	renderRangeOptions(procInfo)
+
+	imgui.Text("\t")
+	renderDrumOptions(procInfo)
+
+	imgui.Text("\t")
+	renderModulationOptions(procInfo)
+
+	imgui.Text("\t")
+	renderAnomalyOptions(procInfo)
+
+	imgui.Text("\t")
+	renderRuleOptions(procInfo)
+
 	imgui.Text("\t")
 	imgui.Text("Key:")
 
@@ -313,25 +654,355 @@ func renderProcessorOptions(procInfo *processor.ProcInfo) {
 
 	}
 	imgui.Text("\t")
-	/*
-		imgui.Text("Type of Generation:")
-		if imgui.ListBoxV("    ", &processorGenerationTypePos, processorGenerationTypes, -1) {
-			processorGenerationType = processorGenerationTypes[processorModePos]
+}
+
+/*renderTempoOptions displays what the tempo follows, the length of tempo ramps and saving of the tempo log. */
+func renderTempoOptions(procInfo *processor.ProcInfo) {
+
+	imgui.Text("Tempo Follows:")
+
+	if imgui.ListBoxV("                         ", &tempoFollowPos, procInfo.GetTempoFollows(), 3) {
+
+		message := processor.ControlMessage{Type: processor.SetTempoFollow, ValueNum: 0, ValueString: procInfo.GetTempoFollows()[tempoFollowPos]}
+		procInfo.Control <- message
+
+	}
+
+	if imgui.SliderInt("Ramp (beats)", &tempoRamp, 0, 32) {
+
+		message := processor.ControlMessage{Type: processor.SetTempoRamp, ValueNum: int(tempoRamp), ValueString: ""}
+		procInfo.Control <- message
+
+	}
+
+	imgui.InputText("                          ", &tempoLogFile)
+	imgui.SameLine()
+
+	if imgui.Button("Save Tempo Log") && tempoLogFile != "" {
+
+		message := processor.ControlMessage{Type: processor.SaveTempoLog, ValueNum: 0, ValueString: tempoLogFile}
+		procInfo.Control <- message
+
+	}
+}
+
+/*renderMelodyOptions displays the melody mode, training of the Markov melody and the random seed. */
+func renderMelodyOptions(procInfo *processor.ProcInfo, scraper *prometheus.Scraper) {
+
+	imgui.Text("Melody:")
+
+	if imgui.ListBoxV("                      ", &melodyModePos, procInfo.GetMelodyModes(), 2) {
+
+		message := processor.ControlMessage{Type: processor.SetMelodyMode, ValueNum: 0, ValueString: procInfo.GetMelodyModes()[melodyModePos]}
+		procInfo.Control <- message
+
+	}
+
+	/* Fetching the history can take a while, so it is done off the render thread. */
+	if imgui.Button("Train From History") {
+
+		queryInfo := prometheus.QueryInfo{Query: metric, Start: parseDateString(prometheusStartDate), End: parseDateString(prometheusEndDate), Step: 600}
+
+		go func() {
+			values := scraper.History(queryInfo)
+			procInfo.Control <- processor.ControlMessage{Type: processor.TrainMarkov, ValueNum: 0, ValueString: "", Values: values}
+		}()
+	}
+
+	imgui.Text("MIDI File:")
+	imgui.InputText("                       ", &markovMIDIFile)
+	imgui.SameLine()
+
+	if imgui.Button("Train From MIDI") && markovMIDIFile != "" {
+
+		message := processor.ControlMessage{Type: processor.TrainMarkovMIDI, ValueNum: 0, ValueString: markovMIDIFile}
+		procInfo.Control <- message
+
+	}
+
+	imgui.Text("Seed (0 = random):")
+	imgui.InputText("                        ", &seedStr)
+	imgui.SameLine()
+
+	if imgui.Button("Set Seed") {
+		seed, err := strconv.ParseInt(seedStr, 10, 64)
+		if err != nil {
+			log.Printf("Invalid seed: (%v)\n", seedStr)
+		} else {
+			message := processor.ControlMessage{Type: processor.SetSeed, ValueNum: int(seed), ValueString: ""}
+			procInfo.Control <- message
+		}
+	}
+}
+
+/*renderVelocityOptions displays the velocity mode, the velocity range and the metric used by the second metric mode. */
+func renderVelocityOptions(procInfo *processor.ProcInfo) {
+
+	imgui.Text("Velocity:")
+
+	if imgui.ListBoxV("           ", &velocityModePos, procInfo.GetVelocityModes(), 3) {
+
+		velocityMode = procInfo.GetVelocityModes()[velocityModePos]
+		message := processor.ControlMessage{Type: processor.SetVelocityMode, ValueNum: 0, ValueString: velocityMode}
+		procInfo.Control <- message
+
+	}
+
+	if velocityMode == "Fixed" {
+		if imgui.SliderInt("Fixed Velocity", &fixedVelocity, 1, 127) {
+			procInfo.Control <- processor.ControlMessage{Type: processor.SetVelocity, ValueNum: int(fixedVelocity), ValueString: ""}
+		}
+	}
+
+	if velocityMode == "Second Metric" {
+		imgui.Text("Velocity Metric:")
+		imgui.InputText("            ", &velocityMetric)
+	}
+
+	if imgui.SliderInt("Min Velocity", &minVelocity, 1, 127) {
+		procInfo.Control <- processor.ControlMessage{Type: processor.SetMinVelocity, ValueNum: int(minVelocity), ValueString: ""}
+	}
+
+	if imgui.SliderInt("Max Velocity", &maxVelocity, 1, 127) {
+		procInfo.Control <- processor.ControlMessage{Type: processor.SetMaxVelocity, ValueNum: int(maxVelocity), ValueString: ""}
+	}
+}
+
+/*renderArpeggiatorOptions displays the arpeggiator pattern, rate, octave range and gate length. */
+func renderArpeggiatorOptions(procInfo *processor.ProcInfo) {
+
+	imgui.Text("Arpeggiator:")
+
+	if imgui.ListBoxV("        ", &arpPatternPos, procInfo.GetArpPatterns(), 3) {
+
+		message := processor.ControlMessage{Type: processor.SetArpPattern, ValueNum: 0, ValueString: procInfo.GetArpPatterns()[arpPatternPos]}
+		procInfo.Control <- message
+
+	}
+
+	imgui.Text("Rate:")
+
+	if imgui.ListBoxV("          ", &arpRatePos, procInfo.GetArpRates(), 3) {
+
+		message := processor.ControlMessage{Type: processor.SetArpRate, ValueNum: 0, ValueString: procInfo.GetArpRates()[arpRatePos]}
+		procInfo.Control <- message
+
+	}
+
+	if imgui.SliderInt("Octaves", &arpOctaves, 1, 4) {
+
+		message := processor.ControlMessage{Type: processor.SetArpOctaves, ValueNum: int(arpOctaves), ValueString: ""}
+		procInfo.Control <- message
+
+	}
+
+	if imgui.SliderInt("Gate %", &arpGate, 1, 100) {
+
+		message := processor.ControlMessage{Type: processor.SetArpGate, ValueNum: int(arpGate), ValueString: ""}
+		procInfo.Control <- message
+
+	}
+}
+
+/*renderRhythmOptions displays the rhythm mode of the melody and chord tracks. */
+func renderRhythmOptions(procInfo *processor.ProcInfo) {
+
+	imgui.Text("Melody Rhythm:")
+
+	if imgui.ListBoxV("             ", &melodyRhythmPos, procInfo.GetRhythmModes(), 3) {
+
+		message := processor.ControlMessage{Type: processor.SetRhythmMode, ValueNum: 0, ValueString: procInfo.GetRhythmModes()[melodyRhythmPos], Track: processor.MelodyTrack}
+		procInfo.Control <- message
+
+	}
+
+	imgui.Text("Chord Rhythm:")
+
+	if imgui.ListBoxV("              ", &chordRhythmPos, procInfo.GetRhythmModes(), 3) {
+
+		message := processor.ControlMessage{Type: processor.SetRhythmMode, ValueNum: 0, ValueString: procInfo.GetRhythmModes()[chordRhythmPos], Track: processor.ChordTrack}
+		procInfo.Control <- message
+
+	}
+}
+
+/*renderRangeOptions displays the pitch range, octave mode and range strategy of the melody and chord tracks. */
+func renderRangeOptions(procInfo *processor.ProcInfo) {
+
+	for _, r := range trackRanges {
+
+		imgui.Text(r.label + " Range:")
+
+		if imgui.SliderInt(r.label+" Low", &r.low, 0, 127) {
+			procInfo.Control <- processor.ControlMessage{Type: processor.SetLowNote, ValueNum: int(r.low), ValueString: "", Track: r.track}
+		}
+
+		if imgui.SliderInt(r.label+" High", &r.high, 0, 127) {
+			procInfo.Control <- processor.ControlMessage{Type: processor.SetHighNote, ValueNum: int(r.high), ValueString: "", Track: r.track}
+		}
+
+		/* Whitespace labels have to be unique, so each track gets its own width. */
+		padding := strings.Repeat(" ", 18+2*int(r.track))
+
+		imgui.Text("Octave:")
+
+		if imgui.ListBoxV(padding, &r.octavePos, procInfo.GetOctaveModes(), 3) {
+
+			message := processor.ControlMessage{Type: processor.SetOctaveMode, ValueNum: 0, ValueString: procInfo.GetOctaveModes()[r.octavePos], Track: r.track}
+			procInfo.Control <- message
+
+		}
+
+		imgui.Text("Out Of Range:")
+
+		if imgui.ListBoxV(padding+" ", &r.strategyPos, procInfo.GetRangeStrategies(), 3) {
+
+			message := processor.ControlMessage{Type: processor.SetRangeStrategy, ValueNum: 0, ValueString: procInfo.GetRangeStrategies()[r.strategyPos], Track: r.track}
+			procInfo.Control <- message
+
+		}
+	}
+}
+
+/*renderDrumOptions displays the drum track toggle, pattern and kick mode. */
+func renderDrumOptions(procInfo *processor.ProcInfo) {
+
+	if imgui.Checkbox("Drums (Ch10)", &drumsEnabled) {
+
+		enabled := 0
+
+		if drumsEnabled {
+			enabled = 1
+		}
+
+		procInfo.Control <- processor.ControlMessage{Type: processor.SetDrums, ValueNum: enabled, ValueString: ""}
+	}
+
+	imgui.Text("Drum Pattern:")
+
+	if imgui.ListBoxV("               ", &drumPatternPos, procInfo.GetDrumPatterns(), 3) {
+
+		message := processor.ControlMessage{Type: processor.SetDrumPattern, ValueNum: 0, ValueString: procInfo.GetDrumPatterns()[drumPatternPos]}
+		procInfo.Control <- message
+
+	}
+
+	imgui.Text("Kick:")
+
+	if imgui.ListBoxV("                ", &kickModePos, procInfo.GetKickModes(), 3) {
+
+		message := processor.ControlMessage{Type: processor.SetKickMode, ValueNum: 0, ValueString: procInfo.GetKickModes()[kickModePos]}
+		procInfo.Control <- message
+
+	}
+}
+
+/*renderModulationOptions displays a toggle for each of the metric to controller mappings in the config. */
+func renderModulationOptions(procInfo *processor.ProcInfo) {
+
+	if modulationNames == nil {
+		modulationNames, modulationsEnabled = procInfo.GetModulations()
+	}
+
+	if len(modulationNames) == 0 {
+		return
+	}
+
+	imgui.Text("Modulation:")
+
+	for i, name := range modulationNames {
+
+		if imgui.Checkbox(name, &modulationsEnabled[i]) {
+
+			enabled := 0
+
+			if modulationsEnabled[i] {
+				enabled = 1
+			}
+
+			procInfo.Control <- processor.ControlMessage{Type: processor.SetModulation, ValueNum: enabled, ValueString: name}
+		}
+	}
+}
+
+/*renderRuleOptions displays a toggle for each of the rules in the config. */
+func renderRuleOptions(procInfo *processor.ProcInfo) {
+
+	if ruleNames == nil {
+		ruleNames, rulesEnabled = procInfo.GetRules()
+	}
+
+	if len(ruleNames) == 0 {
+		return
+	}
+
+	imgui.Text("Rules:")
+
+	for i, name := range ruleNames {
+
+		/* The ## suffix keeps the checkbox IDs apart from modulations with the same name. */
+		if imgui.Checkbox(name+"##rule", &rulesEnabled[i]) {
+
+			enabled := 0
+
+			if rulesEnabled[i] {
+				enabled = 1
+			}
+
+			procInfo.Control <- processor.ControlMessage{Type: processor.SetRule, ValueNum: enabled, ValueString: name}
+		}
+	}
+}
+
+/*renderAnomalyOptions displays a toggle for the musical responses to anomalies. */
+func renderAnomalyOptions(procInfo *processor.ProcInfo) {
+
+	if imgui.Checkbox("Anomaly Responses", &anomaliesEnabled) {
+
+		enabled := 0
+
+		if anomaliesEnabled {
+			enabled = 1
 		}
-	*/
+
+		procInfo.Control <- processor.ControlMessage{Type: processor.SetAnomalies, ValueNum: enabled, ValueString: ""}
+	}
 }
 
-func renderStartStopButtons(scraper *prometheus.Scraper, procInfo *processor.ProcInfo) {
+func renderStartStopButtons(scraper *prometheus.Scraper, velocityScraper *prometheus.Scraper, baselineScraper *prometheus.Scraper, procInfo *processor.ProcInfo, midiEmitter *midioutput.MIDIEmitter) {
 
 	imgui.Text("\t")
 
 	if imgui.Button("Start") {
 
 		queryInfo := prometheus.QueryInfo{Query: metric, Start: parseDateString(prometheusStartDate), End: parseDateString(prometheusEndDate), Step: 600}
+
+		/* Bindings drive each dimension from their own query, so the scraper runs those instead of the metric. */
+		if queries := procInfo.GetBindingQueries(); len(queries) > 0 {
+			queryInfo.Queries = queries
+		}
+
 		message := prometheus.ControlMessage{Type: prometheus.StartOutput, OutputType: prometheusMode, QueryInfo: queryInfo, Value: 0}
 
 		scraper.Control <- message
 
+		if velocityMode == "Second Metric" && velocityMetric != "" {
+
+			velocityQueryInfo := queryInfo
+			velocityQueryInfo.Query = velocityMetric
+			velocityQueryInfo.Queries = nil
+			velocityScraper.Control <- prometheus.ControlMessage{Type: prometheus.StartOutput, OutputType: prometheusMode, QueryInfo: velocityQueryInfo, Value: 0}
+		}
+
+		/* The seasonal anomaly detector compares against the same query a week earlier. */
+		if procInfo.UsesBaseline() {
+
+			baselineQueryInfo := queryInfo
+			baselineQueryInfo.Queries = nil
+			baselineQueryInfo.Offset = baselineOffset
+			baselineScraper.Control <- prometheus.ControlMessage{Type: prometheus.StartOutput, OutputType: prometheusMode, QueryInfo: baselineQueryInfo, Value: 0}
+		}
+
 		stopProcessor := processor.ControlMessage{Type: processor.StartProcessor, ValueNum: 0, ValueString: ""}
 		procInfo.Control <- stopProcessor
 
@@ -345,11 +1016,24 @@ func renderStartStopButtons(scraper *prometheus.Scraper, procInfo *processor.Pro
 
 		messageStop := prometheus.ControlMessage{Type: prometheus.StopOutput, OutputType: prometheus.Playback, QueryInfo: prometheus.QueryInfo{}, Value: 0}
 		scraper.Control <- messageStop
+		velocityScraper.Control <- messageStop
+		baselineScraper.Control <- messageStop
 
 		stopProcessor := processor.ControlMessage{Type: processor.StopProcessor, ValueNum: 0, ValueString: ""}
 		procInfo.Control <- stopProcessor
 
 	}
+
+	imgui.SameLine()
+	imgui.Text(" ")
+	imgui.SameLine()
+
+	if imgui.Button("Panic") {
+
+		procInfo.Control <- processor.ControlMessage{Type: processor.Panic, ValueNum: 0, ValueString: ""}
+		midiEmitter.Control <- midioutput.ControlMessage{Type: midioutput.Panic, Value: ""}
+
+	}
 }
 
 func renderFractal(displaySize [2]float32, framebufferSize [2]float32) {
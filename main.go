@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/ElectricNoodle/prometheus-midi-generator/fractals"
 	"github.com/ElectricNoodle/prometheus-midi-generator/graph"
@@ -11,34 +13,77 @@ import (
 	"github.com/ElectricNoodle/prometheus-midi-generator/gui/platforms"
 	"github.com/ElectricNoodle/prometheus-midi-generator/gui/renderers"
 	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+	"github.com/ElectricNoodle/prometheus-midi-generator/midiinput"
 	"github.com/ElectricNoodle/prometheus-midi-generator/midioutput"
 	"github.com/ElectricNoodle/prometheus-midi-generator/processor"
 	"github.com/ElectricNoodle/prometheus-midi-generator/prometheus"
+	"github.com/ElectricNoodle/prometheus-midi-generator/tuning"
 	"github.com/inkyblackness/imgui-go/v4"
 	"gopkg.in/yaml.v2"
 )
 
 type config struct {
-	PrometheusServer string           `yaml:"prometheus_server"`
-	ProcessorConfig  processor.Config `yaml:"processor_config"`
+	PrometheusServer string                `yaml:"prometheus_server"`
+	ProcessorConfig  processor.Config      `yaml:"processor_config"`
+	Tuning           tuning.Config         `yaml:"tuning"`
+	Routing          midioutput.Routing    `yaml:"routing"`
+	Sink             midioutput.SinkConfig `yaml:"sink"`
+	OSC              midioutput.OSCConfig  `yaml:"osc"`
+	MIDIInput        midiinput.Config      `yaml:"midi_input"`
 }
 
 var log *logging.Logger
 
 var configuration *config
+var tunings []*tuning.Tuning
 var scraper *prometheus.Scraper
+var velocityScraper *prometheus.Scraper
+var baselineScraper *prometheus.Scraper
 var metricProcessor *processor.ProcInfo
 var midiEmitter *midioutput.MIDIEmitter
+var midiInput *midiinput.MIDIInput
 var fractalRenderer *fractals.FractalRenderer
 var graphRenderer *graph.GraphRenderer
 
 func main() {
 
+	log = logging.NewLogger()
+
+	options := parseRenderOptions()
+
 	configuration = loadConfig("config/config.yml")
+	tunings = loadTunings(configuration)
+
+	if err := configuration.ProcessorConfig.Validate(); err != nil {
+		log.Fatalf("Configuration file invalid: %v\n", err)
+	}
+
+	if err := configuration.Routing.Validate(); err != nil {
+		log.Fatalf("Configuration file invalid: %v\n", err)
+	}
+
+	if err := configuration.Sink.Validate(); err != nil {
+		log.Fatalf("Configuration file invalid: %v\n", err)
+	}
+
+	if err := configuration.OSC.Validate(); err != nil {
+		log.Fatalf("Configuration file invalid: %v\n", err)
+	}
+
+	if err := configuration.MIDIInput.Validate(); err != nil {
+		log.Fatalf("Configuration file invalid: %v\n", err)
+	}
+
+	if options.rendering() {
+		renderOffline(options)
+		return
+	}
 
 	initializeBackend()
+	handleSignals()
 	initializeGUI()
 
+	shutdown()
 }
 
 func loadConfig(path string) *config {
@@ -59,33 +104,80 @@ func loadConfig(path string) *config {
 		log.Fatal("Configuration file invalid: No Prometheus server is defined.\n")
 	}
 
-	if len(conf.ProcessorConfig.Scales) < 1 {
-		log.Fatal("Processor configuration doesn't contain any scale definitions.\n")
+	return conf
+}
+
+/*loadTunings Reads the Scala files from the tuning config and adds a scale to the processor for each one, stepping through every degree of the tuning. */
+func loadTunings(conf *config) []*tuning.Tuning {
+
+	if err := conf.Tuning.Validate(); err != nil {
+		log.Fatalf("Configuration file invalid: %v\n", err)
 	}
 
-	for _, scale := range conf.ProcessorConfig.Scales {
-		if scale.Name == "" {
-			log.Fatal("Configuration file invalid: Scale defined without name.\n")
+	loaded, err := tuning.Load(conf.Tuning)
+
+	if err != nil {
+		log.Fatalf("Failed to load tuning: %v\n", err)
+	}
+
+	names := make(map[string]bool)
+
+	for _, t := range loaded {
+
+		names[t.Name] = true
+		intervals := make([]int, t.Degrees())
+
+		for i := range intervals {
+			intervals[i] = 1
 		}
-		if scale.Intervals == nil || len(scale.Intervals) < 1 {
-			log.Fatalf("Configuration file invalid: %s scale defined without any intervals.\n", scale.Name)
+
+		conf.ProcessorConfig.Scales = append(conf.ProcessorConfig.Scales, processor.Scale{Name: t.Name, Intervals: intervals, Tuning: t.Name})
+	}
+
+	for _, scale := range conf.ProcessorConfig.Scales {
+		if scale.Tuning != "" && !names[scale.Tuning] {
+			log.Fatalf("Configuration file invalid: %s scale uses unknown tuning %s.\n", scale.Name, scale.Tuning)
 		}
 	}
 
-	return conf
+	return loaded
 }
 
 func initializeBackend() {
 
-	log = logging.NewLogger()
-
 	scraper = prometheus.NewScraper(log, configuration.PrometheusServer, prometheus.Playback)
-	metricProcessor = processor.NewProcessor(log, configuration.ProcessorConfig, scraper.Output)
-	midiEmitter = midioutput.NewMidi(log, metricProcessor.Output)
+	velocityScraper = prometheus.NewScraper(log, configuration.PrometheusServer, prometheus.Playback)
+	baselineScraper = prometheus.NewScraper(log, configuration.PrometheusServer, prometheus.Playback)
+	metricProcessor = processor.NewProcessor(log, configuration.ProcessorConfig, scraper.Output, velocityScraper.Output, baselineScraper.Output, scraper.Frames)
+	midiEmitter = midioutput.NewMidi(log, metricProcessor.Output, configuration.Sink)
+	midiEmitter.ConfigureTuning(configuration.Tuning, tunings)
+	midiEmitter.ConfigureOSC(configuration.OSC)
+	midiEmitter.ConfigureRouting(configuration.Routing)
+	midiInput = midiinput.NewMIDIInput(log, configuration.MIDIInput, metricProcessor, scraper)
 	fractalRenderer = fractals.NewFractalRenderer(log)
 	graphRenderer = graph.NewGraphRenderer(log)
 }
 
+/*handleSignals Makes sure no notes are left hanging if the process is interrupted or killed. */
+func handleSignals() {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		shutdown()
+		os.Exit(0)
+	}()
+}
+
+/*shutdown Stops listening to MIDI input and turns off any sounding notes before exit. */
+func shutdown() {
+
+	midiInput.Close()
+	midiEmitter.Close()
+}
+
 func initializeGUI() {
 
 	context := imgui.CreateContext(nil)
@@ -112,5 +204,5 @@ func initializeGUI() {
 
 	defer renderer.Dispose()
 
-	gui.Run(platform, renderer, log, scraper, metricProcessor, midiEmitter, fractalRenderer, graphRenderer)
+	gui.Run(platform, renderer, log, scraper, velocityScraper, baselineScraper, metricProcessor, midiEmitter, midiInput, fractalRenderer, graphRenderer)
 }
@@ -0,0 +1,219 @@
+package midiinput
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+Config Defines the format of the midi_input config:
+ports		MIDI input ports to listen to, a port that isn't there yet is opened as soon as it is plugged in.
+bindings	File the bindings are loaded from, bindings learned in the front end are saved back to it
+(config/bindings.yml).
+*/
+type Config struct {
+	Ports    []string `yaml:"ports"`
+	Bindings string   `yaml:"bindings"`
+}
+
+/*
+Binding Defines the format of a binding, which has a note or a controller change a setting:
+channel		MIDI channel (1-16) the binding listens on, every channel when left out.
+note		Note that triggers the binding, a pad or a key.
+cc			Controller that triggers the binding, a knob or a fader. Set either note or cc.
+control		What the binding changes: key, scale, chord_mode, voicing, progression, bpm, output_rate, start, stop or panic.
+value		What a pad sets, a key, scale etc. or a number for bpm and output_rate. A pad without one steps to the next
+key, scale etc.
+min, max	Range a knob sweeps for bpm (40-200) and output_rate (100-2000 milliseconds).
+*/
+type Binding struct {
+	Channel int    `yaml:"channel,omitempty"`
+	Note    *int   `yaml:"note,omitempty"`
+	CC      *int   `yaml:"cc,omitempty"`
+	Control string `yaml:"control"`
+	Value   string `yaml:"value,omitempty"`
+	Min     int    `yaml:"min,omitempty"`
+	Max     int    `yaml:"max,omitempty"`
+}
+
+/*bindingsFile Defines the format of the bindings file. */
+type bindingsFile struct {
+	Bindings []Binding `yaml:"bindings"`
+}
+
+/* Written at the top of the bindings file, as saving replaces anything else in it. */
+const bindingsHeader = "# MIDI input bindings, see midiinput.Binding. Learning a binding in the front end rewrites this file.\n"
+
+const defaultBindingsFile = "config/bindings.yml"
+
+/*binding Parsed version of a binding, with the state needed to only send a change when the setting changes. */
+type binding struct {
+	config  Binding
+	control *control
+	number  int
+	last    int
+}
+
+/*Validate Checks the midi_input config, filling in the default bindings file. */
+func (config *Config) Validate() error {
+
+	if config.Bindings == "" {
+		config.Bindings = defaultBindingsFile
+	}
+
+	for i, port := range config.Ports {
+		if port == "" {
+			return fmt.Errorf("midi_input.ports[%d]: port without a name", i)
+		}
+	}
+
+	return nil
+}
+
+/*loadBindings Reads the bindings file, there are no bindings until one has been learned when it doesn't exist yet. */
+func loadBindings(path string) ([]Binding, error) {
+
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var file bindingsFile
+
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	return file.Bindings, nil
+}
+
+/*saveBindings Writes the bindings to the bindings file. */
+func saveBindings(path string, bindings []Binding) error {
+
+	data, err := yaml.Marshal(bindingsFile{Bindings: bindings})
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append([]byte(bindingsHeader), data...), 0644)
+}
+
+/*parseBinding Validates a binding, the options of list controls come from the processor. */
+func (input *MIDIInput) parseBinding(config Binding) (*binding, error) {
+
+	parsed := &binding{config: config, control: findControl(config.Control), last: -1}
+
+	if parsed.control == nil {
+		return nil, fmt.Errorf("unknown control %q, expected one of %v", config.Control, input.GetControlNames())
+	}
+
+	if config.Channel < 0 || config.Channel > 16 {
+		return nil, fmt.Errorf("channel %d must be between 1 and 16", config.Channel)
+	}
+
+	if (config.Note == nil) == (config.CC == nil) {
+		return nil, fmt.Errorf("set either a note or a cc")
+	}
+
+	if source := parsed.source(); source < 0 || source > 127 {
+		return nil, fmt.Errorf("note or cc %d must be between 0 and 127", source)
+	}
+
+	switch parsed.control.kind {
+
+	case listControl:
+
+		if config.Value != "" {
+
+			parsed.number = indexOf(parsed.control.options(input.procInfo), config.Value)
+
+			if parsed.number < 0 {
+				return nil, fmt.Errorf("%q is not a %s, expected one of %v", config.Value, config.Control, parsed.control.options(input.procInfo))
+			}
+		}
+
+	case numberControl:
+
+		if config.Min == 0 && config.Max == 0 {
+			parsed.config.Min, parsed.config.Max = parsed.control.low, parsed.control.high
+		}
+
+		if parsed.config.Min > parsed.config.Max {
+			return nil, fmt.Errorf("min %d is above max %d", parsed.config.Min, parsed.config.Max)
+		}
+
+		if config.Value != "" {
+
+			number, err := strconv.Atoi(config.Value)
+
+			if err != nil {
+				return nil, fmt.Errorf("%s value %q isn't a number", config.Control, config.Value)
+			}
+
+			parsed.number = number
+
+		} else if config.Note != nil {
+			return nil, fmt.Errorf("a note needs a value to set %s to", config.Control)
+		}
+	}
+
+	return parsed, nil
+}
+
+/*source Returns the note or controller number of the binding. */
+func (b *binding) source() int {
+
+	if b.config.Note != nil {
+		return *b.config.Note
+	}
+
+	return *b.config.CC
+}
+
+/*matches Returns true if the binding listens to the note or controller on the channel. */
+func (b *binding) matches(e event) bool {
+	return (b.config.Note != nil) == e.note && b.source() == e.number && (b.config.Channel == 0 || b.config.Channel == e.channel)
+}
+
+/*describe Returns a line describing the binding for the front end. */
+func (b *binding) describe() string {
+
+	source := "CC " + strconv.Itoa(b.source())
+
+	if b.config.Note != nil {
+		source = "Note " + strconv.Itoa(b.source())
+	}
+
+	if b.config.Channel != 0 {
+		source += " (channel " + strconv.Itoa(b.config.Channel) + ")"
+	}
+
+	target := b.config.Control
+
+	if b.config.Value != "" {
+		target += " = " + b.config.Value
+	}
+
+	return source + " -> " + target
+}
+
+func indexOf(values []string, value string) int {
+
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+
+	return -1
+}
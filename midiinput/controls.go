@@ -0,0 +1,113 @@
+package midiinput
+
+import (
+	"github.com/ElectricNoodle/prometheus-midi-generator/processor"
+	"github.com/ElectricNoodle/prometheus-midi-generator/prometheus"
+)
+
+/*controlKind How a control turns incoming notes and controller values into a setting. */
+type controlKind int
+
+/*
+List controls pick one of their options, a knob sweeps through them and a pad selects its value or steps to the
+next one. Number controls set a number in a range from a knob, or the value of a pad. Triggers fire on a pad, or a
+knob turned past half way.
+*/
+const (
+	listControl    controlKind = 0
+	numberControl  controlKind = 1
+	triggerControl controlKind = 2
+)
+
+/*control Something a binding can change, and how to send the change to the processor or scraper. */
+type control struct {
+	name    string
+	kind    controlKind
+	low     int
+	high    int
+	options func(procInfo *processor.ProcInfo) []string
+	current func(procInfo *processor.ProcInfo) string
+	send    func(input *MIDIInput, number int, option string)
+}
+
+/* Half way through the controller range, knobs fire triggers and pads when turned past it. */
+const controllerThreshold = 64
+
+/*controls Everything bindings can change, in the order they are listed in the front end. */
+var controls = []*control{
+	{name: "key", kind: listControl,
+		options: (*processor.ProcInfo).GetKeyNames,
+		current: func(procInfo *processor.ProcInfo) string {
+			return procInfo.GetKeyNames()[procInfo.GetSettings().Key]
+		},
+		send: func(input *MIDIInput, number int, option string) {
+			input.procInfo.Control <- processor.ControlMessage{Type: processor.SetKey, ValueNum: number}
+		}},
+	{name: "scale", kind: listControl,
+		options: (*processor.ProcInfo).GetModeNames,
+		current: func(procInfo *processor.ProcInfo) string { return procInfo.GetSettings().Scale },
+		send: func(input *MIDIInput, number int, option string) {
+			input.procInfo.Control <- processor.ControlMessage{Type: processor.SetMode, ValueString: option}
+		}},
+	{name: "chord_mode", kind: listControl,
+		options: (*processor.ProcInfo).GetGenerationModes,
+		current: func(procInfo *processor.ProcInfo) string { return procInfo.GetSettings().ChordMode },
+		send: func(input *MIDIInput, number int, option string) {
+			input.procInfo.Control <- processor.ControlMessage{Type: processor.SetChordMode, ValueString: option}
+		}},
+	{name: "voicing", kind: listControl,
+		options: (*processor.ProcInfo).GetVoicingModes,
+		current: func(procInfo *processor.ProcInfo) string { return procInfo.GetSettings().Voicing },
+		send: func(input *MIDIInput, number int, option string) {
+			input.procInfo.Control <- processor.ControlMessage{Type: processor.SetVoicing, ValueString: option}
+		}},
+	{name: "progression", kind: listControl,
+		options: (*processor.ProcInfo).GetProgressionNames,
+		send: func(input *MIDIInput, number int, option string) {
+			input.procInfo.Control <- processor.ControlMessage{Type: processor.SetProgression, ValueString: option}
+		}},
+	{name: "bpm", kind: numberControl, low: 40, high: 200,
+		send: func(input *MIDIInput, number int, option string) {
+			input.procInfo.Control <- processor.ControlMessage{Type: processor.SetBPM, ValueNum: number}
+		}},
+	{name: "output_rate", kind: numberControl, low: 100, high: 2000,
+		send: func(input *MIDIInput, number int, option string) {
+			input.scraper.Control <- prometheus.ControlMessage{Type: prometheus.ChangeOutputRate, Value: number}
+		}},
+	{name: "start", kind: triggerControl,
+		send: func(input *MIDIInput, number int, option string) {
+			input.procInfo.Control <- processor.ControlMessage{Type: processor.StartProcessor}
+		}},
+	{name: "stop", kind: triggerControl,
+		send: func(input *MIDIInput, number int, option string) {
+			input.procInfo.Control <- processor.ControlMessage{Type: processor.StopProcessor}
+		}},
+	{name: "panic", kind: triggerControl,
+		send: func(input *MIDIInput, number int, option string) {
+			input.procInfo.Control <- processor.ControlMessage{Type: processor.Panic}
+		}},
+}
+
+/*findControl Returns the control with a name, nil if there isn't one. */
+func findControl(name string) *control {
+
+	for _, c := range controls {
+		if c.name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+/*GetControlNames Returns the names of everything a binding can change, for the front end. */
+func (input *MIDIInput) GetControlNames() []string {
+
+	names := make([]string, len(controls))
+
+	for i, c := range controls {
+		names[i] = c.name
+	}
+
+	return names
+}
@@ -0,0 +1,481 @@
+package midiinput
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+	"github.com/ElectricNoodle/prometheus-midi-generator/processor"
+	"github.com/ElectricNoodle/prometheus-midi-generator/prometheus"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+var log *logging.Logger
+
+/*MessageType Defines the different types of Control Message. */
+type MessageType int
+
+/* Used to nicely assign values to message types */
+const (
+	StartLearning MessageType = 0
+	StopLearning  MessageType = 1
+	RemoveBinding MessageType = 2
+)
+
+/*ControlMessage Used for sending control messages to the MIDI input. Control and Value are what a learned binding changes, Index the binding to remove. */
+type ControlMessage struct {
+	Type    MessageType
+	Control string
+	Value   string
+	Index   int
+}
+
+/* MIDI status bytes the bindings respond to. */
+const (
+	noteOn        = 0x90
+	controlChange = 0xB0
+)
+
+/* How often the input ports are enumerated, to open a port when its device is plugged in. */
+const portPollInterval = 2 * time.Second
+
+/* Messages waiting to be handled, more than that are dropped rather than holding up the driver. */
+const inputQueueSize = 256
+
+/* Port status shown in the front end. */
+const (
+	portConnected    = "Connected"
+	portDisconnected = "Disconnected, waiting for it to come back"
+	portNotFound     = "Not found, waiting for it to be plugged in"
+	portFailed       = "Failed"
+)
+
+/*port An input port from the config, stop is set while it is being listened to. */
+type port struct {
+	name   string
+	status string
+	stop   func()
+}
+
+/*event A note on or controller change that bindings respond to, channels are 1-16. */
+type event struct {
+	channel int
+	note    bool
+	number  int
+	value   int
+}
+
+/*MIDIInput Listens to MIDI input ports and changes the processor and scraper settings the notes and controllers are bound to. */
+type MIDIInput struct {
+	Control  chan ControlMessage
+	input    chan midi.Message
+	done     chan struct{}
+	config   Config
+	procInfo *processor.ProcInfo
+	scraper  *prometheus.Scraper
+	ports    []*port
+	bindings []*binding
+	learning *Binding
+	changed  bool
+	lock     sync.Mutex
+}
+
+/*NewMIDIInput Loads the bindings, opens the input ports that are there and starts the input thread. */
+func NewMIDIInput(logIn *logging.Logger, config Config, procInfo *processor.ProcInfo, scraper *prometheus.Scraper) *MIDIInput {
+
+	log = logIn
+
+	input := &MIDIInput{Control: make(chan ControlMessage, 6), input: make(chan midi.Message, inputQueueSize), done: make(chan struct{}),
+		config: config, procInfo: procInfo, scraper: scraper}
+
+	bindings, err := loadBindings(config.Bindings)
+
+	if err != nil {
+		log.Printf("Failed to load MIDI bindings from %s: %v\n", config.Bindings, err)
+	}
+
+	for i, config := range bindings {
+
+		parsed, err := input.parseBinding(config)
+
+		if err != nil {
+			log.Printf("Skipping MIDI binding %d: %v\n", i+1, err)
+			continue
+		}
+
+		input.bindings = append(input.bindings, parsed)
+	}
+
+	for _, name := range config.Ports {
+		input.ports = append(input.ports, &port{name: name, status: portNotFound})
+	}
+
+	input.pollPorts()
+
+	go input.inputThread()
+
+	return input
+}
+
+/*inputThread Handles control messages and incoming MIDI, and keeps the input ports open. */
+func (input *MIDIInput) inputThread() {
+
+	ticker := time.NewTicker(portPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-input.Control:
+			input.handleControlMessage(message)
+		case message := <-input.input:
+			input.handleMessage(message)
+		case <-ticker.C:
+			input.pollPorts()
+		case <-input.done:
+			return
+		}
+	}
+}
+
+func (input *MIDIInput) handleControlMessage(message ControlMessage) {
+
+	input.lock.Lock()
+	defer input.lock.Unlock()
+
+	switch message.Type {
+
+	case StartLearning:
+
+		if findControl(message.Control) == nil {
+			log.Printf("Can't learn unknown control %s\n", message.Control)
+			return
+		}
+
+		input.learning = &Binding{Control: message.Control, Value: message.Value}
+		log.Printf("Learning %s, play a note or move a controller.\n", message.Control)
+
+	case StopLearning:
+		input.learning = nil
+
+	case RemoveBinding:
+
+		if message.Index < 0 || message.Index >= len(input.bindings) {
+			return
+		}
+
+		input.bindings = append(input.bindings[:message.Index], input.bindings[message.Index+1:]...)
+		input.save()
+	}
+}
+
+/*receive Queues a message from a port, called by the driver. */
+func (input *MIDIInput) receive(message midi.Message, timestampms int32) {
+
+	select {
+	case input.input <- message:
+	default:
+	}
+}
+
+/*parseEvent Returns the note on or controller change in a message, ok is false for anything else. */
+func parseEvent(message []byte) (event, bool) {
+
+	if len(message) < 3 {
+		return event{}, false
+	}
+
+	e := event{channel: int(message[0]&0x0F) + 1, number: int(message[1]), value: int(message[2])}
+
+	switch message[0] & 0xF0 {
+	case noteOn:
+		e.note = true
+		return e, e.value > 0
+	case controlChange:
+		return e, true
+	}
+
+	return event{}, false
+}
+
+/*handleMessage Learns a binding from the message when learning, otherwise applies every binding it matches. */
+func (input *MIDIInput) handleMessage(message midi.Message) {
+
+	e, ok := parseEvent(message)
+
+	if !ok {
+		return
+	}
+
+	input.lock.Lock()
+	defer input.lock.Unlock()
+
+	if input.learning != nil {
+		input.learn(e)
+		return
+	}
+
+	for _, b := range input.bindings {
+		if b.matches(e) {
+			input.apply(b, e)
+		}
+	}
+}
+
+/*learn Binds the note or controller to the control being learned, replacing whatever it was bound to before. */
+func (input *MIDIInput) learn(e event) {
+
+	config := *input.learning
+	config.Channel = e.channel
+	number := e.number
+
+	if e.note {
+		config.Note = &number
+	} else {
+		config.CC = &number
+	}
+
+	input.learning = nil
+	parsed, err := input.parseBinding(config)
+
+	if err != nil {
+		log.Printf("Failed to learn %s: %v\n", config.Control, err)
+		return
+	}
+
+	bindings := input.bindings[:0]
+
+	for _, b := range input.bindings {
+		if !b.matches(e) {
+			bindings = append(bindings, b)
+		}
+	}
+
+	input.bindings = append(bindings, parsed)
+	input.save()
+
+	log.Printf("Learned %s\n", parsed.describe())
+}
+
+/*save Writes the bindings to the bindings file, so they are there next time. */
+func (input *MIDIInput) save() {
+
+	configs := make([]Binding, len(input.bindings))
+
+	for i, b := range input.bindings {
+		configs[i] = b.config
+	}
+
+	if err := saveBindings(input.config.Bindings, configs); err != nil {
+		log.Printf("Failed to save MIDI bindings to %s: %v\n", input.config.Bindings, err)
+	}
+}
+
+/*apply Changes the setting of a binding from a note or controller value. */
+func (input *MIDIInput) apply(b *binding, e event) {
+
+	c := b.control
+
+	switch c.kind {
+
+	case triggerControl:
+
+		if b.pressed(e) {
+			input.send(b, 0, "")
+		}
+
+	case numberControl:
+
+		if b.config.Value != "" {
+
+			if b.pressed(e) {
+				input.send(b, b.number, "")
+			}
+
+			return
+		}
+
+		if number := b.config.Min + e.value*(b.config.Max-b.config.Min)/127; number != b.last {
+			b.last = number
+			input.send(b, number, "")
+		}
+
+	case listControl:
+
+		options := c.options(input.procInfo)
+
+		if len(options) == 0 {
+			return
+		}
+
+		switch {
+
+		case b.config.Value != "":
+
+			if b.pressed(e) {
+				input.send(b, b.number, options[b.number])
+			}
+
+		/* A knob sweeps through the options. */
+		case !e.note:
+
+			if index := e.value * len(options) / 128; index != b.last {
+				b.last = index
+				input.send(b, index, options[index])
+			}
+
+		/* A pad steps to the option after the current one. */
+		default:
+
+			current := b.last
+
+			if c.current != nil {
+				current = indexOf(options, c.current(input.procInfo))
+			}
+
+			b.last = (current + 1) % len(options)
+			input.send(b, b.last, options[b.last])
+		}
+	}
+}
+
+/*pressed Returns true for a note, or a controller that has just gone past half way, so a knob fires once per turn. */
+func (b *binding) pressed(e event) bool {
+
+	if e.note {
+		return true
+	}
+
+	fired := e.value >= controllerThreshold && b.last < controllerThreshold
+	b.last = e.value
+
+	return fired
+}
+
+func (input *MIDIInput) send(b *binding, number int, option string) {
+	b.control.send(input, number, option)
+	input.changed = true
+}
+
+/*pollPorts Opens the configured ports that have appeared and closes the ones whose device has gone. */
+func (input *MIDIInput) pollPorts() {
+
+	names := midi.InPorts()
+
+	input.lock.Lock()
+	defer input.lock.Unlock()
+
+	for _, p := range input.ports {
+
+		present := false
+
+		for _, name := range names {
+			if name == p.name {
+				present = true
+			}
+		}
+
+		if p.stop != nil && !present {
+			p.stop()
+			p.stop = nil
+			p.status = portDisconnected
+			log.Printf("MIDI input %s disconnected.\n", p.name)
+		}
+
+		if p.stop != nil || !present {
+			continue
+		}
+
+		stop, err := midi.ListenTo(midi.FindInPort(p.name), input.receive)
+
+		if err != nil {
+
+			if p.status != portFailed {
+				log.Printf("Failed to open MIDI input %s: %v\n", p.name, err)
+			}
+
+			p.status = portFailed
+			continue
+		}
+
+		p.stop = stop
+		p.status = portConnected
+		log.Printf("Listening to MIDI input %s\n", p.name)
+	}
+}
+
+/*Close Stops listening to the input ports. */
+func (input *MIDIInput) Close() {
+
+	input.lock.Lock()
+	defer input.lock.Unlock()
+
+	select {
+	case <-input.done:
+		return
+	default:
+		close(input.done)
+	}
+
+	for _, p := range input.ports {
+		if p.stop != nil {
+			p.stop()
+			p.stop = nil
+		}
+	}
+}
+
+/*GetPortStatus Returns the configured input ports and whether they are connected, for the front end. */
+func (input *MIDIInput) GetPortStatus() ([]string, []string) {
+
+	input.lock.Lock()
+	defer input.lock.Unlock()
+
+	names := make([]string, len(input.ports))
+	statuses := make([]string, len(input.ports))
+
+	for i, p := range input.ports {
+		names[i], statuses[i] = p.name, p.status
+	}
+
+	return names, statuses
+}
+
+/*GetBindings Returns a description of every binding, for the front end. */
+func (input *MIDIInput) GetBindings() []string {
+
+	input.lock.Lock()
+	defer input.lock.Unlock()
+
+	descriptions := make([]string, len(input.bindings))
+
+	for i, b := range input.bindings {
+		descriptions[i] = b.describe()
+	}
+
+	return descriptions
+}
+
+/*IsLearning Returns true and the control being learned while waiting for a note or controller to bind to it. */
+func (input *MIDIInput) IsLearning() (bool, string) {
+
+	input.lock.Lock()
+	defer input.lock.Unlock()
+
+	if input.learning == nil {
+		return false, ""
+	}
+
+	return true, input.learning.Control
+}
+
+/*Changed Returns true once after a binding has changed a setting, so the front end can show the new selections. */
+func (input *MIDIInput) Changed() bool {
+
+	input.lock.Lock()
+	defer input.lock.Unlock()
+
+	changed := input.changed
+	input.changed = false
+
+	return changed
+}
@@ -0,0 +1,232 @@
+package midiinput
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+	"github.com/ElectricNoodle/prometheus-midi-generator/processor"
+	"github.com/ElectricNoodle/prometheus-midi-generator/prometheus"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+/*newTestInput Returns an input with no ports or thread, bound to an offline processor and saving to a temporary file. */
+func newTestInput(t *testing.T, bindings ...Binding) *MIDIInput {
+
+	log = logging.NewLogger()
+
+	config := processor.Config{Scales: []processor.Scale{
+		{Name: "Ionian", Intervals: []int{2, 2, 1, 2, 2, 2, 1}},
+		{Name: "Dorian", Intervals: []int{2, 1, 2, 2, 2, 1, 2}},
+	}}
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	input := &MIDIInput{config: Config{Bindings: filepath.Join(t.TempDir(), "bindings.yml")},
+		procInfo: processor.NewOfflineProcessor(log, config),
+		scraper:  &prometheus.Scraper{Control: make(chan prometheus.ControlMessage, 6)}}
+
+	for _, config := range bindings {
+
+		parsed, err := input.parseBinding(config)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		input.bindings = append(input.bindings, parsed)
+	}
+
+	return input
+}
+
+/*received Drains and returns the control messages the bindings sent to the processor and the scraper. */
+func received(input *MIDIInput) ([]processor.ControlMessage, []prometheus.ControlMessage) {
+
+	var processorMessages []processor.ControlMessage
+	var scraperMessages []prometheus.ControlMessage
+
+	for {
+		select {
+		case message := <-input.procInfo.Control:
+			processorMessages = append(processorMessages, message)
+		case message := <-input.scraper.Control:
+			scraperMessages = append(scraperMessages, message)
+		default:
+			return processorMessages, scraperMessages
+		}
+	}
+}
+
+/*number Returns a pointer to n, for the note and cc of a binding. */
+func number(n int) *int {
+	return &n
+}
+
+func TestParseEvent(t *testing.T) {
+
+	tests := []struct {
+		message  []byte
+		expected event
+		ok       bool
+	}{
+		{[]byte{0x90, 60, 100}, event{channel: 1, note: true, number: 60, value: 100}, true},
+		{[]byte{0x99, 36, 1}, event{channel: 10, note: true, number: 36, value: 1}, true},
+		{[]byte{0xB1, 21, 0}, event{channel: 2, number: 21, value: 0}, true},
+		{[]byte{0x90, 60, 0}, event{channel: 1, note: true, number: 60}, false},
+		{[]byte{0x80, 60, 64}, event{}, false},
+		{[]byte{0xE0, 0, 64}, event{}, false},
+		{[]byte{0xB0, 21}, event{}, false},
+	}
+
+	for _, test := range tests {
+		if e, ok := parseEvent(test.message); ok != test.ok || (ok && e != test.expected) {
+			t.Errorf("parseEvent(% x) got %+v, %v, want %+v, %v", test.message, e, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestParseBinding(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		binding Binding
+		valid   bool
+	}{
+		{"knob", Binding{CC: number(21), Control: "bpm"}, true},
+		{"pad", Binding{Channel: 10, Note: number(36), Control: "scale", Value: "Dorian"}, true},
+		{"trigger", Binding{Note: number(37), Control: "panic"}, true},
+		{"unknown control", Binding{CC: number(21), Control: "volume"}, false},
+		{"channel", Binding{Channel: 17, CC: number(21), Control: "bpm"}, false},
+		{"note and cc", Binding{Note: number(36), CC: number(21), Control: "bpm"}, false},
+		{"neither", Binding{Control: "bpm"}, false},
+		{"out of range", Binding{CC: number(128), Control: "bpm"}, false},
+		{"unknown option", Binding{Note: number(36), Control: "scale", Value: "Lydian"}, false},
+		{"range", Binding{CC: number(21), Control: "bpm", Min: 180, Max: 60}, false},
+		{"not a number", Binding{Note: number(36), Control: "bpm", Value: "fast"}, false},
+		{"pad without a number", Binding{Note: number(36), Control: "bpm"}, false},
+	}
+
+	input := newTestInput(t)
+
+	for _, test := range tests {
+		if _, err := input.parseBinding(test.binding); (err == nil) != test.valid {
+			t.Errorf("%s: got error %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestBindingDispatch(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		binding   Binding
+		messages  []midi.Message
+		processor []processor.ControlMessage
+		scraper   []prometheus.ControlMessage
+	}{
+		{"knob sets a number in its range, once per change", Binding{CC: number(21), Control: "bpm", Min: 60, Max: 180},
+			[]midi.Message{{0xB0, 21, 127}, {0xB0, 21, 127}, {0xB0, 21, 0}, {0xB0, 22, 64}},
+			[]processor.ControlMessage{{Type: processor.SetBPM, ValueNum: 180}, {Type: processor.SetBPM, ValueNum: 60}}, nil},
+		{"knob defaults to the control's range", Binding{CC: number(21), Control: "output_rate"},
+			[]midi.Message{{0xB3, 21, 127}},
+			nil, []prometheus.ControlMessage{{Type: prometheus.ChangeOutputRate, Value: 2000}}},
+		{"pad sets its value on its channel", Binding{Channel: 10, Note: number(36), Control: "scale", Value: "Dorian"},
+			[]midi.Message{{0x99, 36, 100}, {0x90, 36, 100}, {0x99, 36, 0}, {0x89, 36, 0}},
+			[]processor.ControlMessage{{Type: processor.SetMode, ValueString: "Dorian"}}, nil},
+		{"pad without a value steps to the next option", Binding{Note: number(36), Control: "key"},
+			[]midi.Message{{0x90, 36, 100}},
+			[]processor.ControlMessage{{Type: processor.SetKey, ValueNum: 1}}, nil},
+		{"knob sweeps through the options", Binding{CC: number(1), Control: "key"},
+			[]midi.Message{{0xB0, 1, 0}, {0xB0, 1, 5}, {0xB0, 1, 127}},
+			[]processor.ControlMessage{{Type: processor.SetKey, ValueNum: 0}, {Type: processor.SetKey, ValueNum: 11}}, nil},
+		{"knob fires a trigger once per turn past half way", Binding{CC: number(64), Control: "panic"},
+			[]midi.Message{{0xB0, 64, 10}, {0xB0, 64, 100}, {0xB0, 64, 127}, {0xB0, 64, 0}, {0xB0, 64, 64}},
+			[]processor.ControlMessage{{Type: processor.Panic}, {Type: processor.Panic}}, nil},
+	}
+
+	for _, test := range tests {
+
+		input := newTestInput(t, test.binding)
+
+		for _, message := range test.messages {
+			input.handleMessage(message)
+		}
+
+		processorMessages, scraperMessages := received(input)
+
+		if !reflect.DeepEqual(processorMessages, test.processor) || !reflect.DeepEqual(scraperMessages, test.scraper) {
+			t.Errorf("%s: got %+v and %+v, want %+v and %+v", test.name, processorMessages, scraperMessages, test.processor, test.scraper)
+		}
+
+		if changed := input.Changed(); changed != (len(test.processor)+len(test.scraper) > 0) {
+			t.Errorf("%s: got changed %v", test.name, changed)
+		}
+	}
+}
+
+func TestLearn(t *testing.T) {
+
+	input := newTestInput(t)
+
+	input.handleControlMessage(ControlMessage{Type: StartLearning, Control: "bpm"})
+
+	if learning, control := input.IsLearning(); !learning || control != "bpm" {
+		t.Fatalf("got learning %v %q, want bpm", learning, control)
+	}
+
+	/* The message that is learned doesn't change the setting, the ones after it do. */
+	input.handleMessage(midi.Message{0xB1, 21, 127})
+
+	if processorMessages, _ := received(input); len(processorMessages) != 0 {
+		t.Errorf("learning sent %+v", processorMessages)
+	}
+
+	if learning, _ := input.IsLearning(); learning {
+		t.Error("still learning after a controller was moved")
+	}
+
+	input.handleMessage(midi.Message{0xB1, 21, 0})
+
+	if processorMessages, _ := received(input); !reflect.DeepEqual(processorMessages, []processor.ControlMessage{{Type: processor.SetBPM, ValueNum: 40}}) {
+		t.Errorf("got %+v from the learned binding", processorMessages)
+	}
+
+	/* Learning the same controller again replaces its binding, and the bindings are saved each time. */
+	input.handleControlMessage(ControlMessage{Type: StartLearning, Control: "panic"})
+	input.handleMessage(midi.Message{0xB1, 21, 127})
+
+	if bindings := input.GetBindings(); !reflect.DeepEqual(bindings, []string{"CC 21 (channel 2) -> panic"}) {
+		t.Errorf("got bindings %v", bindings)
+	}
+
+	saved, err := loadBindings(input.config.Bindings)
+
+	if err != nil || !reflect.DeepEqual(saved, []Binding{{Channel: 2, CC: number(21), Control: "panic"}}) {
+		t.Errorf("got saved bindings %+v, %v", saved, err)
+	}
+
+	/* A note can't be learned for a number without a value for it to set, so nothing is bound. */
+	input.handleControlMessage(ControlMessage{Type: StartLearning, Control: "bpm"})
+	input.handleMessage(midi.Message{0x90, 60, 100})
+
+	if learning, _ := input.IsLearning(); learning || len(input.GetBindings()) != 1 {
+		t.Errorf("got learning %v with bindings %v", learning, input.GetBindings())
+	}
+
+	/* Unknown controls aren't learned. */
+	input.handleControlMessage(ControlMessage{Type: StartLearning, Control: "volume"})
+
+	if learning, _ := input.IsLearning(); learning {
+		t.Error("learning an unknown control")
+	}
+
+	input.handleControlMessage(ControlMessage{Type: RemoveBinding, Index: 0})
+
+	if saved, err := loadBindings(input.config.Bindings); err != nil || len(saved) != 0 || len(input.GetBindings()) != 0 {
+		t.Errorf("got %v and saved %+v, %v after removing the binding", input.GetBindings(), saved, err)
+	}
+}
@@ -0,0 +1,134 @@
+package midioutput
+
+import (
+	"time"
+)
+
+/* How often the output ports are enumerated, to notice a device being unplugged or coming back. */
+const devicePollInterval = 2 * time.Second
+
+/* Device status shown in the front end. */
+const (
+	deviceConnected    = "Connected"
+	deviceDisconnected = "Disconnected, waiting for it to come back"
+	deviceNotFound     = "Not found, waiting for it to be plugged in"
+	deviceFailed       = "Failed"
+)
+
+/* Listed in place of the devices when there are none, so it can't be selected. */
+const noDevices = "No devices found."
+
+/*GetDeviceNames returns an array of midi device names, as of the last time the ports were enumerated. */
+func (midiEmitter *MIDIEmitter) GetDeviceNames() []string {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	if len(midiEmitter.deviceNames) < 1 {
+		return []string{noDevices}
+	}
+
+	return midiEmitter.deviceNames
+}
+
+/*GetDeviceStatus Returns the selected device and whether it is connected, for the front end. */
+func (midiEmitter *MIDIEmitter) GetDeviceStatus() (string, string) {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	return midiEmitter.selectedMIDIDevice, midiEmitter.selected.getStatus()
+}
+
+/*setDevice Switches the selected output to the named device, the notes sounding are turned off before the old one is closed. */
+func (midiEmitter *MIDIEmitter) setDevice(name string) {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	if _, connected, _ := midiEmitter.selected.state(); name == noDevices || (name == midiEmitter.selectedMIDIDevice && connected) {
+		return
+	}
+
+	midiEmitter.silence()
+
+	midiEmitter.selectedMIDIDevice = name
+	log.Printf("Midi Device set to %v\n", name)
+
+	midiEmitter.selected.request(outputRequest{open: name})
+
+	/* Routes naming the new device now share the selected output, and those naming the old one need their own. */
+	midiEmitter.setRouting(midiEmitter.routing)
+	midiEmitter.resendTuning()
+}
+
+/*
+pollDevices Enumerates the output ports. A port whose device has gone missing, or that failed to take a message, is
+closed, and it is reopened as soon as its device shows up again.
+*/
+func (midiEmitter *MIDIEmitter) pollDevices() {
+
+	names := midiEmitter.driver.devices()
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	midiEmitter.deviceNames = names
+
+	if midiEmitter.closed {
+		return
+	}
+
+	reopened := false
+
+	for _, out := range midiEmitter.outputList() {
+
+		device, connected, failed := out.state()
+		present := out.driver.present(device)
+
+		if connected && (!present || failed) {
+			log.Printf("Midi Device %s disconnected.\n", device)
+			out.request(outputRequest{close: true})
+			connected = false
+		}
+
+		if !connected && present {
+			out.request(outputRequest{open: device})
+			reopened = true
+		}
+	}
+
+	/* A device that has been plugged back in has lost its tuning setup. */
+	if reopened {
+		midiEmitter.resendTuning()
+	}
+}
+
+/*Sink Returns the sink a device's port is writing to, nil if it isn't open. An empty name is the selected device. */
+func (midiEmitter *MIDIEmitter) Sink(device string) Sink {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	if midiEmitter.selected == nil {
+		return nil
+	}
+
+	if device == "" || device == midiEmitter.selectedMIDIDevice {
+		return midiEmitter.selected.getSink()
+	}
+
+	if out, exists := midiEmitter.outputs[device]; exists {
+		return out.getSink()
+	}
+
+	return nil
+}
+
+/*resendTuning Sends the MPE setup of the active tuning again, after a port has been opened. Called with the lock held. */
+func (midiEmitter *MIDIEmitter) resendTuning() {
+
+	if midiEmitter.tuning != nil {
+		midiEmitter.setTuning(midiEmitter.tuning.Name)
+	}
+}
@@ -0,0 +1,160 @@
+package midioutput
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+/*
+FileSink Writes MIDI messages to a text file instead of a device, one line per message: the time it was sent at in
+seconds followed by its bytes in hex, e.g. "12.500000 90 3c 64". The time is set by whoever drives the emitter, which
+for offline rendering is the virtual clock. As a configured sink the time is since the file was opened.
+*/
+type FileSink struct {
+	file   *os.File
+	writer *bufio.Writer
+	time   time.Duration
+	lock   sync.Mutex
+}
+
+/*NewFileSink Creates (or truncates) the file messages are written to. */
+func NewFileSink(path string) (*FileSink, error) {
+
+	file, err := os.Create(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+/*SetTime Sets the time the following messages are written with. */
+func (sink *FileSink) SetTime(at time.Duration) {
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	sink.time = at
+}
+
+/*Send Writes a message to the file, it has the same signature as the send functions of the MIDI drivers. */
+func (sink *FileSink) Send(message midi.Message) error {
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	return sink.write(message)
+}
+
+/*sendAt Sets the time and writes a message in one go, for ports sharing the file. */
+func (sink *FileSink) sendAt(at time.Duration, message midi.Message) error {
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	sink.time = at
+
+	return sink.write(message)
+}
+
+func (sink *FileSink) write(message midi.Message) error {
+
+	if _, err := fmt.Fprintf(sink.writer, "%.6f", sink.time.Seconds()); err != nil {
+		return err
+	}
+
+	for _, b := range message {
+		if _, err := fmt.Fprintf(sink.writer, " %02x", b); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintln(sink.writer)
+
+	return err
+}
+
+/*Close Flushes anything left to the file and closes it. */
+func (sink *FileSink) Close() error {
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	if err := sink.writer.Flush(); err != nil {
+		sink.file.Close()
+		return err
+	}
+
+	return sink.file.Close()
+}
+
+/*fileDeviceSink A port writing to the file shared by every port, closing it leaves the file open for the others. */
+type fileDeviceSink struct {
+	sink  *FileSink
+	start time.Time
+}
+
+func (device fileDeviceSink) Send(message midi.Message) error {
+	return device.sink.sendAt(time.Since(device.start), message)
+}
+
+func (device fileDeviceSink) Close() error {
+	return nil
+}
+
+/*fileDriver Opens the file on the first port, every port then writes to it until the emitter is closed. */
+type fileDriver struct {
+	path  string
+	sink  *FileSink
+	start time.Time
+	lock  sync.Mutex
+}
+
+func (driver *fileDriver) open(device string) (Sink, error) {
+
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+
+	if driver.sink == nil {
+
+		sink, err := NewFileSink(driver.path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		driver.sink = sink
+		driver.start = time.Now()
+	}
+
+	return fileDeviceSink{sink: driver.sink, start: driver.start}, nil
+}
+
+func (driver *fileDriver) present(device string) bool {
+	return true
+}
+
+func (driver *fileDriver) devices() []string {
+	return []string{driver.path}
+}
+
+func (driver *fileDriver) close() error {
+
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+
+	if driver.sink == nil {
+		return nil
+	}
+
+	err := driver.sink.Close()
+	driver.sink = nil
+
+	return err
+}
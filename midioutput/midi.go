@@ -1,9 +1,12 @@
 package midioutput
 
 import (
+	"sync"
+	"time"
+
 	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+	"github.com/ElectricNoodle/prometheus-midi-generator/tuning"
 	"gitlab.com/gomidi/midi/v2"
-	_ "gitlab.com/gomidi/midi/v2/drivers/portmididrv" // autoregisters driver
 )
 
 var log *logging.Logger
@@ -40,24 +43,58 @@ const (
 	Channel8  MIDIValue = 0x07
 	Channel9  MIDIValue = 0x08
 	Channel10 MIDIValue = 0x09
-	Channel11 MIDIValue = 0x10
-	Channel12 MIDIValue = 0x11
-	Channel13 MIDIValue = 0x12
-	Channel14 MIDIValue = 0x13
-	Channel15 MIDIValue = 0x14
-	Channel16 MIDIValue = 0x15
-
-	NoteOn  MIDIValue = 0x90
-	NoteOff MIDIValue = 0x80
+	Channel11 MIDIValue = 0x0A
+	Channel12 MIDIValue = 0x0B
+	Channel13 MIDIValue = 0x0C
+	Channel14 MIDIValue = 0x0D
+	Channel15 MIDIValue = 0x0E
+	Channel16 MIDIValue = 0x0F
+
+	NoteOn            MIDIValue = 0x90
+	NoteOff           MIDIValue = 0x80
+	PolyAftertouch    MIDIValue = 0xA0
+	ControlChange     MIDIValue = 0xB0
+	ChannelAftertouch MIDIValue = 0xD0
+	PitchBend         MIDIValue = 0xE0
+
+	/* Not a MIDI message, carries the raw metric value to the sinks that take it such as OSC. */
+	MetricValue MIDIValue = 0x100
+)
+
+const numChannels = 16
+
+/* Channel mode controller numbers used when silencing the device. */
+const (
+	allSoundOff = 120
+	allNotesOff = 123
 )
 
-/*MIDIMessage Hold all of the information required to build a MIDI message, recieved from processor.go*/
+/*MIDIMessage Hold all of the information required to build a MIDI message, recieved from processor.go. Controller and Value are used by the parameter messages, pitch bend values are -8192 to 8191. Metric is used by MetricValue. */
 type MIDIMessage struct {
-	Channel  MIDIValue
-	Type     MIDIValue
-	Note     int
-	Octave   int
-	Velocity int64
+	Channel    MIDIValue
+	Type       MIDIValue
+	Note       int
+	Octave     int
+	Velocity   int64
+	Controller int
+	Value      int
+	Metric     float64
+}
+
+/*key Returns the MIDI note number of a message, or false if its octave and note are outside of the MIDI range. */
+func (message MIDIMessage) key() (uint8, bool) {
+
+	if message.Octave < 0 || message.Octave >= len(octaveOffsets) {
+		return 0, false
+	}
+
+	key := int(octaveOffsets[message.Octave]) + message.Note
+
+	if key < 0 || key > 127 {
+		return 0, false
+	}
+
+	return uint8(key), true
 }
 
 /*MessageType Defines type of control message.*/
@@ -66,6 +103,21 @@ type MessageType int
 /*const values for message types */
 const (
 	SetDevice MessageType = 0
+	Panic     MessageType = 1
+	SetTuning MessageType = 2
+)
+
+/* Name of the tuning that turns microtonal playback off. */
+const equalTemperament = "12-TET"
+
+/* Registered parameter numbers used to set up MPE. */
+const (
+	rpnMSB       = 101
+	rpnLSB       = 100
+	dataEntryMSB = 6
+	dataEntryLSB = 38
+	rpnBendRange = 0
+	rpnMPEConfig = 6
 )
 
 /*ControlMessage Used to store information on control messages recieved. */
@@ -74,6 +126,12 @@ type ControlMessage struct {
 	Value string
 }
 
+/*activeNote Identifies a sounding note by the exact channel and MIDI note number it was sent with. */
+type activeNote struct {
+	channel uint8
+	note    uint8
+}
+
 /*MIDIEmitter Holds relevant info needed to recieve input/emit midi messages. */
 type MIDIEmitter struct {
 	Control            chan ControlMessage
@@ -81,15 +139,43 @@ type MIDIEmitter struct {
 	selectedMIDIDevice string
 	deviceCount        int
 	midiOutput         int
+	deviceNames        []string
+	selected           *output
+	outputs            map[string]*output
+	routes             []*route
+	routing            Routing
+	driver             sinkDriver
+	oscConfig          OSCConfig
+	sendMessage        func(midi.Message) error
+	activeNotes        map[activeNote]int
+	tuningConfig       tuning.Config
+	tunings            []*tuning.Tuning
+	tuning             *tuning.Tuning
+	tunedChannels      map[uint8]bool
+	nextMember         int
+	voices             map[activeNote][]activeNote
+	closed             bool
+	recording          *Recording
+	now                func() time.Time
+	offlineTime        time.Duration
+	lock               sync.Mutex
 }
 
 var maxDevices = 10
 
-/*NewMidi Returns a new instance of midi struct, and inits midi connection. */
-func NewMidi(logIn *logging.Logger, inputChannel <-chan MIDIMessage) *MIDIEmitter {
+/* Device opened at startup, until one is picked in the front end. */
+const defaultDevice = "USB Midi"
+
+/*NewMidi Returns a new instance of midi struct, and inits midi connection. The sink config decides what the output ports send to. */
+func NewMidi(logIn *logging.Logger, inputChannel <-chan MIDIMessage, sinkConfig SinkConfig) *MIDIEmitter {
 
 	log = logIn
-	midiEmitter := MIDIEmitter{make(chan ControlMessage, 6), inputChannel, "USB MIDI", 0, -1}
+	driver := newSinkDriver(sinkConfig)
+	midiEmitter := MIDIEmitter{Control: make(chan ControlMessage, 6), input: inputChannel, selectedMIDIDevice: defaultDevice,
+		deviceCount: 0, midiOutput: -1, activeNotes: make(map[activeNote]int), tunedChannels: make(map[uint8]bool),
+		voices: make(map[activeNote][]activeNote), now: time.Now, driver: driver, selected: newOutput(defaultDevice, driver), outputs: make(map[string]*output)}
+
+	midiEmitter.setRouting(nil)
 
 	go midiEmitter.controlThread()
 	go midiEmitter.emitThread()
@@ -97,17 +183,39 @@ func NewMidi(logIn *logging.Logger, inputChannel <-chan MIDIMessage) *MIDIEmitte
 	return &midiEmitter
 }
 
-/*GetDeviceNames returns an array of midi device names. */
-func (midiEmitter *MIDIEmitter) GetDeviceNames() []string {
+/*
+NewOfflineMidi Returns an emitter that sends straight to a sink instead of through the output ports, such as a FileSink. No
+threads are started, messages are handed to it with Emit and its clock is the virtual time they are given, starting
+from zero.
+*/
+func NewOfflineMidi(logIn *logging.Logger, sink Sink) *MIDIEmitter {
 
-	names := midi.OutPorts()
-	numDevices := len(names)
+	log = logIn
 
-	if numDevices < 1 {
-		return []string{"No devices found."}
-	}
+	midiEmitter := &MIDIEmitter{Control: make(chan ControlMessage, 6), selectedMIDIDevice: "Offline", midiOutput: -1, sendMessage: sink.Send,
+		activeNotes: make(map[activeNote]int), tunedChannels: make(map[uint8]bool), voices: make(map[activeNote][]activeNote)}
+	midiEmitter.now = func() time.Time { return time.Time{}.Add(midiEmitter.offlineTime) }
 
-	return names
+	return midiEmitter
+}
+
+/*Emit Converts and sends a single message from the processor at a virtual time, the same way the emit thread does. */
+func (midiEmitter *MIDIEmitter) Emit(at time.Duration, message MIDIMessage) {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	midiEmitter.offlineTime = at
+	midiEmitter.emit(message)
+}
+
+/*SetTuning Switches to the named tuning straight away, for emitters without a control thread. */
+func (midiEmitter *MIDIEmitter) SetTuning(name string) {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	midiEmitter.setTuning(name)
 }
 
 func (midiEmitter *MIDIEmitter) controlThread() {
@@ -120,50 +228,337 @@ func (midiEmitter *MIDIEmitter) controlThread() {
 		case SetDevice:
 			midiEmitter.setDevice(message.Value)
 
+		case Panic:
+			midiEmitter.lock.Lock()
+			midiEmitter.silence()
+			midiEmitter.lock.Unlock()
+
+		case SetTuning:
+			midiEmitter.lock.Lock()
+			midiEmitter.setTuning(message.Value)
+			midiEmitter.lock.Unlock()
 		}
 	}
 }
 
-func (midiEmitter *MIDIEmitter) setDevice(name string) {
+/*Close Turns off every note still sounding and stops any further messages being sent. Called on process exit. */
+func (midiEmitter *MIDIEmitter) Close() {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	if !midiEmitter.closed {
+		midiEmitter.silence()
+		midiEmitter.closed = true
+
+		if midiEmitter.selected != nil {
+			midiEmitter.closeOutputs()
+		}
 
-	midiEmitter.selectedMIDIDevice = name
-	log.Printf("Midi Device set to %v\n", name)
+		if midiEmitter.driver != nil {
+			if err := midiEmitter.driver.close(); err != nil {
+				log.Printf("Failed to close the sink. (%v)\n", err)
+			}
+		}
+	}
 }
 
+/*emitThread Converts the messages from the processor and routes them to the ports, enumerating the ports in between to reconnect any that drop out. */
 func (midiEmitter *MIDIEmitter) emitThread() {
 
-	out := midi.FindOutPort("USB Midi")
-	sendMessage, err := midi.SendTo(out)
+	midiEmitter.pollDevices()
 
-	if err != nil {
-		log.Printf("Failed to send midi message. (%v)\n", err)
-	}
+	poll := time.NewTicker(devicePollInterval)
+	defer poll.Stop()
 
 	for {
 
-		message := <-midiEmitter.input
+		select {
 
-		if sendMessage != nil {
+		case message := <-midiEmitter.input:
+			midiEmitter.lock.Lock()
+			midiEmitter.emit(message)
+			midiEmitter.lock.Unlock()
 
-			var midiMessage midi.Message
+		case <-poll.C:
+			midiEmitter.pollDevices()
+		}
+	}
+}
 
-			if message.Type == NoteOn {
-				//	log.Printf("Type: 0x%x MiDINote: Not+Oct:%d Note:%d\n", int64(message.Type+message.Channel), int64(int(octaveOffsets[message.Octave])+message.Note), int(message.Note))
-				midiMessage = midi.NoteOn(1, uint8(int(octaveOffsets[message.Octave])+message.Note), uint8(message.Velocity))
+/*emit Converts a message from the processor into a MIDI message, keeping track of which notes are left sounding. */
+func (midiEmitter *MIDIEmitter) emit(message MIDIMessage) {
 
-			} else if message.Type == NoteOff {
-				//	log.Printf("Type: 0x%x MiDINote: Not+Oct:%d Note:%d\n", int64(message.Type+message.Channel), int64(int(octaveOffsets[message.Octave])+message.Note), int(message.Note))
-				midiMessage = midi.NoteOff(1, uint8(int(octaveOffsets[message.Octave])+message.Note))
-			}
+	if midiEmitter.closed {
+		return
+	}
+
+	if !midiEmitter.canSend() {
+		log.Println("No MIDI Device configured.")
+		return
+	}
+
+	if message.Type == MetricValue {
+		midiEmitter.sendMetric(message.Metric)
+		return
+	}
+
+	channel := uint8(message.Channel)
+
+	if message.Channel < Channel1 || message.Channel > Channel16 {
+		log.Printf("Dropping message on invalid channel %d.\n", message.Channel)
+		return
+	}
+
+	var note activeNote
+
+	/* Nothing outside of the MIDI note range ever reaches the driver. */
+	if message.Type == NoteOn || message.Type == NoteOff || message.Type == PolyAftertouch {
+
+		key, valid := message.key()
+
+		if !valid {
+			log.Printf("Dropping note outside of the MIDI range (Octave: %d Note: %d).\n", message.Octave, message.Note)
+			return
+		}
+
+		note = activeNote{channel: channel, note: key}
+	}
 
-			err := sendMessage(midiMessage)
+	switch message.Type {
 
-			if err != nil {
-				log.Printf("Failed to send midi message. (%v)\n", err)
+	case ControlChange:
+		midiEmitter.send(midi.ControlChange(channel, uint8(message.Controller), uint8(message.Value)))
+
+	case PitchBend:
+		midiEmitter.send(midi.Pitchbend(channel, int16(message.Value)))
+
+	case ChannelAftertouch:
+		midiEmitter.send(midi.AfterTouch(channel, uint8(message.Value)))
+
+	case PolyAftertouch:
+		midiEmitter.send(midi.PolyAfterTouch(channel, note.note, uint8(message.Value)))
+
+	case NoteOn:
+
+		if midiEmitter.tuning != nil && midiEmitter.tunedChannels[channel] {
+			midiEmitter.tunedNoteOn(note, uint8(message.Velocity))
+			return
+		}
+
+		midiEmitter.noteOn(note, uint8(message.Velocity))
+
+	case NoteOff:
+
+		/* Notes started while a tuning was active are stopped wherever they were sent, even if the tuning has changed since. */
+		if sent := midiEmitter.voices[note]; len(sent) > 0 {
+
+			midiEmitter.voices[note] = sent[1:]
+
+			if len(midiEmitter.voices[note]) == 0 {
+				delete(midiEmitter.voices, note)
 			}
 
-		} else {
-			log.Println("No MIDI Device configured.")
+			note = sent[0]
 		}
+
+		midiEmitter.noteOff(note)
+	}
+}
+
+func (midiEmitter *MIDIEmitter) noteOn(note activeNote, velocity uint8) {
+
+	midiEmitter.send(midi.NoteOn(note.channel, note.note, velocity))
+	midiEmitter.activeNotes[note]++
+}
+
+func (midiEmitter *MIDIEmitter) noteOff(note activeNote) {
+
+	midiEmitter.send(midi.NoteOff(note.channel, note.note))
+
+	if midiEmitter.activeNotes[note] > 1 {
+		midiEmitter.activeNotes[note]--
+	} else {
+		delete(midiEmitter.activeNotes, note)
 	}
 }
+
+/*
+tunedNoteOn Plays a note at the frequency the active tuning gives its key:
+mpe		The note is sent on the next free member channel as the nearest 12-TET note, with a pitch bend for the difference.
+mts		The key is retuned with a MIDI Tuning Standard SysEx message before it is played.
+*/
+func (midiEmitter *MIDIEmitter) tunedNoteOn(note activeNote, velocity uint8) {
+
+	frequency, mapped := midiEmitter.tuning.Frequency(int(note.note))
+
+	if !mapped {
+		log.Printf("Key %d isn't mapped in the %s tuning.\n", note.note, midiEmitter.tuning.Name)
+		return
+	}
+
+	if midiEmitter.tuningConfig.Mode == tuning.MTS {
+		midiEmitter.send(midi.Message(tuning.NoteTuningChange(int(note.note), frequency)))
+		midiEmitter.noteOn(note, velocity)
+		return
+	}
+
+	nearest, cents := tuning.NearestNote(frequency)
+
+	if nearest < 0 || nearest > 127 {
+		log.Printf("%.2fHz is outside the MIDI note range.\n", frequency)
+		return
+	}
+
+	member := midiEmitter.nextMemberChannel()
+	sent := activeNote{channel: member, note: uint8(nearest)}
+
+	midiEmitter.send(midi.Pitchbend(member, tuning.PitchBend(cents, midiEmitter.tuningConfig.BendRange)))
+	midiEmitter.noteOn(sent, velocity)
+	midiEmitter.voices[note] = append(midiEmitter.voices[note], sent)
+}
+
+/*nextMemberChannel Picks the MPE member channel for a new note, the next one with nothing sounding or the oldest if they're all busy. */
+func (midiEmitter *MIDIEmitter) nextMemberChannel() uint8 {
+
+	members := midiEmitter.tuningConfig.MemberChannels
+	busy := make(map[uint8]bool)
+
+	for note := range midiEmitter.activeNotes {
+		busy[note.channel] = true
+	}
+
+	for i := 0; i < len(members); i++ {
+
+		channel := uint8(members[(midiEmitter.nextMember+i)%len(members)] - 1)
+
+		if !busy[channel] {
+			midiEmitter.nextMember = (midiEmitter.nextMember + i + 1) % len(members)
+			return channel
+		}
+	}
+
+	channel := uint8(members[midiEmitter.nextMember] - 1)
+	midiEmitter.nextMember = (midiEmitter.nextMember + 1) % len(members)
+
+	return channel
+}
+
+/*ConfigureTuning Stores the tuning config and the Scala tunings loaded from it, called once at startup. */
+func (midiEmitter *MIDIEmitter) ConfigureTuning(config tuning.Config, tunings []*tuning.Tuning) {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	midiEmitter.tuningConfig = config
+	midiEmitter.tunings = tunings
+
+	for _, channel := range config.Channels {
+		midiEmitter.tunedChannels[uint8(channel-1)] = true
+	}
+}
+
+/*setTuning Switches to the named tuning, 12-TET turns tuning off. MPE synths are told the zone layout and bend range. */
+func (midiEmitter *MIDIEmitter) setTuning(name string) {
+
+	midiEmitter.tuning = nil
+
+	for _, t := range midiEmitter.tunings {
+		if t.Name == name {
+			midiEmitter.tuning = t
+		}
+	}
+
+	if midiEmitter.tuning == nil {
+		log.Printf("Using %s tuning.\n", equalTemperament)
+		return
+	}
+
+	log.Printf("Using %s tuning (%s).\n", name, midiEmitter.tuningConfig.Mode)
+
+	if midiEmitter.tuningConfig.Mode != tuning.MPE || !midiEmitter.canSend() {
+		return
+	}
+
+	members := midiEmitter.tuningConfig.MemberChannels
+	master := uint8(0)
+
+	if members[0] > 1 {
+		master = uint8(members[0] - 2)
+	}
+
+	midiEmitter.sendRPN(master, rpnMPEConfig, uint8(len(members)))
+
+	for _, member := range members {
+		midiEmitter.sendRPN(uint8(member-1), rpnBendRange, uint8(midiEmitter.tuningConfig.BendRange))
+	}
+}
+
+func (midiEmitter *MIDIEmitter) sendRPN(channel uint8, parameter uint8, value uint8) {
+
+	midiEmitter.send(midi.ControlChange(channel, rpnMSB, 0))
+	midiEmitter.send(midi.ControlChange(channel, rpnLSB, parameter))
+	midiEmitter.send(midi.ControlChange(channel, dataEntryMSB, value))
+	midiEmitter.send(midi.ControlChange(channel, dataEntryLSB, 0))
+}
+
+/*GetTuningNames Returns 12-TET followed by the names of the Scala tunings, for the front end. */
+func (midiEmitter *MIDIEmitter) GetTuningNames() []string {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	names := []string{equalTemperament}
+
+	for _, t := range midiEmitter.tunings {
+		names = append(names, t.Name)
+	}
+
+	return names
+}
+
+/*silence Sends a NoteOff for every note we know is sounding, then All Notes Off/All Sound Off on every channel in case the device missed anything. */
+func (midiEmitter *MIDIEmitter) silence() {
+
+	if !midiEmitter.canSend() {
+		return
+	}
+
+	for note := range midiEmitter.activeNotes {
+		midiEmitter.send(midi.NoteOff(note.channel, note.note))
+		delete(midiEmitter.activeNotes, note)
+	}
+
+	for note := range midiEmitter.voices {
+		delete(midiEmitter.voices, note)
+	}
+
+	for channel := uint8(0); channel < numChannels; channel++ {
+		midiEmitter.send(midi.ControlChange(channel, allNotesOff, 0))
+		midiEmitter.send(midi.ControlChange(channel, allSoundOff, 0))
+	}
+
+	log.Println("Sent All Notes Off/All Sound Off on all channels.")
+}
+
+func (midiEmitter *MIDIEmitter) send(midiMessage midi.Message) {
+
+	midiEmitter.record(midiMessage)
+
+	/* Offline emitters send straight to their sink, otherwise the message is queued on the ports it is routed to. */
+	if midiEmitter.sendMessage == nil {
+		midiEmitter.route(midiMessage)
+		return
+	}
+
+	err := midiEmitter.sendMessage(midiMessage)
+
+	if err != nil {
+		log.Printf("Failed to send midi message. (%v)\n", err)
+	}
+}
+
+/*canSend Returns true if there is a sink or at least one route for messages to go down. */
+func (midiEmitter *MIDIEmitter) canSend() bool {
+	return midiEmitter.sendMessage != nil || len(midiEmitter.routes) > 0
+}
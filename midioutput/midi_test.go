@@ -0,0 +1,88 @@
+package midioutput
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+func TestCloseReleasesActiveNotes(t *testing.T) {
+
+	emitter, driver := newTestEmitter(t, nil)
+
+	play(emitter,
+		MIDIMessage{Channel: Channel1, Type: NoteOn, Note: 0, Octave: 4, Velocity: 100},
+		MIDIMessage{Channel: Channel1, Type: NoteOn, Note: 0, Octave: 4, Velocity: 100},
+		MIDIMessage{Channel: Channel1, Type: NoteOff, Note: 0, Octave: 4},
+		MIDIMessage{Channel: Channel2, Type: NoteOn, Note: 7, Octave: 3, Velocity: 80},
+		MIDIMessage{Channel: Channel3, Type: NoteOn, Note: 2, Octave: 5, Velocity: 80},
+		MIDIMessage{Channel: Channel3, Type: NoteOff, Note: 2, Octave: 5},
+	)
+
+	emitter.Close()
+
+	/* Nothing is sent once the emitter has been closed. */
+	play(emitter, MIDIMessage{Channel: Channel4, Type: NoteOn, Note: 0, Octave: 4, Velocity: 100})
+
+	messages := driver.sinks[defaultDevice].Messages()
+
+	if len(messages) < 6 {
+		t.Fatalf("got % x, expected the notes played followed by the release", messages)
+	}
+
+	played := []midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 60, 100), midi.NoteOff(0, 60), midi.NoteOn(1, 55, 80),
+		midi.NoteOn(2, 74, 80), midi.NoteOff(2, 74)}
+
+	if !reflect.DeepEqual(messages[:len(played)], played) {
+		t.Errorf("got % x, want % x", messages[:len(played)], played)
+	}
+
+	/* The notes still sounding are stopped in any order, then every channel is sent all notes and all sound off. */
+	released := messages[len(played):]
+
+	if len(released) != 2+2*numChannels {
+		t.Fatalf("got release % x", released)
+	}
+
+	stopped := released[:2]
+	sort.Slice(stopped, func(a int, b int) bool { return stopped[a][0] < stopped[b][0] })
+
+	if !reflect.DeepEqual(stopped, []midi.Message{midi.NoteOff(0, 60), midi.NoteOff(1, 55)}) {
+		t.Errorf("got note offs % x, want the notes on channels 1 and 2", stopped)
+	}
+
+	for channel := uint8(0); channel < numChannels; channel++ {
+
+		off := released[2+2*channel:]
+
+		if !reflect.DeepEqual(off[:2], []midi.Message{midi.ControlChange(channel, allNotesOff, 0), midi.ControlChange(channel, allSoundOff, 0)}) {
+			t.Errorf("channel %d: got % x, want all notes off and all sound off", channel+1, off[:2])
+		}
+	}
+}
+
+func TestMessageKey(t *testing.T) {
+
+	tests := []struct {
+		message MIDIMessage
+		key     uint8
+		valid   bool
+	}{
+		{MIDIMessage{Note: 0, Octave: 4}, 60, true},
+		{MIDIMessage{Note: 0, Octave: 0}, 12, true},
+		{MIDIMessage{Note: -12, Octave: 0}, 0, true},
+		{MIDIMessage{Note: 19, Octave: 8}, 127, true},
+		{MIDIMessage{Note: 20, Octave: 8}, 0, false},
+		{MIDIMessage{Note: -13, Octave: 0}, 0, false},
+		{MIDIMessage{Note: 0, Octave: 9}, 0, false},
+		{MIDIMessage{Note: 0, Octave: -1}, 0, false},
+	}
+
+	for _, test := range tests {
+		if key, valid := test.message.key(); key != test.key || valid != test.valid {
+			t.Errorf("octave %d note %d: got %d, %v, want %d, %v", test.message.Octave, test.message.Note, key, valid, test.key, test.valid)
+		}
+	}
+}
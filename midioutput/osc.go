@@ -0,0 +1,176 @@
+package midioutput
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/osc"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+/*
+OSCConfig Defines the format of the osc config, used by routes to an osc://host:port port. Messages go over UDP in
+bundles, and {channel} in an address is replaced by the MIDI channel (1-16):
+latency		Milliseconds added to the timetag of every bundle, so the receiver plays them with the timing they were sent
+with however the network delivers them. 0 asks for them to be played as soon as they arrive.
+note_on		Address of note ons, the arguments are channel, note and velocity as ints (/note/on).
+note_off	Address of note offs, the arguments are channel and note as ints (/note/off).
+control		Address of control changes, the arguments are channel, controller and value as ints (/control).
+pitch_bend	Address of pitch bends, the arguments are channel and bend (-8192 to 8191) as ints (/pitch_bend).
+metric		Address of the raw metric values, the argument is the value as a float (/metric).
+*/
+type OSCConfig struct {
+	Latency   int    `yaml:"latency"`
+	NoteOn    string `yaml:"note_on"`
+	NoteOff   string `yaml:"note_off"`
+	Control   string `yaml:"control"`
+	PitchBend string `yaml:"pitch_bend"`
+	Metric    string `yaml:"metric"`
+}
+
+/*OSCScheme Ports starting with this are OSC destinations rather than devices of the sink, e.g. osc://127.0.0.1:57120. */
+const OSCScheme = "osc://"
+
+/*Validate Checks the osc config, filling in the defaults. */
+func (config *OSCConfig) Validate() error {
+
+	if config.Latency < 0 {
+		return fmt.Errorf("osc.latency: %d can't be negative", config.Latency)
+	}
+
+	defaults := []string{"/note/on", "/note/off", "/control", "/pitch_bend", "/metric"}
+
+	for i, address := range []*string{&config.NoteOn, &config.NoteOff, &config.Control, &config.PitchBend, &config.Metric} {
+
+		if *address == "" {
+			*address = defaults[i]
+		}
+
+		if !strings.HasPrefix(*address, "/") {
+			return fmt.Errorf("osc: address %q must start with /", *address)
+		}
+	}
+
+	return nil
+}
+
+/*oscSink Translates MIDI messages into OSC messages and sends them to a UDP address. */
+type oscSink struct {
+	conn   net.Conn
+	config OSCConfig
+}
+
+/*Send Sends a MIDI message as OSC, anything without an address (aftertouch, SysEx) is dropped. */
+func (sink *oscSink) Send(message midi.Message) error {
+
+	if len(message) < 2 || message[0] >= 0xF0 {
+		return nil
+	}
+
+	channel := int32(message[0]&0x0F) + 1
+	var address string
+	var arguments []interface{}
+
+	switch MIDIValue(message[0] & 0xF0) {
+
+	case NoteOn:
+
+		if len(message) < 3 {
+			return nil
+		}
+
+		if message[2] == 0 {
+			address, arguments = sink.config.NoteOff, []interface{}{channel, int32(message[1])}
+			break
+		}
+
+		address, arguments = sink.config.NoteOn, []interface{}{channel, int32(message[1]), int32(message[2])}
+
+	case NoteOff:
+		address, arguments = sink.config.NoteOff, []interface{}{channel, int32(message[1])}
+
+	case ControlChange:
+
+		if len(message) < 3 {
+			return nil
+		}
+
+		address, arguments = sink.config.Control, []interface{}{channel, int32(message[1]), int32(message[2])}
+
+	case PitchBend:
+
+		if len(message) < 3 {
+			return nil
+		}
+
+		bend := (int32(message[1]) | int32(message[2])<<7) - 8192
+		address, arguments = sink.config.PitchBend, []interface{}{channel, bend}
+
+	default:
+		return nil
+	}
+
+	address = strings.Replace(address, "{channel}", strconv.Itoa(int(channel)), -1)
+
+	return sink.sendBundle(osc.Message{Address: address, Arguments: arguments})
+}
+
+/*SendMetric Sends a raw metric value. */
+func (sink *oscSink) SendMetric(value float64) error {
+	return sink.sendBundle(osc.Message{Address: sink.config.Metric, Arguments: []interface{}{float32(value)}})
+}
+
+/*sendBundle Sends a message in a bundle timetagged with the latency from now. */
+func (sink *oscSink) sendBundle(message osc.Message) error {
+
+	bundle := osc.Bundle{Messages: []osc.Message{message}}
+
+	if sink.config.Latency > 0 {
+		bundle.Time = time.Now().Add(time.Duration(sink.config.Latency) * time.Millisecond)
+	}
+
+	data, err := bundle.MarshalBinary()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = sink.conn.Write(data)
+
+	return err
+}
+
+func (sink *oscSink) Close() error {
+	return sink.conn.Close()
+}
+
+type oscDriver struct {
+	config OSCConfig
+}
+
+func (driver oscDriver) open(device string) (Sink, error) {
+
+	conn, err := net.Dial("udp", strings.TrimPrefix(device, OSCScheme))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &oscSink{conn: conn, config: driver.config}, nil
+}
+
+/*present UDP has no connection to lose, the destination is always there. */
+func (driver oscDriver) present(device string) bool {
+	return true
+}
+
+func (driver oscDriver) devices() []string {
+	return nil
+}
+
+func (driver oscDriver) close() error {
+	return nil
+}
@@ -0,0 +1,319 @@
+package midioutput
+
+import (
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+/* Messages waiting to go out of a single port, a port that falls this far behind drops messages rather than hold up the others. */
+const outputQueueSize = 1024
+
+/* How often a port that is dropping messages is logged, rather than once for every message dropped. */
+const dropLogInterval = 5 * time.Second
+
+/*outputRequest Something for an output thread to do, send a message or metric value, open a device or close the port. */
+type outputRequest struct {
+	message   midi.Message
+	metric    float64
+	hasMetric bool
+	open      string
+	close     bool
+	stop      bool
+}
+
+/*
+output An output port with its own thread, writing to a sink opened from the driver. Everything that touches the sink
+goes through the thread, so messages reach it in order and a port that is slow or stuck only holds up itself. Opens,
+closes and NoteOffs that don't fit in the queue are kept in a list of their own, so asking for one never waits on a
+stuck port and they are never dropped.
+*/
+type output struct {
+	device      string
+	driver      sinkDriver
+	queue       chan outputRequest
+	wake        chan struct{}
+	pending     []outputRequest
+	pendingLock sync.Mutex
+	done        chan struct{}
+	sink        Sink
+	status      string
+	failed      bool
+	dropped     int
+	lastDropLog time.Time
+	lock        sync.Mutex
+}
+
+func newOutput(device string, driver sinkDriver) *output {
+
+	out := &output{device: device, driver: driver, queue: make(chan outputRequest, outputQueueSize),
+		wake: make(chan struct{}, 1), done: make(chan struct{})}
+
+	go out.outputThread()
+
+	return out
+}
+
+func (out *output) outputThread() {
+
+	defer close(out.done)
+
+	for {
+		select {
+
+		case request := <-out.queue:
+			out.deliver(request)
+
+		case <-out.wake:
+
+			for _, request := range out.takePending() {
+
+				/* Whatever was queued before the request is sent first. */
+				for len(out.queue) > 0 {
+					out.deliver(<-out.queue)
+				}
+
+				switch {
+				case request.message != nil:
+					out.send(request.message)
+				case request.open != "":
+					out.open(request.open)
+				case request.close:
+					out.close(deviceDisconnected)
+				}
+
+				if request.stop {
+					return
+				}
+			}
+		}
+	}
+}
+
+/*deliver Sends a queued message or metric value. */
+func (out *output) deliver(request outputRequest) {
+
+	if request.hasMetric {
+		out.sendMetric(request.metric)
+		return
+	}
+
+	out.send(request.message)
+}
+
+func (out *output) takePending() []outputRequest {
+
+	out.pendingLock.Lock()
+	defer out.pendingLock.Unlock()
+
+	pending := out.pending
+	out.pending = nil
+
+	return pending
+}
+
+/*
+enqueue Queues a message for the port without waiting. A port that has fallen too far behind drops notes, controls and
+metric values, but a NoteOff is kept aside until the port catches up so no note is left hanging.
+*/
+func (out *output) enqueue(message midi.Message) {
+	out.enqueueRequest(outputRequest{message: message})
+}
+
+/*enqueueMetric Queues a metric value for the port, it is only sent if the sink takes them. */
+func (out *output) enqueueMetric(value float64) {
+	out.enqueueRequest(outputRequest{metric: value, hasMetric: true})
+}
+
+func (out *output) enqueueRequest(request outputRequest) {
+
+	select {
+	case out.queue <- request:
+		return
+	default:
+	}
+
+	/* A NoteOn with a velocity of 0 ends the note too. */
+	message := request.message
+	kind := MIDIValue(0)
+
+	if len(message) == 3 {
+		kind = MIDIValue(message[0] & 0xF0)
+	}
+
+	if kind == NoteOff || kind == NoteOn && message[2] == 0 {
+		out.request(request)
+		return
+	}
+
+	out.drop()
+}
+
+/*drop Counts a dropped message, logging how many have been dropped at most once every dropLogInterval. */
+func (out *output) drop() {
+
+	out.pendingLock.Lock()
+	defer out.pendingLock.Unlock()
+
+	out.dropped++
+
+	if time.Since(out.lastDropLog) < dropLogInterval {
+		return
+	}
+
+	log.Printf("Midi Device %s is too far behind, dropped %d messages.\n", out.name(), out.dropped)
+	out.dropped = 0
+	out.lastDropLog = time.Now()
+}
+
+/*request Asks the port to do something without waiting for it, these are never dropped. */
+func (out *output) request(request outputRequest) {
+
+	out.pendingLock.Lock()
+	out.pending = append(out.pending, request)
+	out.pendingLock.Unlock()
+
+	select {
+	case out.wake <- struct{}{}:
+	default:
+	}
+}
+
+/*send Sends a message to the sink, the lock isn't held while sending so a stuck device doesn't hold up its status. */
+func (out *output) send(message midi.Message) {
+
+	out.lock.Lock()
+	sink, failed := out.sink, out.failed
+	out.lock.Unlock()
+
+	/* Once a device has failed nothing more is sent until it has been reopened. */
+	if sink == nil || failed {
+		return
+	}
+
+	if err := sink.Send(message); err != nil {
+		out.fail(err)
+	}
+}
+
+func (out *output) fail(err error) {
+
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	log.Printf("Failed to send midi message to %s. (%v)\n", out.device, err)
+	out.failed = true
+	out.status = deviceFailed
+}
+
+/*sendMetric Sends a metric value to the sink if it takes them, the lock isn't held while sending as for send. */
+func (out *output) sendMetric(value float64) {
+
+	out.lock.Lock()
+	sink, takesMetrics := out.sink.(metricSink)
+	failed := out.failed
+	out.lock.Unlock()
+
+	if !takesMetrics || failed {
+		return
+	}
+
+	if err := sink.SendMetric(value); err != nil {
+		out.fail(err)
+	}
+}
+
+/*open Opens the named device, closing whatever was open before. */
+func (out *output) open(device string) {
+
+	out.close(deviceNotFound)
+
+	out.lock.Lock()
+	out.device = device
+	out.lock.Unlock()
+
+	if !out.driver.present(device) {
+		out.setStatus(deviceNotFound)
+		log.Printf("Midi Device %s not found.\n", device)
+		return
+	}
+
+	sink, err := out.driver.open(device)
+
+	if err != nil {
+		out.setStatus(deviceFailed)
+		log.Printf("Failed to open Midi Device %s. (%v)\n", device, err)
+		return
+	}
+
+	out.lock.Lock()
+	out.sink = sink
+	out.failed = false
+	out.status = deviceConnected
+	out.lock.Unlock()
+
+	log.Printf("Connected to Midi Device %s.\n", device)
+}
+
+/*close Closes the port if it is open, leaving the given status. */
+func (out *output) close(status string) {
+
+	out.lock.Lock()
+	sink, device := out.sink, out.device
+	out.sink = nil
+	out.status = status
+	out.lock.Unlock()
+
+	if sink != nil {
+
+		if err := sink.Close(); err != nil {
+			log.Printf("Failed to close Midi Device %s. (%v)\n", device, err)
+		}
+	}
+}
+
+func (out *output) setStatus(status string) {
+
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	out.status = status
+}
+
+/*state Returns the device the output is for, whether its sink is open and whether sending to it has failed. */
+func (out *output) state() (string, bool, bool) {
+
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	return out.device, out.sink != nil, out.failed
+}
+
+func (out *output) getSink() Sink {
+
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	return out.sink
+}
+
+func (out *output) name() string {
+
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	return out.device
+}
+
+func (out *output) getStatus() string {
+
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	if out.status == "" {
+		return deviceNotFound
+	}
+
+	return out.status
+}
@@ -0,0 +1,54 @@
+package midioutput
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+	_ "gitlab.com/gomidi/midi/v2/drivers/portmididrv" // autoregisters driver
+)
+
+/*portMIDISink Sends to a MIDI device through portmidi. */
+type portMIDISink struct {
+	port        drivers.Out
+	sendMessage func(midi.Message) error
+}
+
+func (sink *portMIDISink) Send(message midi.Message) error {
+	return sink.sendMessage(message)
+}
+
+func (sink *portMIDISink) Close() error {
+	return sink.port.Close()
+}
+
+type portMIDIDriver struct{}
+
+func (portMIDIDriver) open(device string) (Sink, error) {
+
+	port := midi.FindOutPort(device)
+
+	if port == nil {
+		return nil, fmt.Errorf("not found")
+	}
+
+	sendMessage, err := midi.SendTo(port)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &portMIDISink{port: port, sendMessage: sendMessage}, nil
+}
+
+func (portMIDIDriver) present(device string) bool {
+	return midi.FindOutPort(device) != nil
+}
+
+func (portMIDIDriver) devices() []string {
+	return midi.OutPorts()
+}
+
+func (portMIDIDriver) close() error {
+	return nil
+}
@@ -0,0 +1,164 @@
+package midioutput
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/smf"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+/*RecordedMessage A message sent by the emitter and when it was sent, from the start of the recording. */
+type RecordedMessage struct {
+	Time    time.Duration
+	Message midi.Message
+}
+
+/*Recording Every message the emitter sent between StartRecording and StopRecording. Start is zero for offline renders, which start at the beginning of the tempo log. */
+type Recording struct {
+	Start    time.Time
+	Messages []RecordedMessage
+}
+
+/*StartRecording Starts recording every message sent, replacing any recording in progress. */
+func (midiEmitter *MIDIEmitter) StartRecording() {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	midiEmitter.recording = &Recording{Start: midiEmitter.now()}
+	log.Println("Recording started.")
+}
+
+/*StopRecording Stops recording and returns what was recorded, nil if nothing was being recorded. */
+func (midiEmitter *MIDIEmitter) StopRecording() *Recording {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	recording := midiEmitter.recording
+	midiEmitter.recording = nil
+
+	if recording != nil {
+		log.Printf("Recording stopped, %d messages recorded.\n", len(recording.Messages))
+	}
+
+	return recording
+}
+
+/*IsRecording Returns true while messages are being recorded, for the front end. */
+func (midiEmitter *MIDIEmitter) IsRecording() bool {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	return midiEmitter.recording != nil
+}
+
+/*record Adds a message to the recording in progress, called with the lock held. */
+func (midiEmitter *MIDIEmitter) record(message midi.Message) {
+
+	if midiEmitter.recording == nil {
+		return
+	}
+
+	at := midiEmitter.now().Sub(midiEmitter.recording.Start)
+	midiEmitter.recording.Messages = append(midiEmitter.recording.Messages, RecordedMessage{Time: at, Message: append(midi.Message{}, message...)})
+}
+
+/*
+Song Turns the recording into a song with a track for each MIDI channel that was used, plus one for any SysEx (MTS
+tuning) messages. Tracks are named after the title followed by the name given to their channel, or the channel number.
+*/
+func (recording *Recording) Song(title string, tempos []smf.Tempo, key smf.KeySignature, names map[uint8]string) smf.Song {
+
+	channels := make(map[int][]smf.Event)
+	sounding := make(map[activeNote]int)
+	end := time.Duration(0)
+
+	for _, recorded := range recording.Messages {
+
+		if len(recorded.Message) == 0 {
+			continue
+		}
+
+		end = recorded.Time
+		trackSounding(sounding, recorded.Message)
+
+		/* SysEx isn't on a channel, it gets a track of its own after the channels. */
+		channel := numChannels
+
+		if recorded.Message[0] < 0xF0 {
+			channel = int(recorded.Message[0] & 0x0F)
+		}
+
+		channels[channel] = append(channels[channel], smf.Event{Time: recorded.Time, Data: recorded.Message})
+	}
+
+	/* Notes still sounding when the recording stopped are ended with it, so they don't hang in the DAW. */
+	for note, count := range sounding {
+		for i := 0; i < count; i++ {
+			channels[int(note.channel)] = append(channels[int(note.channel)], smf.Event{Time: end, Data: midi.NoteOff(note.channel, note.note)})
+		}
+	}
+
+	order := make([]int, 0, len(channels))
+
+	for channel := range channels {
+		order = append(order, channel)
+	}
+
+	sort.Ints(order)
+
+	song := smf.Song{Tempos: tempos, Numerator: 4, Denominator: 4, Key: key}
+
+	for _, channel := range order {
+
+		name, named := names[uint8(channel)]
+
+		if channel == numChannels {
+			name = "SysEx"
+		} else if !named {
+			name = fmt.Sprintf("Channel %d", channel+1)
+		}
+
+		if title != "" {
+			name = title + " - " + name
+		}
+
+		song.Tracks = append(song.Tracks, smf.Track{Name: name, Events: channels[channel]})
+	}
+
+	return song
+}
+
+/*trackSounding Counts the notes a message starts or stops. */
+func trackSounding(sounding map[activeNote]int, message midi.Message) {
+
+	if len(message) < 3 {
+		return
+	}
+
+	note := activeNote{channel: message[0] & 0x0F, note: message[1]}
+
+	switch MIDIValue(message[0] & 0xF0) {
+
+	case NoteOn:
+
+		if message[2] > 0 {
+			sounding[note]++
+			return
+		}
+
+		fallthrough
+
+	case NoteOff:
+
+		if sounding[note] > 1 {
+			sounding[note]--
+		} else {
+			delete(sounding, note)
+		}
+	}
+}
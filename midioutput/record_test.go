@@ -0,0 +1,102 @@
+package midioutput
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+	"github.com/ElectricNoodle/prometheus-midi-generator/smf"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+func TestRecording(t *testing.T) {
+
+	sink := &MemorySink{}
+	emitter := NewOfflineMidi(logging.NewLogger(), sink)
+
+	/* Messages before the recording starts are sent but not recorded, times are from the start of the recording. */
+	emitter.Emit(0, MIDIMessage{Channel: Channel1, Type: NoteOn, Note: 2, Octave: 4, Velocity: 100})
+	emitter.Emit(time.Second, MIDIMessage{Channel: Channel1, Type: NoteOff, Note: 2, Octave: 4})
+	emitter.StartRecording()
+
+	if !emitter.IsRecording() {
+		t.Fatal("expected the emitter to be recording")
+	}
+
+	emitter.Emit(time.Second, MIDIMessage{Channel: Channel1, Type: NoteOn, Note: 0, Octave: 4, Velocity: 100})
+	emitter.Emit(1500*time.Millisecond, MIDIMessage{Channel: Channel1, Type: NoteOff, Note: 0, Octave: 4})
+	emitter.Emit(2*time.Second, MIDIMessage{Channel: Channel2, Type: NoteOn, Note: 4, Octave: 3, Velocity: 90})
+	emitter.Emit(2250*time.Millisecond, MIDIMessage{Channel: Channel1, Type: NoteOn, Note: 7, Octave: 4, Velocity: 80})
+	emitter.Emit(2500*time.Millisecond, MIDIMessage{Channel: Channel1, Type: ControlChange, Controller: 1, Value: 64})
+
+	recording := emitter.StopRecording()
+
+	if recording == nil || emitter.IsRecording() || emitter.StopRecording() != nil {
+		t.Fatal("expected a single recording")
+	}
+
+	expected := []RecordedMessage{
+		{0, midi.NoteOn(0, 60, 100)},
+		{500 * time.Millisecond, midi.NoteOff(0, 60)},
+		{time.Second, midi.NoteOn(1, 52, 90)},
+		{1250 * time.Millisecond, midi.NoteOn(0, 67, 80)},
+		{1500 * time.Millisecond, midi.ControlChange(0, 1, 64)},
+	}
+
+	if !reflect.DeepEqual(recording.Messages, expected) {
+		t.Errorf("got %v, want %v", recording.Messages, expected)
+	}
+
+	if messages := sink.Messages(); len(messages) != 2+len(expected) {
+		t.Errorf("got %d messages sent, want %d", len(messages), 2+len(expected))
+	}
+}
+
+func TestRecordingSong(t *testing.T) {
+
+	recording := &Recording{Messages: []RecordedMessage{
+		{0, midi.NoteOn(0, 60, 100)},
+		{500 * time.Millisecond, midi.NoteOff(0, 60)},
+		{time.Second, midi.NoteOn(1, 52, 90)},
+		{time.Second, midi.Message{0xF0, 0x7F, 0x7F, 0x08, 0x02, 0x00, 0x01, 52, 52, 0x10, 0x00, 0xF7}},
+		{1250 * time.Millisecond, midi.NoteOn(0, 67, 80)},
+		{1500 * time.Millisecond, midi.ControlChange(0, 1, 64)},
+	}}
+
+	song := recording.Song("Test", []smf.Tempo{{Time: 0, BPM: 60}}, smf.KeySignature{Sharps: 1}, map[uint8]string{0: "Melody"})
+
+	names := make([]string, len(song.Tracks))
+
+	for i, track := range song.Tracks {
+		names[i] = track.Name
+	}
+
+	if expected := []string{"Test - Melody", "Test - Channel 2", "Test - SysEx"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("got tracks %v, want %v", names, expected)
+	}
+
+	var data bytes.Buffer
+
+	if err := smf.Write(&data, song); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := smf.Read(data.Bytes())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* At 60 BPM a second is a beat of 480 ticks, the notes still sounding are ended when the recording stops. */
+	expected := []smf.Note{
+		{Tick: 0, Channel: 0, Key: 60, Velocity: 100, Duration: 240},
+		{Tick: 480, Channel: 1, Key: 52, Velocity: 90, Duration: 240},
+		{Tick: 600, Channel: 0, Key: 67, Velocity: 80, Duration: 120},
+	}
+
+	if !reflect.DeepEqual(file.Notes, expected) {
+		t.Errorf("got notes %+v, want %+v", file.Notes, expected)
+	}
+}
@@ -0,0 +1,330 @@
+package midioutput
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+/*
+Route Defines the format of a route config, which sends channels to an output port. The processor's tracks each play
+on their own channel (see melody_channel, chord_channel and channel 10 for drums), so routing channels routes tracks:
+port		Name of the output port, left empty for the device selected in the front end.
+channels	Channels (1-16) sent down the route, all of them when left out.
+remap		Channels changed on the way to the port, e.g. {10: 1} plays the drums on channel 1 of this port only.
+*/
+type Route struct {
+	Port     string      `yaml:"port"`
+	Channels []int       `yaml:"channels,flow"`
+	Remap    map[int]int `yaml:"remap,flow"`
+}
+
+/*Routing Defines the routing table, every message goes down each route its channel matches. SysEx goes to every port. */
+type Routing []Route
+
+/*route Parsed version of a route, indexed by channel (0-15). */
+type route struct {
+	out      *output
+	channels [numChannels]bool
+	remap    [numChannels]uint8
+}
+
+/* How long Close waits for the ports to send what is left in their queues. */
+const outputDrainTimeout = time.Second
+
+/*Validate Checks the routing table, no routes sends everything to the selected device. */
+func (routing Routing) Validate() error {
+
+	for i, r := range routing {
+
+		for _, channel := range r.Channels {
+			if channel < 1 || channel > numChannels {
+				return fmt.Errorf("routing[%d].channels: channel %d must be between 1 and %d", i, channel, numChannels)
+			}
+		}
+
+		for from, to := range r.Remap {
+			if from < 1 || from > numChannels || to < 1 || to > numChannels {
+				return fmt.Errorf("routing[%d].remap: %d: %d channels must be between 1 and %d", i, from, to, numChannels)
+			}
+		}
+	}
+
+	return nil
+}
+
+/*ParseRoute Builds a route from the text fields of the front end, channels as "1, 2, 10" and remaps as "10:1, 2:3". */
+func ParseRoute(port string, channels string, remap string) (Route, error) {
+
+	parsed := Route{Port: strings.TrimSpace(port), Remap: make(map[int]int)}
+
+	for _, field := range strings.FieldsFunc(channels, isSeparator) {
+
+		channel, err := strconv.Atoi(field)
+
+		if err != nil {
+			return parsed, fmt.Errorf("%q is not a channel", field)
+		}
+
+		parsed.Channels = append(parsed.Channels, channel)
+	}
+
+	for _, field := range strings.FieldsFunc(remap, isSeparator) {
+
+		pair := strings.Split(field, ":")
+
+		if len(pair) != 2 {
+			return parsed, fmt.Errorf("remap %q should be <from>:<to>", field)
+		}
+
+		from, fromErr := strconv.Atoi(pair[0])
+		to, toErr := strconv.Atoi(pair[1])
+
+		if fromErr != nil || toErr != nil {
+			return parsed, fmt.Errorf("remap %q should be <from>:<to>", field)
+		}
+
+		parsed.Remap[from] = to
+	}
+
+	return parsed, Routing{parsed}.Validate()
+}
+
+func isSeparator(r rune) bool {
+	return r == ',' || r == ' '
+}
+
+/*FormatRoute Returns the channels and remaps of a route as the text ParseRoute reads, for the front end. */
+func FormatRoute(r Route) (string, string) {
+
+	channels := make([]string, len(r.Channels))
+
+	for i, channel := range r.Channels {
+		channels[i] = strconv.Itoa(channel)
+	}
+
+	froms := make([]int, 0, len(r.Remap))
+
+	for from := range r.Remap {
+		froms = append(froms, from)
+	}
+
+	sort.Ints(froms)
+	remaps := make([]string, len(froms))
+
+	for i, from := range froms {
+		remaps[i] = fmt.Sprintf("%d:%d", from, r.Remap[from])
+	}
+
+	return strings.Join(channels, ", "), strings.Join(remaps, ", ")
+}
+
+/*ConfigureOSC Stores the osc config used by routes to OSC destinations, called once at startup before the routing is set. */
+func (midiEmitter *MIDIEmitter) ConfigureOSC(config OSCConfig) {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	midiEmitter.oscConfig = config
+}
+
+/*ConfigureRouting Sets the routing table from the config, called once at startup. */
+func (midiEmitter *MIDIEmitter) ConfigureRouting(routing Routing) {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	midiEmitter.setRouting(routing)
+}
+
+/*SetRouting Replaces the routing table, the notes sounding are turned off first as they may not be routed the same way. */
+func (midiEmitter *MIDIEmitter) SetRouting(routing Routing) error {
+
+	if err := routing.Validate(); err != nil {
+		return err
+	}
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	midiEmitter.silence()
+	midiEmitter.setRouting(routing)
+
+	log.Printf("Routing set to %d routes.\n", len(midiEmitter.routing))
+
+	return nil
+}
+
+/*GetRouting Returns a copy of the routing table, for the front end. */
+func (midiEmitter *MIDIEmitter) GetRouting() Routing {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	return append(Routing{}, midiEmitter.routing...)
+}
+
+/*GetPortStatus Returns the name and status of every port in use. The selected device comes first. */
+func (midiEmitter *MIDIEmitter) GetPortStatus() ([]string, []string) {
+
+	midiEmitter.lock.Lock()
+	defer midiEmitter.lock.Unlock()
+
+	var names, statuses []string
+
+	for _, out := range midiEmitter.outputList() {
+		names = append(names, out.name())
+		statuses = append(statuses, out.getStatus())
+	}
+
+	return names, statuses
+}
+
+/*
+setRouting Builds the routes, opening any port that isn't already open and stopping those no longer used. The opens and
+closes are queued without waiting on the ports. Called with the lock held.
+*/
+func (midiEmitter *MIDIEmitter) setRouting(routing Routing) {
+
+	if len(routing) == 0 {
+		routing = Routing{{}}
+	}
+
+	outputs := make(map[string]*output)
+	midiEmitter.routes = nil
+
+	for _, config := range routing {
+
+		r := &route{}
+
+		/* A route naming the selected device shares its output, rather than opening the port a second time. */
+		if config.Port == "" || config.Port == midiEmitter.selectedMIDIDevice {
+			r.out = midiEmitter.selected
+		} else if out, exists := outputs[config.Port]; exists {
+			r.out = out
+		} else if out, exists := midiEmitter.outputs[config.Port]; exists {
+			r.out = out
+		} else {
+			r.out = newOutput(config.Port, midiEmitter.driverFor(config.Port))
+			r.out.request(outputRequest{open: config.Port})
+		}
+
+		if r.out != midiEmitter.selected {
+			outputs[config.Port] = r.out
+		}
+
+		for channel := 0; channel < numChannels; channel++ {
+			r.channels[channel] = len(config.Channels) == 0
+			r.remap[channel] = uint8(channel)
+		}
+
+		for _, channel := range config.Channels {
+			r.channels[channel-1] = true
+		}
+
+		for from, to := range config.Remap {
+			r.remap[from-1] = uint8(to - 1)
+		}
+
+		midiEmitter.routes = append(midiEmitter.routes, r)
+	}
+
+	for name, out := range midiEmitter.outputs {
+		if _, used := outputs[name]; !used {
+			out.request(outputRequest{close: true, stop: true})
+		}
+	}
+
+	midiEmitter.outputs = outputs
+	midiEmitter.routing = append(Routing{}, routing...)
+}
+
+/*outputList Returns the selected output followed by the others in name order. */
+func (midiEmitter *MIDIEmitter) outputList() []*output {
+
+	names := make([]string, 0, len(midiEmitter.outputs))
+
+	for name := range midiEmitter.outputs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	outputs := []*output{midiEmitter.selected}
+
+	for _, name := range names {
+		outputs = append(outputs, midiEmitter.outputs[name])
+	}
+
+	return outputs
+}
+
+/*route Queues a message on every port its channel is routed to, with the channel remapped for each one. */
+func (midiEmitter *MIDIEmitter) route(message midi.Message) {
+
+	/* SysEx and other system messages aren't on a channel. */
+	if message[0] >= 0xF0 {
+
+		for _, out := range midiEmitter.outputList() {
+			out.enqueue(message)
+		}
+
+		return
+	}
+
+	channel := message[0] & 0x0F
+
+	for _, r := range midiEmitter.routes {
+
+		if !r.channels[channel] {
+			continue
+		}
+
+		routed := append(midi.Message{}, message...)
+		routed[0] = message[0]&0xF0 | r.remap[channel]
+
+		r.out.enqueue(routed)
+	}
+}
+
+/*driverFor Returns the driver that opens a port, OSC destinations are opened whatever the sink is. */
+func (midiEmitter *MIDIEmitter) driverFor(port string) sinkDriver {
+
+	if strings.HasPrefix(port, OSCScheme) {
+		return oscDriver{config: midiEmitter.oscConfig}
+	}
+
+	return midiEmitter.driver
+}
+
+/*sendMetric Queues a raw metric value on every port, those that don't take them ignore it. */
+func (midiEmitter *MIDIEmitter) sendMetric(value float64) {
+
+	if midiEmitter.sendMessage != nil {
+		return
+	}
+
+	for _, out := range midiEmitter.outputList() {
+		out.enqueueMetric(value)
+	}
+}
+
+/*closeOutputs Closes every port once it has sent what is queued, waiting a short time for them. Called with the lock held. */
+func (midiEmitter *MIDIEmitter) closeOutputs() {
+
+	timeout := time.After(outputDrainTimeout)
+
+	for _, out := range midiEmitter.outputList() {
+
+		out.request(outputRequest{close: true, stop: true})
+
+		select {
+		case <-out.done:
+		case <-timeout:
+			log.Printf("Midi Device %s didn't finish sending in time.\n", out.name())
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package midioutput
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+/*newTestEmitter Returns an emitter writing to memory sinks, once every port of the routing table has been opened. */
+func newTestEmitter(t *testing.T, routing Routing) (*MIDIEmitter, *memoryDriver) {
+
+	emitter := NewMidi(logging.NewLogger(), make(chan MIDIMessage), SinkConfig{Type: MemorySinkType})
+	emitter.ConfigureRouting(routing)
+
+	for timeout := time.After(2 * time.Second); ; {
+
+		connected := true
+		_, statuses := emitter.GetPortStatus()
+
+		for _, status := range statuses {
+			connected = connected && status == deviceConnected
+		}
+
+		if connected {
+			return emitter, emitter.driver.(*memoryDriver)
+		}
+
+		select {
+		case <-timeout:
+			t.Fatalf("ports didn't connect: %v", statuses)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+/*idleOutput Returns an output without a thread, so whatever is queued on it stays there to be checked. */
+func idleOutput(device string, size int) *output {
+	return &output{device: device, queue: make(chan outputRequest, size), wake: make(chan struct{}, 1)}
+}
+
+/*queued Drains and returns the messages queued on an idle output. */
+func queued(out *output) []midi.Message {
+
+	var messages []midi.Message
+
+	for len(out.queue) > 0 {
+		messages = append(messages, (<-out.queue).message)
+	}
+
+	return messages
+}
+
+/*play Hands the messages to the emitter the way the emit thread does. */
+func play(emitter *MIDIEmitter, messages ...MIDIMessage) {
+
+	emitter.lock.Lock()
+	defer emitter.lock.Unlock()
+
+	for _, message := range messages {
+		emitter.emit(message)
+	}
+}
+
+/*sent Returns the note and SysEx messages a device's memory sink was sent, leaving out everything sent by silence. */
+func sent(driver *memoryDriver, device string) []midi.Message {
+
+	driver.lock.Lock()
+	sink, exists := driver.sinks[device]
+	driver.lock.Unlock()
+
+	if !exists {
+		return nil
+	}
+
+	var messages []midi.Message
+
+	for _, message := range sink.Messages() {
+		if kind := message[0] & 0xF0; kind == 0x80 || kind == 0x90 || kind == 0xF0 {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
+
+func TestRouting(t *testing.T) {
+
+	emitter, driver := newTestEmitter(t, Routing{
+		{Port: "Synth", Channels: []int{1, 2}},
+		{Port: "Drums", Channels: []int{10}, Remap: map[int]int{10: 1}},
+		{Channels: []int{2}},
+	})
+
+	play(emitter,
+		MIDIMessage{Channel: Channel1, Type: NoteOn, Note: 0, Octave: 4, Velocity: 100},
+		MIDIMessage{Channel: Channel2, Type: NoteOn, Note: 4, Octave: 3, Velocity: 90},
+		MIDIMessage{Channel: Channel10, Type: NoteOn, Note: 0, Octave: 2, Velocity: 127},
+		MIDIMessage{Channel: Channel3, Type: NoteOn, Note: 0, Octave: 4, Velocity: 100},
+		MIDIMessage{Channel: Channel1, Type: NoteOff, Note: 0, Octave: 4},
+		MIDIMessage{Channel: Channel2, Type: NoteOff, Note: 4, Octave: 3},
+		MIDIMessage{Channel: Channel10, Type: NoteOff, Note: 0, Octave: 2},
+	)
+
+	/* SysEx isn't on a channel, so it goes to every port. */
+	sysEx := midi.Message{0xF0, 0x7F, 0x7F, 0x08, 0x02, 0xF7}
+
+	emitter.lock.Lock()
+	emitter.send(sysEx)
+	emitter.lock.Unlock()
+
+	emitter.Close()
+
+	expected := map[string][]midi.Message{
+		"Synth":       {midi.NoteOn(0, 60, 100), midi.NoteOn(1, 52, 90), midi.NoteOff(0, 60), midi.NoteOff(1, 52), sysEx},
+		"Drums":       {midi.NoteOn(0, 36, 127), midi.NoteOff(0, 36), sysEx},
+		defaultDevice: {midi.NoteOn(1, 52, 90), midi.NoteOff(1, 52), sysEx},
+	}
+
+	for device, messages := range expected {
+		if got := sent(driver, device); !reflect.DeepEqual(got, messages) {
+			t.Errorf("%s: got % x, want % x", device, got, messages)
+		}
+	}
+}
+
+func TestRoutingSelectedDevice(t *testing.T) {
+
+	/* A route naming the selected device shares its port instead of opening it again. */
+	emitter, driver := newTestEmitter(t, Routing{{Port: defaultDevice, Channels: []int{1}}, {Port: "Synth", Channels: []int{2}}})
+
+	if names, _ := emitter.GetPortStatus(); !reflect.DeepEqual(names, []string{defaultDevice, "Synth"}) {
+		t.Errorf("got ports %v, want %v", names, []string{defaultDevice, "Synth"})
+	}
+
+	play(emitter, MIDIMessage{Channel: Channel1, Type: NoteOn, Note: 0, Octave: 4, Velocity: 100},
+		MIDIMessage{Channel: Channel2, Type: NoteOn, Note: 0, Octave: 4, Velocity: 100})
+	emitter.Close()
+
+	if got := sent(driver, defaultDevice); !reflect.DeepEqual(got, []midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOff(0, 60)}) {
+		t.Errorf("%s: got % x", defaultDevice, got)
+	}
+}
+
+func TestEnqueueKeepsNoteOffs(t *testing.T) {
+
+	NewOfflineMidi(logging.NewLogger(), &MemorySink{})
+	out := idleOutput("Synth", 1)
+
+	out.enqueue(midi.NoteOn(0, 60, 100))
+
+	/* The queue is full, notes, controls and metric values are dropped but note offs wait for the port to catch up. */
+	out.enqueue(midi.NoteOn(0, 64, 100))
+	out.enqueue(midi.NoteOff(0, 60))
+	out.enqueue(midi.ControlChange(0, 1, 64))
+	out.enqueue(midi.NoteOn(0, 67, 0))
+	out.enqueueMetric(0.5)
+
+	if got := queued(out); !reflect.DeepEqual(got, []midi.Message{midi.NoteOn(0, 60, 100)}) {
+		t.Errorf("got queued % x", got)
+	}
+
+	var kept []midi.Message
+
+	for _, request := range out.takePending() {
+		kept = append(kept, request.message)
+	}
+
+	if expected := []midi.Message{midi.NoteOff(0, 60), midi.NoteOn(0, 67, 0)}; !reflect.DeepEqual(kept, expected) {
+		t.Errorf("got note offs % x, want % x", kept, expected)
+	}
+
+	/* The first drop is logged straight away, the ones after it are counted until the next log. */
+	if out.dropped != 2 {
+		t.Errorf("got %d dropped messages waiting to be logged, want 2", out.dropped)
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+
+	route, err := ParseRoute(" Synth ", "1, 2 10", "10:1, 2:3")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Route{Port: "Synth", Channels: []int{1, 2, 10}, Remap: map[int]int{10: 1, 2: 3}}
+
+	if !reflect.DeepEqual(route, expected) {
+		t.Errorf("got %+v, want %+v", route, expected)
+	}
+
+	if channels, remap := FormatRoute(route); channels != "1, 2, 10" || remap != "2:3, 10:1" {
+		t.Errorf("FormatRoute returned %q, %q", channels, remap)
+	}
+
+	for _, fields := range [][2]string{{"one", ""}, {"17", ""}, {"0", ""}, {"", "10"}, {"", "10:x"}, {"", "10:17"}} {
+		if _, err := ParseRoute("Synth", fields[0], fields[1]); err == nil {
+			t.Errorf("ParseRoute(%q, %q) expected an error", fields[0], fields[1])
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package midioutput
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/synth"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+/*Sink Somewhere MIDI messages are sent, each output port writes to one. */
+type Sink interface {
+	Send(message midi.Message) error
+	Close() error
+}
+
+/*
+SinkConfig Defines the format of the sink config, what the output ports are:
+type	portmidi sends to MIDI devices (the default), memory keeps every message in memory for tests, file writes them to a
+text file (see FileSink), synth plays them on the built-in synth (see SynthSink) and null drops them. Anything but
+portmidi runs without sound hardware.
+path	File the file and synth sinks write to, every port writes to the same file. The synth can stream PCM to stdout (-).
+format	wav or pcm (raw 16-bit little endian mono), what the synth sink writes (wav).
+synth	Sound of the synth, see synth.Config. Also used when rendering offline to WAV.
+*/
+type SinkConfig struct {
+	Type   string       `yaml:"type"`
+	Path   string       `yaml:"path"`
+	Format string       `yaml:"format"`
+	Synth  synth.Config `yaml:"synth"`
+}
+
+/* Types of sink that can be configured. */
+const (
+	PortMIDISinkType = "portmidi"
+	MemorySinkType   = "memory"
+	FileSinkType     = "file"
+	SynthSinkType    = "synth"
+	NullSinkType     = "null"
+)
+
+/*metricSink A sink that also takes the raw metric values, not just MIDI. */
+type metricSink interface {
+	SendMetric(value float64) error
+}
+
+/*sinkDriver Opens the sinks of one type by device name, and lists the devices that can be opened. */
+type sinkDriver interface {
+	open(device string) (Sink, error)
+	present(device string) bool
+	devices() []string
+	close() error
+}
+
+/*Validate Checks the sink config, filling in the default. */
+func (config *SinkConfig) Validate() error {
+
+	switch config.Type {
+	case "":
+		config.Type = PortMIDISinkType
+	case PortMIDISinkType, MemorySinkType, NullSinkType:
+	case FileSinkType, SynthSinkType:
+		if config.Path == "" {
+			return fmt.Errorf("sink.path: the %s sink needs a path", config.Type)
+		}
+	default:
+		return fmt.Errorf("sink.type: %q must be %s, %s, %s, %s or %s", config.Type, PortMIDISinkType, MemorySinkType, FileSinkType, SynthSinkType, NullSinkType)
+	}
+
+	switch config.Format {
+	case "":
+		config.Format = WAVFormat
+	case WAVFormat, PCMFormat:
+	default:
+		return fmt.Errorf("sink.format: %q must be %s or %s", config.Format, WAVFormat, PCMFormat)
+	}
+
+	if config.Type == SynthSinkType && config.Format == WAVFormat && config.Path == "-" {
+		return fmt.Errorf("sink.path: WAV can't be streamed to stdout, use the pcm format")
+	}
+
+	return config.Synth.Validate()
+}
+
+func newSinkDriver(config SinkConfig) sinkDriver {
+
+	switch config.Type {
+	case MemorySinkType:
+		return &memoryDriver{sinks: make(map[string]*MemorySink)}
+	case FileSinkType:
+		return &fileDriver{path: config.Path}
+	case SynthSinkType:
+		return &synthDriver{config: config}
+	case NullSinkType:
+		return nullDriver{}
+	}
+
+	return portMIDIDriver{}
+}
+
+/*MultiSink Sends every message to each of its sinks, such as a FileSink and a SynthSink together when rendering offline. */
+type MultiSink []Sink
+
+/*Send Sends the message to every sink, returning the first error. */
+func (sinks MultiSink) Send(message midi.Message) error {
+
+	var first error
+
+	for _, sink := range sinks {
+		if err := sink.Send(message); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+/*Close Closes every sink, returning the first error. */
+func (sinks MultiSink) Close() error {
+
+	var first error
+
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+/*sharedSink A port writing to a sink shared by every port, closing it leaves the sink open for the others. */
+type sharedSink struct {
+	Sink
+}
+
+func (sharedSink) Close() error {
+	return nil
+}
+
+/*MemorySink Keeps every message sent to it, so the whole pipeline can be checked without a MIDI device. */
+type MemorySink struct {
+	messages []midi.Message
+	lock     sync.Mutex
+}
+
+/*Send Stores a copy of the message. */
+func (sink *MemorySink) Send(message midi.Message) error {
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	sink.messages = append(sink.messages, append(midi.Message{}, message...))
+
+	return nil
+}
+
+/*Close Does nothing, the messages are kept after the port closes. */
+func (sink *MemorySink) Close() error {
+	return nil
+}
+
+/*Messages Returns a copy of the messages sent so far. */
+func (sink *MemorySink) Messages() []midi.Message {
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	return append([]midi.Message{}, sink.messages...)
+}
+
+/*Reset Forgets the messages sent so far. */
+func (sink *MemorySink) Reset() {
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	sink.messages = nil
+}
+
+/*memoryDriver Keeps one memory sink per device, which is reused if the device is reopened. */
+type memoryDriver struct {
+	sinks map[string]*MemorySink
+	lock  sync.Mutex
+}
+
+func (driver *memoryDriver) open(device string) (Sink, error) {
+
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+
+	if _, exists := driver.sinks[device]; !exists {
+		driver.sinks[device] = &MemorySink{}
+	}
+
+	return driver.sinks[device], nil
+}
+
+func (driver *memoryDriver) present(device string) bool {
+	return true
+}
+
+func (driver *memoryDriver) devices() []string {
+
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+
+	names := make([]string, 0, len(driver.sinks))
+
+	for name := range driver.sinks {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func (driver *memoryDriver) close() error {
+	return nil
+}
+
+/*nullSink Drops every message. */
+type nullSink struct{}
+
+func (nullSink) Send(message midi.Message) error {
+	return nil
+}
+
+func (nullSink) Close() error {
+	return nil
+}
+
+type nullDriver struct{}
+
+func (nullDriver) open(device string) (Sink, error) {
+	return nullSink{}, nil
+}
+
+func (nullDriver) present(device string) bool {
+	return true
+}
+
+func (nullDriver) devices() []string {
+	return nil
+}
+
+func (nullDriver) close() error {
+	return nil
+}
@@ -0,0 +1,237 @@
+package midioutput
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/synth"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+/* Audio formats the synth sink writes. */
+const (
+	WAVFormat = "wav"
+	PCMFormat = "pcm"
+)
+
+/* How often a realtime synth sink renders up to the present, short enough for a player to never run dry. */
+const synthRenderInterval = 20 * time.Millisecond
+
+/* Longest the release tail rendered when the sink closes can be, in case a note never finishes. */
+const maxSynthTail = 10 * time.Second
+
+/*
+SynthSink Plays MIDI messages on the built-in synth and writes the audio to a WAV file, or streams it as raw PCM. The
+audio up to the time of each message is rendered before the message is played. Like the FileSink the time is set by
+whoever drives the emitter for offline rendering, as a configured sink the audio is rendered in real time.
+*/
+type SynthSink struct {
+	synth    *synth.Synth
+	writer   synth.Writer
+	file     *os.File
+	time     time.Duration
+	rendered int64
+	clock    func() time.Duration
+	stop     chan struct{}
+	lock     sync.Mutex
+}
+
+/*
+NewSynthSink Loads the SoundFont when one is configured and creates (or truncates) the file the audio is written to.
+PCM can be streamed to stdout with a path of -.
+*/
+func NewSynthSink(path string, format string, config synth.Config) (*SynthSink, error) {
+
+	sink := &SynthSink{synth: synth.New(config)}
+	sink.clock = func() time.Duration { return sink.time }
+
+	if config.SoundFont != "" {
+
+		if err := sink.synth.LoadSoundFont(config.SoundFont); err != nil {
+			return nil, fmt.Errorf("soundfont %s: %v", config.SoundFont, err)
+		}
+	}
+
+	if format == PCMFormat && path == "-" {
+		sink.writer = synth.NewPCMWriter(os.Stdout)
+		return sink, nil
+	}
+
+	file, err := os.Create(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sink.file = file
+	sink.writer = synth.NewPCMWriter(file)
+
+	if format != PCMFormat {
+
+		if sink.writer, err = synth.NewWAVWriter(file, config.SampleRate); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return sink, nil
+}
+
+/*startRealtime Renders against the clock from now on, keeping the audio up to date between messages. */
+func (sink *SynthSink) startRealtime() {
+
+	start := time.Now()
+	sink.clock = func() time.Duration { return time.Since(start) }
+	sink.stop = make(chan struct{})
+
+	go func() {
+
+		ticker := time.NewTicker(synthRenderInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				sink.lock.Lock()
+				sink.advance(sink.clock())
+				sink.lock.Unlock()
+			case <-sink.stop:
+				return
+			}
+		}
+	}()
+}
+
+/*SetTime Sets the time the following messages are played at. */
+func (sink *SynthSink) SetTime(at time.Duration) {
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	sink.time = at
+}
+
+/*Send Renders the audio up to now and plays the message. */
+func (sink *SynthSink) Send(message midi.Message) error {
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	if err := sink.advance(sink.clock()); err != nil {
+		return err
+	}
+
+	sink.synth.Handle(message)
+
+	return nil
+}
+
+/*advance Renders the audio up to a point in time, a second at a time. */
+func (sink *SynthSink) advance(at time.Duration) error {
+
+	rate := int64(sink.synth.SampleRate())
+	target := int64(at.Seconds() * float64(rate))
+
+	for sink.rendered < target {
+
+		count := target - sink.rendered
+
+		if count > rate {
+			count = rate
+		}
+
+		if err := sink.writer.Write(sink.synth.Render(int(count))); err != nil {
+			return err
+		}
+
+		sink.rendered += count
+	}
+
+	return nil
+}
+
+/*Close Renders the release of the notes still sounding and finishes the file. */
+func (sink *SynthSink) Close() error {
+
+	if sink.stop != nil {
+		close(sink.stop)
+	}
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	rate := sink.synth.SampleRate()
+	tail := 0
+
+	for !sink.synth.Silent() && tail < int(maxSynthTail.Seconds())*rate {
+
+		if err := sink.writer.Write(sink.synth.Render(rate / 10)); err != nil {
+			return err
+		}
+
+		tail += rate / 10
+	}
+
+	err := sink.writer.Close()
+
+	if sink.file != nil {
+
+		if closeErr := sink.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+/*synthDriver Starts the synth on the first port, every port then plays on it until the emitter is closed. */
+type synthDriver struct {
+	config SinkConfig
+	sink   *SynthSink
+	lock   sync.Mutex
+}
+
+func (driver *synthDriver) open(device string) (Sink, error) {
+
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+
+	if driver.sink == nil {
+
+		sink, err := NewSynthSink(driver.config.Path, driver.config.Format, driver.config.Synth)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sink.startRealtime()
+		driver.sink = sink
+	}
+
+	return sharedSink{driver.sink}, nil
+}
+
+func (driver *synthDriver) present(device string) bool {
+	return true
+}
+
+func (driver *synthDriver) devices() []string {
+	return []string{"Synth"}
+}
+
+func (driver *synthDriver) close() error {
+
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+
+	if driver.sink == nil {
+		return nil
+	}
+
+	err := driver.sink.Close()
+	driver.sink = nil
+
+	return err
+}
@@ -0,0 +1,108 @@
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+/*Message An OSC message, arguments can be int32, float32 or string. */
+type Message struct {
+	Address   string
+	Arguments []interface{}
+}
+
+/*Bundle Messages to be acted on together at the time of the timetag. A zero time means immediately. */
+type Bundle struct {
+	Time     time.Time
+	Messages []Message
+}
+
+/* Seconds between the NTP epoch (1900) that timetags count from and the Unix epoch. */
+const ntpEpochOffset = 2208988800
+
+/* The timetag with the special meaning of immediately. */
+const immediately = 1
+
+/*MarshalBinary Encodes the message, the type tag string is built from the arguments. */
+func (message Message) MarshalBinary() ([]byte, error) {
+
+	var data bytes.Buffer
+	tags := ","
+
+	for _, argument := range message.Arguments {
+
+		switch argument.(type) {
+		case int32:
+			tags += "i"
+		case float32:
+			tags += "f"
+		case string:
+			tags += "s"
+		default:
+			return nil, fmt.Errorf("osc: unsupported argument type %T", argument)
+		}
+	}
+
+	writeString(&data, message.Address)
+	writeString(&data, tags)
+
+	for _, argument := range message.Arguments {
+
+		switch value := argument.(type) {
+		case int32:
+			binary.Write(&data, binary.BigEndian, value)
+		case float32:
+			binary.Write(&data, binary.BigEndian, math.Float32bits(value))
+		case string:
+			writeString(&data, value)
+		}
+	}
+
+	return data.Bytes(), nil
+}
+
+/*MarshalBinary Encodes the bundle, each message is preceded by its size. */
+func (bundle Bundle) MarshalBinary() ([]byte, error) {
+
+	var data bytes.Buffer
+
+	writeString(&data, "#bundle")
+	binary.Write(&data, binary.BigEndian, Timetag(bundle.Time))
+
+	for _, message := range bundle.Messages {
+
+		encoded, err := message.MarshalBinary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		binary.Write(&data, binary.BigEndian, int32(len(encoded)))
+		data.Write(encoded)
+	}
+
+	return data.Bytes(), nil
+}
+
+/*Timetag Converts a time into an NTP timestamp, the top 32 bits are seconds and the bottom 32 the fraction of a second. */
+func Timetag(at time.Time) uint64 {
+
+	if at.IsZero() {
+		return immediately
+	}
+
+	seconds := uint64(at.Unix() + ntpEpochOffset)
+	fraction := uint64(at.Nanosecond()) << 32 / uint64(time.Second)
+
+	return seconds<<32 | fraction
+}
+
+/*writeString Writes a string null terminated and padded to a multiple of 4 bytes. */
+func writeString(data *bytes.Buffer, value string) {
+
+	data.WriteString(value)
+	data.Write(make([]byte, 4-len(value)%4))
+}
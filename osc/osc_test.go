@@ -0,0 +1,106 @@
+package osc
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMessageMarshalBinary(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		message Message
+		encoded []byte
+	}{
+		{"no arguments", Message{Address: "/ping"}, []byte("/ping\x00\x00\x00,\x00\x00\x00")},
+		{"address padded to 4 bytes", Message{Address: "/abc"}, []byte("/abc\x00\x00\x00\x00,\x00\x00\x00")},
+		{"int", Message{Address: "/note", Arguments: []interface{}{int32(60)}},
+			[]byte("/note\x00\x00\x00,i\x00\x00\x00\x00\x00\x3C")},
+		{"negative int", Message{Address: "/a", Arguments: []interface{}{int32(-2)}},
+			[]byte("/a\x00\x00,i\x00\x00\xFF\xFF\xFF\xFE")},
+		{"float", Message{Address: "/a", Arguments: []interface{}{float32(0.5)}},
+			[]byte("/a\x00\x00,f\x00\x00\x3F\x00\x00\x00")},
+		{"string", Message{Address: "/a", Arguments: []interface{}{"hello"}},
+			[]byte("/a\x00\x00,s\x00\x00hello\x00\x00\x00")},
+		{"mixed", Message{Address: "/midi/note", Arguments: []interface{}{int32(1), float32(-1), "on"}},
+			[]byte("/midi/note\x00\x00,ifs\x00\x00\x00\x00\x00\x00\x00\x01\xBF\x80\x00\x00on\x00\x00")},
+	}
+
+	for _, test := range tests {
+
+		encoded, err := test.message.MarshalBinary()
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("%s: got % x, want % x", test.name, encoded, test.encoded)
+		}
+
+		if len(encoded)%4 != 0 {
+			t.Errorf("%s: length %d isn't a multiple of 4", test.name, len(encoded))
+		}
+	}
+}
+
+func TestMessageMarshalBinaryUnsupported(t *testing.T) {
+
+	for _, argument := range []interface{}{1, int64(1), 1.5, true, nil} {
+
+		message := Message{Address: "/a", Arguments: []interface{}{argument}}
+
+		if _, err := message.MarshalBinary(); err == nil {
+			t.Errorf("%T argument: expected an error", argument)
+		}
+	}
+}
+
+func TestTimetag(t *testing.T) {
+
+	tests := []struct {
+		at      time.Time
+		timetag uint64
+	}{
+		{time.Time{}, immediately},
+		{time.Unix(0, 0), ntpEpochOffset << 32},
+		{time.Unix(1, int64(500*time.Millisecond)), (ntpEpochOffset+1)<<32 | 1<<31},
+		{time.Unix(1600000000, int64(250*time.Millisecond)), (ntpEpochOffset+1600000000)<<32 | 1<<30},
+	}
+
+	for _, test := range tests {
+		if timetag := Timetag(test.at); timetag != test.timetag {
+			t.Errorf("Timetag(%v) = %#x, want %#x", test.at, timetag, test.timetag)
+		}
+	}
+}
+
+func TestBundleMarshalBinary(t *testing.T) {
+
+	bundle := Bundle{Time: time.Unix(0, 0), Messages: []Message{
+		{Address: "/a", Arguments: []interface{}{int32(7)}},
+		{Address: "/bc"},
+	}}
+
+	encoded, err := bundle.MarshalBinary()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte("#bundle\x00\x83\xAA\x7E\x80\x00\x00\x00\x00" +
+		"\x00\x00\x00\x0C/a\x00\x00,i\x00\x00\x00\x00\x00\x07" +
+		"\x00\x00\x00\x08/bc\x00,\x00\x00\x00")
+
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("got % x, want % x", encoded, expected)
+	}
+
+	bundle.Messages = append(bundle.Messages, Message{Address: "/bad", Arguments: []interface{}{true}})
+
+	if _, err := bundle.MarshalBinary(); err == nil {
+		t.Errorf("expected an error for a message that can't be encoded")
+	}
+}
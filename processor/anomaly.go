@@ -0,0 +1,347 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+)
+
+/*
+Anomalies Defines the format of the anomaly config. Every detector watches the incoming samples and while any of them
+fires the response is applied, easing back over a number of samples once the metric recovers:
+detectors	The detectors to run, see AnomalyDetector.
+response	What the music does during an anomaly, see AnomalyResponse.
+*/
+type Anomalies struct {
+	Detectors []AnomalyDetector `yaml:"detectors"`
+	Response  AnomalyResponse   `yaml:"response"`
+}
+
+/*
+AnomalyDetector Defines the format of a detector config:
+type		zscore, ewma, seasonal or rate_of_change.
+threshold	How far from normal a sample has to be to fire, see the detector types below for the units.
+alpha		Smoothing of the ewma detector (0-1), higher values follow the metric more closely.
+*/
+type AnomalyDetector struct {
+	Type      string  `yaml:"type"`
+	Threshold float64 `yaml:"threshold"`
+	Alpha     float64 `yaml:"alpha"`
+}
+
+/*
+AnomalyResponse Defines the format of the response config:
+scale		Scale switched to during an anomaly, something dissonant works best. Left empty the scale doesn't change.
+accent		Plays an accented diminished chord when an anomaly starts.
+bpm			Added to the tempo during an anomaly (can be negative).
+fill		Plays a drum fill when an anomaly starts, even with the drum track off.
+recovery	Number of normal samples it takes for the response to ease back.
+*/
+type AnomalyResponse struct {
+	Scale    string `yaml:"scale"`
+	Accent   bool   `yaml:"accent"`
+	BPM      int    `yaml:"bpm"`
+	Fill     bool   `yaml:"fill"`
+	Recovery int    `yaml:"recovery"`
+}
+
+/*detectorType Defines the different ways an anomaly can be detected. */
+type detectorType int
+
+var detectorTypesStr = []string{"zscore", "ewma", "seasonal", "rate_of_change"}
+
+/*
+zScoreDetector		Standard deviations from the mean of the recent values.
+ewmaDetector		Standard deviations from an exponentially weighted moving average, using a weighted variance for the bands.
+seasonalDetector	Relative difference from the baseline, the value of the metric at the same time last week.
+rateDetector		Change from the last value as a multiple of the average change between the recent values.
+*/
+const (
+	zScoreDetector   detectorType = 0
+	ewmaDetector     detectorType = 1
+	seasonalDetector detectorType = 2
+	rateDetector     detectorType = 3
+)
+
+/* Defaults for each detector type, in the same order as the types. */
+var defaultThresholds = []float64{3, 3, 0.5, 4}
+
+const defaultEWMAAlpha = 0.3
+const defaultRecovery = 4
+
+/* Detectors that learn from the recent values stay quiet until they have seen this many. */
+const minAnomalySamples = 5
+
+const anomalyAccentVelocity = 127
+
+/*anomalyDetector Parsed version of a detector config, plus the running state of the ewma detector. */
+type anomalyDetector struct {
+	detectorType detectorType
+	threshold    float64
+	alpha        float64
+	mean         float64
+	variance     float64
+	samples      int
+}
+
+/*anomalyMonitor Runs the detectors and tracks the response to the current anomaly. */
+type anomalyMonitor struct {
+	enabled       bool
+	detectors     []*anomalyDetector
+	response      AnomalyResponse
+	baseline      float64
+	hasBaseline   bool
+	anomalous     bool
+	intensity     float64
+	normalSamples int
+	previousScale string
+}
+
+/*validate Checks the anomaly config, filling in the defaults. Scales are checked against the ones in the config. */
+func (config *Anomalies) validate(scaleNames map[string]bool) error {
+
+	for i := range config.Detectors {
+
+		detector := &config.Detectors[i]
+
+		if !contains(detectorTypesStr, detector.Type) {
+			return fmt.Errorf("processor_config.anomalies.detectors[%d].type: %q is not a detector, expected one of %v", i, detector.Type, detectorTypesStr)
+		}
+
+		if detector.Threshold < 0 {
+			return fmt.Errorf("processor_config.anomalies.detectors[%d].threshold: %f can't be negative", i, detector.Threshold)
+		}
+
+		if detector.Alpha < 0 || detector.Alpha > 1 {
+			return fmt.Errorf("processor_config.anomalies.detectors[%d].alpha: %f must be between 0 and 1", i, detector.Alpha)
+		}
+	}
+
+	if config.Response.Scale != "" && !scaleNames[config.Response.Scale] {
+		return fmt.Errorf("processor_config.anomalies.response.scale: no scale named %q", config.Response.Scale)
+	}
+
+	if config.Response.Recovery == 0 {
+		config.Response.Recovery = defaultRecovery
+	}
+
+	if config.Response.Recovery < 1 {
+		return fmt.Errorf("processor_config.anomalies.response.recovery: %d must be at least 1", config.Response.Recovery)
+	}
+
+	return nil
+}
+
+func newAnomalyMonitor(config Anomalies) *anomalyMonitor {
+
+	monitor := &anomalyMonitor{enabled: len(config.Detectors) > 0, response: config.Response}
+
+	for _, detectorConfig := range config.Detectors {
+
+		detector := &anomalyDetector{threshold: detectorConfig.Threshold, alpha: detectorConfig.Alpha}
+
+		for i, name := range detectorTypesStr {
+			if name == detectorConfig.Type {
+				detector.detectorType = detectorType(i)
+			}
+		}
+
+		if detector.threshold == 0 {
+			detector.threshold = defaultThresholds[detector.detectorType]
+		}
+
+		if detector.alpha == 0 {
+			detector.alpha = defaultEWMAAlpha
+		}
+
+		monitor.detectors = append(monitor.detectors, detector)
+	}
+
+	return monitor
+}
+
+/*check Returns true if the value is anomalous, previous holds the recent values, most recent first. */
+func (detector *anomalyDetector) check(value float64, previous []float64, monitor *anomalyMonitor) bool {
+
+	switch detector.detectorType {
+
+	case zScoreDetector:
+
+		if len(previous) < minAnomalySamples {
+			return false
+		}
+
+		mean, deviation := meanAndDeviation(previous)
+
+		return deviation > 0 && math.Abs(value-mean)/deviation > detector.threshold
+
+	case ewmaDetector:
+
+		/* The sample is checked against the bands before it is added to the average. */
+		deviation := value - detector.mean
+		fired := detector.samples >= minAnomalySamples && detector.variance > 0 &&
+			math.Abs(deviation) > detector.threshold*math.Sqrt(detector.variance)
+
+		if detector.samples == 0 {
+			detector.mean = value
+		} else {
+			detector.mean += detector.alpha * deviation
+			detector.variance = (1 - detector.alpha) * (detector.variance + detector.alpha*deviation*deviation)
+		}
+
+		detector.samples++
+
+		return fired
+
+	case seasonalDetector:
+
+		if !monitor.hasBaseline {
+			return false
+		}
+
+		return math.Abs(value-monitor.baseline)/math.Max(math.Abs(monitor.baseline), 1e-9) > detector.threshold
+
+	case rateDetector:
+
+		if len(previous) < minAnomalySamples {
+			return false
+		}
+
+		change := 0.0
+
+		for i := 1; i < len(previous); i++ {
+			change += math.Abs(previous[i-1] - previous[i])
+		}
+
+		change /= float64(len(previous) - 1)
+
+		return change > 0 && math.Abs(value-previous[0])/change > detector.threshold
+	}
+
+	return false
+}
+
+/*usesBaseline Returns true if a detector needs the value of the metric from last week. */
+func (monitor *anomalyMonitor) usesBaseline() bool {
+
+	for _, detector := range monitor.detectors {
+		if detector.detectorType == seasonalDetector {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+detectAnomalies Runs every detector against a new sample. The response starts as soon as one fires, and its intensity
+eases back over the recovery samples once none of them do, which is when the scale is switched back.
+*/
+func (processor *ProcInfo) detectAnomalies(value float64) {
+
+	monitor := processor.anomalies
+	previous := listValues(processor.previousValues)
+	var fired []string
+
+	/* Every detector sees every sample, so the ewma keeps tracking even while another one has fired. */
+	for _, detector := range monitor.detectors {
+		if detector.check(value, previous, monitor) {
+			fired = append(fired, detectorTypesStr[detector.detectorType])
+		}
+	}
+
+	if !monitor.enabled {
+		return
+	}
+
+	if len(fired) > 0 {
+
+		if !monitor.anomalous {
+			log.Printf("Anomaly detected by %v (value %f)\n", fired, value)
+			processor.startAnomalyResponse(value)
+		}
+
+		monitor.anomalous = true
+		monitor.intensity = 1
+		monitor.normalSamples = 0
+
+		return
+	}
+
+	if !monitor.anomalous {
+		return
+	}
+
+	monitor.normalSamples++
+	monitor.intensity = 1 - float64(monitor.normalSamples)/float64(monitor.response.Recovery)
+
+	if monitor.normalSamples >= monitor.response.Recovery {
+		log.Printf("Metric recovered (value %f)\n", value)
+		processor.endAnomalyResponse()
+	}
+}
+
+/*startAnomalyResponse Switches to the anomaly scale and plays the accent chord and drum fill. */
+func (processor *ProcInfo) startAnomalyResponse(value float64) {
+
+	response := processor.anomalies.response
+
+	if response.Scale != "" && response.Scale != processor.activeScale.name {
+		processor.anomalies.previousScale = processor.activeScale.name
+		processor.setScale(response.Scale)
+	}
+
+	if response.Accent {
+		chord := processor.buildChord(processor.degreeOfValue(value), diminishedSeventh)
+		processor.sendChordEvent(ChordTrack, chord, value, anomalyAccentVelocity, processor.chordOctave, processor.chordChannel)
+	}
+
+	if response.Fill {
+		processor.drums.startFill(processor.tickCount)
+	}
+}
+
+/*endAnomalyResponse Puts back the scale that was playing before the anomaly, unless the scale has been changed since. */
+func (processor *ProcInfo) endAnomalyResponse() {
+
+	monitor := processor.anomalies
+
+	if monitor.previousScale != "" && processor.activeScale.name == monitor.response.Scale {
+		processor.setScale(monitor.previousScale)
+	}
+
+	monitor.previousScale = ""
+
+	monitor.anomalous = false
+	monitor.intensity = 0
+	monitor.normalSamples = 0
+}
+
+/*setAnomalies Turns the anomaly responses on or off, turning them off ends the current response straight away. */
+func (processor *ProcInfo) setAnomalies(enabled bool) {
+
+	processor.anomalies.enabled = enabled
+
+	if !enabled && processor.anomalies.anomalous {
+		processor.endAnomalyResponse()
+	}
+}
+
+/*setBaseline Stores the latest value of the metric from the same time last week. */
+func (processor *ProcInfo) setBaseline(value float64) {
+
+	processor.anomalies.baseline = value
+	processor.anomalies.hasBaseline = true
+}
+
+/*currentBPM Returns the tempo including the change made by an anomaly response. */
+func (processor *ProcInfo) currentBPM() float64 {
+
+	bpm := processor.BPM + float64(processor.anomalies.response.BPM)*processor.anomalies.intensity
+
+	return clamp(bpm, minBPM, maxBPM)
+}
+
+/*UsesBaseline Returns true if the anomaly detectors need the metric from the same time last week fed in. */
+func (processor *ProcInfo) UsesBaseline() bool {
+	return processor.anomalies.usesBaseline()
+}
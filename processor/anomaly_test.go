@@ -0,0 +1,108 @@
+package processor
+
+import "testing"
+
+func TestDetectors(t *testing.T) {
+
+	steady := []float64{10, 11, 10, 11, 10, 11}
+
+	tests := []struct {
+		name     string
+		detector string
+		baseline float64
+		value    float64
+		fired    bool
+	}{
+		{"zscore normal", "zscore", 0, 10.5, false},
+		{"zscore spike", "zscore", 0, 30, true},
+		{"ewma normal", "ewma", 0, 10.5, false},
+		{"ewma spike", "ewma", 0, 30, true},
+		{"seasonal normal", "seasonal", 10, 12, false},
+		{"seasonal spike", "seasonal", 10, 30, true},
+		{"rate of change normal", "rate_of_change", 0, 11, false},
+		{"rate of change spike", "rate_of_change", 0, 30, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			monitor := newAnomalyMonitor(Anomalies{Detectors: []AnomalyDetector{{Type: test.detector}}})
+			monitor.baseline, monitor.hasBaseline = test.baseline, test.baseline != 0
+			detector := monitor.detectors[0]
+
+			var previous []float64
+
+			for _, value := range steady {
+				if detector.check(value, previous, monitor) {
+					t.Fatalf("fired on the steady value %f", value)
+				}
+				previous = append([]float64{value}, previous...)
+			}
+
+			if fired := detector.check(test.value, previous, monitor); fired != test.fired {
+				t.Errorf("check(%f) = %v, want %v", test.value, fired, test.fired)
+			}
+		})
+	}
+}
+
+/*newAnomalyProcessor Builds a processor that switches to the Locrian scale when the zscore detector fires. */
+func newAnomalyProcessor() *ProcInfo {
+
+	scales := append([]Scale{}, testScales...)
+	scales = append(scales, Scale{Name: "Locrian", Intervals: []int{1, 2, 2, 1, 2, 2, 2}})
+
+	return newConfiguredProcessor(Config{Scales: scales, Anomalies: Anomalies{
+		Detectors: []AnomalyDetector{{Type: "zscore"}},
+		Response:  AnomalyResponse{Scale: "Locrian", BPM: 40, Recovery: 2},
+	}})
+}
+
+func TestAnomalyResponse(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		setScale string
+		expected string
+	}{
+		{"scale restored", "", "Chromatic"},
+		{"scale changed during the anomaly", "Ionian", "Ionian"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newAnomalyProcessor()
+
+			for _, value := range []float64{10, 11, 10, 11, 10, 11} {
+				processor.processMessage(value)
+			}
+
+			processor.processMessage(40)
+
+			if processor.activeScale.name != "Locrian" || processor.currentBPM() != processor.BPM+40 {
+				t.Fatalf("anomaly response not applied, scale %s at %f BPM", processor.activeScale.name, processor.currentBPM())
+			}
+
+			if test.setScale != "" {
+				processor.setScale(test.setScale)
+			}
+
+			processor.processMessage(11)
+
+			if !processor.anomalies.anomalous || processor.currentBPM() != processor.BPM+20 {
+				t.Errorf("expected the response to be half way through easing back, got %f BPM", processor.currentBPM())
+			}
+
+			processor.processMessage(10)
+
+			if processor.anomalies.anomalous || processor.currentBPM() != processor.BPM {
+				t.Errorf("expected the response to have ended, got %f BPM", processor.currentBPM())
+			}
+
+			if processor.activeScale.name != test.expected {
+				t.Errorf("got scale %s after recovery, want %s", processor.activeScale.name, test.expected)
+			}
+		})
+	}
+}
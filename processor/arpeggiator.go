@@ -0,0 +1,199 @@
+package processor
+
+import (
+	"math/bits"
+	"sort"
+)
+
+/*arpPattern Defines the order the arpeggiator plays the notes of a chord in. */
+type arpPattern int
+
+var arpPatternsStr = []string{"Off", "Up", "Down", "Up Down", "Random", "As Played", "Binary"}
+
+/*
+The patterns the arpeggiator can use:
+up/down		Notes are played from lowest to highest (or highest to lowest) across the octave range.
+upDown		Notes go up and then back down again without repeating the top and bottom notes.
+random		A random note from the chord is picked on every step.
+asPlayed	Notes are played in the order the chord was voiced.
+binary		The bits of the metric value decide which steps play, a set bit plays the next note and a clear bit rests.
+*/
+const (
+	arpOff      arpPattern = 0
+	arpUp       arpPattern = 1
+	arpDown     arpPattern = 2
+	arpUpDown   arpPattern = 3
+	arpRandom   arpPattern = 4
+	arpAsPlayed arpPattern = 5
+	arpBinary   arpPattern = 6
+)
+
+/* Arpeggiator rates and the number of sequencer ticks each one lasts for. */
+var arpRatesStr = []string{"1/4", "1/8", "1/16"}
+var arpRateTicks = []int{defaultTicksPerBeat, defaultTicksPerBeat / 2, defaultTicksPerBeat / 4}
+
+const maxArpOctaves = 4
+const defaultArpGate = 50
+
+/*arpeggiator Holds the settings of the arpeggiator and the notes it is currently stepping through. */
+type arpeggiator struct {
+	pattern     arpPattern
+	rate        int
+	octaves     int
+	gate        int
+	sequence    []int
+	steps       int
+	position    int
+	value       uint64
+	velocity    int64
+	octave      int
+	midiChannel int
+	track       Track
+}
+
+func newArpeggiator() *arpeggiator {
+	return &arpeggiator{pattern: arpOff, rate: arpRateTicks[1], octaves: 1, gate: defaultArpGate}
+}
+
+/*seed Replaces the notes being arpeggiated, called with the chord (or melody note) of the track for each new metric sample. */
+func (arp *arpeggiator) seed(track Track, tones []int, period int, value float64, velocity int64, octave int, midiChannel int) {
+
+	played := make([]int, 0, len(tones)*arp.octaves)
+
+	for o := 0; o < arp.octaves; o++ {
+		for _, tone := range tones {
+			played = append(played, tone+o*period)
+		}
+	}
+
+	ascending := append([]int{}, played...)
+	sort.Ints(ascending)
+
+	switch arp.pattern {
+
+	case arpDown:
+		for i, j := 0, len(ascending)-1; i < j; i, j = i+1, j-1 {
+			ascending[i], ascending[j] = ascending[j], ascending[i]
+		}
+		arp.sequence = ascending
+
+	case arpUpDown:
+		arp.sequence = ascending
+		for i := len(ascending) - 2; i > 0; i-- {
+			arp.sequence = append(arp.sequence, ascending[i])
+		}
+
+	case arpAsPlayed:
+		arp.sequence = played
+
+	default:
+		arp.sequence = ascending
+	}
+
+	arp.steps = len(arp.sequence)
+	arp.value = 0
+
+	/* The binary pattern steps through every bit of the value, so 0b1011 gives note, note, rest, note. */
+	if arp.pattern == arpBinary && value >= 1 {
+		arp.value = uint64(value)
+		arp.steps = bits.Len64(arp.value)
+	}
+
+	arp.position = 0
+	arp.velocity = velocity
+	arp.octave = octave
+	arp.midiChannel = midiChannel
+	arp.track = track
+}
+
+/*clear Drops the chord being arpeggiated, so starting again waits for a new chord rather than resuming the old one. */
+func (arp *arpeggiator) clear() {
+	arp.sequence = nil
+}
+
+/*stepArpeggiator Called on every sequencer tick, pushes the next note of the arpeggio into the sequencer when a step is due. */
+func (processor *ProcInfo) stepArpeggiator() {
+
+	arp := processor.arp
+
+	if arp.pattern == arpOff || len(arp.sequence) == 0 || processor.tickCount%arp.rate != 0 {
+		return
+	}
+
+	step := arp.position % arp.steps
+	arp.position++
+
+	var tone int
+
+	switch arp.pattern {
+
+	case arpRandom:
+		tone = arp.sequence[processor.random.Intn(len(arp.sequence))]
+
+	case arpBinary:
+		if arp.value != 0 && arp.value&(1<<uint(step)) == 0 {
+			return
+		}
+		tone = arp.sequence[step%len(arp.sequence)]
+
+	default:
+		tone = arp.sequence[step]
+	}
+
+	duration := arp.rate * arp.gate / 100
+
+	if duration < 1 {
+		duration = 1
+	}
+
+	processor.insertEvent(event{eventType: note, track: arp.track, state: ready, duration: duration, value: tone,
+		octave: arp.octave, velocity: arp.velocity, midiChannel: arp.midiChannel})
+}
+
+/*setPattern Changes the arpeggiator pattern, the new pattern is picked up when the next chord is seeded. */
+func (arp *arpeggiator) setPattern(name string) {
+
+	for i, pattern := range arpPatternsStr {
+		if pattern == name {
+			arp.pattern = arpPattern(i)
+			arp.sequence = nil
+		}
+	}
+}
+
+func (arp *arpeggiator) setRate(name string) {
+
+	for i, rate := range arpRatesStr {
+		if rate == name {
+			arp.rate = arpRateTicks[i]
+		}
+	}
+}
+
+func (arp *arpeggiator) setOctaves(octaves int) {
+
+	if octaves >= 1 && octaves <= maxArpOctaves {
+		arp.octaves = octaves
+	} else {
+		log.Printf("Invalid arpeggiator octave range (%d).\n", octaves)
+	}
+}
+
+func (arp *arpeggiator) setGate(gate int) {
+
+	if gate >= 1 && gate <= 100 {
+		arp.gate = gate
+	} else {
+		log.Printf("Invalid arpeggiator gate length (%d%%).\n", gate)
+	}
+}
+
+/*GetArpPatterns Returns an array of arpeggiator pattern names for the front end. */
+func (processor *ProcInfo) GetArpPatterns() []string {
+	return arpPatternsStr
+}
+
+/*GetArpRates Returns an array of arpeggiator rates for the front end. */
+func (processor *ProcInfo) GetArpRates() []string {
+	return arpRatesStr
+}
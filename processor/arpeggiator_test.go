@@ -0,0 +1,161 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeedSequence(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		pattern arpPattern
+		octaves int
+		tones   []int
+		value   float64
+		want    []int
+		steps   int
+	}{
+		{"up", arpUp, 1, []int{7, 0, 4}, 0, []int{0, 4, 7}, 3},
+		{"up two octaves", arpUp, 2, []int{0, 4, 7}, 0, []int{0, 4, 7, 12, 16, 19}, 6},
+		{"down", arpDown, 1, []int{0, 4, 7}, 0, []int{7, 4, 0}, 3},
+		{"up down skips the ends", arpUpDown, 1, []int{0, 4, 7, 11}, 0, []int{0, 4, 7, 11, 7, 4}, 6},
+		{"as played", arpAsPlayed, 1, []int{7, 0, 4}, 0, []int{7, 0, 4}, 3},
+		{"binary steps through the bits", arpBinary, 1, []int{0, 4, 7}, 11, []int{0, 4, 7}, 4},
+		{"binary below one plays every note", arpBinary, 1, []int{0, 4, 7}, 0.5, []int{0, 4, 7}, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			arp := newArpeggiator()
+			arp.pattern = test.pattern
+			arp.octaves = test.octaves
+
+			arp.seed(ChordTrack, test.tones, 12, test.value, 100, 3, 2)
+
+			if !reflect.DeepEqual(arp.sequence, test.want) {
+				t.Errorf("sequence = %v, want %v", arp.sequence, test.want)
+			}
+			if arp.steps != test.steps {
+				t.Errorf("steps = %d, want %d", arp.steps, test.steps)
+			}
+		})
+	}
+}
+
+/*arpeggiate Runs the arpeggiator for a number of ticks and returns the notes it queued, a rest is recorded as -1. */
+func arpeggiate(processor *ProcInfo, ticks int) []int {
+
+	var played []int
+
+	for tick := 0; tick < ticks; tick++ {
+
+		processor.tickCount = tick
+		processor.stepArpeggiator()
+
+		if tick%processor.arp.rate != 0 {
+			continue
+		}
+
+		queued := -1
+
+		for i, e := range processor.events {
+			if (event{}) != e {
+				queued = e.value
+				processor.events[i] = event{}
+			}
+		}
+
+		played = append(played, queued)
+	}
+
+	return played
+}
+
+func TestStepArpeggiator(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		pattern arpPattern
+		value   float64
+		want    []int
+	}{
+		{"up wraps around", arpUp, 0, []int{0, 4, 7, 0, 4}},
+		{"down", arpDown, 0, []int{7, 4, 0, 7, 4}},
+		{"binary 1011 rests on the clear bit", arpBinary, 11, []int{0, 4, -1, 0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+			processor.arp.pattern = test.pattern
+			processor.arp.rate = 2
+			processor.arp.seed(ChordTrack, []int{0, 4, 7}, 12, test.value, 100, 3, 2)
+
+			if got := arpeggiate(processor, 10); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("played %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestArpeggiatorGate(t *testing.T) {
+
+	tests := []struct {
+		rate, gate, want int
+	}{
+		{4, 50, 2},
+		{4, 100, 4},
+		{1, 50, 1},
+	}
+
+	for _, test := range tests {
+
+		processor := newTestProcessor()
+		processor.arp.pattern = arpUp
+		processor.arp.rate = test.rate
+		processor.arp.gate = test.gate
+		processor.arp.seed(ChordTrack, []int{0}, 12, 0, 100, 3, 2)
+
+		processor.stepArpeggiator()
+
+		if duration := processor.events[0].duration; duration != test.want {
+			t.Errorf("rate %d gate %d%% gave a duration of %d, want %d", test.rate, test.gate, duration, test.want)
+		}
+	}
+}
+
+func TestStopClearsArpeggio(t *testing.T) {
+
+	for _, message := range []ControlMessage{{Type: StopProcessor}, {Type: Panic}, {Type: SetKey, ValueNum: 2}} {
+
+		processor := newTestProcessor()
+		processor.arp.pattern = arpUp
+		processor.arp.seed(ChordTrack, []int{0, 4, 7}, 12, 0, 100, 3, 2)
+
+		processor.handleControlMessage(message)
+		processor.handleControlMessage(ControlMessage{Type: StartProcessor})
+
+		if played := arpeggiate(processor, 8); !reflect.DeepEqual(played, []int{-1, -1, -1, -1}) {
+			t.Errorf("control message %d: the old chord was still arpeggiated: %v", message.Type, played)
+		}
+	}
+}
+
+func TestArpeggiatedNotesKeepTheirTrack(t *testing.T) {
+
+	for _, track := range []Track{MelodyTrack, ChordTrack} {
+
+		processor := newTestProcessor()
+		processor.arp.pattern = arpUp
+		processor.arp.seed(track, []int{0, 4, 7}, 12, 0, 100, 3, 2)
+
+		processor.stepArpeggiator()
+
+		if processor.events[0].track != track {
+			t.Errorf("arpeggiating track %d queued a note on track %d", track, processor.events[0].track)
+		}
+	}
+}
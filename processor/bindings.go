@@ -0,0 +1,277 @@
+package processor
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+)
+
+/*
+Binding Defines the format of a binding config, which drives one musical dimension from its own query. Once any
+bindings are configured the scraper runs all of their queries together and the processor plays a step for every
+combined frame, instead of following the single metric:
+name		Identifies the binding in the frames from the scraper, must be unique.
+query		Prometheus query for this dimension.
+dimension	pitch, velocity, bpm or density. A pitch binding is required and each dimension can only be bound once.
+transform	linear, log, sqrt or inverse. log and sqrt compress large values, inverse turns high values into low output.
+in_min/max	Range of the (transformed) values, defaults to the recent range of the query.
+min/max		Output range. Scale degrees for pitch (0-7), velocity (the velocity range), BPM (60-180) and density (0-1),
+the chance of a step playing notes.
+*/
+type Binding struct {
+	Name      string   `yaml:"name"`
+	Query     string   `yaml:"query"`
+	Dimension string   `yaml:"dimension"`
+	Transform string   `yaml:"transform"`
+	InMin     *float64 `yaml:"in_min"`
+	InMax     *float64 `yaml:"in_max"`
+	Min       *float64 `yaml:"min"`
+	Max       *float64 `yaml:"max"`
+}
+
+/*bindingDimension Defines the musical dimensions a query can be bound to. */
+type bindingDimension int
+
+var bindingDimensionsStr = []string{"pitch", "velocity", "bpm", "density"}
+
+const (
+	pitchBinding    bindingDimension = 0
+	velocityBinding bindingDimension = 1
+	bpmBinding      bindingDimension = 2
+	densityBinding  bindingDimension = 3
+)
+
+/* Default output ranges of each dimension, velocity uses the velocity range set in the front end instead. */
+var bindingRanges = map[bindingDimension][2]float64{
+	pitchBinding:   {0, 7},
+	bpmBinding:     {60, 180},
+	densityBinding: {0, 1},
+}
+
+var bindingTransforms = map[string]func(float64) float64{
+	"":        func(x float64) float64 { return x },
+	"linear":  func(x float64) float64 { return x },
+	"log":     func(x float64) float64 { return math.Log10(math.Max(x, 0) + 1) },
+	"sqrt":    func(x float64) float64 { return math.Sqrt(math.Max(x, 0)) },
+	"inverse": func(x float64) float64 { return -x },
+}
+
+/*binding Parsed version of the binding config, with the recent values used to find the range of the query. */
+type binding struct {
+	name      string
+	query     string
+	dimension bindingDimension
+	transform func(float64) float64
+	inMin     *float64
+	inMax     *float64
+	min       *float64
+	max       *float64
+	values    *list.List
+}
+
+/*validateBindings Checks the bindings from the config, each dimension can only be bound once and pitch has to be. */
+func validateBindings(bindings []Binding) error {
+
+	names := make(map[string]bool)
+	bound := make(map[string]bool)
+
+	for i, config := range bindings {
+
+		if config.Name == "" || names[config.Name] {
+			return fmt.Errorf("processor_config.bindings[%d].name: %q must be set and unique", i, config.Name)
+		}
+
+		if config.Query == "" {
+			return fmt.Errorf("processor_config.bindings[%d].query: %s binding has no query", i, config.Name)
+		}
+
+		if !contains(bindingDimensionsStr, config.Dimension) {
+			return fmt.Errorf("processor_config.bindings[%d].dimension: %q is not a dimension, expected one of %v", i, config.Dimension, bindingDimensionsStr)
+		}
+
+		if bound[config.Dimension] {
+			return fmt.Errorf("processor_config.bindings[%d].dimension: %s is already bound", i, config.Dimension)
+		}
+
+		if _, exists := bindingTransforms[config.Transform]; !exists {
+			return fmt.Errorf("processor_config.bindings[%d].transform: unknown transform %q", i, config.Transform)
+		}
+
+		if config.InMin != nil && config.InMax != nil && *config.InMin >= *config.InMax {
+			return fmt.Errorf("processor_config.bindings[%d].in_min: %f must be below in_max (%f)", i, *config.InMin, *config.InMax)
+		}
+
+		names[config.Name] = true
+		bound[config.Dimension] = true
+	}
+
+	if len(bindings) > 0 && !bound[bindingDimensionsStr[pitchBinding]] {
+		return fmt.Errorf("processor_config.bindings: a pitch binding is required")
+	}
+
+	return nil
+}
+
+func newBinding(config Binding) *binding {
+
+	parsed := &binding{name: config.Name, query: config.Query, transform: bindingTransforms[config.Transform],
+		inMin: config.InMin, inMax: config.InMax, min: config.Min, max: config.Max, values: list.New()}
+
+	for i, dimension := range bindingDimensionsStr {
+		if dimension == config.Dimension {
+			parsed.dimension = bindingDimension(i)
+		}
+	}
+
+	/* The input range is in transformed values, so it is transformed the same way. */
+	if config.Transform == "inverse" {
+		parsed.inMin, parsed.inMax = negate(config.InMax), negate(config.InMin)
+	} else if parsed.inMin != nil || parsed.inMax != nil {
+		parsed.inMin, parsed.inMax = transformed(parsed.transform, config.InMin), transformed(parsed.transform, config.InMax)
+	}
+
+	return parsed
+}
+
+func negate(value *float64) *float64 {
+
+	if value == nil {
+		return nil
+	}
+
+	negated := -*value
+
+	return &negated
+}
+
+func transformed(transform func(float64) float64, value *float64) *float64 {
+
+	if value == nil {
+		return nil
+	}
+
+	result := transform(*value)
+
+	return &result
+}
+
+/*parseBindings Stores the bindings from the configuration file, they have already been validated. */
+func (processor *ProcInfo) parseBindings(bindingList []Binding) {
+
+	for _, config := range bindingList {
+		processor.bindings = append(processor.bindings, newBinding(config))
+	}
+}
+
+/*level Adds a value to the recent values of the binding and returns where it sits in the input range (0-1). */
+func (b *binding) level(value float64) float64 {
+
+	value = b.transform(value)
+
+	if b.values.Len() >= maxPreviousValues {
+		b.values.Remove(b.values.Back())
+	}
+
+	b.values.PushFront(value)
+
+	min, max := value, value
+
+	for _, v := range listValues(b.values) {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+
+	if b.inMin != nil {
+		min = *b.inMin
+	}
+
+	if b.inMax != nil {
+		max = *b.inMax
+	}
+
+	if max <= min {
+		return 0.5
+	}
+
+	return clamp((value-min)/(max-min), 0, 1)
+}
+
+/*outputRange Returns the range the binding is scaled into, filling in the default for its dimension. */
+func (processor *ProcInfo) outputRange(b *binding) (float64, float64) {
+
+	min, max := bindingRanges[b.dimension][0], bindingRanges[b.dimension][1]
+
+	if b.dimension == velocityBinding {
+		min, max = float64(processor.minVelocity), float64(processor.maxVelocity)
+	}
+
+	if b.min != nil {
+		min = *b.min
+	}
+
+	if b.max != nil {
+		max = *b.max
+	}
+
+	return min, max
+}
+
+/*
+processFrame Plays a step from a frame of time aligned values, one for each binding. The pitch binding picks the note
+the same way a single metric does, velocity is set straight from its binding, BPM ramps to its binding and density is the chance of
+the step playing at all. Skipped steps are still added to the recent values so the features keep up.
+*/
+func (processor *ProcInfo) processFrame(frame map[string]float64) {
+
+	pitch, raw, density := 0.0, 0.0, 1.0
+	processor.boundVelocity = 0
+
+	for _, b := range processor.bindings {
+
+		value, exists := frame[b.name]
+
+		if !exists {
+			continue
+		}
+
+		min, max := processor.outputRange(b)
+		output := min + b.level(value)*(max-min)
+
+		switch b.dimension {
+
+		case pitchBinding:
+			pitch, raw = output, value
+		case velocityBinding:
+			processor.boundVelocity = clampVelocity(int64(math.Round(output)), 1, 127)
+		case bpmBinding:
+			processor.rampTempo(output)
+		case densityBinding:
+			density = output
+		}
+	}
+
+	/* The pitch query is the metric as far as the sinks that take raw values are concerned. */
+	processor.sendMetric(raw)
+
+	if processor.random.Float64() >= density {
+		processor.addToPreviousValues(pitch)
+		return
+	}
+
+	processor.processMessage(pitch)
+}
+
+/*GetBindingQueries Returns the query of each binding by name, empty when the processor follows a single metric. */
+func (processor *ProcInfo) GetBindingQueries() map[string]string {
+
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	queries := make(map[string]string, len(processor.bindings))
+
+	for _, b := range processor.bindings {
+		queries[b.name] = b.query
+	}
+
+	return queries
+}
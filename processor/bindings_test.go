@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/midioutput"
+)
+
+/*bound Returns a pointer to a range value, the way they are left out of the config when nil. */
+func bound(v float64) *float64 {
+	return &v
+}
+
+func TestValidateBindings(t *testing.T) {
+
+	pitch := Binding{Name: "cpu", Query: "cpu_usage", Dimension: "pitch"}
+
+	tests := []struct {
+		name     string
+		bindings []Binding
+		field    string
+	}{
+		{"none", nil, ""},
+		{"pitch only", []Binding{pitch}, ""},
+		{"every dimension", []Binding{pitch, {Name: "mem", Query: "mem", Dimension: "velocity", Transform: "log"},
+			{Name: "req", Query: "req", Dimension: "bpm", Transform: "inverse"}, {Name: "err", Query: "err", Dimension: "density"}}, ""},
+		{"missing name", []Binding{{Query: "cpu_usage", Dimension: "pitch"}}, "bindings[0].name"},
+		{"duplicate name", []Binding{pitch, {Name: "cpu", Query: "mem", Dimension: "velocity"}}, "bindings[1].name"},
+		{"missing query", []Binding{{Name: "cpu", Dimension: "pitch"}}, "bindings[0].query"},
+		{"unknown dimension", []Binding{pitch, {Name: "mem", Query: "mem", Dimension: "timbre"}}, "bindings[1].dimension"},
+		{"bound twice", []Binding{pitch, {Name: "mem", Query: "mem", Dimension: "pitch"}}, "bindings[1].dimension"},
+		{"unknown transform", []Binding{{Name: "cpu", Query: "cpu", Dimension: "pitch", Transform: "cube"}}, "bindings[0].transform"},
+		{"empty input range", []Binding{{Name: "cpu", Query: "cpu", Dimension: "pitch", InMin: bound(5), InMax: bound(5)}}, "bindings[0].in_min"},
+		{"no pitch", []Binding{{Name: "mem", Query: "mem", Dimension: "velocity"}}, "bindings:"},
+	}
+
+	for _, test := range tests {
+
+		err := validateBindings(test.bindings)
+
+		if test.field == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+
+		if test.field != "" && (err == nil || !strings.HasPrefix(err.Error(), "processor_config."+test.field)) {
+			t.Errorf("%s: got error %v, want one for %s", test.name, err, test.field)
+		}
+	}
+}
+
+func TestBindingLevel(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		binding  Binding
+		values   []float64
+		expected float64
+	}{
+		{"single value", Binding{}, []float64{5}, 0.5},
+		{"recent range", Binding{}, []float64{0, 10, 5}, 0.5},
+		{"recent top", Binding{}, []float64{0, 10, 10}, 1},
+		{"fixed range", Binding{InMin: bound(0), InMax: bound(100)}, []float64{25}, 0.25},
+		{"clamped", Binding{InMin: bound(0), InMax: bound(100)}, []float64{150}, 1},
+		{"log", Binding{Transform: "log", InMin: bound(0), InMax: bound(99)}, []float64{9}, 0.5},
+		{"sqrt", Binding{Transform: "sqrt", InMin: bound(0), InMax: bound(100)}, []float64{25}, 0.5},
+		{"inverse", Binding{Transform: "inverse", InMin: bound(0), InMax: bound(100)}, []float64{25}, 0.75},
+	}
+
+	for _, test := range tests {
+
+		b := newBinding(test.binding)
+		level := 0.0
+
+		for _, v := range test.values {
+			level = b.level(v)
+		}
+
+		if level != test.expected {
+			t.Errorf("%s: got level %f, want %f", test.name, level, test.expected)
+		}
+	}
+}
+
+func TestProcessFrame(t *testing.T) {
+
+	processor := newConfiguredProcessor(Config{Scales: testScales, Bindings: []Binding{
+		{Name: "cpu", Query: "cpu", Dimension: "pitch", InMin: bound(0), InMax: bound(100)},
+		{Name: "mem", Query: "mem", Dimension: "velocity", InMin: bound(0), InMax: bound(10), Min: bound(20), Max: bound(120)},
+		{Name: "req", Query: "req", Dimension: "bpm", InMin: bound(0), InMax: bound(1)},
+		{Name: "err", Query: "err", Dimension: "density", InMin: bound(0), InMax: bound(1)},
+	}})
+
+	processor.processFrame(map[string]float64{"cpu": 50, "mem": 5, "req": 0.5, "err": 1})
+	processor.handleEvents()
+
+	if processor.BPM != 120 {
+		t.Errorf("got %f BPM, want 120", processor.BPM)
+	}
+
+	/* The raw value of the pitch query goes out first, for the sinks that take metric values. */
+	messages := sent(processor)
+
+	if len(messages) < 2 || messages[0].Type != midioutput.MetricValue || messages[0].Metric != 50 {
+		t.Fatalf("expected the pitch query's value to be sent first, got %v", messages)
+	}
+
+	if messages[1].Note != 3 {
+		t.Errorf("expected the pitch to play degree 3 first, got %v", messages[1:])
+	}
+
+	for _, message := range messages {
+		if message.Type == midioutput.NoteOn && message.Velocity != 70 {
+			t.Errorf("got velocity %d, want 70 from the velocity binding", message.Velocity)
+		}
+	}
+
+	/* With a density of 0 the step is skipped, but the value still counts towards the features. */
+	processor.processFrame(map[string]float64{"cpu": 50, "mem": 5, "req": 0.5, "err": 0})
+	processor.handleEvents()
+
+	if messages := sent(processor); len(messages) != 1 || messages[0].Type != midioutput.MetricValue {
+		t.Errorf("a step with no density played %v", messages)
+	}
+
+	if processor.previousValues.Len() != 2 {
+		t.Errorf("got %d recent values, want 2", processor.previousValues.Len())
+	}
+}
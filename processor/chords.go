@@ -0,0 +1,255 @@
+package processor
+
+import (
+	"math"
+	"strings"
+)
+
+/*chordType Defines the kinds of chord the chord engine can build. */
+type chordType int
+
+/*
+Diatonic chords are stacked from the degrees of the active scale, the fixed quality chords (major/minor) are
+built in semitones on top of the scale note, which is what the original Major/Minor modes were after.
+*/
+const (
+	triad      chordType = 0
+	seventh    chordType = 1
+	ninth      chordType = 2
+	sus2       chordType = 3
+	sus4       chordType = 4
+	power      chordType = 5
+	majorTriad chordType = 6
+	minorTriad chordType = 7
+
+	diminishedSeventh chordType = 8
+)
+
+/*chordDegrees Scale degrees, relative to the chord root, that are stacked to build each diatonic chord type. */
+var chordDegrees = map[chordType][]int{
+	triad:   {0, 2, 4},
+	seventh: {0, 2, 4, 6},
+	ninth:   {0, 2, 4, 6, 8},
+	sus2:    {0, 1, 4},
+	sus4:    {0, 3, 4},
+	power:   {0, 4},
+}
+
+/*chordSemitones Semitone intervals for the chord types that have a fixed quality no matter which scale is used. */
+var chordSemitones = map[chordType][]int{
+	majorTriad: {0, 4, 7},
+	minorTriad: {0, 3, 7},
+
+	diminishedSeventh: {0, 3, 6, 9},
+}
+
+/*voicingMode Defines how the notes of a chord are arranged before being sent. */
+type voicingMode int
+
+var voicingModesStr = []string{"Close", "First Inversion", "Second Inversion", "Spread", "Voice Leading"}
+
+const (
+	closeVoicing    voicingMode = 0
+	firstInversion  voicingMode = 1
+	secondInversion voicingMode = 2
+	spreadVoicing   voicingMode = 3
+	voiceLeading    voicingMode = 4
+)
+
+/*degreeCount Returns the number of notes in the scale, offsets also holds the octave so is one longer than this. */
+func (scale scaleMap) degreeCount() int {
+	return len(scale.offsets) - 1
+}
+
+/*period Returns the number of semitones the scale spans before it repeats, normally an octave. */
+func (scale scaleMap) period() int {
+	return scale.offsets[len(scale.offsets)-1]
+}
+
+/*degreeOffset Returns the semitone offset from the root for any scale degree, wrapping into the octaves above and below. */
+func (scale scaleMap) degreeOffset(degree int) int {
+
+	count := scale.degreeCount()
+	octave := degree / count
+	index := degree % count
+
+	if index < 0 {
+		index += count
+		octave--
+	}
+
+	return scale.offsets[index] + octave*scale.period()
+}
+
+/*degreeOfValue Maps a metric value onto a degree of the active scale, negative values use their magnitude. */
+func (processor *ProcInfo) degreeOfValue(value float64) int {
+	return int(math.Abs(value)) % processor.activeScale.degreeCount()
+}
+
+/*buildChord Returns the semitone offsets (from the key root) of a chord of the given type rooted on a scale degree. */
+func (processor *ProcInfo) buildChord(degree int, chord chordType) []int {
+
+	scale := processor.activeScale
+
+	if semitones, exists := chordSemitones[chord]; exists {
+
+		root := scale.degreeOffset(degree)
+		tones := make([]int, len(semitones))
+
+		for i, semitone := range semitones {
+			tones[i] = root + semitone
+		}
+
+		return tones
+	}
+
+	tones := make([]int, 0, len(chordDegrees[chord])+1)
+
+	for _, chordDegree := range chordDegrees[chord] {
+		tones = append(tones, scale.degreeOffset(degree+chordDegree))
+	}
+
+	/* Power chords are doubled at the octave to give them some weight. */
+	if chord == power {
+		tones = append(tones, tones[0]+scale.period())
+	}
+
+	return tones
+}
+
+/*voiceChord Arranges a close position chord according to the current voicing mode. */
+func (processor *ProcInfo) voiceChord(tones []int) []int {
+
+	period := processor.activeScale.period()
+	voiced := tones
+
+	switch processor.voicing {
+
+	case firstInversion:
+		voiced = invertChord(tones, 1, period)
+
+	case secondInversion:
+		voiced = invertChord(tones, 2, period)
+
+	case spreadVoicing:
+		voiced = spreadChord(tones, period)
+
+	case voiceLeading:
+		if len(processor.previousChord) > 0 {
+			voiced = leadVoices(processor.previousChord, tones, period)
+		}
+	}
+
+	processor.previousChord = voiced
+
+	return voiced
+}
+
+/*invertChord Moves the lowest note of the chord up by an octave the specified number of times. */
+func invertChord(tones []int, inversion int, period int) []int {
+
+	inverted := append([]int{}, tones...)
+
+	for i := 0; i < inversion%len(inverted); i++ {
+		inverted = append(inverted[1:], inverted[0]+period)
+	}
+
+	return inverted
+}
+
+/*spreadChord Opens the chord up by moving every other note above the root up an octave. */
+func spreadChord(tones []int, period int) []int {
+
+	spread := append([]int{}, tones...)
+
+	for i := 1; i < len(spread); i += 2 {
+		spread[i] += period
+	}
+
+	return spread
+}
+
+/*
+leadVoices Picks the inversion/octave of the chord that needs the least movement from the previous chord. The
+candidates are kept within an octave either side of the root so the voicing can't slowly drift out of range.
+*/
+func leadVoices(previous []int, tones []int, period int) []int {
+
+	best := tones
+	bestCost := math.MaxInt32
+
+	for inversion := 0; inversion < len(tones); inversion++ {
+
+		inverted := invertChord(tones, inversion, period)
+
+		for _, shift := range []int{-period, 0, period} {
+
+			candidate := make([]int, len(inverted))
+
+			for i, tone := range inverted {
+				candidate[i] = tone + shift
+			}
+
+			if candidate[0] < -period || candidate[0] >= 2*period {
+				continue
+			}
+
+			cost := voiceMovement(previous, candidate)
+
+			if cost < bestCost {
+				best = candidate
+				bestCost = cost
+			}
+		}
+	}
+
+	return best
+}
+
+/*
+voiceMovement Returns how far the voices have to move to get from one chord to the next. Every note is matched with the
+closest note in the other chord in both directions, so chords with a different number of notes can be compared.
+*/
+func voiceMovement(from []int, to []int) int {
+
+	return nearestDistances(from, to) + nearestDistances(to, from)
+}
+
+func nearestDistances(from []int, to []int) int {
+
+	total := 0
+
+	for _, a := range from {
+
+		nearest := math.MaxInt32
+
+		for _, b := range to {
+
+			distance := a - b
+
+			if distance < 0 {
+				distance = -distance
+			}
+
+			if distance < nearest {
+				nearest = distance
+			}
+		}
+
+		total += nearest
+	}
+
+	return total
+}
+
+/*chordNames Returns the note names of a chord, used for logging. */
+func (processor *ProcInfo) chordNames(tones []int) string {
+
+	names := make([]string, len(tones))
+
+	for i, tone := range tones {
+		names[i] = notes[((processor.rootNoteOffset+tone)%12+12)%12]
+	}
+
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildChord(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		degree int
+		chord  chordType
+		want   []int
+	}{
+		{"tonic triad", 0, triad, []int{0, 4, 7}},
+		{"supertonic triad is minor", 1, triad, []int{2, 5, 9}},
+		{"dominant seventh", 4, seventh, []int{7, 11, 14, 17}},
+		{"tonic ninth", 0, ninth, []int{0, 4, 7, 11, 14}},
+		{"sus2", 0, sus2, []int{0, 2, 7}},
+		{"sus4", 0, sus4, []int{0, 5, 7}},
+		{"power chord is doubled", 0, power, []int{0, 7, 12}},
+		{"major quality on a minor degree", 1, majorTriad, []int{2, 6, 9}},
+		{"minor quality on a major degree", 0, minorTriad, []int{0, 3, 7}},
+		{"degree above the octave", 7, triad, []int{12, 16, 19}},
+		{"degree below the root", -1, triad, []int{-1, 2, 5}},
+	}
+
+	processor := newTestProcessor()
+	processor.setScale("Ionian")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := processor.buildChord(test.degree, test.chord); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("buildChord(%d) = %v, want %v", test.degree, got, test.want)
+			}
+		})
+	}
+}
+
+func TestVoiceChord(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		voicing  voicingMode
+		previous []int
+		tones    []int
+		want     []int
+	}{
+		{"close", closeVoicing, nil, []int{0, 4, 7}, []int{0, 4, 7}},
+		{"first inversion", firstInversion, nil, []int{0, 4, 7}, []int{4, 7, 12}},
+		{"second inversion", secondInversion, nil, []int{0, 4, 7}, []int{7, 12, 16}},
+		{"spread", spreadVoicing, nil, []int{0, 4, 7, 11}, []int{0, 16, 7, 23}},
+		{"voice leading without a previous chord", voiceLeading, nil, []int{5, 9, 12}, []int{5, 9, 12}},
+		{"voice leading keeps common tones", voiceLeading, []int{0, 4, 7}, []int{5, 9, 12}, []int{0, 5, 9}},
+		{"voice leading moves down an octave", voiceLeading, []int{-12, -8, -5}, []int{7, 11, 14}, []int{-5, -1, 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+			processor.voicing = test.voicing
+			processor.previousChord = test.previous
+
+			got := processor.voiceChord(test.tones)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("voiceChord(%v) = %v, want %v", test.tones, got, test.want)
+			}
+			if !reflect.DeepEqual(processor.previousChord, got) {
+				t.Errorf("previousChord = %v, want the voiced chord %v", processor.previousChord, got)
+			}
+		})
+	}
+}
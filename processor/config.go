@@ -0,0 +1,241 @@
+package processor
+
+import "fmt"
+
+/*Config Defines the format of the processor config, settings left out of the config file use the defaults below. */
+type Config struct {
+	DefaultKey    string        `yaml:"default_key"`
+	DefaultScale  string        `yaml:"default_scale"`
+	BPM           int           `yaml:"bpm"`
+	ChordMode     string        `yaml:"chord_mode"`
+	VelocityMode  string        `yaml:"velocity_mode"`
+	MelodyMode    string        `yaml:"melody_mode"`
+	Seed          int64         `yaml:"seed"`
+	MelodyOctave  *int          `yaml:"melody_octave"`
+	ChordOctave   *int          `yaml:"chord_octave"`
+	MelodyChannel int           `yaml:"melody_channel"`
+	ChordChannel  int           `yaml:"chord_channel"`
+	MelodyRange   NoteRange     `yaml:"melody_range"`
+	ChordRange    NoteRange     `yaml:"chord_range"`
+	Scales        []Scale       `yaml:"scales"`
+	Progressions  []Progression `yaml:"progressions"`
+	DrumPatterns  []DrumPattern `yaml:"drum_patterns"`
+	Modulations   []Modulation  `yaml:"modulations"`
+	Anomalies     Anomalies     `yaml:"anomalies"`
+	Rules         []Rule        `yaml:"rules"`
+	Bindings      []Binding     `yaml:"bindings"`
+	Tempo         Tempo         `yaml:"tempo"`
+}
+
+const defaultKey = "C"
+const defaultChordMode = "Major"
+const defaultVelocityMode = "Variance"
+const defaultMelodyOctave = 4
+const defaultChordOctave = 3
+const defaultMelodyChannel = 1
+const defaultChordChannel = 2
+
+const minBPM = 1
+const maxBPM = 400
+
+/* Octaves the emitter can play, see midioutput.octaveOffsets. */
+const minOctave = 0
+const maxOctave = 8
+
+/*Settings Describes the current state of the processor, used by the front end to show the right selections. */
+type Settings struct {
+	Key          int
+	Scale        string
+	BPM          int
+	ChordMode    string
+	VelocityMode string
+	Voicing      string
+	MelodyMode   string
+	Seed         int64
+	Anomalies    bool
+	TempoFollow  string
+	TempoRamp    int
+	Ranges       []NoteRange
+}
+
+/*Validate Checks the processor config and fills in defaults for anything left out. Errors name the field that is wrong. */
+func (config *Config) Validate() error {
+
+	if len(config.Scales) < 1 {
+		return fmt.Errorf("processor_config.scales: no scales defined")
+	}
+
+	scaleNames := make(map[string]bool)
+
+	for i, scale := range config.Scales {
+
+		if scale.Name == "" {
+			return fmt.Errorf("processor_config.scales[%d].name: scale defined without a name", i)
+		}
+
+		if len(scale.Intervals) < 1 {
+			return fmt.Errorf("processor_config.scales[%d].intervals: %s scale defined without any intervals", i, scale.Name)
+		}
+
+		for _, interval := range scale.Intervals {
+			if interval < 1 {
+				return fmt.Errorf("processor_config.scales[%d].intervals: %s scale has an interval of %d, intervals must be at least 1", i, scale.Name, interval)
+			}
+		}
+
+		scaleNames[scale.Name] = true
+	}
+
+	if config.DefaultKey == "" {
+		config.DefaultKey = defaultKey
+	}
+
+	if _, exists := noteIndexes[config.DefaultKey]; !exists {
+		return fmt.Errorf("processor_config.default_key: %q is not a key, expected one of %v", config.DefaultKey, notes[:12])
+	}
+
+	if config.DefaultScale == "" {
+		config.DefaultScale = config.Scales[0].Name
+	}
+
+	if !scaleNames[config.DefaultScale] {
+		return fmt.Errorf("processor_config.default_scale: no scale named %q", config.DefaultScale)
+	}
+
+	if config.BPM == 0 {
+		config.BPM = defaultBPM
+	}
+
+	if config.BPM < minBPM || config.BPM > maxBPM {
+		return fmt.Errorf("processor_config.bpm: %d must be between %d and %d", config.BPM, minBPM, maxBPM)
+	}
+
+	if config.ChordMode == "" {
+		config.ChordMode = defaultChordMode
+	}
+
+	if !contains(chordModesStr, config.ChordMode) {
+		return fmt.Errorf("processor_config.chord_mode: %q is not a chord mode, expected one of %v", config.ChordMode, chordModesStr)
+	}
+
+	if config.VelocityMode == "" {
+		config.VelocityMode = defaultVelocityMode
+	}
+
+	if !contains(velocityModesStr, config.VelocityMode) {
+		return fmt.Errorf("processor_config.velocity_mode: %q is not a velocity mode, expected one of %v", config.VelocityMode, velocityModesStr)
+	}
+
+	if config.MelodyMode == "" {
+		config.MelodyMode = melodyModesStr[valueMelody]
+	}
+
+	if !contains(melodyModesStr, config.MelodyMode) {
+		return fmt.Errorf("processor_config.melody_mode: %q is not a melody mode, expected one of %v", config.MelodyMode, melodyModesStr)
+	}
+
+	/* Octave 0 can be configured, so only octaves left out get the defaults. */
+	if config.MelodyOctave == nil {
+		octave := defaultMelodyOctave
+		config.MelodyOctave = &octave
+	}
+
+	if config.ChordOctave == nil {
+		octave := defaultChordOctave
+		config.ChordOctave = &octave
+	}
+
+	for _, octave := range []struct {
+		field string
+		value int
+	}{{"melody_octave", *config.MelodyOctave}, {"chord_octave", *config.ChordOctave}} {
+		if octave.value < minOctave || octave.value > maxOctave {
+			return fmt.Errorf("processor_config.%s: %d must be between %d and %d", octave.field, octave.value, minOctave, maxOctave)
+		}
+	}
+
+	if config.MelodyChannel == 0 {
+		config.MelodyChannel = defaultMelodyChannel
+	}
+
+	if config.ChordChannel == 0 {
+		config.ChordChannel = defaultChordChannel
+	}
+
+	for _, channel := range []struct {
+		field string
+		value int
+	}{{"melody_channel", config.MelodyChannel}, {"chord_channel", config.ChordChannel}} {
+		if channel.value < 1 || channel.value > 16 {
+			return fmt.Errorf("processor_config.%s: %d must be between 1 and 16", channel.field, channel.value)
+		}
+		if channel.value == drumChannel {
+			return fmt.Errorf("processor_config.%s: channel %d is used by the drum track", channel.field, channel.value)
+		}
+	}
+
+	if err := config.MelodyRange.validate("melody_range"); err != nil {
+		return err
+	}
+
+	if err := config.ChordRange.validate("chord_range"); err != nil {
+		return err
+	}
+
+	for i, progression := range config.Progressions {
+		if _, err := parseProgression(progression); err != nil {
+			return fmt.Errorf("processor_config.progressions[%d].%v", i, err)
+		}
+	}
+
+	for i, pattern := range config.DrumPatterns {
+		if _, err := parseDrumPattern(pattern); err != nil {
+			return fmt.Errorf("processor_config.drum_patterns[%d].%v", i, err)
+		}
+	}
+
+	for i, modulation := range config.Modulations {
+		if _, err := parseModulation(modulation); err != nil {
+			return fmt.Errorf("processor_config.modulations[%d].%v", i, err)
+		}
+	}
+
+	for i, rule := range config.Rules {
+		if _, err := parseRule(rule, scaleNames); err != nil {
+			return fmt.Errorf("processor_config.rules[%d].%v", i, err)
+		}
+	}
+
+	if err := config.Anomalies.validate(scaleNames); err != nil {
+		return err
+	}
+
+	if err := validateBindings(config.Bindings); err != nil {
+		return err
+	}
+
+	return config.Tempo.validate()
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*GetSettings Returns the current key, scale, BPM and modes of the processor. */
+func (processor *ProcInfo) GetSettings() Settings {
+
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	return Settings{Key: processor.rootNoteOffset, Scale: processor.activeScale.name, BPM: int(processor.BPM),
+		ChordMode: chordModesStr[processor.chordGenerationMode], VelocityMode: velocityModesStr[processor.velocitySensingMode],
+		Voicing: voicingModesStr[processor.voicing], MelodyMode: melodyModesStr[processor.melodyMode], Seed: processor.seed,
+		Anomalies: processor.anomalies.enabled, TempoFollow: tempoFollowsStr[processor.tempo.follow], TempoRamp: int(processor.tempo.beats), Ranges: processor.rangeSettings()}
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+)
+
+func TestValidate(t *testing.T) {
+
+	zero, nine := 0, 9
+
+	tests := []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{"defaults", Config{Scales: testScales}, ""},
+		{"octave 0 is allowed", Config{Scales: testScales, MelodyOctave: &zero, ChordOctave: &zero}, ""},
+		{"no scales", Config{}, "processor_config.scales: no scales defined"},
+		{"scale without a name", Config{Scales: []Scale{{Intervals: []int{12}}}}, "processor_config.scales[0].name: scale defined without a name"},
+		{"scale without intervals", Config{Scales: []Scale{{Name: "Empty"}}}, "processor_config.scales[0].intervals: Empty scale defined without any intervals"},
+		{"zero interval", Config{Scales: []Scale{{Name: "Flat", Intervals: []int{0, 12}}}}, "processor_config.scales[0].intervals: Flat scale has an interval of 0, intervals must be at least 1"},
+		{"unknown key", Config{Scales: testScales, DefaultKey: "H"}, `processor_config.default_key: "H" is not a key, expected one of [C C# D D# E F F# G G# A A# B]`},
+		{"unknown scale", Config{Scales: testScales, DefaultScale: "Dorian"}, `processor_config.default_scale: no scale named "Dorian"`},
+		{"bpm", Config{Scales: testScales, BPM: 401}, "processor_config.bpm: 401 must be between 1 and 400"},
+		{"chord mode", Config{Scales: testScales, ChordMode: "Jazz"}, `processor_config.chord_mode: "Jazz" is not a chord mode, expected one of [` + strings.Join(chordModesStr, " ") + `]`},
+		{"octave", Config{Scales: testScales, ChordOctave: &nine}, "processor_config.chord_octave: 9 must be between 0 and 8"},
+		{"channel", Config{Scales: testScales, MelodyChannel: 17}, "processor_config.melody_channel: 17 must be between 1 and 16"},
+		{"drum channel", Config{Scales: testScales, ChordChannel: drumChannel}, "processor_config.chord_channel: channel 10 is used by the drum track"},
+		{"progression", Config{Scales: testScales, Progressions: []Progression{{Name: "ok", Chords: []string{"I"}}, {Name: "bad", Chords: []string{"I", "Q"}}}}, `processor_config.progressions[1].chords[1]: "Q" doesn't start with a roman numeral`},
+		{"drum pattern", Config{Scales: testScales, DrumPatterns: []DrumPattern{{Name: "bad", Steps: map[string]string{"gong": "x"}}}}, `processor_config.drum_patterns[0].steps.gong: unknown drum "gong"`},
+		{"modulation", Config{Scales: testScales, Modulations: []Modulation{{Name: "bad", Type: "cc", Controller: 120}}}, "processor_config.modulations[0].controller: 120 must be between 0 and 119"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			config := test.config
+			err := config.Validate()
+
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.err {
+				t.Errorf("error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidateDefaults(t *testing.T) {
+
+	zero := 0
+	config := Config{Scales: testScales, MelodyOctave: &zero}
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.DefaultKey != defaultKey || config.DefaultScale != testScales[0].Name || config.BPM != defaultBPM ||
+		config.ChordMode != defaultChordMode || config.VelocityMode != defaultVelocityMode {
+		t.Errorf("defaults not filled in: %+v", config)
+	}
+	if *config.MelodyOctave != 0 || *config.ChordOctave != defaultChordOctave {
+		t.Errorf("octaves = %d/%d, want 0/%d", *config.MelodyOctave, *config.ChordOctave, defaultChordOctave)
+	}
+	if config.MelodyChannel != defaultMelodyChannel || config.ChordChannel != defaultChordChannel {
+		t.Errorf("channels = %d/%d, want %d/%d", config.MelodyChannel, config.ChordChannel, defaultMelodyChannel, defaultChordChannel)
+	}
+
+	processor := newProcessor(logging.NewLogger(), config, nil)
+
+	if processor.melodyOctave != 0 || processor.activeScale.name != testScales[0].Name || processor.BPM != defaultBPM {
+		t.Errorf("processor didn't pick up the config: octave %d scale %s bpm %v", processor.melodyOctave, processor.activeScale.name, processor.BPM)
+	}
+}
@@ -0,0 +1,320 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+DrumPattern Defines the format of a drum pattern config. Each voice has a step grid where every character is a 16th
+note: 'x' is a hit, 'X' an accented hit and anything else ('.', '-') a rest. Grids can be any length, shorter grids
+simply loop sooner.
+*/
+type DrumPattern struct {
+	Name  string            `yaml:"name"`
+	Steps map[string]string `yaml:"steps"`
+}
+
+/* General MIDI percussion notes, drums are always sent on channel 10. */
+var gmDrums = map[string]int{
+	"kick":       36,
+	"rimshot":    37,
+	"snare":      38,
+	"clap":       39,
+	"low_tom":    45,
+	"mid_tom":    47,
+	"high_tom":   50,
+	"closed_hat": 42,
+	"pedal_hat":  44,
+	"open_hat":   46,
+	"crash":      49,
+	"ride":       51,
+	"cowbell":    56,
+	"tambourine": 54,
+}
+
+const drumChannel = 10
+
+const drumVelocity = 100
+const drumAccentVelocity = 127
+const drumGhostVelocity = 50
+
+/*kickMode Defines where the kick drum comes from. */
+type kickMode int
+
+var kickModesStr = []string{"Pattern", "Every Beat", "Every Bar"}
+
+const (
+	kickPattern kickMode = 0
+	kickBeat    kickMode = 1
+	kickBar     kickMode = 2
+)
+
+/* A fill is a bar of 16ths down the toms, ending on a crash after it. */
+var fillNotes = []string{"snare", "snare", "high_tom", "high_tom", "snare", "high_tom", "mid_tom", "mid_tom",
+	"snare", "mid_tom", "low_tom", "low_tom", "snare", "low_tom", "snare", "snare"}
+
+/* Hi-hat subdivisions in ticks, from quarter notes when the metric is low to 16ths when it is high. */
+var hatSubdivisions = []int{defaultTicksPerBeat, defaultTicksPerBeat / 2, defaultTicksPerBeat / 4}
+
+/*drumStep A single hit of a drum pattern. */
+type drumStep struct {
+	note     int
+	velocity int64
+}
+
+/*drumPattern Parsed version of the drum pattern config, one list of hits per step. */
+type drumPattern struct {
+	name  string
+	steps [][]drumStep
+}
+
+/*
+drumMachine Plays a percussion part alongside the melodic tracks, on the same sequencer tick. On top of the pattern the
+metric drives three layers:
+crash		A crash cymbal on the next beat whenever the metric crosses its recent mean.
+snare		Ghost snare hits on the off beats, the more volatile (error prone) the metric the denser they get.
+hi-hat		Closed hi-hats at a subdivision that follows the level of the metric, so throughput sets how busy they are.
+*/
+type drumMachine struct {
+	enabled      bool
+	patterns     map[string]drumPattern
+	names        []string
+	pattern      *drumPattern
+	kick         kickMode
+	crash        bool
+	snareDensity float64
+	hatRate      int
+	fillStart    int
+	filling      bool
+}
+
+func newDrumMachine() *drumMachine {
+	return &drumMachine{patterns: map[string]drumPattern{}, kick: kickPattern}
+}
+
+/*parseDrumPattern Converts a drum pattern from the config into a list of hits per step. Errors name the field of the pattern that is wrong. */
+func parseDrumPattern(config DrumPattern) (drumPattern, error) {
+
+	parsed := drumPattern{name: config.Name}
+	length := 0
+
+	for _, grid := range config.Steps {
+		if len(grid) > length {
+			length = len(grid)
+		}
+	}
+
+	if length == 0 {
+		return parsed, fmt.Errorf("steps: no steps defined")
+	}
+
+	parsed.steps = make([][]drumStep, length)
+
+	/* Voices are sorted so hits on the same step are always sent in the same order. */
+	var voices []string
+
+	for voice := range config.Steps {
+		voices = append(voices, voice)
+	}
+
+	sort.Strings(voices)
+
+	for _, voice := range voices {
+
+		note, exists := gmDrums[strings.ToLower(voice)]
+
+		if !exists {
+			return parsed, fmt.Errorf("steps.%s: unknown drum %q", voice, voice)
+		}
+
+		grid := config.Steps[voice]
+
+		/* An empty grid can't be looped to the length of the others. */
+		if grid == "" {
+			return parsed, fmt.Errorf("steps.%s: no steps defined", voice)
+		}
+
+		for i := 0; i < length; i++ {
+
+			switch grid[i%len(grid)] {
+			case 'x':
+				parsed.steps[i] = append(parsed.steps[i], drumStep{note: note, velocity: drumVelocity})
+			case 'X':
+				parsed.steps[i] = append(parsed.steps[i], drumStep{note: note, velocity: drumAccentVelocity})
+			}
+		}
+	}
+
+	return parsed, nil
+}
+
+/*parseDrumPatterns Processes and stores the drum patterns from the configuration file, invalid ones are logged and skipped. */
+func (drums *drumMachine) parseDrumPatterns(patternList []DrumPattern) {
+
+	for _, config := range patternList {
+
+		parsed, err := parseDrumPattern(config)
+
+		if err != nil {
+			log.Printf("Skipping drum pattern %s: %v\n", config.Name, err)
+			continue
+		}
+
+		if drums.pattern == nil {
+			drums.pattern = &parsed
+		}
+
+		drums.patterns[config.Name] = parsed
+		drums.names = append(drums.names, config.Name)
+	}
+}
+
+func (drums *drumMachine) setPattern(name string) {
+
+	if selected, exists := drums.patterns[name]; exists {
+		drums.pattern = &selected
+		log.Printf("Using %s drum pattern.\n", name)
+	} else {
+		log.Printf("Drum pattern not found (%s).\n", name)
+	}
+}
+
+func (drums *drumMachine) setKickMode(name string) {
+
+	for i, mode := range kickModesStr {
+		if mode == name {
+			drums.kick = kickMode(i)
+		}
+	}
+}
+
+/*startFill Starts a fill from the current tick, it replaces the pattern until it has finished. */
+func (drums *drumMachine) startFill(tick int) {
+
+	drums.fillStart = tick
+	drums.filling = true
+}
+
+/*fillHits Returns the hit of the fill due on this tick. */
+func (drums *drumMachine) fillHits(tick int) map[int]int64 {
+
+	step := tick - drums.fillStart
+
+	if step < 0 {
+		return nil
+	}
+
+	if step >= len(fillNotes) {
+		drums.filling = false
+		return map[int]int64{gmDrums["crash"]: drumAccentVelocity}
+	}
+
+	/* The fill builds up to an accent on its last few hits. */
+	velocity := int64(drumVelocity)
+
+	if step >= len(fillNotes)-4 {
+		velocity = drumAccentVelocity
+	}
+
+	return map[int]int64{gmDrums[fillNotes[step]]: velocity}
+}
+
+/*updateDrums Works out the metric driven layers from the features of the latest sample. */
+func (processor *ProcInfo) updateDrums(features metricFeatures) {
+
+	drums := processor.drums
+
+	if features.crossed {
+		drums.crash = true
+	}
+
+	drums.snareDensity = features.volatility
+	drums.hatRate = hatSubdivisions[int(clamp(features.level*float64(len(hatSubdivisions)), 0, float64(len(hatSubdivisions)-1)))]
+}
+
+/*stepDrums Called on every sequencer tick, pushes the hits due on this step into the sequencer. */
+func (processor *ProcInfo) stepDrums() {
+
+	drums := processor.drums
+
+	if !processor.active {
+		return
+	}
+
+	/* A fill replaces everything else until it has finished, it plays even with the drum track off. */
+	if drums.filling {
+		processor.insertDrumHits(drums.fillHits(processor.tickCount))
+		return
+	}
+
+	if !drums.enabled {
+		return
+	}
+
+	hits := map[int]int64{}
+	tick := processor.tickCount
+
+	if drums.pattern != nil {
+		for _, step := range drums.pattern.steps[tick%len(drums.pattern.steps)] {
+			if step.note != gmDrums["kick"] || drums.kick == kickPattern {
+				hits[step.note] = step.velocity
+			}
+		}
+	}
+
+	onBeat := tick%defaultTicksPerBeat == 0
+
+	if (drums.kick == kickBeat && onBeat) || (drums.kick == kickBar && tick%euclideanSteps == 0) {
+		hits[gmDrums["kick"]] = drumVelocity
+	}
+
+	if drums.crash && onBeat {
+		hits[gmDrums["crash"]] = drumAccentVelocity
+		drums.crash = false
+	}
+
+	if drums.hatRate > 0 && tick%drums.hatRate == 0 {
+		if _, exists := hits[gmDrums["closed_hat"]]; !exists {
+			hits[gmDrums["closed_hat"]] = drumVelocity
+		}
+	}
+
+	if _, exists := hits[gmDrums["snare"]]; !exists && !onBeat && processor.random.Float64() < drums.snareDensity {
+		hits[gmDrums["snare"]] = drumGhostVelocity
+	}
+
+	processor.insertDrumHits(hits)
+}
+
+/*insertDrumHits Pushes drum hits into the sequencer, hits maps the GM note of each drum to its velocity. */
+func (processor *ProcInfo) insertDrumHits(hits map[int]int64) {
+
+	notes := make([]int, 0, len(hits))
+
+	for note := range hits {
+		notes = append(notes, note)
+	}
+
+	sort.Ints(notes)
+
+	/* Drum notes are fixed so they're stored as an octave and note, the same way the emitter builds them back up. */
+	for _, note := range notes {
+
+		octave, value := splitKey(note)
+
+		processor.insertEvent(event{eventType: drum, state: ready, duration: 1, value: value, octave: octave,
+			velocity: hits[note], midiChannel: drumChannel})
+	}
+}
+
+/*GetDrumPatterns Returns an array of drum pattern names for the front end. */
+func (processor *ProcInfo) GetDrumPatterns() []string {
+	return processor.drums.names
+}
+
+/*GetKickModes Returns an array of kick modes for the front end. */
+func (processor *ProcInfo) GetKickModes() []string {
+	return kickModesStr
+}
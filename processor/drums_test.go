@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDrumPattern(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		steps map[string]string
+		want  [][]drumStep
+		err   string
+	}{
+		{
+			name:  "hits and accents",
+			steps: map[string]string{"kick": "X.x."},
+			want:  [][]drumStep{{{36, drumAccentVelocity}}, nil, {{36, drumVelocity}}, nil},
+		},
+		{
+			name:  "shorter grids loop",
+			steps: map[string]string{"Kick": "x...", "closed_hat": "x."},
+			want:  [][]drumStep{{{36, drumVelocity}, {42, drumVelocity}}, nil, {{42, drumVelocity}}, nil},
+		},
+		{
+			name:  "dashes are rests",
+			steps: map[string]string{"snare": "-x"},
+			want:  [][]drumStep{nil, {{38, drumVelocity}}},
+		},
+		{
+			name:  "unknown drum",
+			steps: map[string]string{"gong": "x"},
+			err:   `steps.gong: unknown drum "gong"`,
+		},
+		{
+			name:  "empty grid",
+			steps: map[string]string{"kick": "x...", "snare": ""},
+			err:   "steps.snare: no steps defined",
+		},
+		{
+			name: "no steps",
+			err:  "steps: no steps defined",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			parsed, err := parseDrumPattern(DrumPattern{Name: test.name, Steps: test.steps})
+
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(parsed.steps, test.want) {
+				t.Errorf("steps = %v, want %v", parsed.steps, test.want)
+			}
+		})
+	}
+}
+
+/*drumHits Runs the drum machine for a number of ticks and returns the GM notes it queued on each one. */
+func drumHits(t *testing.T, processor *ProcInfo, ticks int) [][]int {
+
+	hits := make([][]int, ticks)
+
+	for tick := 0; tick < ticks; tick++ {
+
+		processor.tickCount = tick
+		processor.stepDrums()
+
+		for i, e := range processor.events {
+			if (event{}) != e {
+				if e.eventType != drum || e.midiChannel != drumChannel {
+					t.Errorf("drum hit queued as a note or off channel 10: %v", e)
+				}
+				hits[tick] = append(hits[tick], (e.octave+1)*12+e.value)
+				processor.events[i] = event{}
+			}
+		}
+	}
+
+	return hits
+}
+
+func TestStepDrums(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		kick     string
+		features metricFeatures
+		want     [][]int
+	}{
+		{
+			name: "pattern kick with quarter note hats at a low level",
+			kick: "Pattern",
+			want: [][]int{{36, 42}, nil, {38}, nil, {42}, nil, {38}, nil},
+		},
+		{
+			name: "kick every beat replaces the pattern kick",
+			kick: "Every Beat",
+			want: [][]int{{36, 42}, nil, {38}, nil, {36, 42}, nil, {38}, nil},
+		},
+		{
+			name:     "crossing the mean crashes on the next beat",
+			kick:     "Pattern",
+			features: metricFeatures{crossed: true},
+			want:     [][]int{{36, 42, 49}, nil, {38}, nil, {42}, nil, {38}, nil},
+		},
+		{
+			name:     "a high level plays 16th hats",
+			kick:     "Pattern",
+			features: metricFeatures{level: 1},
+			want:     [][]int{{36, 42}, {42}, {38, 42}, {42}, {42}, {42}, {38, 42}, {42}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+			processor.drums.parseDrumPatterns([]DrumPattern{{Name: "test", Steps: map[string]string{"kick": "x.......", "snare": "..x."}}})
+			processor.drums.enabled = true
+			processor.drums.setKickMode(test.kick)
+
+			processor.updateDrums(test.features)
+
+			if got := drumHits(t, processor, 8); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("hits = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDrumsFollowTheProcessor(t *testing.T) {
+
+	processor := newTestProcessor()
+	processor.drums.parseDrumPatterns([]DrumPattern{{Name: "test", Steps: map[string]string{"kick": "x"}}})
+
+	if hits := drumHits(t, processor, 1); hits[0] != nil {
+		t.Errorf("drums played while disabled: %v", hits)
+	}
+
+	processor.drums.enabled = true
+	processor.handleControlMessage(ControlMessage{Type: StopProcessor})
+
+	if hits := drumHits(t, processor, 1); hits[0] != nil {
+		t.Errorf("drums played while stopped: %v", hits)
+	}
+}
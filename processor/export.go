@@ -0,0 +1,79 @@
+package processor
+
+import "github.com/ElectricNoodle/prometheus-midi-generator/smf"
+
+/* Semitones above the root of the thirds that decide whether a scale is written as major or minor. */
+const (
+	minorThird = 3
+	majorThird = 4
+)
+
+/*
+GetKeySignature Returns the key signature of the current key and scale for MIDI file export. Key signatures only
+describe major and minor keys, so any scale with a minor third and no major third is treated as minor and everything
+else as major.
+*/
+func (processor *ProcInfo) GetKeySignature() smf.KeySignature {
+
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	hasMinorThird, hasMajorThird := false, false
+
+	for _, offset := range processor.activeScale.offsets {
+		hasMinorThird = hasMinorThird || offset == minorThird
+		hasMajorThird = hasMajorThird || offset == majorThird
+	}
+
+	minor := hasMinorThird && !hasMajorThird
+	root := processor.rootNoteOffset
+
+	/* A minor key shares its signature with the major key a minor third above it. */
+	if minor {
+		root += minorThird
+	}
+
+	/* Each fifth up the circle of fifths adds a sharp, 7 semitones is a fifth and 7*7 is 1 semitone mod 12. */
+	sharps := (root * 7) % 12
+
+	if sharps > 6 {
+		sharps -= 12
+	}
+
+	return smf.KeySignature{Sharps: int8(sharps), Minor: minor}
+}
+
+/*GetTrackNames Returns a name for each MIDI channel the processor plays on, by channel number (0-15), for MIDI file export. */
+func (processor *ProcInfo) GetTrackNames() map[uint8]string {
+
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	names := make(map[uint8]string)
+
+	name := func(channel int, part string) {
+
+		if channel < 1 || channel > 16 {
+			return
+		}
+
+		if existing, named := names[uint8(channel-1)]; named {
+			names[uint8(channel-1)] = existing + " & " + part
+			return
+		}
+
+		names[uint8(channel-1)] = part
+	}
+
+	name(processor.melodyChannel, "Melody")
+	name(processor.chordChannel, "Chords")
+	name(drumChannel, "Drums")
+
+	for _, m := range processor.modulations {
+		if _, named := names[uint8(m.midiChannel-1)]; !named {
+			name(m.midiChannel, m.name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,96 @@
+package processor
+
+import "math"
+
+/*
+metricFeatures Describes the shape of the recent metric values, used to drive musical decisions:
+level			Where the value sits between the minimum and maximum of the recent values (0-1).
+trend			Overall change across the recent values relative to their range (-1 falling to 1 rising).
+volatility	Average change between consecutive values relative to their range (0-1).
+crossed		The value has just crossed the mean of the recent values.
+*/
+type metricFeatures struct {
+	level      float64
+	trend      float64
+	volatility float64
+	crossed    bool
+}
+
+/*recentValues Returns the current value followed by the previous values, oldest last. */
+func (processor *ProcInfo) recentValues(value float64) []float64 {
+
+	return append([]float64{value}, listValues(processor.previousValues)...)
+}
+
+/*getFeatures Calculates the features of the current value in the context of the previous values. */
+func (processor *ProcInfo) getFeatures(value float64) metricFeatures {
+	return valueFeatures(processor.recentValues(value))
+}
+
+/*valueFeatures Calculates the features of the first value in the context of the rest, values are ordered newest first. */
+func valueFeatures(values []float64) metricFeatures {
+
+	features := metricFeatures{level: 0.5}
+
+	if len(values) < 2 {
+		return features
+	}
+
+	min, max, mean := values[0], values[0], 0.0
+
+	for _, v := range values {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+		mean += v
+	}
+
+	mean /= float64(len(values))
+	valueRange := max - min
+
+	if valueRange == 0 {
+		return features
+	}
+
+	features.level = (values[0] - min) / valueRange
+	features.trend = clamp(trendSlope(values)*float64(len(values)-1)/valueRange, -1, 1)
+
+	change := 0.0
+
+	for i := 1; i < len(values); i++ {
+		change += math.Abs(values[i-1] - values[i])
+	}
+
+	features.volatility = clamp(change/float64(len(values)-1)/valueRange, 0, 1)
+	features.crossed = (values[0] > mean) != (values[1] > mean)
+
+	return features
+}
+
+/*trendSlope Returns the least squares slope of the values per sample, values are ordered newest first. */
+func trendSlope(values []float64) float64 {
+
+	n := float64(len(values))
+	sumX, sumY, sumXY, sumXX := 0.0, 0.0, 0.0, 0.0
+
+	for i, v := range values {
+
+		x := float64(len(values) - 1 - i)
+
+		sumX += x
+		sumY += v
+		sumXY += x * v
+		sumXX += x * x
+	}
+
+	denominator := n*sumXX - sumX*sumX
+
+	if denominator == 0 {
+		return 0
+	}
+
+	return (n*sumXY - sumX*sumY) / denominator
+}
+
+func clamp(value float64, min float64, max float64) float64 {
+	return math.Max(min, math.Min(max, value))
+}
@@ -0,0 +1,220 @@
+package processor
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/smf"
+)
+
+/*melodyMode Defines how the melody note is picked for each metric value. */
+type melodyMode int
+
+var melodyModesStr = []string{"Value", "Markov"}
+
+/*
+valueMelody		The value is mapped straight onto a degree of the scale.
+markovMelody	The next degree is picked from a Markov chain trained on metric history or a MIDI file, biased by the live value.
+*/
+const (
+	valueMelody  melodyMode = 0
+	markovMelody melodyMode = 1
+)
+
+/* How strongly the degree the live value maps to pulls the Markov melody towards it. */
+const markovValuePull = 0.5
+
+/*markovModel Counts of transitions between scale degrees. */
+type markovModel struct {
+	transitions map[int]map[int]float64
+	degree      int
+}
+
+func newMarkovModel() *markovModel {
+	return &markovModel{transitions: make(map[int]map[int]float64)}
+}
+
+/*train Adds the transitions of a sequence of degrees to the model. */
+func (model *markovModel) train(degrees []int) {
+
+	for i := 1; i < len(degrees); i++ {
+
+		if model.transitions[degrees[i-1]] == nil {
+			model.transitions[degrees[i-1]] = make(map[int]float64)
+		}
+
+		model.transitions[degrees[i-1]][degrees[i]]++
+	}
+
+	if len(degrees) > 0 {
+		model.degree = degrees[len(degrees)-1]
+	}
+}
+
+func (model *markovModel) trained() bool {
+	return len(model.transitions) > 0
+}
+
+/*trainFromValues Trains the Markov melody on historical metric values. */
+func (processor *ProcInfo) trainFromValues(values []float64) {
+
+	degrees := make([]int, len(values))
+
+	for i, value := range values {
+		degrees[i] = processor.degreeOfValue(value)
+	}
+
+	processor.markov.train(degrees)
+	log.Printf("Trained Markov melody on %d metric values.\n", len(values))
+}
+
+/*trainFromMIDI Trains the Markov melody on the notes of a MIDI file, each note is moved to the nearest degree of the active scale. */
+func (processor *ProcInfo) trainFromMIDI(path string) {
+
+	file, err := smf.ReadFile(path)
+
+	if err != nil {
+		log.Printf("Failed to read MIDI file %s (%v)\n", path, err)
+		return
+	}
+
+	degrees := make([]int, 0, len(file.Notes))
+
+	for _, note := range file.Notes {
+
+		/* Drums don't have a pitch to learn from. */
+		if note.Channel == drumChannel-1 {
+			continue
+		}
+
+		degrees = append(degrees, processor.nearestDegree(int(note.Key)-processor.rootNoteOffset))
+	}
+
+	processor.markov.train(degrees)
+	log.Printf("Trained Markov melody on %d notes from %s.\n", len(degrees), path)
+}
+
+/*nearestDegree Returns the degree of the active scale closest to a number of semitones above the root. */
+func (processor *ProcInfo) nearestDegree(semitones int) int {
+
+	period := processor.activeScale.period()
+	pitchClass := ((semitones % period) + period) % period
+	nearest, nearestDistance := 0, period
+
+	for degree := 0; degree < processor.activeScale.degreeCount(); degree++ {
+
+		distance := processor.activeScale.offsets[degree] - pitchClass
+
+		if distance < 0 {
+			distance = -distance
+		}
+
+		if period-distance < distance {
+			distance = period - distance
+		}
+
+		if distance < nearestDistance {
+			nearest, nearestDistance = degree, distance
+		}
+	}
+
+	return nearest
+}
+
+/*
+nextMarkovDegree Picks the next melody degree from the transitions of the current one. The live value biases the choice:
+the trend favours moving up the scale while rising and down while falling, and degrees close to the one the value maps
+to are more likely, so the melody keeps the shape it learnt while still following the metric.
+*/
+func (processor *ProcInfo) nextMarkovDegree(value float64) int {
+
+	model := processor.markov
+	count := processor.activeScale.degreeCount()
+	target := processor.degreeOfValue(value)
+	trend := processor.getFeatures(value).trend
+
+	var candidates []int
+	var weights []float64
+	total := 0.0
+
+	/* Degrees are checked in order so a seeded run always makes the same choices. */
+	for to := 0; to < count; to++ {
+
+		weight, exists := model.transitions[model.degree][to]
+
+		if !exists {
+			continue
+		}
+
+		direction := 0.0
+
+		if to > model.degree {
+			direction = 1
+		} else if to < model.degree {
+			direction = -1
+		}
+
+		distance := math.Abs(float64(to - target))
+
+		weight *= 1 + clamp(trend*direction*trendBias, -0.9, trendBias)
+		weight *= 1 / (1 + distance*markovValuePull)
+
+		candidates = append(candidates, to)
+		weights = append(weights, weight)
+		total += weight
+	}
+
+	/* A degree with nowhere to go (or from another scale) falls back to the value. */
+	if len(candidates) == 0 {
+		model.degree = target
+		return target
+	}
+
+	choice := processor.random.Float64() * total
+	model.degree = candidates[len(candidates)-1]
+
+	for i, weight := range weights {
+
+		choice -= weight
+
+		if choice < 0 {
+			model.degree = candidates[i]
+			break
+		}
+	}
+
+	return model.degree
+}
+
+/*setMelodyMode Changes how the melody is generated. */
+func (processor *ProcInfo) setMelodyMode(name string) {
+
+	for i, mode := range melodyModesStr {
+		if mode == name {
+			processor.melodyMode = melodyMode(i)
+		}
+	}
+
+	if processor.melodyMode == markovMelody && !processor.markov.trained() {
+		log.Println("Markov melody hasn't been trained yet, the value will be used until it is.")
+	}
+}
+
+/*setSeed Reseeds the random source used by every random choice, so a run can be reproduced. 0 picks a seed from the clock. */
+func (processor *ProcInfo) setSeed(seed int64) {
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	processor.seed = seed
+	processor.random = rand.New(rand.NewSource(seed))
+
+	log.Printf("Random seed set to %d\n", seed)
+}
+
+/*GetMelodyModes Returns an array of melody mode names for the front end. */
+func (processor *ProcInfo) GetMelodyModes() []string {
+	return melodyModesStr
+}
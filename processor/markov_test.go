@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarkovTrain(t *testing.T) {
+
+	model := newMarkovModel()
+
+	if model.trained() {
+		t.Fatal("a new model should not be trained")
+	}
+
+	model.train([]int{0, 2, 4, 2, 4, 0})
+
+	expected := map[int]map[int]float64{
+		0: {2: 1},
+		2: {4: 2},
+		4: {2: 1, 0: 1},
+	}
+
+	if !reflect.DeepEqual(model.transitions, expected) {
+		t.Errorf("got transitions %v, want %v", model.transitions, expected)
+	}
+
+	if model.degree != 0 {
+		t.Errorf("got degree %d, want the last degree trained on (0)", model.degree)
+	}
+}
+
+func TestNearestDegree(t *testing.T) {
+
+	processor := newTestProcessor()
+	processor.setScale("Ionian")
+
+	tests := []struct {
+		semitones int
+		degree    int
+	}{
+		{0, 0},
+		{2, 1},
+		{1, 0},
+		{6, 3},
+		{11, 6},
+		{13, 0},
+		{-1, 6},
+		{-12, 0},
+	}
+
+	for _, test := range tests {
+		if degree := processor.nearestDegree(test.semitones); degree != test.degree {
+			t.Errorf("nearestDegree(%d) = %d, want %d", test.semitones, degree, test.degree)
+		}
+	}
+}
+
+func TestMarkovFollowsTransitions(t *testing.T) {
+
+	processor := newTestProcessor()
+	processor.setScale("Ionian")
+
+	/* Values 7 and 9 wrap round to degrees 0 and 2, so every degree has exactly one place to go. */
+	processor.trainFromValues([]float64{0, 2, 4, 7, 9, 4})
+
+	for i, expected := range []int{0, 2, 4, 0, 2} {
+		if degree := processor.nextMarkovDegree(6); degree != expected {
+			t.Fatalf("step %d: got degree %d, want %d", i, degree, expected)
+		}
+	}
+}
+
+func TestSeededMarkovRepeats(t *testing.T) {
+
+	values := []float64{3, 5, 1, 6, 2, 2, 4, 0, 5, 3, 6, 1}
+
+	run := func(seed int64) []int {
+
+		processor := newTestProcessor()
+		processor.setScale("Ionian")
+		processor.trainFromValues(values)
+		processor.setMelodyMode("Markov")
+		processor.setSeed(seed)
+
+		var degrees []int
+
+		for i := 0; i < 3; i++ {
+			for _, value := range values {
+				degrees = append(degrees, processor.nextMarkovDegree(value))
+			}
+		}
+
+		return degrees
+	}
+
+	first, second := run(42), run(42)
+
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("two runs with the same seed differ:\n%v\n%v", first, second)
+	}
+}
@@ -0,0 +1,280 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/midioutput"
+)
+
+/*
+Modulation Defines the format of a continuous metric to controller mapping config:
+type		cc, pitch_bend, aftertouch or poly_aftertouch. mod_wheel, pan, expression and cutoff are shorthands for those CCs.
+controller	CC number when the type is cc.
+channel		MIDI channel (1-16) the messages are sent on, defaults to 1.
+source		metric or second_metric, the second metric is the one set up in the velocity options.
+feature		level, trend or volatility of the source (see metricFeatures), defaults to level.
+min/max		Output range, defaults to the full range of the message type.
+curve		linear, exponential, logarithmic or s-curve.
+smoothing	0-1, how much of the previous output is kept on every update, higher is slower.
+rate		Number of sequencer ticks between updates, defaults to 1.
+*/
+type Modulation struct {
+	Name       string  `yaml:"name"`
+	Type       string  `yaml:"type"`
+	Controller int     `yaml:"controller"`
+	Channel    int     `yaml:"channel"`
+	Source     string  `yaml:"source"`
+	Feature    string  `yaml:"feature"`
+	Min        *int    `yaml:"min"`
+	Max        *int    `yaml:"max"`
+	Curve      string  `yaml:"curve"`
+	Smoothing  float64 `yaml:"smoothing"`
+	Rate       int     `yaml:"rate"`
+	Enabled    bool    `yaml:"enabled"`
+}
+
+/* Shorthands for the common controllers. */
+var controllerNames = map[string]int{
+	"mod_wheel":  1,
+	"pan":        10,
+	"expression": 11,
+	"cutoff":     74,
+}
+
+/* Output ranges of each message type. */
+var modulationRanges = map[midioutput.MIDIValue][2]int{
+	midioutput.ControlChange:     {0, 127},
+	midioutput.PitchBend:         {-8192, 8191},
+	midioutput.ChannelAftertouch: {0, 127},
+	midioutput.PolyAftertouch:    {0, 127},
+}
+
+var modulationTypes = map[string]midioutput.MIDIValue{
+	"cc":              midioutput.ControlChange,
+	"pitch_bend":      midioutput.PitchBend,
+	"aftertouch":      midioutput.ChannelAftertouch,
+	"poly_aftertouch": midioutput.PolyAftertouch,
+}
+
+/*curve Shapes the 0-1 level before it is scaled into the output range. */
+var curves = map[string]func(float64) float64{
+	"":            func(x float64) float64 { return x },
+	"linear":      func(x float64) float64 { return x },
+	"exponential": func(x float64) float64 { return x * x },
+	"logarithmic": func(x float64) float64 { return math.Sqrt(x) },
+	"s-curve":     func(x float64) float64 { return x * x * (3 - 2*x) },
+}
+
+/*modulation Parsed version of the modulation config, with the state needed to smooth its output. */
+type modulation struct {
+	name         string
+	messageType  midioutput.MIDIValue
+	controller   int
+	midiChannel  int
+	secondMetric bool
+	feature      string
+	min          int
+	max          int
+	curve        func(float64) float64
+	smoothing    float64
+	rate         int
+	enabled      bool
+	target       float64
+	current      float64
+	last         int
+}
+
+/*parseModulation Validates a modulation from the config and fills in the defaults. Errors name the field that is wrong. */
+func parseModulation(config Modulation) (*modulation, error) {
+
+	parsed := &modulation{name: config.Name, controller: config.Controller, midiChannel: config.Channel,
+		feature: config.Feature, smoothing: config.Smoothing, rate: config.Rate, enabled: config.Enabled, target: 0.5, current: 0.5, last: math.MinInt32}
+
+	if controller, exists := controllerNames[config.Type]; exists {
+		parsed.messageType = midioutput.ControlChange
+		parsed.controller = controller
+	} else if messageType, exists := modulationTypes[config.Type]; exists {
+		parsed.messageType = messageType
+	} else {
+		return nil, fmt.Errorf("type: unknown type %q", config.Type)
+	}
+
+	if parsed.messageType == midioutput.ControlChange && (parsed.controller < 0 || parsed.controller > 119) {
+		return nil, fmt.Errorf("controller: %d must be between 0 and 119", parsed.controller)
+	}
+
+	if parsed.midiChannel == 0 {
+		parsed.midiChannel = 1
+	}
+
+	if parsed.midiChannel < 1 || parsed.midiChannel > 16 {
+		return nil, fmt.Errorf("channel: %d must be between 1 and 16", parsed.midiChannel)
+	}
+
+	switch config.Source {
+	case "", "metric":
+	case "second_metric":
+		parsed.secondMetric = true
+	default:
+		return nil, fmt.Errorf("source: unknown source %q", config.Source)
+	}
+
+	switch config.Feature {
+	case "", "level", "trend", "volatility":
+	default:
+		return nil, fmt.Errorf("feature: unknown feature %q", config.Feature)
+	}
+
+	outputRange := modulationRanges[parsed.messageType]
+	parsed.min, parsed.max = outputRange[0], outputRange[1]
+
+	if config.Min != nil {
+		parsed.min = *config.Min
+	}
+
+	if config.Max != nil {
+		parsed.max = *config.Max
+	}
+
+	if parsed.min < outputRange[0] || parsed.min > outputRange[1] {
+		return nil, fmt.Errorf("min: %d is outside %d-%d", parsed.min, outputRange[0], outputRange[1])
+	}
+
+	if parsed.max < outputRange[0] || parsed.max > outputRange[1] {
+		return nil, fmt.Errorf("max: %d is outside %d-%d", parsed.max, outputRange[0], outputRange[1])
+	}
+
+	if parsed.min > parsed.max {
+		return nil, fmt.Errorf("max: %d is below min %d", parsed.max, parsed.min)
+	}
+
+	curve, exists := curves[config.Curve]
+
+	if !exists {
+		return nil, fmt.Errorf("curve: unknown curve %q", config.Curve)
+	}
+
+	parsed.curve = curve
+
+	if parsed.smoothing < 0 || parsed.smoothing >= 1 {
+		return nil, fmt.Errorf("smoothing: %f must be between 0 and 1", parsed.smoothing)
+	}
+
+	if parsed.rate == 0 {
+		parsed.rate = 1
+	}
+
+	if parsed.rate < 0 {
+		return nil, fmt.Errorf("rate: %d must be positive", parsed.rate)
+	}
+
+	return parsed, nil
+}
+
+/*parseModulations Processes and stores the modulations from the configuration file, invalid ones are logged and skipped. */
+func (processor *ProcInfo) parseModulations(modulationList []Modulation) {
+
+	for _, config := range modulationList {
+
+		parsed, err := parseModulation(config)
+
+		if err != nil {
+			log.Printf("Skipping modulation %s: %v\n", config.Name, err)
+			continue
+		}
+
+		processor.modulations = append(processor.modulations, parsed)
+	}
+}
+
+/*updateModulations Sets the level each modulation is moving towards, called whenever a new sample arrives from either metric. */
+func (processor *ProcInfo) updateModulations(secondMetric bool, values []float64) {
+
+	features := valueFeatures(values)
+
+	for _, m := range processor.modulations {
+
+		if m.secondMetric != secondMetric {
+			continue
+		}
+
+		switch m.feature {
+		case "trend":
+			m.target = (features.trend + 1) / 2
+		case "volatility":
+			m.target = features.volatility
+		default:
+			m.target = features.level
+		}
+	}
+}
+
+/*stepModulations Called on every sequencer tick, moves each modulation towards its target and sends any change as a parameter event. */
+func (processor *ProcInfo) stepModulations() {
+
+	if !processor.active {
+		return
+	}
+
+	for _, m := range processor.modulations {
+
+		if !m.enabled || processor.tickCount%m.rate != 0 {
+			continue
+		}
+
+		m.current = m.smoothing*m.current + (1-m.smoothing)*m.target
+		value := m.min + int(math.Round(m.curve(clamp(m.current, 0, 1))*float64(m.max-m.min)))
+
+		if value == m.last {
+			continue
+		}
+
+		m.last = value
+
+		e := event{eventType: parameter, state: ready, value: value, controller: m.controller,
+			messageType: m.messageType, midiChannel: m.midiChannel}
+
+		if m.messageType != midioutput.PolyAftertouch {
+			processor.insertEvent(e)
+			continue
+		}
+
+		/* Poly aftertouch is sent to every note sounding on the channel. */
+		for _, sounding := range processor.events {
+			if sounding.eventType != parameter && sounding.state == active && sounding.midiChannel == m.midiChannel {
+				e.note = sounding.note
+				e.octave = sounding.octave
+				processor.insertEvent(e)
+			}
+		}
+	}
+}
+
+/*setModulation Turns a modulation on or off. */
+func (processor *ProcInfo) setModulation(name string, enabled bool) {
+
+	for _, m := range processor.modulations {
+		if m.name == name {
+			m.enabled = enabled
+			m.last = math.MinInt32
+		}
+	}
+}
+
+/*GetModulations Returns the names of the configured modulations and whether each one is enabled, for the front end. */
+func (processor *ProcInfo) GetModulations() ([]string, []bool) {
+
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	names := make([]string, len(processor.modulations))
+	enabled := make([]bool, len(processor.modulations))
+
+	for i, m := range processor.modulations {
+		names[i] = m.name
+		enabled[i] = m.enabled
+	}
+
+	return names, enabled
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/midioutput"
+)
+
+func TestParseModulation(t *testing.T) {
+
+	low, high, tooHigh, negative := 20, 100, 200, -1
+
+	tests := []struct {
+		name   string
+		config Modulation
+		min    int
+		max    int
+		err    string
+	}{
+		{"defaults to the full range", Modulation{Type: "mod_wheel"}, 0, 127, ""},
+		{"pitch bend range", Modulation{Type: "pitch_bend"}, -8192, 8191, ""},
+		{"configured range", Modulation{Type: "cc", Controller: 7, Min: &low, Max: &high}, 20, 100, ""},
+		{"unknown type", Modulation{Type: "volume"}, 0, 0, `type: unknown type "volume"`},
+		{"controller out of range", Modulation{Type: "cc", Controller: 120}, 0, 0, "controller: 120 must be between 0 and 119"},
+		{"channel out of range", Modulation{Type: "cc", Channel: 17}, 0, 0, "channel: 17 must be between 1 and 16"},
+		{"min out of range", Modulation{Type: "cc", Min: &negative}, 0, 0, "min: -1 is outside 0-127"},
+		{"max out of range", Modulation{Type: "cc", Max: &tooHigh}, 0, 0, "max: 200 is outside 0-127"},
+		{"inverted range", Modulation{Type: "cc", Min: &high, Max: &low}, 0, 0, "max: 20 is below min 100"},
+		{"unknown curve", Modulation{Type: "cc", Curve: "cubic"}, 0, 0, `curve: unknown curve "cubic"`},
+		{"smoothing out of range", Modulation{Type: "cc", Smoothing: 1}, 0, 0, "smoothing: 1.000000 must be between 0 and 1"},
+		{"negative rate", Modulation{Type: "cc", Rate: -2}, 0, 0, "rate: -2 must be positive"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			parsed, err := parseModulation(test.config)
+
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if parsed.min != test.min || parsed.max != test.max {
+				t.Errorf("range = %d-%d, want %d-%d", parsed.min, parsed.max, test.min, test.max)
+			}
+		})
+	}
+}
+
+func TestStepModulations(t *testing.T) {
+
+	processor := newTestProcessor()
+	processor.parseModulations([]Modulation{{Name: "wheel", Type: "mod_wheel", Enabled: true}})
+
+	processor.updateModulations(false, []float64{10, 0})
+	processor.stepModulations()
+
+	e := processor.events[0]
+
+	if e.eventType != parameter || e.messageType != midioutput.ControlChange || e.controller != 1 || e.value != 127 {
+		t.Fatalf("expected mod wheel at 127, got %+v", e)
+	}
+
+	/* An unchanged value isn't sent again. */
+	processor.events[0] = event{}
+	processor.stepModulations()
+
+	if processor.events[0] != (event{}) {
+		t.Errorf("unchanged modulation was sent again: %+v", processor.events[0])
+	}
+}
@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+	"github.com/ElectricNoodle/prometheus-midi-generator/midioutput"
+)
+
+/* Number of beats rendered after the last sample, so the notes it started can finish. */
+const offlineTailBeats = 8
+
+/* Names of the series in the render inputs, fed in the same way as the values from velocityInput and baselineInput. */
+const (
+	VelocityInput = "velocity"
+	BaselineInput = "baseline"
+)
+
+/*
+NewOfflineProcessor Returns a processor for offline rendering, no threads are started as RenderValues/RenderFrames drive
+it. It has no Output channel, messages are handed to the emit function of the render instead.
+*/
+func NewOfflineProcessor(logIn *logging.Logger, processorConfig Config) *ProcInfo {
+	return newProcessor(logIn, processorConfig, nil)
+}
+
+/*RenderValues Plays the values of a single metric through the processor on a virtual clock, see render. */
+func (processor *ProcInfo) RenderValues(values []float64, inputs []map[string]float64, interval time.Duration, emit func(time.Duration, midioutput.MIDIMessage)) {
+
+	processor.render(len(values), func(i int) { processor.processSample(values[i]) }, inputs, interval, emit)
+}
+
+/*RenderFrames Plays the frames of the bindings through the processor on a virtual clock, see render. */
+func (processor *ProcInfo) RenderFrames(frames []map[string]float64, inputs []map[string]float64, interval time.Duration, emit func(time.Duration, midioutput.MIDIMessage)) {
+
+	processor.render(len(frames), func(i int) { processor.processFrame(frames[i]) }, inputs, interval, emit)
+}
+
+/*deliverInputs Feeds the second metric and baseline values of a sample in, the way the generation thread does when they arrive. */
+func (processor *ProcInfo) deliverInputs(inputs map[string]float64) {
+
+	if value, exists := inputs[VelocityInput]; exists {
+		processor.addToVelocityValues(value)
+	}
+
+	if value, exists := inputs[BaselineInput]; exists {
+		processor.setBaseline(value)
+	}
+}
+
+/*
+render Runs the processor as fast as the CPU allows. The samples are delivered one every interval of virtual time, the
+way the scraper's output thread delivers them, and the sequencer ticks the same way as in the generation thread, only
+the sleep between ticks moves the virtual clock instead of waiting. Input i, the second metric and baseline values, is
+delivered just before sample i. Every message the processor sends is handed to emit as it is sent, with the virtual
+time it was sent at, so nothing waits on a channel while the lock is held. Once the samples run out a few more beats
+are played and then everything is released.
+*/
+func (processor *ProcInfo) render(count int, deliver func(int), inputs []map[string]float64, interval time.Duration, emit func(time.Duration, midioutput.MIDIMessage)) {
+
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	start := time.Now()
+	virtual := time.Duration(0)
+	processor.now = func() time.Time { return start.Add(virtual) }
+	processor.send = func(message midioutput.MIDIMessage) { emit(virtual, message) }
+
+	next := 0
+	tail := time.Duration(0)
+
+	for next < count || tail < offlineTailBeats*time.Minute/time.Duration(processor.currentBPM()) {
+
+		for next < count && time.Duration(next)*interval <= virtual {
+
+			if next < len(inputs) {
+				processor.deliverInputs(inputs[next])
+			}
+
+			if processor.active {
+				deliver(next)
+			}
+
+			next++
+		}
+
+		sleepTime := processor.step()
+
+		if next >= count {
+			tail += sleepTime
+		}
+
+		virtual += sleepTime
+	}
+
+	processor.releaseEvents()
+
+	log.Printf("Rendered %d samples into %s of music in %s.\n", count, virtual, time.Since(start))
+}
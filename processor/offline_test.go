@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+	"github.com/ElectricNoodle/prometheus-midi-generator/midioutput"
+)
+
+/*sentMessage A message handed to emit by a render, with the virtual time it was sent at. */
+type sentMessage struct {
+	at      time.Duration
+	message midioutput.MIDIMessage
+}
+
+/*renderValues Renders the values with an offline processor built from the config and returns everything it sent. */
+func renderValues(config Config, values []float64, inputs []map[string]float64) ([]sentMessage, *ProcInfo) {
+
+	if err := config.Validate(); err != nil {
+		panic(err)
+	}
+
+	processor := NewOfflineProcessor(logging.NewLogger(), config)
+	var messages []sentMessage
+
+	processor.RenderValues(values, inputs, 600*time.Millisecond, func(at time.Duration, message midioutput.MIDIMessage) {
+		messages = append(messages, sentMessage{at, message})
+	})
+
+	return messages, processor
+}
+
+func TestRenderValues(t *testing.T) {
+
+	values := []float64{0, 3, 5, 2, 7, 1, 4, 6}
+	messages, _ := renderValues(Config{Scales: testScales, Seed: 1}, values, nil)
+
+	var played []midioutput.MIDIMessage
+	var last time.Duration
+	noteOns := 0
+
+	for _, sent := range messages {
+
+		if sent.at < last {
+			t.Fatalf("message sent at %s after one at %s", sent.at, last)
+		}
+
+		if sent.message.Type == midioutput.NoteOn {
+			noteOns++
+		}
+
+		last = sent.at
+		played = append(played, sent.message)
+	}
+
+	if noteOns < len(values) {
+		t.Errorf("got %d notes for %d samples", noteOns, len(values))
+	}
+
+	if last < time.Duration(len(values)-1)*600*time.Millisecond {
+		t.Errorf("the last message was sent at %s, before the last sample was due", last)
+	}
+
+	if left := sounding(played); len(left) != 0 {
+		t.Errorf("notes left hanging at the end of the render: %v", left)
+	}
+
+	/* Rendering runs on the virtual clock, so the same seed renders the same music. */
+	again, _ := renderValues(Config{Scales: testScales, Seed: 1}, values, nil)
+
+	if !reflect.DeepEqual(messages, again) {
+		t.Errorf("two renders of the same values differ")
+	}
+}
+
+func TestRenderInputs(t *testing.T) {
+
+	config := Config{Scales: testScales, VelocityMode: "Second Metric",
+		Anomalies: Anomalies{Detectors: []AnomalyDetector{{Type: "seasonal", Threshold: 100}}}}
+
+	inputs := []map[string]float64{
+		{VelocityInput: 0, BaselineInput: 4},
+		{VelocityInput: 10, BaselineInput: 5},
+		{VelocityInput: 0},
+		{VelocityInput: 10, BaselineInput: 6},
+	}
+
+	messages, processor := renderValues(config, []float64{1, 2, 3, 4}, inputs)
+
+	middle := int64(defaultMinVelocity+defaultMaxVelocity) / 2
+	expected := []int64{middle, defaultMaxVelocity, defaultMinVelocity, defaultMaxVelocity}
+	var velocities []int64
+
+	for _, sent := range messages {
+
+		message := sent.message
+
+		if message.Type != midioutput.NoteOn {
+			continue
+		}
+
+		if len(velocities) == 0 || velocities[len(velocities)-1] != message.Velocity {
+			velocities = append(velocities, message.Velocity)
+		}
+	}
+
+	if !reflect.DeepEqual(velocities, expected) {
+		t.Errorf("got velocities %v from the second metric, want %v", velocities, expected)
+	}
+
+	if processor.velocityValues.Len() != len(inputs) {
+		t.Errorf("got %d second metric values, want %d", processor.velocityValues.Len(), len(inputs))
+	}
+
+	if !processor.anomalies.hasBaseline || processor.anomalies.baseline != 6 {
+		t.Errorf("got baseline %f, want the last one delivered (6)", processor.anomalies.baseline)
+	}
+}
@@ -2,10 +2,10 @@ package processor
 
 import (
 	"container/list"
+	"math/rand"
+	"sync"
 	"time"
 
-	"math"
-
 	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
 	"github.com/ElectricNoodle/prometheus-midi-generator/midioutput"
 
@@ -37,11 +37,7 @@ var notes = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#",
 type Scale struct {
 	Name      string `yaml:"name"`
 	Intervals []int  `yaml:"intervals,flow"`
-}
-
-/*Config Defines the format of the process */
-type Config struct {
-	Scales []Scale `yaml:"scales"`
+	Tuning    string `yaml:"tuning"`
 }
 
 type eventType int
@@ -49,6 +45,7 @@ type eventType int
 const (
 	note      eventType = 0
 	parameter eventType = 1
+	drum      eventType = 2
 )
 
 /*eventState Defines all of the states a sequencer event can be in */
@@ -60,15 +57,30 @@ const (
 	stop   eventState = 2
 )
 
-/*event Stores information needed to send different types of MIDI Message. */
+/*Track Identifies the different parts generated by the processor. */
+type Track int
+
+/* The tracks of the processor, a melody and an accompanying chord part. */
+const (
+	MelodyTrack Track = 0
+	ChordTrack  Track = 1
+)
+
+const numTracks = 2
+
+/*event Stores information needed to send different types of MIDI Message. note is the exact value sent with the NoteOn, so the NoteOff matches it even if the key changes. */
 type event struct {
 	eventType   eventType
+	track       Track
 	state       eventState
 	duration    int
 	value       int
+	note        int
 	octave      int
 	velocity    int64
 	midiChannel int
+	messageType midioutput.MIDIValue
+	controller  int
 }
 
 /*MessageType Defines the different types of Control Message.*/
@@ -76,13 +88,41 @@ type MessageType int
 
 /* Used to nicely assign values to message types */
 const (
-	SetKey          MessageType = 0
-	SetMode         MessageType = 1
-	SetVelocityMode MessageType = 2
-	SetBPM          MessageType = 3
-	SetChordMode    MessageType = 4
-	StopProcessor   MessageType = 5
-	StartProcessor  MessageType = 6
+	SetKey           MessageType = 0
+	SetMode          MessageType = 1
+	SetVelocityMode  MessageType = 2
+	SetBPM           MessageType = 3
+	SetChordMode     MessageType = 4
+	StopProcessor    MessageType = 5
+	StartProcessor   MessageType = 6
+	Panic            MessageType = 7
+	SetVoicing       MessageType = 8
+	SetProgression   MessageType = 9
+	SetArpPattern    MessageType = 10
+	SetArpRate       MessageType = 11
+	SetArpOctaves    MessageType = 12
+	SetArpGate       MessageType = 13
+	SetVelocity      MessageType = 14
+	SetMinVelocity   MessageType = 15
+	SetMaxVelocity   MessageType = 16
+	SetRhythmMode    MessageType = 17
+	SetDrums         MessageType = 18
+	SetDrumPattern   MessageType = 19
+	SetKickMode      MessageType = 20
+	SetModulation    MessageType = 21
+	SetLowNote       MessageType = 22
+	SetHighNote      MessageType = 23
+	SetOctaveMode    MessageType = 24
+	SetRangeStrategy MessageType = 25
+	SetMelodyMode    MessageType = 26
+	TrainMarkov      MessageType = 27
+	TrainMarkovMIDI  MessageType = 28
+	SetSeed          MessageType = 29
+	SetAnomalies     MessageType = 30
+	SetRule          MessageType = 31
+	SetTempoFollow   MessageType = 32
+	SetTempoRamp     MessageType = 33
+	SaveTempoLog     MessageType = 34
 )
 
 /*ControlMessage Used for sending control messages to processor.*/
@@ -90,6 +130,8 @@ type ControlMessage struct {
 	Type        MessageType
 	ValueNum    int
 	ValueString string
+	Track       Track
+	Values      []float64
 }
 
 /*scaleMap Used for storing all useful information of a scale. */
@@ -98,21 +140,12 @@ type scaleMap struct {
 	notes     []string
 	offsets   []int
 	intervals []int
+	tuning    string
 }
 
-type velocityMode int
-
-const (
-	fixed              velocityMode = 0
-	singleNoteVariance velocityMode = 1
-)
-
-const maxVelocity = 110
-const defaultVelocity = 0
-
 type chordMode int
 
-var chordModesStr = []string{"Single Note", "Major", "Minor", "Asc Major", "Asc Minor"}
+var chordModesStr = []string{"Single Note", "Major", "Minor", "Asc Major", "Asc Minor", "Triads", "Sevenths", "Ninths", "Sus2", "Sus4", "Power", "Progression"}
 
 const (
 	none                chordMode = 0
@@ -120,54 +153,139 @@ const (
 	minorOnly           chordMode = 2
 	ascendingMajDescMin chordMode = 3
 	ascendingMinDescMaj chordMode = 4
+	diatonicTriads      chordMode = 5
+	diatonicSevenths    chordMode = 6
+	diatonicNinths      chordMode = 7
+	diatonicSus2        chordMode = 8
+	diatonicSus4        chordMode = 9
+	powerChords         chordMode = 10
+	progressionMode     chordMode = 11
 )
 
+/*diatonicChordTypes Maps the chord modes that always use the same type of diatonic chord to that type. */
+var diatonicChordTypes = map[chordMode]chordType{
+	diatonicTriads:   triad,
+	diatonicSevenths: seventh,
+	diatonicNinths:   ninth,
+	diatonicSus2:     sus2,
+	diatonicSus4:     sus4,
+	powerChords:      power,
+}
+
 const maxEvents = 200
 const defaultBPM = 60
 const defaultTicksPerBeat = 4
 
+/* Note length in sequencer ticks (4 beats). */
+const defaultDuration = 4 * defaultTicksPerBeat
+
 const maxPreviousValues = 20
 
 /*ProcInfo Holds input/output info and generation parameters.*/
 type ProcInfo struct {
 	Control             chan ControlMessage
 	input               chan float64
+	velocityInput       chan float64
+	baselineInput       chan float64
+	frameInput          chan map[string]float64
 	Output              chan midioutput.MIDIMessage
 	BPM                 float64
 	TickInc             time.Duration
 	tick                float64
+	tickCount           int
+	lastSampleTick      int
+	sampleTicks         int
 	scales              *orderedmap.OrderedMap
 	activeScale         scaleMap
 	rootNoteOffset      int
 	velocitySensingMode velocityMode
 	chordGenerationMode chordMode
+	melodyOctave        int
+	chordOctave         int
+	melodyChannel       int
+	chordChannel        int
+	voicing             voicingMode
+	previousChord       []int
+	progressions        *orderedmap.OrderedMap
+	activeProgression   *progression
+	progressionPos      int
+	progressionHold     int
+	random              *rand.Rand
+	seed                int64
+	melodyMode          melodyMode
+	markov              *markovModel
+	arp                 *arpeggiator
+	rhythms             []*rhythm
+	ranges              []*noteRange
+	drums               *drumMachine
+	modulations         []*modulation
+	anomalies           *anomalyMonitor
+	rules               []*rule
+	bindings            []*binding
+	boundVelocity       int64
+	tempo               *tempoRamp
+	now                 func() time.Time
+	send                func(midioutput.MIDIMessage)
 	previousValues      *list.List
+	velocityValues      *list.List
 	maxVariance         float64
+	maxRateOfChange     float64
+	fixedVelocity       int64
+	minVelocity         int64
+	maxVelocity         int64
 	events              []event
 	active              bool
+	lock                sync.Mutex
 }
 
 /*NewProcessor returns a new instance of the processor stack and starts the control/generation threads. */
-func NewProcessor(logIn *logging.Logger, processorConfig Config, inputChannel chan float64) *ProcInfo {
+func NewProcessor(logIn *logging.Logger, processorConfig Config, inputChannel chan float64, velocityChannel chan float64, baselineChannel chan float64, frameChannel chan map[string]float64) *ProcInfo {
 
-	log = logIn
-	processor := ProcInfo{Control: make(chan ControlMessage, 6), input: inputChannel,
-		Output: make(chan midioutput.MIDIMessage, 6), BPM: defaultBPM,
-		TickInc: defaultTicksPerBeat, tick: 0, scales: orderedmap.NewOrderedMap(), activeScale: scaleMap{},
-		rootNoteOffset: 0, velocitySensingMode: singleNoteVariance, chordGenerationMode: majorOnly,
-		previousValues: list.New(), maxVariance: 0, events: make([]event, maxEvents), active: true}
+	processor := newProcessor(logIn, processorConfig, make(chan midioutput.MIDIMessage, 6))
 
-	processor.parseScales(processorConfig.Scales)
-	processor.generateNotesOfScale(noteIndexes["A"])
-	processor.setScale("Chromatic")
+	processor.input = inputChannel
+	processor.velocityInput = velocityChannel
+	processor.baselineInput = baselineChannel
+	processor.frameInput = frameChannel
 
 	go processor.controlThread()
 	go processor.generationThread()
 
-	return &processor
+	return processor
 
 }
 
+/*newProcessor Builds the processor from the config without starting any threads. */
+func newProcessor(logIn *logging.Logger, processorConfig Config, output chan midioutput.MIDIMessage) *ProcInfo {
+
+	log = logIn
+	processor := &ProcInfo{Control: make(chan ControlMessage, 6), Output: output, BPM: float64(processorConfig.BPM), now: time.Now,
+		TickInc: defaultTicksPerBeat, tick: 0, scales: orderedmap.NewOrderedMap(), activeScale: scaleMap{},
+		rootNoteOffset: 0, voicing: closeVoicing, progressions: orderedmap.NewOrderedMap(), markov: newMarkovModel(),
+		melodyOctave: *processorConfig.MelodyOctave, chordOctave: *processorConfig.ChordOctave,
+		melodyChannel: processorConfig.MelodyChannel, chordChannel: processorConfig.ChordChannel,
+		arp: newArpeggiator(), rhythms: []*rhythm{newRhythm(), newRhythm()},
+		ranges: []*noteRange{newNoteRange(processorConfig.MelodyRange), newNoteRange(processorConfig.ChordRange)}, drums: newDrumMachine(), anomalies: newAnomalyMonitor(processorConfig.Anomalies), tempo: newTempoRamp(processorConfig.Tempo), previousValues: list.New(), velocityValues: list.New(), maxVariance: 0, maxRateOfChange: 0,
+		fixedVelocity: defaultVelocity, minVelocity: defaultMinVelocity, maxVelocity: defaultMaxVelocity,
+		events: make([]event, maxEvents), active: true}
+	processor.send = func(message midioutput.MIDIMessage) { processor.Output <- message }
+
+	processor.parseScales(processorConfig.Scales)
+	processor.parseProgressions(processorConfig.Progressions)
+	processor.drums.parseDrumPatterns(processorConfig.DrumPatterns)
+	processor.parseModulations(processorConfig.Modulations)
+	processor.parseRules(processorConfig.Rules)
+	processor.parseBindings(processorConfig.Bindings)
+	processor.generateNotesOfScale(noteIndexes[processorConfig.DefaultKey])
+	processor.setScale(processorConfig.DefaultScale)
+	processor.setChordMode(processorConfig.ChordMode)
+	processor.setVelocityMode(processorConfig.VelocityMode)
+	processor.setMelodyMode(processorConfig.MelodyMode)
+	processor.setSeed(processorConfig.Seed)
+
+	return processor
+}
+
 func (processor *ProcInfo) setScale(name string) {
 
 	scale, exists := processor.scales.Get(name)
@@ -194,6 +312,7 @@ func (processor *ProcInfo) parseScales(scaleList []Scale) {
 		scaleMapping.name = scale.Name
 		scaleMapping.intervals = scale.Intervals
 		scaleMapping.offsets = processor.getNoteOffsets(scaleMapping.intervals)
+		scaleMapping.tuning = scale.Tuning
 
 		processor.scales.Set(scale.Name, scaleMapping)
 	}
@@ -251,108 +370,117 @@ func (processor *ProcInfo) generateNotesOfScale(rootNoteIndex int) {
 	}
 }
 
-func (processor *ProcInfo) getMajorTriad(note int, numNotes int) (int, int, int) {
+/*controlThread listens for any incoming messages and handles them accordingly, updating parameters etc. */
+func (processor *ProcInfo) controlThread() {
 
-	maj_third := (note + 4) % numNotes
-	min_fifth := (maj_third + 3) % numNotes
+	for {
+		message := <-processor.Control
 
-	return note, maj_third, min_fifth
+		processor.lock.Lock()
+		processor.handleControlMessage(message)
+		processor.lock.Unlock()
+	}
 }
 
-func (processor *ProcInfo) getMinorTriad(note int, numNotes int) (int, int, int) {
+func (processor *ProcInfo) handleControlMessage(message ControlMessage) {
 
-	min_third := (note + 3) % numNotes
-	maj_fifth := (min_third + 4) % numNotes
+	switch message.Type {
 
-	return note, min_third, maj_fifth
-}
+	case SetKey:
 
-/*getVelocity implements the logic for different types of velocity sensing based on input metrics:
-  fixed					The input metrics have no effect on velocity and the default is used.
-  singleNoteVariance	The max variance between the current value and the last is tracked over time
-						and used to calculate what percentage the most recent variance is of that.
-						This is then added to the defaultVelocity.
-						NOTE: Should maybe try changing it so it calcs that as a percentage of change
-						of the total velocity range to see how it sounds.
-*/
-func (processor *ProcInfo) getVelocity(value float64) int64 {
+		/* Held notes are released first, so nothing is left sounding in the old key. */
+		processor.releaseEvents()
+		processor.generateNotesOfScale(message.ValueNum)
+		processor.setScale(processor.activeScale.name)
 
-	switch processor.velocitySensingMode {
+	case SetMode:
+		processor.setScale(message.ValueString)
 
-	case fixed:
+	case SetBPM:
+		processor.rampTempo(float64(message.ValueNum))
+	case SetTempoFollow:
+		processor.setTempoFollow(message.ValueString)
+	case SetTempoRamp:
+		processor.setTempoRamp(message.ValueNum)
+	case SaveTempoLog:
+		processor.saveTempoLog(message.ValueString)
 
-		return defaultVelocity
+	case SetVelocityMode:
+		processor.setVelocityMode(message.ValueString)
 
-	case singleNoteVariance:
+	case SetVelocity:
+		processor.fixedVelocity = clampVelocity(int64(message.ValueNum), 1, 127)
 
-		if processor.previousValues.Front() != nil && processor.previousValues.Len() > 1 {
+	case SetMinVelocity:
+		processor.minVelocity = clampVelocity(int64(message.ValueNum), 1, processor.maxVelocity)
 
-			i := 0
-			values := make([]float64, 2)
+	case SetMaxVelocity:
+		processor.maxVelocity = clampVelocity(int64(message.ValueNum), processor.minVelocity, 127)
 
-			for e := processor.previousValues.Front(); e != nil; e = e.Next() {
-				if i < 2 {
-					values[i] = e.Value.(float64)
-				} else {
-					break
-				}
-				i++
-			}
+	case SetChordMode:
+		processor.setChordMode(message.ValueString)
 
-			currentVariance := math.Abs(values[0] - values[1])
+	case SetProgression:
+		processor.setProgression(message.ValueString)
 
-			if currentVariance > processor.maxVariance {
-				processor.maxVariance = currentVariance
-				return maxVelocity
-			}
+	case SetArpPattern:
+		processor.arp.setPattern(message.ValueString)
 
-			velocity := (defaultVelocity + int64((currentVariance/processor.maxVariance)*100))
+	case SetArpRate:
+		processor.arp.setRate(message.ValueString)
 
-			if velocity > maxVelocity {
-				return maxVelocity
-			}
+	case SetArpOctaves:
+		processor.arp.setOctaves(message.ValueNum)
 
-			return velocity
+	case SetArpGate:
+		processor.arp.setGate(message.ValueNum)
 
+	case SetRhythmMode, SetLowNote, SetHighNote, SetOctaveMode, SetRangeStrategy:
+		if message.Track >= 0 && message.Track < numTracks {
+			processor.handleTrackMessage(message)
+		} else {
+			log.Printf("Invalid track (%d).\n", message.Track)
 		}
-		return defaultVelocity
-	default:
-		return 0
-	}
-}
-
-/*controlThread listens for any incoming messages and handles them accordingly, updating parameters etc. */
-func (processor *ProcInfo) controlThread() {
-
-	for {
-		message := <-processor.Control
-
-		switch message.Type {
-
-		case SetKey:
-
-			processor.generateNotesOfScale(message.ValueNum)
-			processor.setScale(processor.activeScale.name)
-
-		case SetMode:
-			processor.setScale(message.ValueString)
 
-		case SetBPM:
-			processor.BPM = float64(message.ValueNum)
-
-		case SetVelocityMode:
-
-		case SetChordMode:
-			for i, mode := range chordModesStr {
-				if mode == message.ValueString {
-					processor.chordGenerationMode = chordMode(i)
-				}
+	case SetDrums:
+		processor.drums.enabled = message.ValueNum != 0
+	case SetDrumPattern:
+		processor.drums.setPattern(message.ValueString)
+	case SetKickMode:
+		processor.drums.setKickMode(message.ValueString)
+
+	case SetMelodyMode:
+		processor.setMelodyMode(message.ValueString)
+	case TrainMarkov:
+		processor.trainFromValues(message.Values)
+	case TrainMarkovMIDI:
+		processor.trainFromMIDI(message.ValueString)
+	case SetSeed:
+		processor.setSeed(int64(message.ValueNum))
+
+	case SetAnomalies:
+		processor.setAnomalies(message.ValueNum != 0)
+	case SetRule:
+		processor.setRule(message.ValueString, message.ValueNum != 0)
+
+	case SetModulation:
+		processor.setModulation(message.ValueString, message.ValueNum != 0)
+
+	case SetVoicing:
+		for i, mode := range voicingModesStr {
+			if mode == message.ValueString {
+				processor.voicing = voicingMode(i)
+				processor.previousChord = nil
 			}
-		case StopProcessor:
-			processor.active = false
-		case StartProcessor:
-			processor.active = true
 		}
+
+	case StopProcessor:
+		processor.active = false
+		processor.releaseEvents()
+	case StartProcessor:
+		processor.active = true
+	case Panic:
+		processor.releaseEvents()
 	}
 }
 
@@ -375,10 +503,43 @@ func (processor *ProcInfo) GetModeNames() []string {
 	return names
 }
 
+/*handleTrackMessage Handles the control messages that change the settings of a single track. */
+func (processor *ProcInfo) handleTrackMessage(message ControlMessage) {
+
+	switch message.Type {
+
+	case SetRhythmMode:
+		processor.rhythms[message.Track].setMode(message.ValueString)
+	case SetLowNote:
+		processor.ranges[message.Track].setLow(message.ValueNum)
+	case SetHighNote:
+		processor.ranges[message.Track].setHigh(message.ValueNum)
+	case SetOctaveMode:
+		processor.ranges[message.Track].setOctaveMode(message.ValueString)
+	case SetRangeStrategy:
+		processor.ranges[message.Track].setStrategy(message.ValueString)
+	}
+}
+
+func (processor *ProcInfo) setChordMode(name string) {
+
+	for i, mode := range chordModesStr {
+		if mode == name {
+			processor.chordGenerationMode = chordMode(i)
+		}
+	}
+}
+
+/*GetGenerationModes Returns an array of chord generation mode names for the front end. */
 func (processor *ProcInfo) GetGenerationModes() []string {
 	return chordModesStr
 }
 
+/*GetVoicingModes Returns an array of chord voicing names for the front end. */
+func (processor *ProcInfo) GetVoicingModes() []string {
+	return voicingModesStr
+}
+
 /*generationThread Handles event processing and timing of note emission acting like a sequencer for notes.*/
 func (processor *ProcInfo) generationThread() {
 
@@ -387,19 +548,48 @@ func (processor *ProcInfo) generationThread() {
 	for {
 		select {
 		case message := <-processor.input:
+			processor.lock.Lock()
 			if processor.active {
-				processor.processMessage(message)
+				processor.processSample(message)
 			}
-		default:
-			if processor.tick == 0 {
-				processor.handleEvents()
+			processor.lock.Unlock()
+		case value := <-processor.velocityInput:
+			processor.lock.Lock()
+			processor.addToVelocityValues(value)
+			processor.lock.Unlock()
+		case frame := <-processor.frameInput:
+			processor.lock.Lock()
+			if processor.active {
+				processor.processFrame(frame)
 			}
-			processor.incrementTick()
+			processor.lock.Unlock()
+		case value := <-processor.baselineInput:
+			processor.lock.Lock()
+			processor.setBaseline(value)
+			processor.lock.Unlock()
+		default:
+			processor.lock.Lock()
+			sleepTime := processor.step()
+			processor.lock.Unlock()
+
+			time.Sleep(sleepTime)
 		}
 
 	}
 }
 
+/*step Runs a single tick of the sequencer and returns how long it is until the next one. */
+func (processor *ProcInfo) step() time.Duration {
+
+	processor.stepRhythms()
+	processor.stepDrums()
+	processor.stepModulations()
+	processor.stepArpeggiator()
+	processor.handleEvents()
+
+	return processor.incrementTick()
+}
+
 /*addToPreviousValues  */
 func (processor *ProcInfo) addToPreviousValues(value float64) {
 
@@ -409,6 +599,17 @@ func (processor *ProcInfo) addToPreviousValues(value float64) {
 	processor.previousValues.PushFront(value)
 }
 
+/*addToVelocityValues Stores values from the second metric, used when it is driving velocity. */
+func (processor *ProcInfo) addToVelocityValues(value float64) {
+
+	if processor.velocityValues.Len() >= maxPreviousValues {
+		processor.velocityValues.Remove(processor.velocityValues.Back())
+	}
+	processor.velocityValues.PushFront(value)
+	processor.updateModulations(true, listValues(processor.velocityValues))
+	processor.followTempo(value, true)
+}
+
 func (processor *ProcInfo) sendNoteEvent(e event, rawValue float64, noteVal int) {
 
 	log.Printf("RootNote: %s Value: %f Index: %d Offset: %d\n",
@@ -417,111 +618,152 @@ func (processor *ProcInfo) sendNoteEvent(e event, rawValue float64, noteVal int)
 		noteVal,
 		processor.activeScale.offsets[noteVal])
 
-	processor.insertEvent(e)
+	e.track = MelodyTrack
+	processor.playEvents(MelodyTrack, []event{e}, rawValue)
 }
 
-func (processor *ProcInfo) sendChordEvent(notes []int, velocity int64, octave int, midiChannel int) {
+/*sendChordEvent Pushes an event into the sequencer for each note of a chord, tones are semitone offsets from the key root. */
+func (processor *ProcInfo) sendChordEvent(track Track, tones []int, value float64, velocity int64, octave int, midiChannel int) {
+
+	log.Printf("Chord: [%s]\n", processor.chordNames(tones))
+
+	events := make([]event, 0, len(tones))
+
+	for _, tone := range tones {
+		events = append(events, event{eventType: note, track: track, state: ready, duration: defaultDuration, value: tone,
+			octave: octave, velocity: velocity, midiChannel: midiChannel})
+	}
+
+	processor.playEvents(track, events, value)
+}
 
-	log.Printf("Chord: [")
-	for _, n := range notes {
+/*sendChord Sends a chord on the given track straight to the sequencer, or hands it to the arpeggiator when one is enabled. */
+func (processor *ProcInfo) sendChord(track Track, tones []int, value float64, velocity int64, octave int, midiChannel int) {
 
-		e := event{eventType: note, state: ready, duration: 4, value: processor.activeScale.offsets[n],
-			octave: octave, velocity: velocity, midiChannel: midiChannel}
+	if processor.arp.pattern != arpOff {
 
-		processor.insertEvent(e)
+		log.Printf("Arpeggiating: [%s]\n", processor.chordNames(tones))
+		processor.arp.seed(track, tones, processor.activeScale.period(), value, velocity, octave, midiChannel)
 
-		log.Printf("%s,", processor.activeScale.notes[n])
+		return
 	}
-	log.Printf("]\n")
+
+	processor.sendChordEvent(track, tones, value, velocity, octave, midiChannel)
 }
 
-/*processMessage Handles mapping metric value into note value. Also pushes event into sequencer. */
-func (processor *ProcInfo) processMessage(value float64) {
-	/*  */
-	noteVal := int(value) % len(processor.activeScale.notes)
+/*getChordType Decides which type of chord (if any) should accompany the current value, depending on the chord mode. */
+func (processor *ProcInfo) getChordType(value float64) (chordType, bool) {
 
-	if processor.chordGenerationMode == none {
+	if chord, exists := diatonicChordTypes[processor.chordGenerationMode]; exists {
+		return chord, true
+	}
 
-		velocity := processor.getVelocity(value)
-		event := event{eventType: note, state: ready, duration: 4, value: processor.activeScale.offsets[noteVal],
-			octave: 3, velocity: velocity, midiChannel: 1}
+	switch processor.chordGenerationMode {
 
-		processor.sendNoteEvent(event, value, noteVal)
+	case majorOnly:
+		return majorTriad, true
 
-	} else if processor.chordGenerationMode == majorOnly {
+	case minorOnly:
+		return minorTriad, true
 
-		velocity := processor.getVelocity(value)
-		rootNoteEvent := event{eventType: note, state: ready, duration: 4, value: processor.activeScale.offsets[noteVal],
-			octave: 4, velocity: velocity, midiChannel: 1}
-		majorFirst, majorSecond, majorThird := processor.getMajorTriad(noteVal, len(processor.activeScale.notes))
+	case ascendingMajDescMin, ascendingMinDescMaj:
 
-		processor.sendNoteEvent(rootNoteEvent, value, noteVal)
-		processor.sendChordEvent([]int{majorFirst, majorSecond, majorThird}, velocity, 3, 2)
+		if processor.previousValues.Front() == nil {
+			return triad, false
+		}
+
+		ascending := processor.previousValues.Front().Value.(float64) < value
 
-	} else if processor.chordGenerationMode == minorOnly {
+		if ascending == (processor.chordGenerationMode == ascendingMajDescMin) {
+			return majorTriad, true
+		}
 
-		velocity := processor.getVelocity(value)
-		rootNoteEvent := event{eventType: note, state: ready, duration: 4, value: processor.activeScale.offsets[noteVal],
-			octave: 4, velocity: velocity, midiChannel: 1}
-		minorFirst, minorSecond, minorThird := processor.getMinorTriad(noteVal, len(processor.activeScale.notes))
+		return minorTriad, true
+	}
 
-		processor.sendNoteEvent(rootNoteEvent, value, noteVal)
-		processor.sendChordEvent([]int{minorFirst, minorSecond, minorThird}, velocity, 3, 2)
+	return triad, false
+}
 
-	} else if processor.chordGenerationMode == ascendingMajDescMin {
+/*processSample Passes a sample from the scraper on to the sinks that take the raw metric, such as OSC, then plays it. */
+func (processor *ProcInfo) processSample(value float64) {
 
-		velocity := processor.getVelocity(value)
-		rootNoteEvent := event{eventType: note, state: ready, duration: 4, value: processor.activeScale.offsets[noteVal],
-			octave: 4, velocity: velocity, midiChannel: 1}
+	processor.sendMetric(value)
+	processor.processMessage(value)
+}
 
-		processor.sendNoteEvent(rootNoteEvent, value, noteVal)
+/*sendMetric Sends the raw metric value to the emitter, only sinks that take metric values (OSC) do anything with it. */
+func (processor *ProcInfo) sendMetric(value float64) {
+	processor.send(midioutput.MIDIMessage{Type: midioutput.MetricValue, Metric: value})
+}
+
+/*processMessage Handles mapping metric value into note value. Also pushes event into sequencer. */
+func (processor *ProcInfo) processMessage(value float64) {
 
-		if processor.previousValues.Front() != nil {
+	/* Anomalies and rules are checked first so a change of scale applies to this sample. */
+	processor.detectAnomalies(value)
+	processor.evaluateRules(value)
 
-			previousValue := processor.previousValues.Front().Value.(float64)
+	noteVal := processor.degreeOfValue(value)
 
-			if previousValue < value {
-				majorFirst, majorSecond, majorThird := processor.getMajorTriad(noteVal, len(processor.activeScale.notes))
-				processor.sendChordEvent([]int{majorFirst, majorSecond, majorThird}, velocity, 3, 2)
+	if processor.melodyMode == markovMelody && processor.markov.trained() {
+		noteVal = processor.nextMarkovDegree(value)
+	}
 
-			} else {
-				minorFirst, minorSecond, minorThird := processor.getMinorTriad(noteVal, len(processor.activeScale.notes))
-				processor.sendChordEvent([]int{minorFirst, minorSecond, minorThird}, velocity, 3, 2)
-			}
-		}
+	processor.sampleTicks = processor.tickCount - processor.lastSampleTick
+	processor.lastSampleTick = processor.tickCount
 
-	} else if processor.chordGenerationMode == ascendingMinDescMaj {
+	velocity := processor.getVelocity(value)
+	melodyOctave := processor.trackOctave(MelodyTrack, processor.melodyOctave, value)
+	chordOctave := processor.trackOctave(ChordTrack, processor.chordOctave, value)
 
-		velocity := processor.getVelocity(value)
-		rootNoteEvent := event{eventType: note, state: ready, duration: 4, value: processor.activeScale.offsets[noteVal],
-			octave: 4, velocity: velocity, midiChannel: 1}
+	if processor.chordGenerationMode == none && processor.arp.pattern != arpOff {
 
-		if processor.previousValues.Front() != nil {
+		/* With no chords to play the arpeggiator works on the single note, across its octave range. */
+		processor.sendChord(MelodyTrack, []int{processor.activeScale.offsets[noteVal]}, value, velocity, melodyOctave, processor.melodyChannel)
 
-			previousValue := processor.previousValues.Front().Value.(float64)
+	} else if processor.chordGenerationMode == none {
 
-			if previousValue < value {
-				minorFirst, minorSecond, minorThird := processor.getMinorTriad(noteVal, len(processor.activeScale.notes))
-				processor.sendChordEvent([]int{minorFirst, minorSecond, minorThird}, velocity, 3, 2)
-			} else {
-				majorFirst, majorSecond, majorThird := processor.getMajorTriad(noteVal, len(processor.activeScale.notes))
-				processor.sendChordEvent([]int{majorFirst, majorSecond, majorThird}, velocity, 3, 2)
+		event := event{eventType: note, state: ready, duration: defaultDuration, value: processor.activeScale.offsets[noteVal],
+			octave: melodyOctave, velocity: velocity, midiChannel: processor.melodyChannel}
 
-			}
-		}
+		processor.sendNoteEvent(event, value, noteVal)
+
+	} else if processor.chordGenerationMode == progressionMode && processor.activeProgression != nil {
+
+		processor.advanceProgression(processor.getFeatures(value))
+
+		chord := processor.progressionChord()
+		melody := followChord(processor.activeScale.offsets[noteVal], chord, processor.activeScale.period())
+
+		rootNoteEvent := event{eventType: note, state: ready, duration: defaultDuration, value: melody,
+			octave: melodyOctave, velocity: velocity, midiChannel: processor.melodyChannel}
 
 		processor.sendNoteEvent(rootNoteEvent, value, noteVal)
+		processor.sendChord(ChordTrack, processor.voiceChord(chord), value, velocity, chordOctave, processor.chordChannel)
+
+	} else {
+
+		rootNoteEvent := event{eventType: note, state: ready, duration: defaultDuration, value: processor.activeScale.offsets[noteVal],
+			octave: melodyOctave, velocity: velocity, midiChannel: processor.melodyChannel}
 
+		processor.sendNoteEvent(rootNoteEvent, value, noteVal)
+
+		if chord, ok := processor.getChordType(value); ok {
+			processor.sendChord(ChordTrack, processor.voiceChord(processor.buildChord(noteVal, chord)), value, velocity, chordOctave, processor.chordChannel)
+		}
 	}
 
+	processor.updateDrums(processor.getFeatures(value))
+	processor.updateModulations(false, processor.recentValues(value))
+	processor.followTempo(value, false)
 	processor.addToPreviousValues(value)
 }
 
 /*
-   handleEvents is used to trigger different kinds of events:
+   handleEvents is used to trigger different kinds of events, it is called on every tick of the sequencer:
 
    If an event is in state ready, then it means it's new and we need to send a NoteOn message to the midi channel.
-   If an event is in state active, then we decrement the duration by 1 and Send a NoteOff message if duration == 1.(Not 0, as this makes the No of beats more readable)
+   If an event is in state active, then we decrement the duration by 1 and Send a NoteOff message if duration == 1.(Not 0, as this makes the No of ticks more readable)
    if an event is in state stop, we deallocate the event entry so it can be used again.
 
    It needs two loops, otherwise if a previous note is the same as one being activated it might trigger a note off for the old note after the new note with the same value has been fired.
@@ -535,10 +777,10 @@ func (processor *ProcInfo) handleEvents() {
 
 				if e.duration == 1 {
 
-					log.Printf("Send stop %d Oct: %d \n", processor.rootNoteOffset+e.value, e.octave)
+					log.Printf("Send stop %d Oct: %d \n", e.note, e.octave)
 
 					processor.events[i].state = stop
-					processor.Output <- midioutput.MIDIMessage{Channel: midioutput.Channel1, Type: midioutput.NoteOff, Note: processor.rootNoteOffset + processor.events[i].value, Octave: processor.events[i].octave, Velocity: 50}
+					processor.sendNoteOff(e)
 
 				}
 			}
@@ -547,15 +789,41 @@ func (processor *ProcInfo) handleEvents() {
 	for i, e := range processor.events {
 
 		if (event{}) != e {
-			if e.state == ready && processor.active {
+			if e.state == ready && processor.active && e.eventType == parameter {
+
+				/* Parameter changes have no duration, they're sent and the slot freed straight away. */
+				processor.send(midioutput.MIDIMessage{Channel: e.channel(), Type: e.messageType, Controller: e.controller,
+					Value: e.value, Note: e.note, Octave: e.octave})
+				processor.events[i] = event{}
+
+			} else if e.state == ready && processor.active {
+
+				/* Drums are mapped to fixed GM notes so they don't follow the key. */
+				if e.eventType == drum {
+					e.note = e.value
+				} else {
+					e.note = processor.rootNoteOffset + e.value
+				}
 
-				log.Printf("Send start %d Oct: %d Vel: %d\n", processor.rootNoteOffset+e.value, e.octave, e.velocity)
+				if e.eventType != drum {
+
+					octave, note, ok := processor.placeNote(e)
+
+					if !ok {
+						processor.events[i] = event{}
+						continue
+					}
+
+					e.octave, e.note = octave, note
+				}
 
-				processor.events[i].state = active
-				processor.Output <- midioutput.MIDIMessage{Channel: midioutput.Channel1,
-					Type: midioutput.NoteOn, Note: processor.rootNoteOffset + processor.events[i].value,
-					Octave: processor.events[i].octave, Velocity: e.velocity}
-				break
+				log.Printf("Send start %d Oct: %d Vel: %d\n", e.note, e.octave, e.velocity)
+
+				e.state = active
+				processor.events[i] = e
+				processor.send(midioutput.MIDIMessage{Channel: e.channel(),
+					Type: midioutput.NoteOn, Note: e.note,
+					Octave: e.octave, Velocity: e.velocity})
 			} else if e.state == ready && !processor.active {
 				processor.events[i] = event{}
 			} else if e.state == stop {
@@ -565,6 +833,35 @@ func (processor *ProcInfo) handleEvents() {
 	}
 }
 
+/*sendNoteOff Sends the NoteOff for an active event using the note and channel it was started with. */
+func (processor *ProcInfo) sendNoteOff(e event) {
+
+	processor.send(midioutput.MIDIMessage{Channel: e.channel(), Type: midioutput.NoteOff, Note: e.note, Octave: e.octave, Velocity: 50})
+}
+
+/*releaseEvents Sends a NoteOff for every sounding event and clears the sequencer, the arpeggiator and the notes held by the rhythms, so nothing is left hanging. */
+func (processor *ProcInfo) releaseEvents() {
+
+	for i, e := range processor.events {
+		if (event{}) != e && e.state == active {
+			log.Printf("Release %d Oct: %d \n", e.note, e.octave)
+			processor.sendNoteOff(e)
+		}
+		processor.events[i] = event{}
+	}
+
+	processor.arp.clear()
+
+	for _, r := range processor.rhythms {
+		r.clear()
+	}
+}
+
+/*channel Returns the MIDI channel value for the event's (1 based) midiChannel. */
+func (e event) channel() midioutput.MIDIValue {
+	return midioutput.MIDIValue(e.midiChannel - 1)
+}
+
 func (processor *ProcInfo) insertEvent(eventIn event) {
 	for i, e := range processor.events {
 		if (event{}) == e {
@@ -575,12 +872,17 @@ func (processor *ProcInfo) insertEvent(eventIn event) {
 
 }
 
-func (processor *ProcInfo) incrementTick() {
+/*incrementTick Advances the sequencer clock and returns how long the generation thread should sleep until the next tick. */
+func (processor *ProcInfo) incrementTick() time.Duration {
+
+	processor.stepTempo()
 
 	processor.tick += float64(processor.TickInc)
+	processor.tickCount++
 
-	milliSecondsPerBeat := (60 / processor.BPM) * 1000
+	milliSecondsPerBeat := (60 / processor.currentBPM()) * 1000
 	sleepTime := milliSecondsPerBeat / defaultTicksPerBeat
+	processor.tempo.elapsed += sleepTime / 1000
 
 	processor.TickInc = time.Duration(milliSecondsPerBeat / defaultTicksPerBeat)
 
@@ -588,6 +890,5 @@ func (processor *ProcInfo) incrementTick() {
 		processor.tick = 0
 	}
 
-	time.Sleep(time.Duration(sleepTime) * time.Millisecond)
-
+	return time.Duration(sleepTime) * time.Millisecond
 }
@@ -0,0 +1,131 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
+	"github.com/ElectricNoodle/prometheus-midi-generator/midioutput"
+)
+
+var testScales = []Scale{
+	{Name: "Chromatic", Intervals: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+	{Name: "Ionian", Intervals: []int{2, 2, 1, 2, 2, 2, 1}},
+}
+
+/*newTestProcessor Builds a processor with the default settings and no threads, with an Output buffer big enough that nothing blocks. */
+func newTestProcessor() *ProcInfo {
+	return newConfiguredProcessor(Config{Scales: testScales})
+}
+
+/*newConfiguredProcessor Validates the config and builds a processor from it the same way as newTestProcessor. */
+func newConfiguredProcessor(config Config) *ProcInfo {
+
+	if err := config.Validate(); err != nil {
+		panic(err)
+	}
+
+	return newProcessor(logging.NewLogger(), config, make(chan midioutput.MIDIMessage, 1024))
+}
+
+/*sent Drains and returns the messages the processor has sent so far. */
+func sent(processor *ProcInfo) []midioutput.MIDIMessage {
+
+	var messages []midioutput.MIDIMessage
+
+	for {
+		select {
+		case message := <-processor.Output:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+/*sounding Returns the notes started by the messages that have not been stopped by a later NoteOff. */
+func sounding(messages []midioutput.MIDIMessage) map[midioutput.MIDIMessage]int {
+
+	notes := make(map[midioutput.MIDIMessage]int)
+
+	for _, message := range messages {
+
+		key := midioutput.MIDIMessage{Channel: message.Channel, Note: message.Note, Octave: message.Octave}
+
+		switch message.Type {
+		case midioutput.NoteOn:
+			notes[key]++
+		case midioutput.NoteOff:
+			if notes[key] == 0 {
+				continue
+			}
+			notes[key]--
+			if notes[key] == 0 {
+				delete(notes, key)
+			}
+		}
+	}
+	return notes
+}
+
+func TestNoteOffsSurviveControlMessages(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		message ControlMessage
+		active  bool
+	}{
+		{"key change", ControlMessage{Type: SetKey, ValueNum: noteIndexes["D"]}, true},
+		{"mode change", ControlMessage{Type: SetMode, ValueString: "Ionian"}, true},
+		{"stop", ControlMessage{Type: StopProcessor}, false},
+		{"panic", ControlMessage{Type: Panic}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+
+			processor.processMessage(5)
+			for i := 0; i < 4; i++ {
+				processor.handleEvents()
+			}
+
+			played := sent(processor)
+			if len(sounding(played)) != 4 {
+				t.Fatalf("expected a root note and a triad sounding, got %v", played)
+			}
+
+			processor.handleControlMessage(test.message)
+
+			/* A mode change releases nothing, its notes expire on their own and must still be stopped. */
+			for i := 0; i < 2*defaultDuration; i++ {
+				processor.handleEvents()
+			}
+
+			messages := append(played, sent(processor)...)
+			if left := sounding(messages); len(left) != 0 {
+				t.Errorf("notes left hanging: %v", left)
+			}
+			if processor.active != test.active {
+				t.Errorf("active = %v, want %v", processor.active, test.active)
+			}
+		})
+	}
+}
+
+func TestStopDropsQueuedNotes(t *testing.T) {
+
+	processor := newTestProcessor()
+
+	processor.processMessage(5)
+	processor.handleControlMessage(ControlMessage{Type: StopProcessor})
+	processor.handleControlMessage(ControlMessage{Type: StartProcessor})
+
+	for i := 0; i < 8; i++ {
+		processor.handleEvents()
+	}
+
+	if messages := sent(processor); len(messages) != 0 {
+		t.Errorf("notes queued before the stop were played after it: %v", messages)
+	}
+}
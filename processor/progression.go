@@ -0,0 +1,339 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+Progression Defines the format of a chord progression config. Either a fixed list of chords that is stepped through,
+or a set of weighted transitions between chords (Markov chain). Chords are written as roman numerals of the scale
+degree (I-VII) with an optional 7, 9, sus2, sus4 or 5 suffix, the chord quality always comes from the active scale
+so the case of the numeral is only there for readability.
+*/
+type Progression struct {
+	Name        string                        `yaml:"name"`
+	Chords      []string                      `yaml:"chords,flow"`
+	Transitions map[string]map[string]float64 `yaml:"transitions"`
+}
+
+/*progressionStep A single chord of a progression. */
+type progressionStep struct {
+	symbol string
+	degree int
+	chord  chordType
+}
+
+/*transition A weighted move from one progression step to another. */
+type transition struct {
+	to     int
+	weight float64
+}
+
+/*progression Parsed version of the progression config, transitions is only populated for Markov progressions. */
+type progression struct {
+	name        string
+	steps       []progressionStep
+	transitions map[int][]transition
+}
+
+/* Roman numerals for the scale degrees, longest first so that "IV" isn't matched as "I". */
+var romanNumerals = []struct {
+	numeral string
+	degree  int
+}{{"VII", 6}, {"III", 2}, {"VI", 5}, {"IV", 3}, {"II", 1}, {"V", 4}, {"I", 0}}
+
+/* Chord suffixes that can follow the numeral, anything else is treated as a triad. */
+var chordSuffixes = map[string]chordType{
+	"":     triad,
+	"7":    seventh,
+	"9":    ninth,
+	"sus2": sus2,
+	"sus4": sus4,
+	"5":    power,
+}
+
+/* Number of samples the harmony holds a chord for when the metric is calm. */
+const maxProgressionHold = 8
+
+/* How strongly the trend of the metric pulls Markov transitions up or down the scale. */
+const trendBias = 2.0
+
+/*parseChordSymbol Converts a chord symbol such as "ii7" into a scale degree and chord type. */
+func parseChordSymbol(symbol string) (progressionStep, error) {
+
+	upper := strings.ToUpper(symbol)
+
+	for _, roman := range romanNumerals {
+
+		if strings.HasPrefix(upper, roman.numeral) {
+
+			suffix := strings.ToLower(symbol[len(roman.numeral):])
+			chord, exists := chordSuffixes[suffix]
+
+			if !exists {
+				return progressionStep{}, fmt.Errorf("unknown chord suffix %q in %q", suffix, symbol)
+			}
+
+			return progressionStep{symbol: symbol, degree: roman.degree, chord: chord}, nil
+		}
+	}
+
+	return progressionStep{}, fmt.Errorf("%q doesn't start with a roman numeral", symbol)
+}
+
+/*parseProgression Converts a progression from the config into steps, and weighted transitions for Markov progressions. Errors name the field that is wrong. */
+func parseProgression(config Progression) (progression, error) {
+
+	parsed := progression{name: config.Name}
+
+	if len(config.Transitions) == 0 {
+
+		for i, symbol := range config.Chords {
+
+			step, err := parseChordSymbol(symbol)
+
+			if err != nil {
+				return parsed, fmt.Errorf("chords[%d]: %v", i, err)
+			}
+
+			parsed.steps = append(parsed.steps, step)
+		}
+
+		if len(parsed.steps) == 0 {
+			return parsed, fmt.Errorf("chords: no chords or transitions defined")
+		}
+
+		return parsed, nil
+	}
+
+	/* Every chord mentioned in the transitions becomes a step, sorted so the order doesn't depend on map iteration. */
+	indexes := map[string]int{}
+	var symbols []string
+
+	for from, targets := range config.Transitions {
+		symbols = append(symbols, from)
+		for to := range targets {
+			symbols = append(symbols, to)
+		}
+	}
+
+	sort.Strings(symbols)
+
+	for _, symbol := range symbols {
+
+		if _, exists := indexes[symbol]; exists {
+			continue
+		}
+
+		step, err := parseChordSymbol(symbol)
+
+		if err != nil {
+			return parsed, fmt.Errorf("transitions: %v", err)
+		}
+
+		indexes[symbol] = len(parsed.steps)
+		parsed.steps = append(parsed.steps, step)
+	}
+
+	parsed.transitions = make(map[int][]transition)
+
+	for from, targets := range config.Transitions {
+
+		var targetSymbols []string
+
+		for to := range targets {
+			targetSymbols = append(targetSymbols, to)
+		}
+
+		sort.Strings(targetSymbols)
+
+		for _, to := range targetSymbols {
+
+			if targets[to] <= 0 {
+				return parsed, fmt.Errorf("transitions.%s.%s: must have a positive weight", from, to)
+			}
+
+			parsed.transitions[indexes[from]] = append(parsed.transitions[indexes[from]], transition{to: indexes[to], weight: targets[to]})
+		}
+	}
+
+	return parsed, nil
+}
+
+/*
+parseProgressions Processes and stores the progressions from the configuration file, invalid ones are logged and skipped.
+The first one is selected so the Progression chord mode has something to play until another is picked.
+*/
+func (processor *ProcInfo) parseProgressions(progressionList []Progression) {
+
+	for _, config := range progressionList {
+
+		parsed, err := parseProgression(config)
+
+		if err != nil {
+			log.Printf("Skipping progression %s: %v\n", config.Name, err)
+			continue
+		}
+
+		processor.progressions.Set(config.Name, parsed)
+	}
+
+	if first := processor.progressions.Front(); first != nil {
+		processor.setProgression(first.Key.(string))
+	} else {
+		log.Printf("No progressions configured, the Progression chord mode will only play the melody.\n")
+	}
+}
+
+/*setProgression Switches to the named progression, starting from its first chord. */
+func (processor *ProcInfo) setProgression(name string) {
+
+	selected, exists := processor.progressions.Get(name)
+
+	if exists {
+
+		parsed := selected.(progression)
+
+		processor.activeProgression = &parsed
+		processor.progressionPos = 0
+		processor.progressionHold = 0
+
+		log.Printf("Using %s progression.\n", name)
+
+	} else {
+		log.Printf("Progression not found (%s).", name)
+	}
+}
+
+/*
+advanceProgression Decides when and where the harmony moves based on the metric.
+When: the chord changes when the value crosses the mean of the recent values, or once it has been held for long enough.
+The more volatile the metric the shorter the hold, so busy metrics move the harmony faster.
+Where: fixed progressions step forwards while the metric is rising and backwards while it is falling. Markov progressions
+pick a weighted transition, with the trend favouring chords higher up the scale when rising and lower when falling.
+*/
+func (processor *ProcInfo) advanceProgression(features metricFeatures) {
+
+	active := processor.activeProgression
+
+	if active == nil {
+		return
+	}
+
+	processor.progressionHold++
+
+	hold := int(float64(maxProgressionHold) * (1 - features.volatility))
+
+	if !features.crossed && processor.progressionHold < hold {
+		return
+	}
+
+	processor.progressionHold = 0
+
+	if active.transitions == nil {
+
+		if features.trend < 0 {
+			processor.progressionPos = (processor.progressionPos - 1 + len(active.steps)) % len(active.steps)
+		} else {
+			processor.progressionPos = (processor.progressionPos + 1) % len(active.steps)
+		}
+
+	} else {
+		processor.progressionPos = processor.chooseTransition(features.trend)
+	}
+
+	log.Printf("Progression moved to %s\n", active.steps[processor.progressionPos].symbol)
+}
+
+/*chooseTransition Picks the next step of a Markov progression, biased by the trend of the metric. */
+func (processor *ProcInfo) chooseTransition(trend float64) int {
+
+	active := processor.activeProgression
+	current := active.steps[processor.progressionPos]
+	transitions := active.transitions[processor.progressionPos]
+
+	/* A chord with nowhere to go restarts the progression. */
+	if len(transitions) == 0 {
+		return 0
+	}
+
+	weights := make([]float64, len(transitions))
+	total := 0.0
+
+	for i, t := range transitions {
+
+		direction := 0.0
+
+		if active.steps[t.to].degree > current.degree {
+			direction = 1
+		} else if active.steps[t.to].degree < current.degree {
+			direction = -1
+		}
+
+		weights[i] = t.weight * (1 + clamp(trend*direction*trendBias, -0.9, trendBias))
+		total += weights[i]
+	}
+
+	choice := processor.random.Float64() * total
+
+	for i, weight := range weights {
+
+		choice -= weight
+
+		if choice < 0 {
+			return transitions[i].to
+		}
+	}
+
+	return transitions[len(transitions)-1].to
+}
+
+/*progressionChord Returns the semitone offsets of the current chord of the progression. */
+func (processor *ProcInfo) progressionChord() []int {
+
+	step := processor.activeProgression.steps[processor.progressionPos]
+
+	return processor.buildChord(step.degree, step.chord)
+}
+
+/*followChord Moves a melody note to the nearest note of the current chord, so the melody follows the harmony. */
+func followChord(melody int, tones []int, period int) int {
+
+	nearest := melody
+	nearestDistance := period
+
+	for _, tone := range tones {
+
+		pitchClass := ((tone % period) + period) % period
+
+		for _, candidate := range []int{pitchClass - period, pitchClass, pitchClass + period} {
+
+			distance := candidate - melody
+
+			if distance < 0 {
+				distance = -distance
+			}
+
+			if distance < nearestDistance || (distance == nearestDistance && candidate < nearest) {
+				nearest = candidate
+				nearestDistance = distance
+			}
+		}
+	}
+
+	return nearest
+}
+
+/*GetProgressionNames Returns an array of progression names for the front end. */
+func (processor *ProcInfo) GetProgressionNames() []string {
+
+	names := make([]string, 0, processor.progressions.Len())
+
+	for _, key := range processor.progressions.Keys() {
+		names = append(names, key.(string))
+	}
+
+	return names
+}
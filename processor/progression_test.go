@@ -0,0 +1,202 @@
+package processor
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestParseChordSymbol(t *testing.T) {
+
+	tests := []struct {
+		symbol string
+		degree int
+		chord  chordType
+		err    bool
+	}{
+		{"I", 0, triad, false},
+		{"ii7", 1, seventh, false},
+		{"iii", 2, triad, false},
+		{"IV", 3, triad, false},
+		{"V9", 4, ninth, false},
+		{"vi", 5, triad, false},
+		{"viisus4", 6, sus4, false},
+		{"Isus2", 0, sus2, false},
+		{"V5", 4, power, false},
+		{"V13", 0, triad, true},
+		{"X", 0, triad, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.symbol, func(t *testing.T) {
+
+			step, err := parseChordSymbol(test.symbol)
+
+			if (err != nil) != test.err {
+				t.Fatalf("parseChordSymbol(%q) error = %v, want error %v", test.symbol, err, test.err)
+			}
+			if !test.err && (step.degree != test.degree || step.chord != test.chord) {
+				t.Errorf("parseChordSymbol(%q) = degree %d chord %d, want degree %d chord %d", test.symbol, step.degree, step.chord, test.degree, test.chord)
+			}
+		})
+	}
+}
+
+func TestParseProgression(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		config      Progression
+		symbols     []string
+		transitions map[int][]transition
+		err         string
+	}{
+		{
+			name:    "fixed",
+			config:  Progression{Name: "pop", Chords: []string{"I", "V", "vi", "IV"}},
+			symbols: []string{"I", "V", "vi", "IV"},
+		},
+		{
+			name:        "markov steps are sorted",
+			config:      Progression{Name: "walk", Transitions: map[string]map[string]float64{"V": {"I": 1}, "I": {"V": 2, "IV": 1}}},
+			symbols:     []string{"I", "IV", "V"},
+			transitions: map[int][]transition{0: {{to: 1, weight: 1}, {to: 2, weight: 2}}, 2: {{to: 0, weight: 1}}},
+		},
+		{
+			name:   "empty",
+			config: Progression{Name: "empty"},
+			err:    "chords: no chords or transitions defined",
+		},
+		{
+			name:   "bad chord",
+			config: Progression{Name: "bad", Chords: []string{"I", "Q"}},
+			err:    `chords[1]: "Q" doesn't start with a roman numeral`,
+		},
+		{
+			name:   "bad weight",
+			config: Progression{Name: "bad", Transitions: map[string]map[string]float64{"I": {"V": 0}}},
+			err:    "transitions.I.V: must have a positive weight",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			parsed, err := parseProgression(test.config)
+
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			symbols := make([]string, len(parsed.steps))
+			for i, step := range parsed.steps {
+				symbols[i] = step.symbol
+			}
+
+			if !reflect.DeepEqual(symbols, test.symbols) {
+				t.Errorf("steps = %v, want %v", symbols, test.symbols)
+			}
+			if !reflect.DeepEqual(parsed.transitions, test.transitions) {
+				t.Errorf("transitions = %v, want %v", parsed.transitions, test.transitions)
+			}
+		})
+	}
+}
+
+func TestFirstProgressionIsSelected(t *testing.T) {
+
+	processor := newTestProcessor()
+
+	if processor.activeProgression != nil {
+		t.Fatalf("expected no progression without any configured, got %s", processor.activeProgression.name)
+	}
+
+	processor.parseProgressions([]Progression{{Name: "broken", Chords: []string{"Q"}}, {Name: "pop", Chords: []string{"I", "V"}}, {Name: "blues", Chords: []string{"I", "IV"}}})
+
+	if processor.activeProgression == nil || processor.activeProgression.name != "pop" {
+		t.Errorf("expected the first valid progression to be selected, got %v", processor.activeProgression)
+	}
+}
+
+func TestAdvanceProgression(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		features metricFeatures
+		hold     int
+		want     int
+	}{
+		{"rising steps forwards", metricFeatures{trend: 0.5, crossed: true}, 0, 1},
+		{"falling steps backwards", metricFeatures{trend: -0.5, crossed: true}, 0, 3},
+		{"calm metric holds the chord", metricFeatures{trend: 0.5}, 0, 0},
+		{"held long enough moves on", metricFeatures{trend: 0.5}, maxProgressionHold, 1},
+		{"volatile metric moves quickly", metricFeatures{trend: 0.5, volatility: 1}, 0, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+			processor.parseProgressions([]Progression{{Name: "pop", Chords: []string{"I", "V", "vi", "IV"}}})
+			processor.progressionHold = test.hold
+
+			processor.advanceProgression(test.features)
+
+			if processor.progressionPos != test.want {
+				t.Errorf("progressionPos = %d, want %d", processor.progressionPos, test.want)
+			}
+		})
+	}
+}
+
+func TestChooseTransitionFollowsTrend(t *testing.T) {
+
+	processor := newTestProcessor()
+	processor.random = rand.New(rand.NewSource(1))
+	processor.parseProgressions([]Progression{{Name: "walk", Transitions: map[string]map[string]float64{"IV": {"I": 1, "V": 1}}}})
+
+	/* Steps are sorted, so I is 0, IV is 1 and V is 2. */
+	for _, test := range []struct {
+		trend float64
+		want  int
+	}{{1, 2}, {-1, 0}} {
+
+		counts := map[int]int{}
+
+		for i := 0; i < 1000; i++ {
+			processor.progressionPos = 1
+			counts[processor.chooseTransition(test.trend)]++
+		}
+
+		if counts[test.want] < 700 {
+			t.Errorf("trend %v picked step %d %d times out of 1000, expected it to be favoured", test.trend, test.want, counts[test.want])
+		}
+	}
+}
+
+func TestFollowChord(t *testing.T) {
+
+	tests := []struct {
+		melody int
+		tones  []int
+		want   int
+	}{
+		{0, []int{0, 4, 7}, 0},
+		{2, []int{0, 4, 7}, 0},
+		{5, []int{0, 4, 7}, 4},
+		{11, []int{0, 4, 7}, 12},
+		{6, []int{14, 17, 21}, 5},
+	}
+
+	for _, test := range tests {
+		if got := followChord(test.melody, test.tones, 12); got != test.want {
+			t.Errorf("followChord(%d, %v) = %d, want %d", test.melody, test.tones, got, test.want)
+		}
+	}
+}
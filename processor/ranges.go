@@ -0,0 +1,282 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+)
+
+/*NoteRange Defines the format of a track's pitch range config, low and high are MIDI note numbers. */
+type NoteRange struct {
+	Low      int    `yaml:"low"`
+	High     int    `yaml:"high"`
+	Octave   string `yaml:"octave"`
+	Strategy string `yaml:"strategy"`
+}
+
+/*octaveMode Defines how a track picks the octave its notes are played in. */
+type octaveMode int
+
+var octaveModesStr = []string{"Fixed", "Level", "Magnitude", "Trend"}
+
+/*
+The octave modes a track can use:
+fixedOctave		The octave set in the config (melody_octave/chord_octave).
+levelOctave		Where the value sits in its recent range picks an octave between the bottom and top of the pitch range.
+magnitudeOctave	Every power of ten of the value moves up an octave from the bottom of the pitch range.
+trendOctave		The fixed octave, moved up one while the metric is clearly rising and down one while it is falling.
+*/
+const (
+	fixedOctave     octaveMode = 0
+	levelOctave     octaveMode = 1
+	magnitudeOctave octaveMode = 2
+	trendOctave     octaveMode = 3
+)
+
+/*rangeStrategy Defines what happens to notes that fall outside a track's pitch range. */
+type rangeStrategy int
+
+var rangeStrategiesStr = []string{"Fold", "Clamp", "Drop"}
+
+/*
+fold	Notes are moved by whole octaves (periods for Scala tunings) until they fit, keeping their pitch class.
+clamp	Notes play at the nearest edge of the range.
+drop	Notes aren't played.
+*/
+const (
+	foldRange  rangeStrategy = 0
+	clampRange rangeStrategy = 1
+	dropRange  rangeStrategy = 2
+)
+
+/* How strong the trend has to be before the trend octave mode moves. */
+const trendOctaveThreshold = 0.5
+
+const lowestNote = 0
+const highestNote = 127
+
+/*noteRange Parsed version of the range config for a track. */
+type noteRange struct {
+	low        int
+	high       int
+	octaveMode octaveMode
+	strategy   rangeStrategy
+}
+
+/*validate Checks a track range from the config, filling in the defaults. */
+func (config *NoteRange) validate(field string) error {
+
+	if config.High == 0 {
+		config.High = highestNote
+	}
+
+	if config.Octave == "" {
+		config.Octave = octaveModesStr[fixedOctave]
+	}
+
+	if config.Strategy == "" {
+		config.Strategy = rangeStrategiesStr[foldRange]
+	}
+
+	if config.Low < lowestNote || config.Low > highestNote {
+		return fmt.Errorf("processor_config.%s.low: %d must be between %d and %d", field, config.Low, lowestNote, highestNote)
+	}
+
+	if config.High < config.Low || config.High > highestNote {
+		return fmt.Errorf("processor_config.%s.high: %d must be between low (%d) and %d", field, config.High, config.Low, highestNote)
+	}
+
+	if !contains(octaveModesStr, config.Octave) {
+		return fmt.Errorf("processor_config.%s.octave: %q is not an octave mode, expected one of %v", field, config.Octave, octaveModesStr)
+	}
+
+	if !contains(rangeStrategiesStr, config.Strategy) {
+		return fmt.Errorf("processor_config.%s.strategy: %q is not a range strategy, expected one of %v", field, config.Strategy, rangeStrategiesStr)
+	}
+
+	return nil
+}
+
+func newNoteRange(config NoteRange) *noteRange {
+
+	r := &noteRange{low: config.Low, high: config.High}
+
+	r.setOctaveMode(config.Octave)
+	r.setStrategy(config.Strategy)
+
+	return r
+}
+
+func (r *noteRange) setOctaveMode(name string) {
+
+	for i, mode := range octaveModesStr {
+		if mode == name {
+			r.octaveMode = octaveMode(i)
+		}
+	}
+}
+
+func (r *noteRange) setStrategy(name string) {
+
+	for i, strategy := range rangeStrategiesStr {
+		if strategy == name {
+			r.strategy = rangeStrategy(i)
+		}
+	}
+}
+
+/*setLow Moves the bottom of the range, the top is pushed up with it if needed. */
+func (r *noteRange) setLow(note int) {
+
+	r.low = int(clamp(float64(note), lowestNote, highestNote))
+
+	if r.high < r.low {
+		r.high = r.low
+	}
+}
+
+/*setHigh Moves the top of the range, the bottom is pushed down with it if needed. */
+func (r *noteRange) setHigh(note int) {
+
+	r.high = int(clamp(float64(note), lowestNote, highestNote))
+
+	if r.low > r.high {
+		r.low = r.high
+	}
+}
+
+/*trackOctave Picks the octave for the notes of a track generated from the current value. */
+func (processor *ProcInfo) trackOctave(track Track, octave int, value float64) int {
+
+	r := processor.ranges[track]
+	lowOctave, highOctave := splitKeyOctave(r.low), splitKeyOctave(r.high)
+
+	switch r.octaveMode {
+
+	case levelOctave:
+		level := processor.getFeatures(value).level
+		return lowOctave + int(math.Round(level*float64(highOctave-lowOctave)))
+
+	case magnitudeOctave:
+		magnitude := 0
+
+		if math.Abs(value) >= 1 {
+			magnitude = int(math.Log10(math.Abs(value)))
+		}
+
+		if magnitude > highOctave-lowOctave {
+			magnitude = highOctave - lowOctave
+		}
+
+		return lowOctave + magnitude
+
+	case trendOctave:
+		trend := processor.getFeatures(value).trend
+
+		if trend > trendOctaveThreshold {
+			return octave + 1
+		} else if trend < -trendOctaveThreshold {
+			return octave - 1
+		}
+	}
+
+	return octave
+}
+
+/*fitToRange Applies the track's range strategy to a MIDI note, returns false if the note shouldn't be played. */
+func (processor *ProcInfo) fitToRange(track Track, key int, period int) (int, bool) {
+
+	r := processor.ranges[track]
+
+	if key >= r.low && key <= r.high {
+		return key, true
+	}
+
+	switch r.strategy {
+
+	case foldRange:
+
+		for key < r.low {
+			key += period
+		}
+
+		for key > r.high {
+			key -= period
+		}
+
+		/* Ranges narrower than an octave can't always fit the pitch class, those notes are clamped instead. */
+		if key < r.low {
+			key = r.low
+		}
+
+	case clampRange:
+		key = int(clamp(float64(key), float64(r.low), float64(r.high)))
+
+	case dropRange:
+		log.Printf("Dropping note %d outside of range %d-%d\n", key, r.low, r.high)
+		return key, false
+	}
+
+	return key, true
+}
+
+/*
+placeNote Works out the MIDI note an event will play from its octave and note, keeps it inside the pitch range of its
+track and splits it back into the octave and note the emitter expects. Scales built on a Scala tuning count in degrees
+of the tuning, so an octave (period) is as many keys as the tuning has degrees, counted from middle C. Returns false if
+the note shouldn't be played.
+*/
+func (processor *ProcInfo) placeNote(e event) (int, int, bool) {
+
+	key := 12*(e.octave+1) + e.note
+	period := 12
+
+	if processor.activeScale.tuning != "" {
+		period = processor.activeScale.period()
+		key = 60 + (e.octave-4)*period + e.note
+	}
+
+	key, ok := processor.fitToRange(e.track, key, period)
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	key = int(clamp(float64(key), lowestNote, highestNote))
+	octave, note := splitKey(key)
+
+	return octave, note, true
+}
+
+/*splitKey Splits a MIDI note into an octave and note, keeping the octave within what the emitter supports. */
+func splitKey(key int) (int, int) {
+
+	octave := splitKeyOctave(key)
+
+	return octave, key - 12*(octave+1)
+}
+
+func splitKeyOctave(key int) int {
+	return int(clamp(float64(key/12-1), minOctave, maxOctave))
+}
+
+/*rangeSettings Returns the current range of each track in the config format. */
+func (processor *ProcInfo) rangeSettings() []NoteRange {
+
+	settings := make([]NoteRange, len(processor.ranges))
+
+	for i, r := range processor.ranges {
+		settings[i] = NoteRange{Low: r.low, High: r.high, Octave: octaveModesStr[r.octaveMode], Strategy: rangeStrategiesStr[r.strategy]}
+	}
+
+	return settings
+}
+
+/*GetOctaveModes Returns an array of octave mode names for the front end. */
+func (processor *ProcInfo) GetOctaveModes() []string {
+	return octaveModesStr
+}
+
+/*GetRangeStrategies Returns an array of range strategy names for the front end. */
+func (processor *ProcInfo) GetRangeStrategies() []string {
+	return rangeStrategiesStr
+}
@@ -0,0 +1,125 @@
+package processor
+
+import "testing"
+
+func TestFitToRange(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		strategy string
+		low      int
+		high     int
+		key      int
+		want     int
+		ok       bool
+	}{
+		{"inside the range", "Fold", 48, 72, 60, 60, true},
+		{"fold up", "Fold", 48, 72, 40, 52, true},
+		{"fold down several octaves", "Fold", 48, 72, 100, 64, true},
+		{"fold into a narrow range clamps", "Fold", 61, 63, 60, 61, true},
+		{"clamp low", "Clamp", 48, 72, 40, 48, true},
+		{"clamp high", "Clamp", 48, 72, 100, 72, true},
+		{"drop", "Drop", 48, 72, 100, 100, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+			processor.ranges[MelodyTrack] = newNoteRange(NoteRange{Low: test.low, High: test.high, Octave: "Fixed", Strategy: test.strategy})
+
+			key, ok := processor.fitToRange(MelodyTrack, test.key, 12)
+
+			if key != test.want || ok != test.ok {
+				t.Errorf("fitToRange(%d) = %d, %v, want %d, %v", test.key, key, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestTrackOctave(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		mode     string
+		previous []float64
+		value    float64
+		want     int
+	}{
+		{"fixed", "Fixed", nil, 1000, 4},
+		{"level at the top", "Level", []float64{0, 5}, 10, 6},
+		{"level at the bottom", "Level", []float64{10, 5}, 0, 2},
+		{"magnitude of a small value", "Magnitude", nil, 5, 2},
+		{"magnitude of a large value", "Magnitude", nil, 1500, 5},
+		{"magnitude is capped at the top of the range", "Magnitude", nil, 1e9, 6},
+		{"magnitude of a negative value", "Magnitude", nil, -150, 4},
+		{"trend rising", "Trend", []float64{0, 5}, 10, 5},
+		{"trend falling", "Trend", []float64{10, 5}, 0, 3},
+		{"trend flat", "Trend", []float64{5, 0}, 5, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+			processor.ranges[MelodyTrack] = newNoteRange(NoteRange{Low: 36, High: 84, Octave: test.mode, Strategy: "Fold"})
+
+			for _, v := range test.previous {
+				processor.addToPreviousValues(v)
+			}
+
+			if got := processor.trackOctave(MelodyTrack, 4, test.value); got != test.want {
+				t.Errorf("trackOctave(%v) = %d, want %d", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNegativeValuesPlay(t *testing.T) {
+
+	for _, value := range []float64{-1, -13.5, -1e6} {
+
+		processor := newTestProcessor()
+		processor.processMessage(value)
+
+		if processor.events[0] == (event{}) {
+			t.Errorf("nothing queued for %v", value)
+		}
+		if degree := processor.degreeOfValue(value); degree != processor.degreeOfValue(-value) {
+			t.Errorf("degreeOfValue(%v) = %d, want the same degree as %v", value, degree, -value)
+		}
+	}
+}
+
+func TestValidateRange(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		config NoteRange
+		err    string
+	}{
+		{"defaults", NoteRange{}, ""},
+		{"low", NoteRange{Low: -1}, "processor_config.melody_range.low: -1 must be between 0 and 127"},
+		{"high below low", NoteRange{Low: 60, High: 50}, "processor_config.melody_range.high: 50 must be between low (60) and 127"},
+		{"octave mode", NoteRange{Octave: "Random"}, `processor_config.melody_range.octave: "Random" is not an octave mode, expected one of [Fixed Level Magnitude Trend]`},
+		{"strategy", NoteRange{Strategy: "Wrap"}, `processor_config.melody_range.strategy: "Wrap" is not a range strategy, expected one of [Fold Clamp Drop]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			config := test.config
+			err := config.validate("melody_range")
+
+			if test.err == "" {
+				if err != nil || config.High != highestNote || config.Octave != "Fixed" || config.Strategy != "Fold" {
+					t.Errorf("defaults not filled in: %+v, %v", config, err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.err {
+				t.Errorf("error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
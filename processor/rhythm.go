@@ -0,0 +1,185 @@
+package processor
+
+import "math"
+
+/*rhythmMode Defines how a track decides when its notes start and how long they last. */
+type rhythmMode int
+
+var rhythmModesStr = []string{"Every Sample", "Euclidean", "Volatility Length", "Rest On Flat", "Tie Plateaus"}
+
+/*
+The rhythm modes a track can use:
+everySample			Every metric sample triggers the track's notes straight away, with the default duration.
+euclidean			Notes are triggered by a euclidean pattern over a bar, the higher the metric level the more pulses.
+volatilityLength	Calm metrics give long notes and volatile metrics give short ones.
+restOnFlat			Samples that haven't changed from the last one are rests.
+tiePlateaus			Samples that haven't changed from the last one extend the notes already sounding instead of playing new ones.
+*/
+const (
+	everySample      rhythmMode = 0
+	euclidean        rhythmMode = 1
+	volatilityLength rhythmMode = 2
+	restOnFlat       rhythmMode = 3
+	tiePlateaus      rhythmMode = 4
+)
+
+/* Number of steps in a euclidean pattern, a bar of 16th notes. */
+const euclideanSteps = 4 * defaultTicksPerBeat
+
+/* Changes smaller than this fraction of the recent range count as the metric being flat. */
+const flatThreshold = 0.02
+
+/*rhythm Holds the rhythm mode of a track and the notes waiting to be triggered by its pattern. */
+type rhythm struct {
+	mode     rhythmMode
+	pattern  []bool
+	material []event
+}
+
+func newRhythm() *rhythm {
+	return &rhythm{mode: everySample}
+}
+
+/*setMode Changes the rhythm mode of a track. */
+func (r *rhythm) setMode(name string) {
+
+	for i, mode := range rhythmModesStr {
+		if mode == name {
+			r.mode = rhythmMode(i)
+			r.pattern = nil
+			r.material = nil
+		}
+	}
+}
+
+/*clear Drops the notes waiting to be triggered, so nothing held back before a stop plays once it starts again. */
+func (r *rhythm) clear() {
+	r.material = nil
+}
+
+/*playEvents Passes the events generated for a sample through the track's rhythm before they reach the sequencer. */
+func (processor *ProcInfo) playEvents(track Track, events []event, value float64) {
+
+	r := processor.rhythms[track]
+
+	switch r.mode {
+
+	case euclidean:
+		features := processor.getFeatures(value)
+		pulses := 1 + int(math.Round(features.level*float64(euclideanSteps-1)))
+
+		r.pattern = euclideanPattern(pulses, euclideanSteps)
+		r.material = events
+		return
+
+	case volatilityLength:
+		features := processor.getFeatures(value)
+		duration := int(math.Round(float64(defaultDuration) * (1 - features.volatility)))
+
+		if duration < 1 {
+			duration = 1
+		}
+
+		for i := range events {
+			events[i].duration = duration
+		}
+
+	case restOnFlat:
+		if processor.isFlat(value) {
+			return
+		}
+
+	case tiePlateaus:
+		if processor.isFlat(value) && processor.tieEvents(track) {
+			return
+		}
+	}
+
+	for _, e := range events {
+		processor.insertEvent(e)
+	}
+}
+
+/*stepRhythms Called on every sequencer tick, triggers the notes of any track whose euclidean pattern has a pulse on this step. */
+func (processor *ProcInfo) stepRhythms() {
+
+	for _, r := range processor.rhythms {
+
+		if r.mode != euclidean || len(r.pattern) == 0 {
+			continue
+		}
+
+		step := processor.tickCount % len(r.pattern)
+
+		if !r.pattern[step] {
+			continue
+		}
+
+		/* Notes last until the next pulse so the pattern stays legato. */
+		duration := 1
+
+		for duration < len(r.pattern) && !r.pattern[(step+duration)%len(r.pattern)] {
+			duration++
+		}
+
+		for _, e := range r.material {
+			e.duration = duration
+			processor.insertEvent(e)
+		}
+	}
+}
+
+/*euclideanPattern Spreads the pulses as evenly as possible over the steps. */
+func euclideanPattern(pulses int, steps int) []bool {
+
+	pattern := make([]bool, steps)
+
+	for i := range pattern {
+		pattern[i] = (i*pulses)%steps < pulses
+	}
+
+	return pattern
+}
+
+/*isFlat Returns true if the value hasn't really changed since the last sample. */
+func (processor *ProcInfo) isFlat(value float64) bool {
+
+	values := processor.recentValues(value)
+
+	if len(values) < 2 {
+		return false
+	}
+
+	min, max := values[0], values[0]
+
+	for _, v := range values {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+
+	return math.Abs(values[0]-values[1]) <= (max-min)*flatThreshold
+}
+
+/*tieEvents Makes sure the notes of a track that are still sounding last until the next sample is due. Returns false if there was nothing to tie. */
+func (processor *ProcInfo) tieEvents(track Track) bool {
+
+	tied := false
+
+	for i, e := range processor.events {
+		if (event{}) != e && e.track == track && e.eventType == note && e.state != stop {
+
+			if e.duration <= processor.sampleTicks {
+				processor.events[i].duration = processor.sampleTicks + 1
+			}
+
+			tied = true
+		}
+	}
+
+	return tied
+}
+
+/*GetRhythmModes Returns an array of rhythm mode names for the front end. */
+func (processor *ProcInfo) GetRhythmModes() []string {
+	return rhythmModesStr
+}
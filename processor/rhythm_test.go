@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*patternString Draws a pattern as x for a pulse and . for a rest. */
+func patternString(pattern []bool) string {
+
+	var drawn strings.Builder
+
+	for _, pulse := range pattern {
+		if pulse {
+			drawn.WriteString("x")
+		} else {
+			drawn.WriteString(".")
+		}
+	}
+
+	return drawn.String()
+}
+
+func TestEuclideanPattern(t *testing.T) {
+
+	tests := []struct {
+		pulses int
+		steps  int
+		want   string
+	}{
+		{1, 16, "x..............."},
+		{4, 16, "x...x...x...x..."},
+		{3, 8, "x..x..x."},
+		{5, 8, "x.x.xx.x"},
+		{8, 8, "xxxxxxxx"},
+		{0, 4, "...."},
+	}
+
+	for _, test := range tests {
+		if got := patternString(euclideanPattern(test.pulses, test.steps)); got != test.want {
+			t.Errorf("euclideanPattern(%d, %d) = %s, want %s", test.pulses, test.steps, got, test.want)
+		}
+	}
+}
+
+func TestEuclideanRhythm(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		previous  []float64
+		value     float64
+		durations []int
+	}{
+		/* The first sample sits halfway, so 1 + round(0.5 * 15) = 9 pulses over the bar. */
+		{"no history", nil, 5, []int{2, 2, 2, 2, 1, 2, 2, 2, 1}},
+		{"lowest value plays once a bar", []float64{10, 0}, 0, []int{16}},
+		{"highest value plays every step", []float64{0, 10}, 10, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+			processor.rhythms[MelodyTrack].setMode("Euclidean")
+
+			for _, v := range test.previous {
+				processor.addToPreviousValues(v)
+			}
+
+			processor.playEvents(MelodyTrack, []event{{eventType: note, track: MelodyTrack, state: ready, duration: defaultDuration, value: 3, octave: 4, midiChannel: 1}}, test.value)
+
+			var durations []int
+
+			for tick := 0; tick < euclideanSteps; tick++ {
+
+				processor.tickCount = tick
+				processor.stepRhythms()
+
+				for i, e := range processor.events {
+					if (event{}) != e {
+						durations = append(durations, e.duration)
+						processor.events[i] = event{}
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(durations, test.durations) {
+				t.Errorf("durations = %v, want %v", durations, test.durations)
+			}
+		})
+	}
+}
+
+func TestPlayEvents(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		mode     string
+		previous []float64
+		value    float64
+		queued   bool
+		duration int
+	}{
+		{"every sample", "Every Sample", []float64{1, 5}, 5, true, defaultDuration},
+		{"rest on flat plays changes", "Rest On Flat", []float64{1, 5}, 9, true, defaultDuration},
+		{"rest on flat rests when flat", "Rest On Flat", []float64{1, 5}, 5, false, 0},
+		{"volatility length holds calm notes", "Volatility Length", []float64{1, 2, 3}, 4, true, 11},
+		{"volatility length cuts volatile notes short", "Volatility Length", []float64{0, 10, 0}, 10, true, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+			processor.rhythms[MelodyTrack].setMode(test.mode)
+
+			for _, v := range test.previous {
+				processor.addToPreviousValues(v)
+			}
+
+			processor.playEvents(MelodyTrack, []event{{eventType: note, track: MelodyTrack, state: ready, duration: defaultDuration, value: 3, octave: 4, midiChannel: 1}}, test.value)
+
+			queued := processor.events[0]
+
+			if ((event{}) != queued) != test.queued {
+				t.Fatalf("queued = %v, want %v", queued, test.queued)
+			}
+			if test.queued && queued.duration != test.duration {
+				t.Errorf("duration = %d, want %d", queued.duration, test.duration)
+			}
+		})
+	}
+}
+
+func TestTiePlateaus(t *testing.T) {
+
+	processor := newTestProcessor()
+	processor.rhythms[MelodyTrack].setMode("Tie Plateaus")
+	processor.sampleTicks = 20
+
+	processor.addToPreviousValues(1)
+	processor.addToPreviousValues(5)
+	processor.playEvents(MelodyTrack, []event{{eventType: note, track: MelodyTrack, state: ready, duration: defaultDuration, value: 3, octave: 4, midiChannel: 1}}, 9)
+	processor.addToPreviousValues(9)
+
+	/* The plateau extends the sounding note to the next sample rather than queueing another one. */
+	processor.playEvents(MelodyTrack, []event{{eventType: note, track: MelodyTrack, state: ready, duration: defaultDuration, value: 7, octave: 4, midiChannel: 1}}, 9)
+
+	if processor.events[1] != (event{}) {
+		t.Errorf("a new note was queued on a plateau: %v", processor.events[1])
+	}
+	if processor.events[0].duration != 21 {
+		t.Errorf("tied duration = %d, want 21", processor.events[0].duration)
+	}
+}
+
+func TestStopClearsRhythms(t *testing.T) {
+
+	processor := newTestProcessor()
+	processor.rhythms[ChordTrack].setMode("Euclidean")
+	processor.playEvents(ChordTrack, []event{{eventType: note, track: ChordTrack, state: ready, duration: defaultDuration, value: 3, octave: 3, midiChannel: 2}}, 5)
+
+	processor.handleControlMessage(ControlMessage{Type: StopProcessor})
+	processor.handleControlMessage(ControlMessage{Type: StartProcessor})
+
+	for tick := 0; tick < euclideanSteps; tick++ {
+		processor.tickCount = tick
+		processor.stepRhythms()
+	}
+
+	for _, e := range processor.events {
+		if (event{}) != e {
+			t.Fatalf("a note held by the rhythm before the stop was played: %v", e)
+		}
+	}
+}
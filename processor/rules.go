@@ -0,0 +1,290 @@
+package processor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Rule Defines the format of a rule config, which changes the musical state when a condition has held for long enough:
+when		Conditions on the incoming samples joined with "and", each one is <subject> <operator> <number>. Subjects are
+value (the raw metric) and the features level, trend and volatility (see metricFeatures). Operators are >, >=, <, <=,
+== and !=. For example "value > 0.8" or "trend < 0 and volatility > 0.5".
+for			How long the conditions have to hold before the rule fires, e.g. 30s or 5m. Fires straight away when left out.
+then		Settings applied when the rule fires.
+otherwise	Settings applied when the conditions stop holding after the rule has fired, optional.
+enabled		Rules can be turned off in the front end, they start on unless this is set to false.
+*/
+type Rule struct {
+	Name      string      `yaml:"name"`
+	When      string      `yaml:"when"`
+	For       string      `yaml:"for"`
+	Then      RuleActions `yaml:"then"`
+	Otherwise RuleActions `yaml:"otherwise"`
+	Enabled   *bool       `yaml:"enabled"`
+}
+
+/*RuleActions Defines the settings a rule can change, anything left out is left alone. */
+type RuleActions struct {
+	Key       string `yaml:"key"`
+	Scale     string `yaml:"scale"`
+	BPM       int    `yaml:"bpm"`
+	ChordMode string `yaml:"chord_mode"`
+}
+
+/*condition A single comparison of a subject against a number. */
+type condition struct {
+	subject  string
+	operator string
+	value    float64
+}
+
+var ruleSubjects = []string{"value", "level", "trend", "volatility"}
+
+var ruleOperators = map[string]func(float64, float64) bool{
+	">":  func(a float64, b float64) bool { return a > b },
+	">=": func(a float64, b float64) bool { return a >= b },
+	"<":  func(a float64, b float64) bool { return a < b },
+	"<=": func(a float64, b float64) bool { return a <= b },
+	"==": func(a float64, b float64) bool { return a == b },
+	"!=": func(a float64, b float64) bool { return a != b },
+}
+
+/*rule Parsed version of the rule config, with the state needed to track how long it has held. */
+type rule struct {
+	name       string
+	conditions []condition
+	duration   time.Duration
+	then       []ControlMessage
+	otherwise  []ControlMessage
+	enabled    bool
+	since      time.Time
+	fired      bool
+}
+
+/*parseConditions Splits a when string into its conditions. */
+func parseConditions(when string) ([]condition, error) {
+
+	var conditions []condition
+
+	for _, part := range strings.Split(when, " and ") {
+
+		fields := strings.Fields(part)
+
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("condition %q should be <subject> <operator> <number>", strings.TrimSpace(part))
+		}
+
+		if !contains(ruleSubjects, fields[0]) {
+			return nil, fmt.Errorf("unknown subject %q, expected one of %v", fields[0], ruleSubjects)
+		}
+
+		if _, exists := ruleOperators[fields[1]]; !exists {
+			return nil, fmt.Errorf("unknown operator %q", fields[1])
+		}
+
+		value, err := strconv.ParseFloat(fields[2], 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", fields[2])
+		}
+
+		conditions = append(conditions, condition{subject: fields[0], operator: fields[1], value: value})
+	}
+
+	return conditions, nil
+}
+
+/*parseActions Converts rule actions into the control messages the front end would send for the same changes. Errors name the field that is wrong. */
+func parseActions(actions RuleActions, scaleNames map[string]bool) ([]ControlMessage, error) {
+
+	var messages []ControlMessage
+
+	if actions.Key != "" {
+
+		index, exists := noteIndexes[actions.Key]
+
+		if !exists {
+			return nil, fmt.Errorf("key: %q is not a key, expected one of %v", actions.Key, notes[:12])
+		}
+
+		messages = append(messages, ControlMessage{Type: SetKey, ValueNum: index})
+	}
+
+	if actions.Scale != "" {
+
+		if !scaleNames[actions.Scale] {
+			return nil, fmt.Errorf("scale: no scale named %q", actions.Scale)
+		}
+
+		messages = append(messages, ControlMessage{Type: SetMode, ValueString: actions.Scale})
+	}
+
+	if actions.BPM != 0 {
+
+		if actions.BPM < minBPM || actions.BPM > maxBPM {
+			return nil, fmt.Errorf("bpm: %d must be between %d and %d", actions.BPM, minBPM, maxBPM)
+		}
+
+		messages = append(messages, ControlMessage{Type: SetBPM, ValueNum: actions.BPM})
+	}
+
+	if actions.ChordMode != "" {
+
+		if !contains(chordModesStr, actions.ChordMode) {
+			return nil, fmt.Errorf("chord_mode: %q is not a chord mode, expected one of %v", actions.ChordMode, chordModesStr)
+		}
+
+		messages = append(messages, ControlMessage{Type: SetChordMode, ValueString: actions.ChordMode})
+	}
+
+	return messages, nil
+}
+
+/*parseRule Validates a rule from the config, scales named in its settings have to be among the scale names. Errors name the field that is wrong. */
+func parseRule(config Rule, scaleNames map[string]bool) (*rule, error) {
+
+	parsed := &rule{name: config.Name, enabled: config.Enabled == nil || *config.Enabled}
+	var err error
+
+	if parsed.conditions, err = parseConditions(config.When); err != nil {
+		return nil, fmt.Errorf("when: %v", err)
+	}
+
+	if config.For != "" {
+
+		if parsed.duration, err = time.ParseDuration(config.For); err != nil || parsed.duration < 0 {
+			return nil, fmt.Errorf("for: invalid duration %q", config.For)
+		}
+	}
+
+	if parsed.then, err = parseActions(config.Then, scaleNames); err != nil {
+		return nil, fmt.Errorf("then.%v", err)
+	}
+
+	if len(parsed.then) == 0 {
+		return nil, fmt.Errorf("then: no settings to change")
+	}
+
+	if parsed.otherwise, err = parseActions(config.Otherwise, scaleNames); err != nil {
+		return nil, fmt.Errorf("otherwise.%v", err)
+	}
+
+	return parsed, nil
+}
+
+/*parseRules Processes and stores the rules from the configuration file, invalid ones are logged and skipped. */
+func (processor *ProcInfo) parseRules(ruleList []Rule) {
+
+	scaleNames := make(map[string]bool)
+
+	for _, name := range processor.scales.Keys() {
+		scaleNames[name.(string)] = true
+	}
+
+	for _, config := range ruleList {
+
+		parsed, err := parseRule(config, scaleNames)
+
+		if err != nil {
+			log.Printf("Skipping rule %s: %v\n", config.Name, err)
+			continue
+		}
+
+		processor.rules = append(processor.rules, parsed)
+	}
+}
+
+/*matches Returns true if every condition holds for the value and its features. */
+func (r *rule) matches(value float64, features metricFeatures) bool {
+
+	subjects := map[string]float64{"value": value, "level": features.level, "trend": features.trend, "volatility": features.volatility}
+
+	for _, c := range r.conditions {
+		if !ruleOperators[c.operator](subjects[c.subject], c.value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+evaluateRules Checks every rule against a new sample. A rule fires once its conditions have held for its duration and
+its settings are handled exactly like control messages from the front end. Once the conditions stop holding the rule
+is reset, applying its otherwise settings if it had fired.
+*/
+func (processor *ProcInfo) evaluateRules(value float64) {
+
+	features := processor.getFeatures(value)
+	now := processor.now()
+
+	for _, r := range processor.rules {
+
+		if !r.enabled {
+			continue
+		}
+
+		if !r.matches(value, features) {
+
+			if r.fired {
+				log.Printf("Rule %s no longer holds.\n", r.name)
+				processor.applyRule(r.otherwise)
+			}
+
+			r.since = time.Time{}
+			r.fired = false
+
+			continue
+		}
+
+		if r.since.IsZero() {
+			r.since = now
+		}
+
+		if !r.fired && now.Sub(r.since) >= r.duration {
+			log.Printf("Rule %s fired.\n", r.name)
+			processor.applyRule(r.then)
+			r.fired = true
+		}
+	}
+}
+
+/*applyRule Handles the control messages of a rule, the lock is already held by the generation thread. */
+func (processor *ProcInfo) applyRule(messages []ControlMessage) {
+
+	for _, message := range messages {
+		processor.handleControlMessage(message)
+	}
+}
+
+/*setRule Turns a rule on or off, a rule that is turned back on has to hold for its full duration again. */
+func (processor *ProcInfo) setRule(name string, enabled bool) {
+
+	for _, r := range processor.rules {
+		if r.name == name {
+			r.enabled = enabled
+			r.since = time.Time{}
+			r.fired = false
+		}
+	}
+}
+
+/*GetRules Returns the names of the configured rules and whether each one is enabled, for the front end. */
+func (processor *ProcInfo) GetRules() ([]string, []bool) {
+
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	names := make([]string, len(processor.rules))
+	enabled := make([]bool, len(processor.rules))
+
+	for i, r := range processor.rules {
+		names[i] = r.name
+		enabled[i] = r.enabled
+	}
+
+	return names, enabled
+}
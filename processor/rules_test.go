@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+
+	scaleNames := map[string]bool{"Ionian": true}
+
+	tests := []struct {
+		name  string
+		rule  Rule
+		field string
+	}{
+		{"valid", Rule{When: "value > 0.8 and trend < 0", For: "30s", Then: RuleActions{Key: "D", Scale: "Ionian", BPM: 90, ChordMode: "Major"}}, ""},
+		{"missing operator", Rule{When: "value 0.8", Then: RuleActions{Key: "D"}}, "when:"},
+		{"unknown subject", Rule{When: "pitch > 1", Then: RuleActions{Key: "D"}}, "when:"},
+		{"unknown operator", Rule{When: "value => 1", Then: RuleActions{Key: "D"}}, "when:"},
+		{"invalid number", Rule{When: "value > high", Then: RuleActions{Key: "D"}}, "when:"},
+		{"invalid duration", Rule{When: "value > 1", For: "soon", Then: RuleActions{Key: "D"}}, "for:"},
+		{"nothing to change", Rule{When: "value > 1"}, "then:"},
+		{"unknown key", Rule{When: "value > 1", Then: RuleActions{Key: "H"}}, "then.key:"},
+		{"unknown scale", Rule{When: "value > 1", Then: RuleActions{Scale: "Locrian"}}, "then.scale:"},
+		{"bpm out of range", Rule{When: "value > 1", Then: RuleActions{BPM: 500}}, "then.bpm:"},
+		{"unknown chord mode", Rule{When: "value > 1", Then: RuleActions{ChordMode: "Jazz"}}, "then.chord_mode:"},
+		{"unknown otherwise scale", Rule{When: "value > 1", Then: RuleActions{Key: "D"}, Otherwise: RuleActions{Scale: "Locrian"}}, "otherwise.scale:"},
+	}
+
+	for _, test := range tests {
+
+		_, err := parseRule(test.rule, scaleNames)
+
+		if test.field == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+
+		if test.field != "" && (err == nil || !strings.HasPrefix(err.Error(), test.field)) {
+			t.Errorf("%s: got error %v, want one starting with %q", test.name, err, test.field)
+		}
+	}
+}
+
+func TestParseRuleActions(t *testing.T) {
+
+	parsed, err := parseRule(Rule{When: "value >= 10", Then: RuleActions{Key: "D", Scale: "Ionian", BPM: 90, ChordMode: "Minor"}},
+		map[string]bool{"Ionian": true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ControlMessage{
+		{Type: SetKey, ValueNum: noteIndexes["D"]},
+		{Type: SetMode, ValueString: "Ionian"},
+		{Type: SetBPM, ValueNum: 90},
+		{Type: SetChordMode, ValueString: "Minor"},
+	}
+
+	if !reflect.DeepEqual(parsed.then, expected) {
+		t.Errorf("got messages %+v, want %+v", parsed.then, expected)
+	}
+
+	if !parsed.enabled || parsed.duration != 0 {
+		t.Errorf("expected an enabled rule that fires straight away, got enabled %v duration %v", parsed.enabled, parsed.duration)
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+
+	processor := newConfiguredProcessor(Config{Scales: testScales, Rules: []Rule{
+		{Name: "high", When: "value >= 10", Then: RuleActions{Scale: "Ionian"}, Otherwise: RuleActions{Scale: "Chromatic"}},
+		{Name: "held", When: "value >= 10", For: "1h", Then: RuleActions{BPM: 200}},
+	}})
+
+	tests := []struct {
+		value float64
+		scale string
+	}{
+		{5, "Chromatic"},
+		{12, "Ionian"},
+		{15, "Ionian"},
+		{3, "Chromatic"},
+	}
+
+	for _, test := range tests {
+
+		processor.evaluateRules(test.value)
+
+		if processor.activeScale.name != test.scale {
+			t.Errorf("after %f got scale %s, want %s", test.value, processor.activeScale.name, test.scale)
+		}
+	}
+
+	if processor.BPM != defaultBPM {
+		t.Errorf("a rule that has to hold for an hour fired, BPM is %f", processor.BPM)
+	}
+
+	processor.setRule("high", false)
+	processor.evaluateRules(20)
+
+	if processor.activeScale.name != "Chromatic" {
+		t.Errorf("a disabled rule fired, scale is %s", processor.activeScale.name)
+	}
+
+	if processor.rules[1].since.IsZero() || processor.rules[1].fired {
+		t.Errorf("the held rule should be timing how long its conditions hold without firing")
+	}
+}
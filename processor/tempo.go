@@ -0,0 +1,272 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/smf"
+)
+
+/*
+Tempo Defines the format of the tempo config:
+follow		none, metric or second_metric, the tempo follows the level of that metric (none).
+transform	linear, log, sqrt or inverse, applied to the metric before it is mapped into the tempo range.
+in_min/max	Range of the (transformed) metric, defaults to its recent range.
+min/max		Tempo range in BPM the metric is mapped into (60-180).
+ramp		Length of the ramp to every new tempo, including ones set in the front end, by rules or by a bpm binding. 0 jumps.
+ramp_unit	beats or bars (4 beats), what ramp is counted in (beats).
+*/
+type Tempo struct {
+	Follow    string   `yaml:"follow"`
+	Transform string   `yaml:"transform"`
+	InMin     *float64 `yaml:"in_min"`
+	InMax     *float64 `yaml:"in_max"`
+	Min       *float64 `yaml:"min"`
+	Max       *float64 `yaml:"max"`
+	Ramp      float64  `yaml:"ramp"`
+	RampUnit  string   `yaml:"ramp_unit"`
+}
+
+/*tempoFollow Defines what the tempo follows. */
+type tempoFollow int
+
+var tempoFollowsStr = []string{"None", "Metric", "Second Metric"}
+
+const (
+	followNone         tempoFollow = 0
+	followMetric       tempoFollow = 1
+	followSecondMetric tempoFollow = 2
+)
+
+/* The config uses the same names as the modulation sources. */
+var tempoFollowConfig = []string{"none", "metric", "second_metric"}
+
+const beatsPerBar = 4
+
+/* Tempo changes smaller than this aren't logged, so a ramp that has finished doesn't keep adding entries. */
+const tempoLogResolution = 0.01
+
+const maxTempoLog = 100000
+
+/*TempoChange An entry of the tempo log, the tempo the sequencer changed to and when. Seconds are counted from the start of the processor, Time is the clock time the change happened. */
+type TempoChange struct {
+	Tick    int
+	Seconds float64
+	Time    time.Time
+	BPM     float64
+}
+
+/*tempoRamp Moves the tempo between two values over a number of sequencer ticks. */
+type tempoRamp struct {
+	follow    tempoFollow
+	mapping   *binding
+	beats     float64
+	from      float64
+	to        float64
+	length    int
+	position  int
+	elapsed   float64
+	log       []TempoChange
+	lastBPM   float64
+	hasLogged bool
+}
+
+/*validate Checks the tempo config, filling in the defaults. */
+func (config *Tempo) validate() error {
+
+	if config.Follow == "" {
+		config.Follow = tempoFollowConfig[followNone]
+	}
+
+	if !contains(tempoFollowConfig, config.Follow) {
+		return fmt.Errorf("processor_config.tempo.follow: %q expected one of %v", config.Follow, tempoFollowConfig)
+	}
+
+	if _, exists := bindingTransforms[config.Transform]; !exists {
+		return fmt.Errorf("processor_config.tempo.transform: unknown transform %q", config.Transform)
+	}
+
+	for _, limit := range []*float64{config.Min, config.Max} {
+		if limit != nil && (*limit < minBPM || *limit > maxBPM) {
+			return fmt.Errorf("processor_config.tempo: min/max %f must be between %d and %d", *limit, minBPM, maxBPM)
+		}
+	}
+
+	if config.Ramp < 0 {
+		return fmt.Errorf("processor_config.tempo.ramp: %f can't be negative", config.Ramp)
+	}
+
+	switch config.RampUnit {
+	case "", "beats":
+	case "bars":
+		config.Ramp *= beatsPerBar
+	default:
+		return fmt.Errorf("processor_config.tempo.ramp_unit: %q must be beats or bars", config.RampUnit)
+	}
+
+	/* The ramp is stored in beats from here on. */
+	config.RampUnit = "beats"
+
+	return nil
+}
+
+func newTempoRamp(config Tempo) *tempoRamp {
+
+	ramp := &tempoRamp{beats: config.Ramp,
+		mapping: newBinding(Binding{Name: "tempo", Dimension: bindingDimensionsStr[bpmBinding], Transform: config.Transform,
+			InMin: config.InMin, InMax: config.InMax, Min: config.Min, Max: config.Max})}
+
+	for i, follow := range tempoFollowConfig {
+		if follow == config.Follow {
+			ramp.follow = tempoFollow(i)
+		}
+	}
+
+	return ramp
+}
+
+/*rampTempo Starts a ramp from the current tempo to a new one, which replaces any ramp already running. */
+func (processor *ProcInfo) rampTempo(bpm float64) {
+
+	ramp := processor.tempo
+	bpm = clamp(bpm, minBPM, maxBPM)
+
+	ramp.from = processor.BPM
+	ramp.to = bpm
+	ramp.position = 0
+	ramp.length = int(math.Round(ramp.beats * defaultTicksPerBeat))
+
+	if ramp.length == 0 {
+		processor.BPM = bpm
+	}
+}
+
+/*stepTempo Called on every sequencer tick, moves the tempo along the current ramp and logs any change. */
+func (processor *ProcInfo) stepTempo() {
+
+	ramp := processor.tempo
+
+	if ramp.position < ramp.length {
+		ramp.position++
+		processor.BPM = ramp.from + (ramp.to-ramp.from)*float64(ramp.position)/float64(ramp.length)
+	}
+
+	bpm := processor.currentBPM()
+
+	if !ramp.hasLogged || math.Abs(bpm-ramp.lastBPM) >= tempoLogResolution {
+
+		if len(ramp.log) >= maxTempoLog {
+			ramp.log = ramp.log[1:]
+		}
+
+		ramp.log = append(ramp.log, TempoChange{Tick: processor.tickCount, Seconds: ramp.elapsed, Time: processor.now(), BPM: bpm})
+		ramp.lastBPM = bpm
+		ramp.hasLogged = true
+	}
+}
+
+/*followTempo Ramps to the tempo the latest value of the followed metric maps to. */
+func (processor *ProcInfo) followTempo(value float64, secondMetric bool) {
+
+	ramp := processor.tempo
+
+	if ramp.follow == followNone || (ramp.follow == followSecondMetric) != secondMetric {
+		return
+	}
+
+	min, max := processor.outputRange(ramp.mapping)
+	target := min + ramp.mapping.level(value)*(max-min)
+
+	/* Small changes would restart the ramp on every sample without being heard. */
+	if math.Abs(target-ramp.to) < 1 {
+		return
+	}
+
+	processor.rampTempo(target)
+}
+
+func (processor *ProcInfo) setTempoFollow(name string) {
+
+	for i, follow := range tempoFollowsStr {
+		if follow == name {
+			processor.tempo.follow = tempoFollow(i)
+			processor.tempo.mapping.values.Init()
+		}
+	}
+}
+
+/*setTempoRamp Changes the length of tempo ramps, in beats. */
+func (processor *ProcInfo) setTempoRamp(beats int) {
+	processor.tempo.beats = math.Max(0, float64(beats))
+}
+
+/*saveTempoLog Writes the tempo log to a CSV file, one line per tempo change. */
+func (processor *ProcInfo) saveTempoLog(path string) {
+
+	file, err := os.Create(path)
+
+	if err != nil {
+		log.Printf("Failed to save tempo log (%v)\n", err)
+		return
+	}
+
+	defer file.Close()
+
+	fmt.Fprintln(file, "tick,seconds,bpm")
+
+	for _, change := range processor.tempo.log {
+		fmt.Fprintf(file, "%d,%.3f,%.2f\n", change.Tick, change.Seconds, change.BPM)
+	}
+
+	log.Printf("Saved %d tempo changes to %s\n", len(processor.tempo.log), path)
+}
+
+/*GetTempoLog Returns a copy of the tempo changes since the processor started. */
+func (processor *ProcInfo) GetTempoLog() []TempoChange {
+
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	return append([]TempoChange{}, processor.tempo.log...)
+}
+
+/*
+GetTempoMap Returns the tempo changes from a point in time on, for exporting a recording that started then. The tempo
+in effect at the start comes first. A zero start takes the whole log, which is how offline renders are exported.
+*/
+func (processor *ProcInfo) GetTempoMap(start time.Time) []smf.Tempo {
+
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	changes := processor.tempo.log
+
+	if len(changes) == 0 {
+		return []smf.Tempo{{Time: 0, BPM: processor.currentBPM()}}
+	}
+
+	if start.IsZero() {
+		start = changes[0].Time
+	}
+
+	tempos := []smf.Tempo{{Time: 0, BPM: changes[0].BPM}}
+
+	for _, change := range changes {
+
+		if !change.Time.After(start) {
+			tempos[0].BPM = change.BPM
+			continue
+		}
+
+		tempos = append(tempos, smf.Tempo{Time: change.Time.Sub(start), BPM: change.BPM})
+	}
+
+	return tempos
+}
+
+/*GetTempoFollows Returns an array of what the tempo can follow for the front end. */
+func (processor *ProcInfo) GetTempoFollows() []string {
+	return tempoFollowsStr
+}
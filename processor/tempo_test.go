@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTempo(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		tempo Tempo
+		ramp  float64
+		field string
+	}{
+		{"defaults", Tempo{}, 0, ""},
+		{"beats", Tempo{Follow: "metric", Ramp: 2}, 2, ""},
+		{"bars", Tempo{Follow: "second_metric", Ramp: 2, RampUnit: "bars"}, 8, ""},
+		{"unknown follow", Tempo{Follow: "disk"}, 0, "tempo.follow"},
+		{"unknown transform", Tempo{Transform: "cube"}, 0, "tempo.transform"},
+		{"min out of range", Tempo{Min: bound(0)}, 0, "tempo:"},
+		{"max out of range", Tempo{Max: bound(500)}, 0, "tempo:"},
+		{"negative ramp", Tempo{Ramp: -1}, 0, "tempo.ramp:"},
+		{"unknown ramp unit", Tempo{Ramp: 1, RampUnit: "seconds"}, 0, "tempo.ramp_unit"},
+	}
+
+	for _, test := range tests {
+
+		err := test.tempo.validate()
+
+		if test.field == "" && (err != nil || test.tempo.Ramp != test.ramp) {
+			t.Errorf("%s: got ramp %f error %v, want ramp %f", test.name, test.tempo.Ramp, err, test.ramp)
+		}
+
+		if test.field != "" && (err == nil || !strings.HasPrefix(err.Error(), "processor_config."+test.field)) {
+			t.Errorf("%s: got error %v, want one for %s", test.name, err, test.field)
+		}
+	}
+}
+
+func TestRampTempo(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		beats    float64
+		expected []float64
+		changes  int
+	}{
+		{"jump", 0, []float64{120, 120, 120, 120}, 1},
+		{"one beat", 1, []float64{75, 90, 105, 120, 120}, 4},
+		{"half a beat", 0.5, []float64{90, 120, 120}, 2},
+	}
+
+	for _, test := range tests {
+
+		processor := newConfiguredProcessor(Config{Scales: testScales, Tempo: Tempo{Ramp: test.beats}})
+		processor.handleControlMessage(ControlMessage{Type: SetBPM, ValueNum: 120})
+
+		for i, expected := range test.expected {
+
+			processor.incrementTick()
+
+			if processor.BPM != expected {
+				t.Errorf("%s: tick %d got %f BPM, want %f", test.name, i, processor.BPM, expected)
+			}
+		}
+
+		/* The log only gets an entry when the tempo changes. */
+		changes := processor.GetTempoLog()
+
+		if len(changes) != test.changes || changes[len(changes)-1].BPM != 120 {
+			t.Errorf("%s: got tempo log %+v", test.name, changes)
+		}
+	}
+}
+
+func TestFollowTempo(t *testing.T) {
+
+	processor := newConfiguredProcessor(Config{Scales: testScales,
+		Tempo: Tempo{Follow: "metric", InMin: bound(0), InMax: bound(100), Min: bound(60), Max: bound(180)}})
+
+	tests := []struct {
+		value        float64
+		secondMetric bool
+		expected     float64
+	}{
+		{50, false, 120},
+		{100, true, 120},
+		{100, false, 180},
+		{100.5, false, 180},
+		{0, false, 60},
+	}
+
+	for _, test := range tests {
+
+		processor.followTempo(test.value, test.secondMetric)
+
+		if processor.BPM != test.expected {
+			t.Errorf("followTempo(%f, %v) got %f BPM, want %f", test.value, test.secondMetric, processor.BPM, test.expected)
+		}
+	}
+}
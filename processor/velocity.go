@@ -0,0 +1,188 @@
+package processor
+
+import (
+	"container/list"
+	"math"
+)
+
+type velocityMode int
+
+var velocityModesStr = []string{"Fixed", "Variance", "Z-Score", "Percentile", "Rate of Change", "Second Metric"}
+
+const (
+	fixed              velocityMode = 0
+	singleNoteVariance velocityMode = 1
+	zScore             velocityMode = 2
+	percentile         velocityMode = 3
+	rateOfChange       velocityMode = 4
+	secondMetric       velocityMode = 5
+)
+
+const defaultVelocity = 100
+const defaultMinVelocity = 40
+const defaultMaxVelocity = 110
+
+/* How much of the largest variance/rate of change seen is kept after each sample, so a single spike doesn't flatten everything after it. */
+const varianceDecay = 0.95
+
+/* Number of standard deviations from the mean that is treated as the loudest note. */
+const zScoreRange = 3.0
+
+/*
+getVelocity implements the logic for different types of velocity sensing based on input metrics. Apart from fixed, every
+mode works out a level between 0 and 1 which is then scaled into the min/max velocity range:
+fixed				The input metrics have no effect on velocity and the fixed velocity is used.
+singleNoteVariance	The change from the last value as a percentage of the largest change seen, which decays over time.
+zScore				How many standard deviations the value is from the mean of the recent values.
+percentile			The percentage of recent values that are lower than the current value.
+rateOfChange		The relative change from the last value, rising values are louder and falling values softer.
+secondMetric		The latest value of a second metric, relative to its own recent range.
+*/
+func (processor *ProcInfo) getVelocity(value float64) int64 {
+
+	/* A velocity binding sets the velocity from its own query. */
+	if processor.boundVelocity > 0 {
+		return processor.boundVelocity
+	}
+
+	if processor.velocitySensingMode == fixed {
+		return clampVelocity(processor.fixedVelocity, processor.minVelocity, processor.maxVelocity)
+	}
+
+	level := 0.5
+	previous := listValues(processor.previousValues)
+
+	switch processor.velocitySensingMode {
+
+	case singleNoteVariance:
+
+		if len(previous) > 0 {
+
+			currentVariance := math.Abs(value - previous[0])
+			processor.maxVariance = math.Max(processor.maxVariance*varianceDecay, currentVariance)
+
+			if processor.maxVariance > 0 {
+				level = currentVariance / processor.maxVariance
+			}
+		}
+
+	case zScore:
+
+		if len(previous) > 1 {
+
+			mean, deviation := meanAndDeviation(previous)
+
+			if deviation > 0 {
+				level = math.Abs(value-mean) / deviation / zScoreRange
+			}
+		}
+
+	case percentile:
+
+		if len(previous) > 0 {
+
+			lower := 0
+
+			for _, v := range previous {
+				if v < value {
+					lower++
+				}
+			}
+
+			level = float64(lower) / float64(len(previous))
+		}
+
+	case rateOfChange:
+
+		if len(previous) > 0 && previous[0] != 0 {
+
+			change := (value - previous[0]) / math.Abs(previous[0])
+			processor.maxRateOfChange = math.Max(processor.maxRateOfChange*varianceDecay, math.Abs(change))
+
+			if processor.maxRateOfChange > 0 {
+				level = 0.5 + change/(2*processor.maxRateOfChange)
+			}
+		}
+
+	case secondMetric:
+
+		values := listValues(processor.velocityValues)
+
+		if len(values) > 1 {
+
+			min, max := values[0], values[0]
+
+			for _, v := range values {
+				min = math.Min(min, v)
+				max = math.Max(max, v)
+			}
+
+			if max > min {
+				level = (values[0] - min) / (max - min)
+			}
+		}
+	}
+
+	level = clamp(level, 0, 1)
+
+	return processor.minVelocity + int64(math.Round(level*float64(processor.maxVelocity-processor.minVelocity)))
+}
+
+func (processor *ProcInfo) setVelocityMode(name string) {
+
+	for i, mode := range velocityModesStr {
+		if mode == name {
+			processor.velocitySensingMode = velocityMode(i)
+			processor.maxVariance = 0
+			processor.maxRateOfChange = 0
+			log.Printf("Velocity mode set to %s\n", name)
+		}
+	}
+}
+
+/*meanAndDeviation Returns the mean and standard deviation of the values. */
+func meanAndDeviation(values []float64) (float64, float64) {
+
+	mean := 0.0
+
+	for _, v := range values {
+		mean += v
+	}
+
+	mean /= float64(len(values))
+	variance := 0.0
+
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+/*listValues Copies the float values out of a list, most recent first. */
+func listValues(values *list.List) []float64 {
+
+	copied := make([]float64, 0, values.Len())
+
+	for e := values.Front(); e != nil; e = e.Next() {
+		copied = append(copied, e.Value.(float64))
+	}
+
+	return copied
+}
+
+func clampVelocity(velocity int64, min int64, max int64) int64 {
+
+	if velocity < min {
+		return min
+	}
+	if velocity > max {
+		return max
+	}
+	return velocity
+}
+
+/*GetVelocityModes Returns an array of velocity mode names for the front end. */
+func (processor *ProcInfo) GetVelocityModes() []string {
+	return velocityModesStr
+}
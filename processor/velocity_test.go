@@ -0,0 +1,80 @@
+package processor
+
+import "testing"
+
+func TestVelocityControlClamps(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		message ControlMessage
+		fixed   int64
+		min     int64
+		max     int64
+	}{
+		{"fixed above MIDI range", ControlMessage{Type: SetVelocity, ValueNum: 200}, 127, defaultMinVelocity, defaultMaxVelocity},
+		{"fixed below MIDI range", ControlMessage{Type: SetVelocity, ValueNum: -5}, 1, defaultMinVelocity, defaultMaxVelocity},
+		{"min above max", ControlMessage{Type: SetMinVelocity, ValueNum: 120}, defaultVelocity, defaultMaxVelocity, defaultMaxVelocity},
+		{"min below one", ControlMessage{Type: SetMinVelocity, ValueNum: 0}, defaultVelocity, 1, defaultMaxVelocity},
+		{"max below min", ControlMessage{Type: SetMaxVelocity, ValueNum: 10}, defaultVelocity, defaultMinVelocity, defaultMinVelocity},
+		{"max above MIDI range", ControlMessage{Type: SetMaxVelocity, ValueNum: 300}, defaultVelocity, defaultMinVelocity, 127},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+			processor.handleControlMessage(test.message)
+
+			if processor.fixedVelocity != test.fixed || processor.minVelocity != test.min || processor.maxVelocity != test.max {
+				t.Errorf("fixed/min/max = %d/%d/%d, want %d/%d/%d", processor.fixedVelocity, processor.minVelocity, processor.maxVelocity, test.fixed, test.min, test.max)
+			}
+		})
+	}
+}
+
+func TestGetVelocity(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		mode     string
+		fixed    int64
+		previous []float64
+		second   []float64
+		value    float64
+		want     int64
+	}{
+		{"fixed is clamped to the range", "Fixed", 127, nil, nil, 5, defaultMaxVelocity},
+		{"fixed inside the range", "Fixed", 80, nil, nil, 5, 80},
+		{"variance without history is halfway", "Variance", 0, nil, nil, 5, 75},
+		{"largest variance is loudest", "Variance", 0, []float64{5}, nil, 50, defaultMaxVelocity},
+		{"percentile above everything", "Percentile", 0, []float64{1, 2, 3}, nil, 10, defaultMaxVelocity},
+		{"percentile below everything", "Percentile", 0, []float64{1, 2, 3}, nil, 0, defaultMinVelocity},
+		{"z-score at the mean is quietest", "Z-Score", 0, []float64{1, 3, 1, 3}, nil, 2, defaultMinVelocity},
+		{"z-score far from the mean is clamped", "Z-Score", 0, []float64{1, 3, 1, 3}, nil, 100, defaultMaxVelocity},
+		{"rising rate of change is loudest", "Rate of Change", 0, []float64{10}, nil, 20, defaultMaxVelocity},
+		{"falling rate of change is quietest", "Rate of Change", 0, []float64{10}, nil, 5, defaultMinVelocity},
+		{"second metric at its peak", "Second Metric", 0, nil, []float64{0, 5, 10}, 0, defaultMaxVelocity},
+		{"second metric at its low", "Second Metric", 0, nil, []float64{10, 5, 0}, 0, defaultMinVelocity},
+		{"second metric without a range is halfway", "Second Metric", 0, nil, []float64{3}, 0, 75},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			processor := newTestProcessor()
+			processor.setVelocityMode(test.mode)
+			processor.fixedVelocity = test.fixed
+
+			for _, v := range test.previous {
+				processor.addToPreviousValues(v)
+			}
+			for _, v := range test.second {
+				processor.addToVelocityValues(v)
+			}
+
+			if got := processor.getVelocity(test.value); got != test.want {
+				t.Errorf("getVelocity(%v) = %d, want %d", test.value, got, test.want)
+			}
+		})
+	}
+}
@@ -3,7 +3,9 @@ package prometheus
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/ElectricNoodle/prometheus-midi-generator/logging"
@@ -42,10 +44,14 @@ var httpClient = &http.Client{
 	Timeout: time.Second * 3,
 }
 
-/*Scraper Holds all relevant variables for scraping Promthetheus.*/
+/*Frame Values of several queries at the same timestamp, keyed by the name each query was given. An alias so consumers don't need this package. */
+type Frame = map[string]float64
+
+/*Scraper Holds all relevant variables for scraping Promthetheus. Single queries are sent on Output, sets of queries as Frames.*/
 type Scraper struct {
 	Target     string
 	Output     chan float64
+	Frames     chan Frame
 	Control    chan ControlMessage
 	mode       OutputType
 	data       *queue.RingBuffer
@@ -80,12 +86,14 @@ const (
 	Init     OutputType = -1
 )
 
-/*QueryInfo Information used to store information on query being used to scrape metric values.*/
+/*QueryInfo Information used to store information on query being used to scrape metric values. Offset shifts the query back in time by a number of seconds, Queries (name to query) is run instead of Query when set, combining the values into frames.*/
 type QueryInfo struct {
-	Query string
-	Start float64
-	End   float64
-	Step  int
+	Query   string
+	Queries map[string]string
+	Start   float64
+	End     float64
+	Step    int
+	Offset  float64
 }
 
 /*ControlMessage Message used to change behaviour of Prometheus scraper.*/
@@ -117,7 +125,7 @@ func NewScraper(logIn *logging.Logger, server string, mode OutputType) *Scraper
 
 	log = logIn
 	queryEndpoint := "http://" + server + "/api/v1/query_range"
-	scraper := Scraper{queryEndpoint, make(chan float64, 3), make(chan ControlMessage, 6), mode, queue.NewRingBuffer(defaultRingSize), defaultPollRate, defaulttOutputRate, true}
+	scraper := Scraper{queryEndpoint, make(chan float64, 3), make(chan Frame, 3), make(chan ControlMessage, 6), mode, queue.NewRingBuffer(defaultRingSize), defaultPollRate, defaulttOutputRate, true}
 
 	go scraper.prometheusControlThread()
 
@@ -138,7 +146,7 @@ func (collector *Scraper) prometheusControlThread() {
 			log.Printf("Query: %s Start: %f Stop: %f Step: %d \n", message.QueryInfo.Query, message.QueryInfo.Start, message.QueryInfo.End, message.QueryInfo.Step)
 
 			collector.isActive = true
-			collector.queryPrometheus(message.OutputType, message.QueryInfo.Query, message.QueryInfo.Start, message.QueryInfo.End, message.QueryInfo.Step)
+			collector.queryPrometheus(message.OutputType, message.QueryInfo)
 
 		case ChangePollRate:
 
@@ -161,14 +169,18 @@ func (collector *Scraper) prometheusControlThread() {
 }
 
 /*  Stores the initial time series data, starts the output thread, and also the live playback query thread if required. */
-func (collector *Scraper) queryPrometheus(mode OutputType, query string, start float64, end float64, step int) {
+func (collector *Scraper) queryPrometheus(mode OutputType, queryInfo QueryInfo) {
 
-	data := collector.getTimeSeriesData(query, start, end, step)
-	collector.populateRingBuffer(data)
+	if len(queryInfo.Queries) > 0 {
+		collector.populateFrames(collector.getFrames(queryInfo.Queries, queryInfo.Start-queryInfo.Offset, queryInfo.End-queryInfo.Offset, queryInfo.Step))
+	} else {
+		data := collector.getTimeSeriesData(queryInfo.Query, queryInfo.Start-queryInfo.Offset, queryInfo.End-queryInfo.Offset, queryInfo.Step)
+		collector.populateRingBuffer(data)
+	}
 
 	if mode == Live {
 		log.Println("Running in live mode")
-		go collector.queryThread(query, step)
+		go collector.queryThread(queryInfo)
 	}
 
 	go collector.outputThread()
@@ -187,7 +199,13 @@ func (collector *Scraper) outputThread() {
 				log.Printf("Error: %s", err)
 			}
 
-			collector.Output <- item.(float64)
+			switch value := item.(type) {
+			case float64:
+				collector.Output <- value
+			case Frame:
+				collector.Frames <- value
+			}
+
 			time.Sleep(time.Duration(collector.outputRate) * time.Millisecond)
 
 		} else {
@@ -201,13 +219,17 @@ func (collector *Scraper) outputThread() {
 }
 
 /* Queries for latest TimeSeries data, and sleeps for configurable duration. */
-func (collector *Scraper) queryThread(query string, step int) {
+func (collector *Scraper) queryThread(queryInfo QueryInfo) {
 	for {
 		if collector.isActive {
-			now := float64(time.Now().Unix())
+			now := float64(time.Now().Unix()) - queryInfo.Offset
 
-			data := collector.getTimeSeriesData(query, now, now, step)
-			collector.populateRingBuffer(data)
+			if len(queryInfo.Queries) > 0 {
+				collector.populateFrames(collector.getFrames(queryInfo.Queries, now, now, queryInfo.Step))
+			} else {
+				data := collector.getTimeSeriesData(queryInfo.Query, now, now, queryInfo.Step)
+				collector.populateRingBuffer(data)
+			}
 
 			time.Sleep(time.Duration(collector.pollRate) * time.Millisecond)
 		} else {
@@ -224,6 +246,87 @@ func (collector *Scraper) populateRingBuffer(data []point) {
 	}
 }
 
+func (collector *Scraper) populateFrames(frames []Frame) {
+	for _, frame := range frames {
+		collector.data.Put(frame)
+	}
+}
+
+/*
+Runs every query in parallel over the same range and lines up their values by timestamp. Prometheus evaluates range
+queries at start + n*step, so samples from different queries share timestamps. A query with no sample at a timestamp
+keeps its last value, and timestamps before every query has a value are left out.
+*/
+func (collector *Scraper) getFrames(queries map[string]string, start float64, end float64, step int) []Frame {
+
+	results := make(map[string][]point, len(queries))
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+
+	for name, query := range queries {
+
+		wait.Add(1)
+
+		go func(name string, query string) {
+
+			defer wait.Done()
+			data := collector.getTimeSeriesData(query, start, end, step)
+
+			lock.Lock()
+			results[name] = data
+			lock.Unlock()
+
+		}(name, query)
+	}
+
+	wait.Wait()
+
+	values := make(map[int64]map[string]float64)
+
+	for name, data := range results {
+		for _, point := range data {
+
+			if values[point.Timestamp] == nil {
+				values[point.Timestamp] = make(map[string]float64)
+			}
+
+			values[point.Timestamp][name] = point.Value
+		}
+	}
+
+	timestamps := make([]int64, 0, len(values))
+
+	for timestamp := range values {
+		timestamps = append(timestamps, timestamp)
+	}
+
+	sort.Slice(timestamps, func(i int, j int) bool { return timestamps[i] < timestamps[j] })
+
+	last := make(Frame, len(queries))
+	frames := make([]Frame, 0, len(timestamps))
+
+	for _, timestamp := range timestamps {
+
+		for name, value := range values[timestamp] {
+			last[name] = value
+		}
+
+		if len(last) < len(queries) {
+			continue
+		}
+
+		frame := make(Frame, len(last))
+
+		for name, value := range last {
+			frame[name] = value
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames
+}
+
 /* Returns an array of points which represent the timeseries data for the specified query.
    NOTE: Doesn't handle more than one set of time series (Result[0]), Will expand to handle it later.
 */
@@ -263,5 +366,28 @@ func (collector *Scraper) getTimeSeriesData(query string, start float64, end flo
 		return []point{}
 	}
 	/* Need to check that return value is valid before returning. */
+	if len(apiResponse.Data.Result) == 0 {
+		log.Printf("No data returned for query: %s\n", query)
+		return []point{}
+	}
+
 	return apiResponse.Data.Result[0].Values
 }
+
+/*HistoryFrames Returns the frames of a set of queries (QueryInfo.Queries) over a time range straight away, without playing them back. */
+func (collector *Scraper) HistoryFrames(queryInfo QueryInfo) []Frame {
+	return collector.getFrames(queryInfo.Queries, queryInfo.Start-queryInfo.Offset, queryInfo.End-queryInfo.Offset, queryInfo.Step)
+}
+
+/*History Returns the values of a query over a time range straight away, without playing them back. Used to train models on past data. */
+func (collector *Scraper) History(queryInfo QueryInfo) []float64 {
+
+	data := collector.getTimeSeriesData(queryInfo.Query, queryInfo.Start-queryInfo.Offset, queryInfo.End-queryInfo.Offset, queryInfo.Step)
+	values := make([]float64, len(data))
+
+	for i, point := range data {
+		values[i] = point.Value
+	}
+
+	return values
+}
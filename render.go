@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/midioutput"
+	"github.com/ElectricNoodle/prometheus-midi-generator/processor"
+	"github.com/ElectricNoodle/prometheus-midi-generator/prometheus"
+	"github.com/ElectricNoodle/prometheus-midi-generator/smf"
+)
+
+/*renderOptions Command line options for rendering a time range offline instead of starting the GUI. */
+type renderOptions struct {
+	path          string
+	export        string
+	wav           string
+	soundFont     string
+	query         string
+	velocityQuery string
+	start         string
+	end           string
+	step          int
+	interval      int
+	tuning        string
+}
+
+/* Same format as the start/end times in the GUI. */
+const renderTimeLayout = "2006-01-02 15:04"
+
+/* Matches the default output rate of the scraper, so a render sounds like real-time playback. */
+const defaultRenderInterval = 600
+
+/* One week in seconds, how far back the anomaly baseline is taken from, the same as in the GUI. */
+const renderBaselineOffset = 7 * 24 * 60 * 60
+
+func parseRenderOptions() renderOptions {
+
+	var options renderOptions
+
+	flag.StringVar(&options.path, "render", "", "Render offline to this file instead of starting the GUI.")
+	flag.StringVar(&options.export, "export", "", "Also export the render as a Standard MIDI File.")
+	flag.StringVar(&options.wav, "wav", "", "Also play the render on the built-in synth and write it to this WAV file.")
+	flag.StringVar(&options.soundFont, "soundfont", "", "SF2 file the built-in synth plays the WAV render with, instead of the configured one.")
+	flag.StringVar(&options.query, "query", "", "Query to render, not needed when bindings are configured.")
+	flag.StringVar(&options.velocityQuery, "velocity-query", "", "Second metric to render alongside the query, for the Second Metric velocity mode, modulations and tempo.")
+	flag.StringVar(&options.start, "start", "", "Start of the time range to render ("+renderTimeLayout+").")
+	flag.StringVar(&options.end, "end", "", "End of the time range to render ("+renderTimeLayout+").")
+	flag.IntVar(&options.step, "step", 600, "Query step in seconds.")
+	flag.IntVar(&options.interval, "interval", defaultRenderInterval, "Milliseconds of music between samples, the scraper output rate.")
+	flag.StringVar(&options.tuning, "tuning", "", "Tuning to render with, 12-TET when left out.")
+	flag.Parse()
+
+	return options
+}
+
+/*rendering Whether any of the render outputs were asked for. */
+func (options renderOptions) rendering() bool {
+	return options.path != "" || options.export != "" || options.wav != ""
+}
+
+/*
+renderOffline Fetches the whole time range up front and plays it through the processor and emitter on a virtual clock,
+writing every MIDI message to a file with the time it would have been sent and/or playing it on the built-in synth to a
+WAV file. Nothing sleeps, so a week of data renders as fast as the CPU allows.
+*/
+func renderOffline(options renderOptions) {
+
+	start, err := time.Parse(renderTimeLayout, options.start)
+
+	if err != nil {
+		log.Fatalf("Invalid start time: %v\n", err)
+	}
+
+	end, err := time.Parse(renderTimeLayout, options.end)
+
+	if err != nil {
+		log.Fatalf("Invalid end time: %v\n", err)
+	}
+
+	var sinks midioutput.MultiSink
+	var fileSink *midioutput.FileSink
+	var synthSink *midioutput.SynthSink
+
+	if options.path != "" {
+
+		if fileSink, err = midioutput.NewFileSink(options.path); err != nil {
+			log.Fatalf("Failed to create %s: %v\n", options.path, err)
+		}
+
+		sinks = append(sinks, fileSink)
+	}
+
+	if options.wav != "" {
+
+		if options.soundFont != "" {
+			configuration.Sink.Synth.SoundFont = options.soundFont
+		}
+
+		if synthSink, err = midioutput.NewSynthSink(options.wav, midioutput.WAVFormat, configuration.Sink.Synth); err != nil {
+			log.Fatalf("Failed to create %s: %v\n", options.wav, err)
+		}
+
+		sinks = append(sinks, synthSink)
+	}
+
+	renderProcessor := processor.NewOfflineProcessor(log, configuration.ProcessorConfig)
+	renderEmitter := midioutput.NewOfflineMidi(log, sinks)
+	renderEmitter.ConfigureTuning(configuration.Tuning, tunings)
+
+	if options.tuning != "" {
+		renderEmitter.SetTuning(options.tuning)
+	}
+
+	if options.export != "" {
+		renderEmitter.StartRecording()
+	}
+
+	emit := func(at time.Duration, message midioutput.MIDIMessage) {
+		if fileSink != nil {
+			fileSink.SetTime(at)
+		}
+
+		if synthSink != nil {
+			synthSink.SetTime(at)
+		}
+
+		renderEmitter.Emit(at, message)
+	}
+
+	renderScraper := prometheus.NewScraper(log, configuration.PrometheusServer, prometheus.Playback)
+	queryInfo := prometheus.QueryInfo{Query: options.query, Start: float64(start.Unix()), End: float64(end.Unix()), Step: options.step}
+	interval := time.Duration(options.interval) * time.Millisecond
+	inputs := renderInputs(renderScraper, queryInfo, options.velocityQuery, renderProcessor.UsesBaseline())
+
+	if queries := renderProcessor.GetBindingQueries(); len(queries) > 0 {
+
+		queryInfo.Queries = queries
+		renderProcessor.RenderFrames(renderScraper.HistoryFrames(queryInfo), inputs, interval, emit)
+
+	} else {
+
+		if options.query == "" {
+			log.Fatal("A query is needed to render when no bindings are configured.\n")
+		}
+
+		renderProcessor.RenderValues(renderScraper.History(queryInfo), inputs, interval, emit)
+	}
+
+	if recording := renderEmitter.StopRecording(); recording != nil {
+		exportRender(recording, renderProcessor, queryInfo, options.export)
+	}
+
+	renderEmitter.Close()
+
+	if err := sinks.Close(); err != nil {
+		log.Fatalf("Failed to write the render: %v\n", err)
+	}
+
+	for _, path := range []string{options.path, options.wav} {
+		if path != "" {
+			log.Printf("Rendered to %s\n", path)
+		}
+	}
+}
+
+/*exportRender Writes the recording of a render to a Standard MIDI File, named after the query or the binding queries. */
+func exportRender(recording *midioutput.Recording, renderProcessor *processor.ProcInfo, queryInfo prometheus.QueryInfo, path string) {
+
+	title := queryInfo.Query
+
+	if len(queryInfo.Queries) > 0 {
+
+		names := make([]string, 0, len(queryInfo.Queries))
+
+		for name := range queryInfo.Queries {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		title = strings.Join(names, ", ")
+	}
+
+	song := recording.Song(title, renderProcessor.GetTempoMap(recording.Start), renderProcessor.GetKeySignature(), renderProcessor.GetTrackNames())
+
+	if err := smf.WriteFile(path, song); err != nil {
+		log.Fatalf("Failed to export %s: %v\n", path, err)
+	}
+
+	log.Printf("Exported to %s\n", path)
+}
+
+/*
+renderInputs Fetches the second metric and the baseline (the query a week earlier) over the same time range as the
+samples, the same series the GUI starts the velocity and baseline scrapers with. Frame i goes with sample i.
+*/
+func renderInputs(scraper *prometheus.Scraper, queryInfo prometheus.QueryInfo, velocityQuery string, usesBaseline bool) []prometheus.Frame {
+
+	var inputs []prometheus.Frame
+
+	add := func(name string, query string, offset float64) {
+
+		inputInfo := queryInfo
+		inputInfo.Queries = map[string]string{name: query}
+		inputInfo.Offset = offset
+
+		for i, frame := range scraper.HistoryFrames(inputInfo) {
+
+			if i == len(inputs) {
+				inputs = append(inputs, prometheus.Frame{})
+			}
+
+			inputs[i][name] = frame[name]
+		}
+	}
+
+	if velocityQuery != "" {
+		add(processor.VelocityInput, velocityQuery, 0)
+	}
+
+	if usesBaseline && queryInfo.Query != "" {
+		add(processor.BaselineInput, queryInfo.Query, renderBaselineOffset)
+	}
+
+	return inputs
+}
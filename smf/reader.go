@@ -0,0 +1,230 @@
+package smf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+/*File The contents of a Standard MIDI File that we use, the notes of every track merged in time order. */
+type File struct {
+	Format     uint16
+	Resolution uint16
+	Notes      []Note
+}
+
+/*ReadFile Reads the notes from a Standard MIDI File (format 0 or 1). */
+func ReadFile(path string) (*File, error) {
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Read(data)
+}
+
+/*Read Parses a Standard MIDI File. */
+func Read(data []byte) (*File, error) {
+
+	reader := bytes.NewReader(data)
+	chunkType, header, err := readChunk(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if chunkType != headerChunk || len(header) < 6 {
+		return nil, fmt.Errorf("not a Standard MIDI File")
+	}
+
+	file := &File{Format: binary.BigEndian.Uint16(header[0:2]), Resolution: binary.BigEndian.Uint16(header[4:6])}
+	tracks := binary.BigEndian.Uint16(header[2:4])
+
+	if file.Format > 1 {
+		return nil, fmt.Errorf("format %d files aren't supported", file.Format)
+	}
+
+	/* SMPTE based timing has the top bit set, ticks per beat is the only timing we support. */
+	if file.Resolution&0x8000 != 0 {
+		return nil, fmt.Errorf("SMPTE timing isn't supported")
+	}
+
+	for i := uint16(0); i < tracks; i++ {
+
+		chunkType, track, err := readChunk(reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		/* Unknown chunks are allowed by the spec and have to be skipped. */
+		if chunkType != trackChunk {
+			i--
+			continue
+		}
+
+		notes, err := readTrack(track)
+
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %v", i, err)
+		}
+
+		file.Notes = append(file.Notes, notes...)
+	}
+
+	sort.SliceStable(file.Notes, func(a int, b int) bool { return file.Notes[a].Tick < file.Notes[b].Tick })
+
+	return file, nil
+}
+
+func readChunk(reader *bytes.Reader) (string, []byte, error) {
+
+	header := make([]byte, 8)
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", nil, fmt.Errorf("unexpected end of file")
+	}
+
+	length := binary.BigEndian.Uint32(header[4:8])
+
+	if int64(length) > int64(reader.Len()) {
+		return "", nil, fmt.Errorf("chunk %s is longer than the file", header[0:4])
+	}
+
+	data := make([]byte, length)
+	_, err := reader.Read(data)
+
+	return string(header[0:4]), data, err
+}
+
+/*readVariableLength Reads a variable length quantity, 7 bits per byte with the top bit set on every byte but the last. */
+func readVariableLength(reader *bytes.Reader) (uint32, error) {
+
+	value := uint32(0)
+
+	for i := 0; i < 4; i++ {
+
+		b, err := reader.ReadByte()
+
+		if err != nil {
+			return 0, fmt.Errorf("unexpected end of track")
+		}
+
+		value = value<<7 | uint32(b&0x7F)
+
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+
+	return 0, fmt.Errorf("variable length value is too long")
+}
+
+/*readTrack Reads the notes of a track, pairing every note on with the note off that ends it. */
+func readTrack(track []byte) ([]Note, error) {
+
+	reader := bytes.NewReader(track)
+	tick := uint32(0)
+	status := byte(0)
+
+	var notes []Note
+	sounding := make(map[[2]uint8][]int)
+
+	for reader.Len() > 0 {
+
+		delta, err := readVariableLength(reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tick += delta
+		b, err := reader.ReadByte()
+
+		if err != nil {
+			return nil, err
+		}
+
+		/* Running status, the data bytes follow on from the last status byte. */
+		if b < 0x80 {
+
+			if status == 0 {
+				return nil, fmt.Errorf("data byte without a status byte")
+			}
+
+			reader.UnreadByte()
+			b = status
+		}
+
+		switch {
+
+		case b == metaStatus:
+			metaType, _ := reader.ReadByte()
+			length, err := readVariableLength(reader)
+
+			if err != nil {
+				return nil, err
+			}
+
+			reader.Seek(int64(length), 1)
+
+			if metaType == endOfTrack {
+				return notes, nil
+			}
+
+		case b == sysExStatus || b == sysExEscape:
+			length, err := readVariableLength(reader)
+
+			if err != nil {
+				return nil, err
+			}
+
+			reader.Seek(int64(length), 1)
+
+		default:
+			status = b
+			data := make([]byte, dataLength(b))
+
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return nil, fmt.Errorf("unexpected end of track")
+			}
+
+			channel := b & 0x0F
+			kind := b & 0xF0
+
+			if kind == noteOnStatus && data[1] > 0 {
+
+				sounding[[2]uint8{channel, data[0]}] = append(sounding[[2]uint8{channel, data[0]}], len(notes))
+				notes = append(notes, Note{Tick: tick, Channel: channel, Key: data[0], Velocity: data[1]})
+
+			} else if kind == noteOffStatus || kind == noteOnStatus {
+
+				/* A note on with a velocity of 0 is a note off. */
+				key := [2]uint8{channel, data[0]}
+
+				if started := sounding[key]; len(started) > 0 {
+					notes[started[0]].Duration = tick - notes[started[0]].Tick
+					sounding[key] = started[1:]
+				}
+			}
+		}
+	}
+
+	return notes, nil
+}
+
+/*dataLength Returns the number of data bytes that follow a channel message status byte. */
+func dataLength(status byte) int {
+
+	switch status & 0xF0 {
+	case 0xC0, 0xD0:
+		return 1
+	}
+
+	return 2
+}
@@ -0,0 +1,111 @@
+package smf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+/*chunk Builds a chunk from its type and data, the way they are laid out in a file. */
+func chunk(chunkType string, data ...byte) []byte {
+
+	header := make([]byte, 8)
+	copy(header[0:4], chunkType)
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(data)))
+
+	return append(header, data...)
+}
+
+/*header Builds the header chunk of a file with the given format, number of tracks and ticks per beat. */
+func header(format uint16, tracks uint16, resolution uint16) []byte {
+	return chunk(headerChunk, byte(format>>8), byte(format), byte(tracks>>8), byte(tracks), byte(resolution>>8), byte(resolution))
+}
+
+func file(chunks ...[]byte) []byte {
+	return bytes.Join(chunks, nil)
+}
+
+func TestReadNotes(t *testing.T) {
+
+	track := chunk(trackChunk,
+		/* A track name meta event, skipped. */
+		0x00, 0xFF, 0x03, 0x04, 'l', 'e', 'a', 'd',
+		0x00, 0x90, 60, 100,
+		/* Running status, a second note on without its status byte. */
+		0x00, 64, 90,
+		0x83, 0x60, 0x80, 60, 0,
+		/* A note on with a velocity of 0 ends the note. */
+		0x00, 0x90, 64, 0,
+		0x00, 0xF0, 0x03, 0x7E, 0x7F, 0xF7,
+		0x00, 0xC1, 5,
+		0x10, 0x91, 48, 127,
+		0x81, 0x00, 0x81, 48, 0,
+		0x00, 0xFF, endOfTrack, 0x00)
+
+	read, err := Read(file(header(0, 1, 480), track))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Note{
+		{Tick: 0, Channel: 0, Key: 60, Velocity: 100, Duration: 480},
+		{Tick: 0, Channel: 0, Key: 64, Velocity: 90, Duration: 480},
+		{Tick: 496, Channel: 1, Key: 48, Velocity: 127, Duration: 128},
+	}
+
+	if read.Format != 0 || read.Resolution != 480 {
+		t.Errorf("got format %d resolution %d, want format 0 resolution 480", read.Format, read.Resolution)
+	}
+
+	if !reflect.DeepEqual(read.Notes, expected) {
+		t.Errorf("got notes %+v, want %+v", read.Notes, expected)
+	}
+}
+
+func TestReadMergesTracks(t *testing.T) {
+
+	first := chunk(trackChunk, 0x81, 0x00, 0x90, 60, 100, 0x60, 0x80, 60, 0, 0x00, 0xFF, endOfTrack, 0x00)
+	second := chunk(trackChunk, 0x40, 0x92, 36, 80, 0x40, 0x82, 36, 0, 0x00, 0xFF, endOfTrack, 0x00)
+
+	/* Unknown chunks between the tracks are skipped. */
+	read, err := Read(file(header(1, 2, 96), first, chunk("XFIH", 1, 2, 3), second))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Note{
+		{Tick: 64, Channel: 2, Key: 36, Velocity: 80, Duration: 64},
+		{Tick: 128, Channel: 0, Key: 60, Velocity: 100, Duration: 96},
+	}
+
+	if !reflect.DeepEqual(read.Notes, expected) {
+		t.Errorf("got notes %+v, want %+v", read.Notes, expected)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a midi file", chunk("RIFF", 0, 0, 0, 0, 0, 0)},
+		{"format 2", header(2, 0, 480)},
+		{"smpte timing", header(0, 0, 0xE728)},
+		{"missing track", header(0, 1, 480)},
+		{"chunk too long", file(header(0, 1, 480), []byte{'M', 'T', 'r', 'k', 0, 0, 1, 0, 0x00})},
+		{"data without status", file(header(0, 1, 480), chunk(trackChunk, 0x00, 60, 100))},
+		{"truncated event", file(header(0, 1, 480), chunk(trackChunk, 0x00, 0x90, 60))},
+		{"variable length too long", file(header(0, 1, 480), chunk(trackChunk, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F))},
+	}
+
+	for _, test := range tests {
+		if _, err := Read(test.data); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
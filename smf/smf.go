@@ -0,0 +1,35 @@
+package smf
+
+/*Note A note read from a Standard MIDI File, times are in ticks from the start of the file. */
+type Note struct {
+	Tick     uint32
+	Channel  uint8
+	Key      uint8
+	Velocity uint8
+	Duration uint32
+}
+
+/* Chunk types of a Standard MIDI File. */
+const (
+	headerChunk = "MThd"
+	trackChunk  = "MTrk"
+)
+
+/* Status bytes of the events we care about. */
+const (
+	noteOffStatus = 0x80
+	noteOnStatus  = 0x90
+	metaStatus    = 0xFF
+	sysExStatus   = 0xF0
+	sysExEscape   = 0xF7
+)
+
+const endOfTrack = 0x2F
+const setTempo = 0x51
+
+/* Meta events written to exported files. */
+const (
+	trackName     = 0x03
+	timeSignature = 0x58
+	keySignature  = 0x59
+)
@@ -0,0 +1,269 @@
+package smf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+/* Ticks per beat of exported files, high enough that live timing isn't noticeably quantised. */
+const defaultResolution = 480
+
+/* Tempo of the file until the first tempo change, the same default as the Standard MIDI File spec. */
+const defaultBPM = 120
+
+/*Tempo A tempo change, Time is from the start of the song. */
+type Tempo struct {
+	Time time.Duration
+	BPM  float64
+}
+
+/*KeySignature Number of sharps (negative for flats) and whether the key is minor, as stored in the key signature meta event. */
+type KeySignature struct {
+	Sharps int8
+	Minor  bool
+}
+
+/*Event A MIDI message in a track, Time is from the start of the song. SysEx messages start with 0xF0. */
+type Event struct {
+	Time time.Duration
+	Data []byte
+}
+
+/*Track A named track of a song. */
+type Track struct {
+	Name   string
+	Events []Event
+}
+
+/*
+Song Everything written to a format 1 file. The tempo map, time signature and key signature go on a track of their own
+ahead of the others, times are converted into ticks using the tempo map.
+*/
+type Song struct {
+	Resolution  uint16
+	Tempos      []Tempo
+	Numerator   uint8
+	Denominator uint8
+	Key         KeySignature
+	Tracks      []Track
+}
+
+/*tempoSegment The tick a tempo change falls on, so times can be converted without walking the whole tempo map. */
+type tempoSegment struct {
+	time time.Duration
+	tick float64
+	bpm  float64
+}
+
+/*WriteFile Writes a song to a Standard MIDI File. */
+func WriteFile(path string, song Song) error {
+
+	file, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+
+	if err := Write(writer, song); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+/*Write Writes a song as a format 1 Standard MIDI File. */
+func Write(writer io.Writer, song Song) error {
+
+	if song.Resolution == 0 {
+		song.Resolution = defaultResolution
+	}
+
+	if song.Numerator == 0 || song.Denominator == 0 {
+		song.Numerator, song.Denominator = 4, 4
+	}
+
+	segments := song.tempoSegments()
+
+	header := make([]byte, 6)
+	binary.BigEndian.PutUint16(header[0:2], 1)
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(song.Tracks)+1))
+	binary.BigEndian.PutUint16(header[4:6], song.Resolution)
+
+	if err := writeChunk(writer, headerChunk, header); err != nil {
+		return err
+	}
+
+	if err := writeChunk(writer, trackChunk, song.conductorTrack(segments)); err != nil {
+		return err
+	}
+
+	for _, track := range song.Tracks {
+		if err := writeChunk(writer, trackChunk, song.track(track, segments)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*tempoSegments Sorts the tempo map and works out the tick each change falls on. */
+func (song *Song) tempoSegments() []tempoSegment {
+
+	tempos := append([]Tempo{}, song.Tempos...)
+	sort.SliceStable(tempos, func(a int, b int) bool { return tempos[a].Time < tempos[b].Time })
+
+	if len(tempos) == 0 || tempos[0].Time > 0 {
+		tempos = append([]Tempo{{Time: 0, BPM: defaultBPM}}, tempos...)
+	}
+
+	segments := make([]tempoSegment, len(tempos))
+
+	for i, tempo := range tempos {
+
+		segments[i] = tempoSegment{time: tempo.Time, bpm: tempo.BPM}
+
+		if i > 0 {
+			previous := segments[i-1]
+			segments[i].tick = previous.tick + (tempo.Time-previous.time).Minutes()*previous.bpm*float64(song.Resolution)
+		}
+	}
+
+	return segments
+}
+
+/*tick Converts a time from the start of the song into ticks. */
+func (song *Song) tick(at time.Duration, segments []tempoSegment) uint32 {
+
+	i := sort.Search(len(segments), func(i int) bool { return segments[i].time > at }) - 1
+
+	if i < 0 {
+		i = 0
+	}
+
+	segment := segments[i]
+	tick := segment.tick + (at-segment.time).Minutes()*segment.bpm*float64(song.Resolution)
+
+	return uint32(math.Round(math.Max(tick, 0)))
+}
+
+/*conductorTrack Builds the first track, which holds the time signature, key signature and every tempo change. */
+func (song *Song) conductorTrack(segments []tempoSegment) []byte {
+
+	var track bytes.Buffer
+	last := uint32(0)
+
+	denominator := uint8(0)
+
+	for 1<<denominator < song.Denominator {
+		denominator++
+	}
+
+	minor := uint8(0)
+
+	if song.Key.Minor {
+		minor = 1
+	}
+
+	writeEvent(&track, &last, 0, []byte{metaStatus, timeSignature, 4, song.Numerator, denominator, 24, 8})
+	writeEvent(&track, &last, 0, []byte{metaStatus, keySignature, 2, uint8(song.Key.Sharps), minor})
+
+	for _, segment := range segments {
+
+		microseconds := uint32(math.Round(60000000 / segment.bpm))
+		tick := uint32(math.Round(segment.tick))
+
+		writeEvent(&track, &last, tick, []byte{metaStatus, setTempo, 3, uint8(microseconds >> 16), uint8(microseconds >> 8), uint8(microseconds)})
+	}
+
+	writeEvent(&track, &last, last, []byte{metaStatus, endOfTrack, 0})
+
+	return track.Bytes()
+}
+
+/*track Builds a track from its name and events, SysEx messages are stored with their length in place of the 0xF0. */
+func (song *Song) track(in Track, segments []tempoSegment) []byte {
+
+	var track bytes.Buffer
+	last := uint32(0)
+
+	events := append([]Event{}, in.Events...)
+	sort.SliceStable(events, func(a int, b int) bool { return events[a].Time < events[b].Time })
+
+	if in.Name != "" {
+		name := append([]byte{metaStatus, trackName}, variableLength(uint32(len(in.Name)))...)
+		writeEvent(&track, &last, 0, append(name, in.Name...))
+	}
+
+	for _, event := range events {
+
+		if len(event.Data) == 0 {
+			continue
+		}
+
+		data := event.Data
+
+		if data[0] == sysExStatus {
+			data = append(append([]byte{sysExStatus}, variableLength(uint32(len(data)-1))...), data[1:]...)
+		}
+
+		writeEvent(&track, &last, song.tick(event.Time, segments), data)
+	}
+
+	writeEvent(&track, &last, last, []byte{metaStatus, endOfTrack, 0})
+
+	return track.Bytes()
+}
+
+/*writeEvent Writes an event with the delta from the last one. Events are never written with running status. */
+func writeEvent(track *bytes.Buffer, last *uint32, tick uint32, data []byte) {
+
+	if tick < *last {
+		tick = *last
+	}
+
+	track.Write(variableLength(tick - *last))
+	track.Write(data)
+
+	*last = tick
+}
+
+func writeChunk(writer io.Writer, chunkType string, data []byte) error {
+
+	header := make([]byte, 8)
+	copy(header[0:4], chunkType)
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(data)))
+
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+
+	_, err := writer.Write(data)
+
+	return err
+}
+
+/*variableLength Encodes a variable length quantity, the opposite of readVariableLength. */
+func variableLength(value uint32) []byte {
+
+	encoded := []byte{byte(value & 0x7F)}
+
+	for value >>= 7; value > 0; value >>= 7 {
+		encoded = append([]byte{byte(value&0x7F) | 0x80}, encoded...)
+	}
+
+	return encoded
+}
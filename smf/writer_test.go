@@ -0,0 +1,125 @@
+package smf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVariableLength(t *testing.T) {
+
+	tests := []struct {
+		value   uint32
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{0x40, []byte{0x40}},
+		{0x7F, []byte{0x7F}},
+		{0x80, []byte{0x81, 0x00}},
+		{0x2000, []byte{0xC0, 0x00}},
+		{0x3FFF, []byte{0xFF, 0x7F}},
+		{0x100000, []byte{0xC0, 0x80, 0x00}},
+		{0x0FFFFFFF, []byte{0xFF, 0xFF, 0xFF, 0x7F}},
+	}
+
+	for _, test := range tests {
+
+		encoded := variableLength(test.value)
+
+		if !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("variableLength(%#x) = % x, want % x", test.value, encoded, test.encoded)
+		}
+
+		decoded, err := readVariableLength(bytes.NewReader(encoded))
+
+		if err != nil || decoded != test.value {
+			t.Errorf("readVariableLength(% x) = %#x, %v, want %#x", encoded, decoded, err, test.value)
+		}
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+
+	song := Song{Key: KeySignature{Sharps: -3, Minor: true}, Tracks: []Track{
+		{Name: "Melody", Events: []Event{
+			/* Out of order events are sorted before they're written. */
+			{Time: 500 * time.Millisecond, Data: []byte{0x80, 60, 0}},
+			{Time: 0, Data: []byte{0x90, 60, 100}},
+			{Time: time.Second, Data: []byte{0x90, 62, 90}},
+			{Time: 1500 * time.Millisecond, Data: []byte{0x90, 62, 0}},
+		}},
+		{Name: "Chords", Events: []Event{
+			{Time: 0, Data: []byte{0xF0, 0x7F, 0x7F, 0x08, 0x02, 0xF7}},
+			{Time: 250 * time.Millisecond, Data: []byte{0x91, 48, 80}},
+			{Time: 2 * time.Second, Data: []byte{0x81, 48, 0}},
+		}},
+	}}
+
+	var buffer bytes.Buffer
+
+	if err := Write(&buffer, song); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(buffer.Bytes())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* 120 BPM at 480 ticks per beat is 960 ticks a second. */
+	expected := []Note{
+		{Tick: 0, Channel: 0, Key: 60, Velocity: 100, Duration: 480},
+		{Tick: 240, Channel: 1, Key: 48, Velocity: 80, Duration: 1680},
+		{Tick: 960, Channel: 0, Key: 62, Velocity: 90, Duration: 480},
+	}
+
+	if read.Format != 1 || read.Resolution != defaultResolution {
+		t.Errorf("got format %d resolution %d, want format 1 resolution %d", read.Format, read.Resolution, defaultResolution)
+	}
+
+	if !reflect.DeepEqual(read.Notes, expected) {
+		t.Errorf("got notes %+v, want %+v", read.Notes, expected)
+	}
+}
+
+func TestWriteFollowsTempoMap(t *testing.T) {
+
+	song := Song{Resolution: 100, Tempos: []Tempo{{Time: time.Second, BPM: 60}, {Time: 3 * time.Second, BPM: 240}},
+		Tracks: []Track{{Events: []Event{
+			{Time: 500 * time.Millisecond, Data: []byte{0x90, 60, 100}},
+			{Time: 2 * time.Second, Data: []byte{0x80, 60, 0}},
+			{Time: 4 * time.Second, Data: []byte{0x90, 64, 100}},
+			{Time: 4250 * time.Millisecond, Data: []byte{0x80, 64, 0}},
+		}}}}
+
+	var buffer bytes.Buffer
+
+	if err := Write(&buffer, song); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(buffer.Bytes())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* 120 BPM for the first second (200 ticks), 60 BPM for two seconds (200 ticks) and 240 BPM after that. */
+	expected := []Note{
+		{Tick: 100, Channel: 0, Key: 60, Velocity: 100, Duration: 200},
+		{Tick: 800, Channel: 0, Key: 64, Velocity: 100, Duration: 100},
+	}
+
+	if !reflect.DeepEqual(read.Notes, expected) {
+		t.Errorf("got notes %+v, want %+v", read.Notes, expected)
+	}
+
+	conductor := song.conductorTrack(song.tempoSegments())
+
+	/* 60 BPM is a second per beat, written at tick 200 (a delta of 200). */
+	if !bytes.Contains(conductor, []byte{0x81, 0x48, metaStatus, setTempo, 3, 0x0F, 0x42, 0x40}) {
+		t.Errorf("conductor track % x is missing the 60 BPM tempo change", conductor)
+	}
+}
@@ -0,0 +1,429 @@
+package soundfont
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+/* Generators we play, the rest (filters, modulation, pan and so on) are ignored. */
+const (
+	startOffset           = 0
+	endOffset             = 1
+	loopStartOffset       = 2
+	loopEndOffset         = 3
+	startCoarseOffset     = 4
+	endCoarseOffset       = 12
+	attackVolumeEnvelope  = 34
+	decayVolumeEnvelope   = 36
+	sustainVolumeEnvelope = 37
+	releaseVolumeEnvelope = 38
+	instrumentGenerator   = 41
+	keyRange              = 43
+	velocityRange         = 44
+	loopStartCoarseOffset = 45
+	initialAttenuation    = 48
+	loopEndCoarseOffset   = 50
+	coarseTune            = 51
+	fineTune              = 52
+	sampleIDGenerator     = 53
+	sampleModes           = 54
+	scaleTuning           = 56
+	exclusiveClass        = 57
+	overridingRootKey     = 58
+	coarseOffsetScale     = 32768
+	fullRange             = 127 << 8
+	defaultTimecents      = -12000
+	defaultScaleTuning    = 100
+	maxAttenuation        = 1440
+	loopContinuously      = 1
+	loopUntilRelease      = 3
+	defaultRootKey        = 60
+	presetHeaderSize      = 38
+	instrumentHeaderSize  = 22
+	sampleHeaderSize      = 46
+	bagSize               = 4
+	generatorSize         = 4
+)
+
+/* Generators a preset zone adds to the instrument zones it plays, instead of being ignored. */
+var presetAdditive = []uint16{attackVolumeEnvelope, decayVolumeEnvelope, sustainVolumeEnvelope, releaseVolumeEnvelope,
+	initialAttenuation, coarseTune, fineTune}
+
+type presetHeader struct {
+	name    string
+	program uint16
+	bank    uint16
+	bag     uint16
+}
+
+type instrumentHeader struct {
+	name string
+	bag  uint16
+}
+
+type sampleHeader struct {
+	start      uint32
+	end        uint32
+	loopStart  uint32
+	loopEnd    uint32
+	rate       uint32
+	rootKey    uint8
+	correction int8
+}
+
+type generator struct {
+	operator uint16
+	amount   uint16
+}
+
+/*zone The generators of a zone by operator, with their raw amounts. */
+type zone map[uint16]uint16
+
+/*hydra The articulation records of an SF2 file, each list ends with a terminal record. */
+type hydra struct {
+	presets              []presetHeader
+	presetBags           []uint16
+	presetGenerators     []generator
+	instruments          []instrumentHeader
+	instrumentBags       []uint16
+	instrumentGenerators []generator
+	samples              []sampleHeader
+}
+
+func readName(data []byte) string {
+	return strings.TrimRight(string(data[:20]), "\x00")
+}
+
+func readPresetHeaders(data []byte) []presetHeader {
+
+	headers := make([]presetHeader, len(data)/presetHeaderSize)
+
+	for i := range headers {
+		record := data[i*presetHeaderSize:]
+		headers[i] = presetHeader{name: readName(record), program: binary.LittleEndian.Uint16(record[20:]),
+			bank: binary.LittleEndian.Uint16(record[22:]), bag: binary.LittleEndian.Uint16(record[24:])}
+	}
+
+	return headers
+}
+
+func readInstrumentHeaders(data []byte) []instrumentHeader {
+
+	headers := make([]instrumentHeader, len(data)/instrumentHeaderSize)
+
+	for i := range headers {
+		record := data[i*instrumentHeaderSize:]
+		headers[i] = instrumentHeader{name: readName(record), bag: binary.LittleEndian.Uint16(record[20:])}
+	}
+
+	return headers
+}
+
+func readSampleHeaders(data []byte) []sampleHeader {
+
+	headers := make([]sampleHeader, len(data)/sampleHeaderSize)
+
+	for i := range headers {
+		record := data[i*sampleHeaderSize:]
+		headers[i] = sampleHeader{start: binary.LittleEndian.Uint32(record[20:]), end: binary.LittleEndian.Uint32(record[24:]),
+			loopStart: binary.LittleEndian.Uint32(record[28:]), loopEnd: binary.LittleEndian.Uint32(record[32:]),
+			rate: binary.LittleEndian.Uint32(record[36:]), rootKey: record[40], correction: int8(record[41])}
+	}
+
+	return headers
+}
+
+/*readBags Returns the index of the first generator of each zone, the modulator indexes aren't needed. */
+func readBags(data []byte) []uint16 {
+
+	bags := make([]uint16, len(data)/bagSize)
+
+	for i := range bags {
+		bags[i] = binary.LittleEndian.Uint16(data[i*bagSize:])
+	}
+
+	return bags
+}
+
+func readGenerators(data []byte) []generator {
+
+	generators := make([]generator, len(data)/generatorSize)
+
+	for i := range generators {
+		record := data[i*generatorSize:]
+		generators[i] = generator{operator: binary.LittleEndian.Uint16(record), amount: binary.LittleEndian.Uint16(record[2:])}
+	}
+
+	return generators
+}
+
+/*
+zones Returns the zones from bag first up to bag last. The first zone is the global zone when it doesn't end in the
+terminal generator (the instrument or sample), its generators apply to every other zone that doesn't set them.
+*/
+func zones(bags []uint16, generators []generator, first uint16, last uint16, terminal uint16) (zone, []zone, error) {
+
+	if int(last) >= len(bags) || first > last {
+		return nil, nil, fmt.Errorf("zone index %d out of range", last)
+	}
+
+	var global zone
+	var local []zone
+
+	for i := first; i < last; i++ {
+
+		start, end := bags[i], bags[i+1]
+
+		if int(end) > len(generators) || start > end {
+			return nil, nil, fmt.Errorf("generator index %d out of range", end)
+		}
+
+		z := make(zone)
+
+		for _, gen := range generators[start:end] {
+			z[gen.operator] = gen.amount
+		}
+
+		if _, ok := z[terminal]; ok {
+			local = append(local, z)
+		} else if i == first {
+			global = z
+		}
+	}
+
+	return global, local, nil
+}
+
+/*merge Returns the zone with the generators of the global zone it doesn't set itself. */
+func (z zone) merge(global zone) zone {
+
+	merged := make(zone)
+
+	for operator, amount := range global {
+		merged[operator] = amount
+	}
+
+	for operator, amount := range z {
+		merged[operator] = amount
+	}
+
+	return merged
+}
+
+/*value Returns the signed amount of a generator, or its default when the zone doesn't set it. */
+func (z zone) value(operator uint16) int {
+
+	if amount, ok := z[operator]; ok {
+		return int(int16(amount))
+	}
+
+	switch operator {
+	case attackVolumeEnvelope, decayVolumeEnvelope, releaseVolumeEnvelope:
+		return defaultTimecents
+	case scaleTuning:
+		return defaultScaleTuning
+	case overridingRootKey:
+		return -1
+	}
+
+	return 0
+}
+
+/*span Returns the low and high values of a range generator, the full range when the zone doesn't set it. */
+func (z zone) span(operator uint16) (uint8, uint8) {
+
+	amount, ok := z[operator]
+
+	if !ok {
+		amount = fullRange
+	}
+
+	return uint8(amount & 0xFF), uint8(amount >> 8)
+}
+
+/*build Merges the zones of every preset with the zones of the instruments they play into regions. */
+func (hydra hydra) build(sampleCount int) ([]Preset, error) {
+
+	var presets []Preset
+
+	/* The last header of each list only marks where the zones of the one before end. */
+	for p := 0; p+1 < len(hydra.presets); p++ {
+
+		header := hydra.presets[p]
+		preset := Preset{Name: header.name, Bank: int(header.bank), Program: int(header.program)}
+		presetGlobal, presetZones, err := zones(hydra.presetBags, hydra.presetGenerators, header.bag, hydra.presets[p+1].bag, instrumentGenerator)
+
+		if err != nil {
+			return nil, fmt.Errorf("preset %q: %v", header.name, err)
+		}
+
+		for _, presetZone := range presetZones {
+
+			presetZone = presetZone.merge(presetGlobal)
+			regions, err := hydra.instrumentRegions(presetZone, sampleCount)
+
+			if err != nil {
+				return nil, fmt.Errorf("preset %q: %v", header.name, err)
+			}
+
+			preset.Regions = append(preset.Regions, regions...)
+		}
+
+		presets = append(presets, preset)
+	}
+
+	return presets, nil
+}
+
+/*instrumentRegions Returns the regions of the instrument a preset zone plays, within the key and velocity ranges of the zone. */
+func (hydra hydra) instrumentRegions(presetZone zone, sampleCount int) ([]Region, error) {
+
+	index := int(presetZone[instrumentGenerator])
+
+	if index+1 >= len(hydra.instruments) {
+		return nil, fmt.Errorf("instrument %d out of range", index)
+	}
+
+	header := hydra.instruments[index]
+	global, local, err := zones(hydra.instrumentBags, hydra.instrumentGenerators, header.bag, hydra.instruments[index+1].bag, sampleIDGenerator)
+
+	if err != nil {
+		return nil, fmt.Errorf("instrument %q: %v", header.name, err)
+	}
+
+	var regions []Region
+
+	for _, instrumentZone := range local {
+
+		instrumentZone = instrumentZone.merge(global)
+
+		for _, operator := range presetAdditive {
+			if _, ok := presetZone[operator]; ok {
+				instrumentZone[operator] = uint16(int16(instrumentZone.value(operator) + presetZone.value(operator)))
+			}
+		}
+
+		region, ok, err := hydra.region(instrumentZone, presetZone, sampleCount)
+
+		if err != nil {
+			return nil, fmt.Errorf("instrument %q: %v", header.name, err)
+		}
+
+		if ok {
+			regions = append(regions, region)
+		}
+	}
+
+	return regions, nil
+}
+
+/*region Turns the generators of an instrument zone into a region, ok is false when the preset zone leaves nothing of its ranges. */
+func (hydra hydra) region(z zone, presetZone zone, sampleCount int) (Region, bool, error) {
+
+	index := int(z[sampleIDGenerator])
+
+	if index+1 >= len(hydra.samples) {
+		return Region{}, false, fmt.Errorf("sample %d out of range", index)
+	}
+
+	sample := hydra.samples[index]
+	region := Region{SampleRate: sample.rate, ScaleTuning: float64(z.value(scaleTuning)), ExclusiveClass: z.value(exclusiveClass)}
+
+	/* Ranges of the preset zone narrow the ranges of the instrument zone. */
+	keyLow, keyHigh := z.span(keyRange)
+	presetKeyLow, presetKeyHigh := presetZone.span(keyRange)
+	velocityLow, velocityHigh := z.span(velocityRange)
+	presetVelocityLow, presetVelocityHigh := presetZone.span(velocityRange)
+
+	region.KeyLow, region.KeyHigh = maxByte(keyLow, presetKeyLow), minByte(keyHigh, presetKeyHigh)
+	region.VelocityLow, region.VelocityHigh = maxByte(velocityLow, presetVelocityLow), minByte(velocityHigh, presetVelocityHigh)
+
+	if region.KeyLow > region.KeyHigh || region.VelocityLow > region.VelocityHigh {
+		return Region{}, false, nil
+	}
+
+	offset := func(base uint32, fine uint16, coarse uint16) uint32 {
+		return uint32(int64(base) + int64(z.value(fine)) + int64(z.value(coarse))*coarseOffsetScale)
+	}
+
+	region.Start = offset(sample.start, startOffset, startCoarseOffset)
+	region.End = offset(sample.end, endOffset, endCoarseOffset)
+	region.LoopStart = offset(sample.loopStart, loopStartOffset, loopStartCoarseOffset)
+	region.LoopEnd = offset(sample.loopEnd, loopEndOffset, loopEndCoarseOffset)
+
+	if region.Start >= region.End || int(region.End) > sampleCount {
+		return Region{}, false, fmt.Errorf("sample %d runs past the sample data", index)
+	}
+
+	switch z.value(sampleModes) & 3 {
+	case loopContinuously:
+		region.Loop = true
+	case loopUntilRelease:
+		region.Loop = true
+		region.LoopUntilRelease = true
+	}
+
+	/* A loop outside the sample would read past it, play the sample through once instead. */
+	if region.LoopStart < region.Start || region.LoopEnd > region.End || region.LoopStart >= region.LoopEnd {
+		region.Loop = false
+		region.LoopUntilRelease = false
+	}
+
+	region.RootKey = z.value(overridingRootKey)
+
+	if region.RootKey < 0 {
+		region.RootKey = int(sample.rootKey)
+	}
+
+	if region.RootKey > 127 {
+		region.RootKey = defaultRootKey
+	}
+
+	region.Tune = float64(z.value(coarseTune)*100 + z.value(fineTune) + int(sample.correction))
+	region.Gain = centibels(z.value(initialAttenuation))
+	region.Attack = timecents(z.value(attackVolumeEnvelope))
+	region.Decay = timecents(z.value(decayVolumeEnvelope))
+	region.Sustain = centibels(z.value(sustainVolumeEnvelope))
+	region.Release = timecents(z.value(releaseVolumeEnvelope))
+
+	return region, true, nil
+}
+
+/*timecents Converts a time in timecents to seconds. */
+func timecents(value int) float64 {
+	return math.Pow(2, float64(value)/1200)
+}
+
+/*centibels Converts an attenuation in centibels to a level, anything past 144dB is silence. */
+func centibels(value int) float64 {
+
+	if value >= maxAttenuation {
+		return 0
+	}
+
+	if value < 0 {
+		value = 0
+	}
+
+	return math.Pow(10, -float64(value)/200)
+}
+
+func maxByte(a uint8, b uint8) uint8 {
+
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+func minByte(a uint8, b uint8) uint8 {
+
+	if a < b {
+		return a
+	}
+
+	return b
+}
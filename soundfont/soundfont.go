@@ -0,0 +1,196 @@
+package soundfont
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+)
+
+/*
+SoundFont The samples and presets of an SF2 file. The zones of every preset and the instruments they play are merged
+into regions up front, so starting a note is a lookup.
+*/
+type SoundFont struct {
+	Samples []int16
+	Presets []Preset
+}
+
+/*Preset An instrument that can be picked with a bank and program change. */
+type Preset struct {
+	Name    string
+	Bank    int
+	Program int
+	Regions []Region
+}
+
+/*
+Region A sample played over a range of keys and velocities, with everything needed to play it. Sample positions index
+the samples of the SoundFont, times are in seconds and levels between 0 and 1.
+*/
+type Region struct {
+	KeyLow           uint8
+	KeyHigh          uint8
+	VelocityLow      uint8
+	VelocityHigh     uint8
+	Start            uint32
+	End              uint32
+	LoopStart        uint32
+	LoopEnd          uint32
+	SampleRate       uint32
+	RootKey          int
+	Tune             float64
+	ScaleTuning      float64
+	Loop             bool
+	LoopUntilRelease bool
+	Gain             float64
+	Attack           float64
+	Decay            float64
+	Sustain          float64
+	Release          float64
+	ExclusiveClass   int
+}
+
+/* Bank holding the drum kits, channel 10 plays from it. */
+const PercussionBank = 128
+
+/*ReadFile Reads a SoundFont from an SF2 file. */
+func ReadFile(path string) (*SoundFont, error) {
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Read(data)
+}
+
+/*Read Parses an SF2 file, only the 16-bit samples are used. */
+func Read(data []byte) (*SoundFont, error) {
+
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "sfbk" {
+		return nil, fmt.Errorf("not a SoundFont")
+	}
+
+	chunks := make(map[string][]byte)
+
+	if err := readChunks(data[12:], chunks); err != nil {
+		return nil, err
+	}
+
+	for _, id := range []string{"smpl", "phdr", "pbag", "pgen", "inst", "ibag", "igen", "shdr"} {
+		if _, ok := chunks[id]; !ok {
+			return nil, fmt.Errorf("the %s chunk is missing", id)
+		}
+	}
+
+	font := &SoundFont{Samples: make([]int16, len(chunks["smpl"])/2)}
+
+	for i := range font.Samples {
+		font.Samples[i] = int16(binary.LittleEndian.Uint16(chunks["smpl"][i*2:]))
+	}
+
+	hydra := hydra{
+		presets:              readPresetHeaders(chunks["phdr"]),
+		presetBags:           readBags(chunks["pbag"]),
+		presetGenerators:     readGenerators(chunks["pgen"]),
+		instruments:          readInstrumentHeaders(chunks["inst"]),
+		instrumentBags:       readBags(chunks["ibag"]),
+		instrumentGenerators: readGenerators(chunks["igen"]),
+		samples:              readSampleHeaders(chunks["shdr"]),
+	}
+
+	presets, err := hydra.build(len(font.Samples))
+
+	if err != nil {
+		return nil, err
+	}
+
+	font.Presets = presets
+
+	return font, nil
+}
+
+/*readChunks Collects the chunks of a RIFF body by id, descending into LIST chunks. */
+func readChunks(data []byte, chunks map[string][]byte) error {
+
+	for len(data) >= 8 {
+
+		id := string(data[0:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+
+		if size > len(data) {
+			return fmt.Errorf("the %s chunk is truncated", id)
+		}
+
+		if id == "LIST" {
+
+			if size < 4 {
+				return fmt.Errorf("empty LIST chunk")
+			}
+
+			if err := readChunks(data[4:size], chunks); err != nil {
+				return err
+			}
+
+		} else {
+			chunks[id] = data[:size]
+		}
+
+		/* Chunks are padded to an even size. */
+		size += size & 1
+
+		if size > len(data) {
+			size = len(data)
+		}
+
+		data = data[size:]
+	}
+
+	return nil
+}
+
+/*
+Regions Returns the regions of a preset that play a key at a velocity. A preset missing from the SoundFont falls back
+to bank 0, or the standard drum kit for the percussion bank, like a General MIDI synth does.
+*/
+func (font *SoundFont) Regions(bank int, program int, key uint8, velocity uint8) []*Region {
+
+	preset := font.Preset(bank, program)
+
+	if preset == nil && bank == PercussionBank {
+		preset = font.Preset(PercussionBank, 0)
+	} else if preset == nil {
+		preset = font.Preset(0, program)
+	}
+
+	if preset == nil {
+		return nil
+	}
+
+	var regions []*Region
+
+	for i := range preset.Regions {
+
+		region := &preset.Regions[i]
+
+		if key >= region.KeyLow && key <= region.KeyHigh && velocity >= region.VelocityLow && velocity <= region.VelocityHigh {
+			regions = append(regions, region)
+		}
+	}
+
+	return regions
+}
+
+/*Preset Returns the preset for a bank and program, nil when the SoundFont doesn't have it. */
+func (font *SoundFont) Preset(bank int, program int) *Preset {
+
+	for i := range font.Presets {
+		if font.Presets[i].Bank == bank && font.Presets[i].Program == program {
+			return &font.Presets[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package soundfont
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+/*testPreset A preset to build into a test SoundFont, each zone is a list of generators. */
+type testPreset struct {
+	name    string
+	bank    uint16
+	program uint16
+	zones   [][]generator
+}
+
+/*testInstrument An instrument to build into a test SoundFont. */
+type testInstrument struct {
+	name  string
+	zones [][]generator
+}
+
+/*testFont Everything that goes into a test SoundFont, the terminal records are added when it is built. */
+type testFont struct {
+	samples     []int16
+	presets     []testPreset
+	instruments []testInstrument
+	headers     []sampleHeader
+}
+
+func riffChunk(id string, body []byte) []byte {
+
+	var chunk bytes.Buffer
+
+	chunk.WriteString(id)
+	binary.Write(&chunk, binary.LittleEndian, uint32(len(body)))
+	chunk.Write(body)
+
+	if len(body)%2 == 1 {
+		chunk.WriteByte(0)
+	}
+
+	return chunk.Bytes()
+}
+
+func listChunk(kind string, chunks ...[]byte) []byte {
+	return riffChunk("LIST", append([]byte(kind), bytes.Join(chunks, nil)...))
+}
+
+/*littleEndian Encodes the values one after the other, the way the records of an SF2 file are laid out. */
+func littleEndian(values ...interface{}) []byte {
+
+	var data bytes.Buffer
+
+	for _, value := range values {
+		binary.Write(&data, binary.LittleEndian, value)
+	}
+
+	return data.Bytes()
+}
+
+func name(value string) []byte {
+
+	padded := make([]byte, 20)
+	copy(padded, value)
+
+	return padded
+}
+
+/*amount Returns the raw amount of a signed generator value. */
+func amount(value int) uint16 {
+	return uint16(int16(value))
+}
+
+/*span Returns the raw amount of a key or velocity range. */
+func span(low uint8, high uint8) uint16 {
+	return uint16(high)<<8 | uint16(low)
+}
+
+/*zoneRecords Builds the bag and generator records of a list of zones, with the terminal bag and generator. */
+func zoneRecords(zones [][]generator) ([]byte, []byte) {
+
+	var bags, generators []byte
+	count := 0
+
+	for _, z := range zones {
+
+		bags = append(bags, littleEndian(uint16(count), uint16(0))...)
+
+		for _, gen := range z {
+			generators = append(generators, littleEndian(gen.operator, gen.amount)...)
+			count++
+		}
+	}
+
+	bags = append(bags, littleEndian(uint16(count), uint16(0))...)
+	generators = append(generators, littleEndian(uint16(0), uint16(0))...)
+
+	return bags, generators
+}
+
+/*build Returns the font as the bytes of an SF2 file. */
+func (font testFont) build() []byte {
+
+	var presetZones, instrumentZones [][]generator
+	var phdr, inst, shdr []byte
+
+	for _, preset := range font.presets {
+		phdr = append(phdr, name(preset.name)...)
+		phdr = append(phdr, littleEndian(preset.program, preset.bank, uint16(len(presetZones)), uint32(0), uint32(0), uint32(0))...)
+		presetZones = append(presetZones, preset.zones...)
+	}
+
+	phdr = append(phdr, name("EOP")...)
+	phdr = append(phdr, littleEndian(uint16(0), uint16(0), uint16(len(presetZones)), uint32(0), uint32(0), uint32(0))...)
+
+	for _, instrument := range font.instruments {
+		inst = append(inst, name(instrument.name)...)
+		inst = append(inst, littleEndian(uint16(len(instrumentZones)))...)
+		instrumentZones = append(instrumentZones, instrument.zones...)
+	}
+
+	inst = append(inst, name("EOI")...)
+	inst = append(inst, littleEndian(uint16(len(instrumentZones)))...)
+
+	for _, header := range append(font.headers, sampleHeader{}) {
+		shdr = append(shdr, name("sample")...)
+		shdr = append(shdr, littleEndian(header.start, header.end, header.loopStart, header.loopEnd, header.rate, header.rootKey,
+			header.correction, uint16(0), uint16(1))...)
+	}
+
+	pbag, pgen := zoneRecords(presetZones)
+	ibag, igen := zoneRecords(instrumentZones)
+
+	body := append([]byte("sfbk"), listChunk("INFO", riffChunk("ifil", littleEndian(uint16(2), uint16(1))))...)
+	body = append(body, listChunk("sdta", riffChunk("smpl", littleEndian(font.samples)))...)
+	body = append(body, listChunk("pdta", riffChunk("phdr", phdr), riffChunk("pbag", pbag), riffChunk("pmod", make([]byte, 10)),
+		riffChunk("pgen", pgen), riffChunk("inst", inst), riffChunk("ibag", ibag), riffChunk("imod", make([]byte, 10)),
+		riffChunk("igen", igen), riffChunk("shdr", shdr))...)
+
+	return riffChunk("RIFF", body)
+}
+
+/*newTestFont Returns a font with a piano split over two samples and a drum kit, see TestRead for what it plays. */
+func newTestFont() testFont {
+
+	samples := make([]int16, 300)
+
+	for i := range samples {
+		samples[i] = int16(i * 100)
+	}
+
+	return testFont{
+		samples: samples,
+		presets: []testPreset{
+			{name: "Piano", bank: 0, program: 0, zones: [][]generator{
+				/* The global zone adds to the attack of every instrument zone. */
+				{{attackVolumeEnvelope, amount(-1200)}},
+				{{keyRange, span(0, 63)}, {instrumentGenerator, 0}},
+				/* Narrows the instrument to nothing, so it adds no regions. */
+				{{keyRange, span(100, 110)}, {velocityRange, span(101, 127)}, {instrumentGenerator, 0}},
+			}},
+			{name: "Standard", bank: PercussionBank, program: 0, zones: [][]generator{
+				{{instrumentGenerator, 1}},
+			}},
+		},
+		instruments: []testInstrument{
+			{name: "Piano", zones: [][]generator{
+				{{releaseVolumeEnvelope, amount(-1200)}, {sampleModes, loopContinuously}},
+				{{keyRange, span(0, 59)}, {overridingRootKey, 48}, {sampleIDGenerator, 0}},
+				{{keyRange, span(60, 127)}, {velocityRange, span(0, 100)}, {fineTune, 10}, {initialAttenuation, 200},
+					{sampleIDGenerator, 1}},
+			}},
+			{name: "Kick", zones: [][]generator{
+				{{keyRange, span(36, 36)}, {startOffset, 5}, {coarseTune, 2}, {sampleModes, loopUntilRelease},
+					{exclusiveClass, 1}, {sampleIDGenerator, 0}},
+			}},
+		},
+		headers: []sampleHeader{
+			{start: 0, end: 100, loopStart: 10, loopEnd: 90, rate: 22050, rootKey: 60, correction: -5},
+			/* The loop runs past the end of the sample, so it is played through once. */
+			{start: 100, end: 200, loopStart: 150, loopEnd: 250, rate: 44100, rootKey: 72},
+		},
+	}
+}
+
+func TestRead(t *testing.T) {
+
+	font, err := Read(newTestFont().build())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(font.Samples) != 300 || font.Samples[0] != 0 || font.Samples[299] != 29900 {
+		t.Errorf("got %d samples, want the 300 written", len(font.Samples))
+	}
+
+	/* Default envelope times are -12000 timecents, the preset attack of -1200 is added to them. */
+	attack := math.Pow(2, -11)
+	instant := math.Pow(2, -10)
+
+	expected := []Preset{
+		{Name: "Piano", Bank: 0, Program: 0, Regions: []Region{
+			{KeyLow: 0, KeyHigh: 59, VelocityLow: 0, VelocityHigh: 127, Start: 0, End: 100, LoopStart: 10, LoopEnd: 90,
+				SampleRate: 22050, RootKey: 48, Tune: -5, ScaleTuning: 100, Loop: true, Gain: 1, Attack: attack,
+				Decay: instant, Sustain: 1, Release: 0.5},
+			{KeyLow: 60, KeyHigh: 63, VelocityLow: 0, VelocityHigh: 100, Start: 100, End: 200, LoopStart: 150, LoopEnd: 250,
+				SampleRate: 44100, RootKey: 72, Tune: 10, ScaleTuning: 100, Gain: math.Pow(10, -1), Attack: attack,
+				Decay: instant, Sustain: 1, Release: 0.5},
+		}},
+		{Name: "Standard", Bank: PercussionBank, Program: 0, Regions: []Region{
+			{KeyLow: 36, KeyHigh: 36, VelocityLow: 0, VelocityHigh: 127, Start: 5, End: 100, LoopStart: 10, LoopEnd: 90,
+				SampleRate: 22050, RootKey: 60, Tune: 195, ScaleTuning: 100, Loop: true, LoopUntilRelease: true, Gain: 1,
+				Attack: instant, Decay: instant, Sustain: 1, Release: instant, ExclusiveClass: 1},
+		}},
+	}
+
+	if !reflect.DeepEqual(font.Presets, expected) {
+		t.Errorf("got presets\n%+v\nwant\n%+v", font.Presets, expected)
+	}
+}
+
+func TestRegions(t *testing.T) {
+
+	font, err := Read(newTestFont().build())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	piano := font.Preset(0, 0)
+	kit := font.Preset(PercussionBank, 0)
+
+	tests := []struct {
+		name     string
+		bank     int
+		program  int
+		key      uint8
+		velocity uint8
+		expected []*Region
+	}{
+		{"low key", 0, 0, 40, 127, []*Region{&piano.Regions[0]}},
+		{"high key", 0, 0, 61, 100, []*Region{&piano.Regions[1]}},
+		{"above the velocity range", 0, 0, 61, 101, nil},
+		{"above the preset key range", 0, 0, 64, 100, nil},
+		{"missing bank falls back to bank 0", 8, 0, 40, 100, []*Region{&piano.Regions[0]}},
+		{"drum kit", PercussionBank, 0, 36, 100, []*Region{&kit.Regions[0]}},
+		{"missing kit falls back to the standard kit", PercussionBank, 25, 36, 100, []*Region{&kit.Regions[0]}},
+		{"missing program", 0, 1, 40, 100, nil},
+	}
+
+	for _, test := range tests {
+		if regions := font.Regions(test.bank, test.program, test.key, test.velocity); !reflect.DeepEqual(regions, test.expected) {
+			t.Errorf("%s: got %v, want %v", test.name, regions, test.expected)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+
+	missingSample := newTestFont()
+	missingSample.instruments[1].zones[0] = []generator{{sampleIDGenerator, 5}}
+
+	missingInstrument := newTestFont()
+	missingInstrument.presets[1].zones[0] = []generator{{instrumentGenerator, 7}}
+
+	pastTheData := newTestFont()
+	pastTheData.headers[1].end = 400
+
+	valid := newTestFont().build()
+	missingChunk := bytes.Replace(valid, []byte("shdr"), []byte("xxxx"), 1)
+	truncated := valid[:len(valid)-50]
+
+	tests := map[string][]byte{
+		"empty":              nil,
+		"not a soundfont":    riffChunk("RIFF", []byte("WAVEfmt ")),
+		"missing chunk":      missingChunk,
+		"truncated":          truncated,
+		"missing sample":     missingSample.build(),
+		"missing instrument": missingInstrument.build(),
+		"sample past data":   pastTheData.build(),
+	}
+
+	for name, data := range tests {
+		if _, err := Read(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestConversions(t *testing.T) {
+
+	timecentTests := map[int]float64{0: 1, 1200: 2, -1200: 0.5, -12000: math.Pow(2, -10)}
+
+	for value, seconds := range timecentTests {
+		if converted := timecents(value); math.Abs(converted-seconds) > 1e-9 {
+			t.Errorf("timecents(%d) = %f, want %f", value, converted, seconds)
+		}
+	}
+
+	centibelTests := map[int]float64{-100: 1, 0: 1, 200: 0.1, 60: math.Pow(10, -0.3), maxAttenuation: 0, 2000: 0}
+
+	for value, level := range centibelTests {
+		if converted := centibels(value); math.Abs(converted-level) > 1e-9 {
+			t.Errorf("centibels(%d) = %f, want %f", value, converted, level)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package synth
+
+import (
+	"fmt"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/soundfont"
+)
+
+/*
+Config Defines the format of the synth config:
+sample_rate	Samples per second of the rendered audio (44100).
+polyphony	Most notes that sound at once, the oldest is cut off to make room for a new one (32).
+limiter		Level in dBFS the master limiter holds the mix under (-1).
+presets		Sound of each channel, channels without one use the default preset, or a noise preset on channel 10.
+soundfont	SF2 file to play the channels with, each plays the program of its preset (or falls back to its waveform).
+*/
+type Config struct {
+	SampleRate int      `yaml:"sample_rate"`
+	Polyphony  int      `yaml:"polyphony"`
+	Limiter    float64  `yaml:"limiter"`
+	Presets    []Preset `yaml:"presets"`
+	SoundFont  string   `yaml:"soundfont"`
+}
+
+/*
+Preset Defines the format of a preset config:
+channels	MIDI channels (1-16) that play the preset.
+waveform	sine, saw, square or noise.
+attack		Seconds the note takes to reach full level.
+decay		Seconds it takes to fall from full level to the sustain level.
+sustain		Level held while the note is on (0-1).
+release		Seconds it takes to fade out after the note off.
+gain		Level of the preset in the mix (0-1).
+bend_range	Pitch bend range in semitones, set it to the tuning bend_range when the channel is tuned with MPE (2).
+program		SoundFont program the channels start on (0-127, 0 is the General MIDI piano), program changes switch it.
+bank		SoundFont bank the channels start on (0, channel 10 uses the drum kits in bank 128 when it has no preset).
+*/
+type Preset struct {
+	Channels  []int   `yaml:"channels,flow"`
+	Waveform  string  `yaml:"waveform"`
+	Attack    float64 `yaml:"attack"`
+	Decay     float64 `yaml:"decay"`
+	Sustain   float64 `yaml:"sustain"`
+	Release   float64 `yaml:"release"`
+	Gain      float64 `yaml:"gain"`
+	BendRange float64 `yaml:"bend_range"`
+	Program   int     `yaml:"program"`
+	Bank      int     `yaml:"bank"`
+}
+
+/* Oscillator waveforms. */
+const (
+	Sine   = "sine"
+	Saw    = "saw"
+	Square = "square"
+	Noise  = "noise"
+)
+
+const (
+	defaultSampleRate = 44100
+	defaultPolyphony  = 32
+	defaultLimiter    = -1
+	defaultBendRange  = 2
+	numChannels       = 16
+	drumChannel       = 9
+)
+
+/*defaultPreset Plays every channel without a preset, a soft saw. */
+var defaultPreset = Preset{Waveform: Saw, Attack: 0.01, Decay: 0.2, Sustain: 0.6, Release: 0.3, Gain: 0.5, BendRange: defaultBendRange}
+
+/*drumPreset Plays channel 10 without a preset, short bursts of noise. */
+var drumPreset = Preset{Waveform: Noise, Attack: 0.001, Decay: 0.12, Sustain: 0, Release: 0.05, Gain: 0.4, BendRange: defaultBendRange,
+	Bank: soundfont.PercussionBank}
+
+/*Validate Checks the synth config and fills in the defaults. */
+func (config *Config) Validate() error {
+
+	if config.SampleRate == 0 {
+		config.SampleRate = defaultSampleRate
+	}
+
+	if config.SampleRate < 8000 || config.SampleRate > 192000 {
+		return fmt.Errorf("synth sample_rate %d must be between 8000 and 192000", config.SampleRate)
+	}
+
+	if config.Polyphony == 0 {
+		config.Polyphony = defaultPolyphony
+	}
+
+	if config.Polyphony < 1 {
+		return fmt.Errorf("synth polyphony %d must be at least 1", config.Polyphony)
+	}
+
+	if config.Limiter == 0 {
+		config.Limiter = defaultLimiter
+	}
+
+	if config.Limiter > 0 {
+		return fmt.Errorf("synth limiter %f dBFS can't be above 0", config.Limiter)
+	}
+
+	for i := range config.Presets {
+
+		preset := &config.Presets[i]
+
+		switch preset.Waveform {
+		case "":
+			preset.Waveform = defaultPreset.Waveform
+		case Sine, Saw, Square, Noise:
+		default:
+			return fmt.Errorf("synth presets[%d].waveform %q must be %s, %s, %s or %s", i, preset.Waveform, Sine, Saw, Square, Noise)
+		}
+
+		for _, channel := range preset.Channels {
+			if channel < 1 || channel > numChannels {
+				return fmt.Errorf("synth presets[%d].channels: channel %d must be between 1 and %d", i, channel, numChannels)
+			}
+		}
+
+		if preset.Attack < 0 || preset.Decay < 0 || preset.Release < 0 {
+			return fmt.Errorf("synth presets[%d]: attack, decay and release can't be negative", i)
+		}
+
+		if preset.Sustain < 0 || preset.Sustain > 1 {
+			return fmt.Errorf("synth presets[%d].sustain %f must be between 0 and 1", i, preset.Sustain)
+		}
+
+		if preset.Gain == 0 {
+			preset.Gain = defaultPreset.Gain
+		}
+
+		if preset.Gain < 0 || preset.Gain > 1 {
+			return fmt.Errorf("synth presets[%d].gain %f must be between 0 and 1", i, preset.Gain)
+		}
+
+		if preset.BendRange == 0 {
+			preset.BendRange = defaultBendRange
+		}
+
+		if preset.Program < 0 || preset.Program > 127 {
+			return fmt.Errorf("synth presets[%d].program %d must be between 0 and 127", i, preset.Program)
+		}
+
+		if preset.Bank < 0 || preset.Bank > 16383 {
+			return fmt.Errorf("synth presets[%d].bank %d must be between 0 and 16383", i, preset.Bank)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package synth
+
+import (
+	"math"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/soundfont"
+)
+
+/* Waveform of voices playing SoundFont samples, not one that can be configured. */
+const sampleWaveform = "sample"
+
+/*LoadSoundFont Plays the channels on the samples of an SF2 file from now on. */
+func (synth *Synth) LoadSoundFont(path string) error {
+
+	font, err := soundfont.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	synth.font = font
+
+	return nil
+}
+
+/*
+sampleVoice Returns a voice playing a region of the SoundFont. The region brings its own envelope and level, the delay
+and hold stages of the SoundFont envelope are left out. Starting a voice in an exclusive class (an open hi-hat) cuts
+off the earlier voices of the same class on the channel (the closed hi-hat), not the other regions of the same note.
+*/
+func (synth *Synth) sampleVoice(number uint8, key uint8, velocity uint8, region *soundfont.Region) *voice {
+
+	if region.ExclusiveClass != 0 {
+		for _, v := range synth.voices {
+			if v.channel == number && v.region != nil && v.region.ExclusiveClass == region.ExclusiveClass && v.started != synth.samples {
+				v.stage = doneStage
+			}
+		}
+	}
+
+	preset := &Preset{Waveform: sampleWaveform, Attack: region.Attack, Decay: region.Decay, Sustain: region.Sustain,
+		Release: region.Release, Gain: region.Gain, BendRange: synth.channels[number].preset.BendRange}
+
+	cents := (float64(key)-float64(region.RootKey))*region.ScaleTuning + region.Tune
+	step := float64(region.SampleRate) / synth.sampleRate * math.Pow(2, cents/1200)
+
+	return &voice{channel: number, key: key, preset: preset, velocity: float64(velocity) / 127, started: synth.samples,
+		region: region, position: float64(region.Start), step: step}
+}
+
+/*renderSample Returns the next sample of a voice playing a SoundFont sample and moves it along, looping if the region loops. */
+func (synth *Synth) renderSample(v *voice, bend float64) float64 {
+
+	region := v.region
+	index := int(v.position)
+
+	if index+1 >= int(region.End) {
+		v.stage = doneStage
+		return 0
+	}
+
+	fraction := v.position - float64(index)
+	sample := (float64(synth.font.Samples[index])*(1-fraction) + float64(synth.font.Samples[index+1])*fraction) / 32768
+
+	v.position += v.step * math.Pow(2, bend/12)
+
+	/* Loop until release regions play on to the end of the sample once the note is released. */
+	looping := region.Loop && !(region.LoopUntilRelease && v.stage == releaseStage)
+
+	if looping && v.position >= float64(region.LoopEnd) {
+		v.position -= float64(region.LoopEnd - region.LoopStart)
+	}
+
+	return sample
+}
@@ -0,0 +1,371 @@
+package synth
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/ElectricNoodle/prometheus-midi-generator/soundfont"
+)
+
+/* MIDI status bytes and controllers the synth responds to. */
+const (
+	noteOff       = 0x80
+	noteOn        = 0x90
+	controlChange = 0xB0
+	programChange = 0xC0
+	pitchBend     = 0xE0
+	bankSelect    = 0
+	volume        = 7
+	allSoundOff   = 120
+	allNotesOff   = 123
+)
+
+/* Headroom given to each voice, so a handful of notes at full velocity don't lean on the limiter. */
+const voiceLevel = 0.25
+
+/* Seconds the limiter takes to let the level back up after holding it down. */
+const limiterRelease = 0.05
+
+/*envelopeStage Where a voice is in its ADSR envelope. */
+type envelopeStage int
+
+const (
+	attackStage  envelopeStage = 0
+	decayStage   envelopeStage = 1
+	sustainStage envelopeStage = 2
+	releaseStage envelopeStage = 3
+	doneStage    envelopeStage = 4
+)
+
+/*voice A sounding note. */
+type voice struct {
+	channel  uint8
+	key      uint8
+	preset   *Preset
+	velocity float64
+	phase    float64
+	stage    envelopeStage
+	level    float64
+	released float64
+	started  int64
+	region   *soundfont.Region
+	position float64
+	step     float64
+}
+
+/*channel The state of a MIDI channel. */
+type channel struct {
+	preset  *Preset
+	volume  float64
+	bend    float64
+	program int
+	bank    int
+}
+
+/*Synth A small polyphonic synth driven by MIDI messages, rendering mono audio on demand. */
+type Synth struct {
+	sampleRate float64
+	polyphony  int
+	threshold  float64
+	gain       float64
+	recovery   float64
+	channels   [numChannels]channel
+	voices     []*voice
+	random     *rand.Rand
+	samples    int64
+	font       *soundfont.SoundFont
+}
+
+/*New Returns a synth for a validated config. */
+func New(config Config) *Synth {
+
+	synth := &Synth{sampleRate: float64(config.SampleRate), polyphony: config.Polyphony,
+		threshold: math.Pow(10, config.Limiter/20), gain: 1, random: rand.New(rand.NewSource(1))}
+
+	synth.recovery = 1 - math.Exp(-1/(limiterRelease*synth.sampleRate))
+
+	for i := range synth.channels {
+
+		synth.channels[i] = channel{preset: &defaultPreset, volume: 1}
+
+		if i == drumChannel {
+			synth.channels[i].preset = &drumPreset
+		}
+	}
+
+	for i := range config.Presets {
+		for _, number := range config.Presets[i].Channels {
+			synth.channels[number-1].preset = &config.Presets[i]
+		}
+	}
+
+	for i := range synth.channels {
+		synth.channels[i].program = synth.channels[i].preset.Program
+		synth.channels[i].bank = synth.channels[i].preset.Bank
+	}
+
+	return synth
+}
+
+/*SampleRate Returns the samples per second the synth renders at. */
+func (synth *Synth) SampleRate() int {
+	return int(synth.sampleRate)
+}
+
+/*Handle Plays a MIDI message, anything the synth doesn't respond to (SysEx, aftertouch) is ignored. */
+func (synth *Synth) Handle(message []byte) {
+
+	if len(message) < 2 || message[0] >= 0xF0 {
+		return
+	}
+
+	number := message[0] & 0x0F
+	channel := &synth.channels[number]
+
+	/* Program changes are the only two byte message we respond to. */
+	if message[0]&0xF0 == programChange {
+		channel.program = int(message[1])
+		return
+	}
+
+	if len(message) < 3 {
+		return
+	}
+
+	switch message[0] & 0xF0 {
+
+	case noteOn:
+
+		if message[2] == 0 {
+			synth.releaseNote(number, message[1])
+			return
+		}
+
+		synth.start(number, message[1], message[2])
+
+	case noteOff:
+		synth.releaseNote(number, message[1])
+
+	case controlChange:
+
+		switch message[1] {
+		case bankSelect:
+			channel.bank = int(message[2])
+		case volume:
+			channel.volume = float64(message[2]) / 127
+		case allNotesOff:
+			synth.releaseChannel(number)
+		case allSoundOff:
+			synth.silenceChannel(number)
+		}
+
+	case pitchBend:
+		bend := float64((int(message[1])|int(message[2])<<7)-8192) / 8192
+		channel.bend = bend * channel.preset.BendRange
+	}
+}
+
+/*start Starts a note, on the samples of the SoundFont when it has the program of the channel or the preset waveform. */
+func (synth *Synth) start(number uint8, key uint8, velocity uint8) {
+
+	channel := synth.channels[number]
+
+	if synth.font != nil {
+
+		if regions := synth.font.Regions(channel.bank, channel.program, key, velocity); len(regions) > 0 {
+
+			for _, region := range regions {
+				synth.add(synth.sampleVoice(number, key, velocity, region))
+			}
+
+			return
+		}
+	}
+
+	synth.add(&voice{channel: number, key: key, preset: channel.preset, velocity: float64(velocity) / 127, started: synth.samples})
+}
+
+/*add Adds a voice, cutting off the oldest one if every voice is in use. Released voices are cut off first. */
+func (synth *Synth) add(v *voice) {
+
+	if len(synth.voices) >= synth.polyphony {
+
+		steal := 0
+
+		for i, candidate := range synth.voices {
+			if stealsBefore(candidate, synth.voices[steal]) {
+				steal = i
+			}
+		}
+
+		synth.voices = append(synth.voices[:steal], synth.voices[steal+1:]...)
+	}
+
+	synth.voices = append(synth.voices, v)
+}
+
+/*stealsBefore Returns true if voice a should be cut off before voice b, released voices go first and then the oldest. */
+func stealsBefore(a *voice, b *voice) bool {
+
+	if (a.stage == releaseStage) != (b.stage == releaseStage) {
+		return a.stage == releaseStage
+	}
+
+	return a.started < b.started
+}
+
+/*releaseNote Moves the oldest held voice playing the key into its release. */
+func (synth *Synth) releaseNote(number uint8, key uint8) {
+
+	for _, v := range synth.voices {
+		if v.channel == number && v.key == key && v.stage < releaseStage {
+			v.release()
+			return
+		}
+	}
+}
+
+func (synth *Synth) releaseChannel(number uint8) {
+
+	for _, v := range synth.voices {
+		if v.channel == number && v.stage < releaseStage {
+			v.release()
+		}
+	}
+}
+
+func (synth *Synth) silenceChannel(number uint8) {
+
+	voices := synth.voices[:0]
+
+	for _, v := range synth.voices {
+		if v.channel != number {
+			voices = append(voices, v)
+		}
+	}
+
+	synth.voices = voices
+}
+
+func (v *voice) release() {
+	v.stage = releaseStage
+	v.released = v.level
+}
+
+/*Silent Returns true once every voice has finished its release. */
+func (synth *Synth) Silent() bool {
+	return len(synth.voices) == 0
+}
+
+/*Render Renders the next samples of the mix, between -1 and 1. */
+func (synth *Synth) Render(count int) []float32 {
+
+	samples := make([]float32, count)
+
+	for i := range samples {
+
+		mix := 0.0
+
+		for _, v := range synth.voices {
+			mix += synth.renderVoice(v)
+		}
+
+		/* The limiter holds the gain down as soon as the mix goes over, then lets it recover smoothly. */
+		if level := math.Abs(mix) * synth.gain; level > synth.threshold {
+			synth.gain = synth.threshold / math.Abs(mix)
+		} else {
+			synth.gain += (1 - synth.gain) * synth.recovery
+		}
+
+		samples[i] = float32(math.Max(-1, math.Min(1, mix*synth.gain)))
+		synth.samples++
+
+		voices := synth.voices[:0]
+
+		for _, v := range synth.voices {
+			if v.stage != doneStage {
+				voices = append(voices, v)
+			}
+		}
+
+		synth.voices = voices
+	}
+
+	return samples
+}
+
+/*renderVoice Returns the next sample of a voice and moves its oscillator and envelope along. */
+func (synth *Synth) renderVoice(v *voice) float64 {
+
+	channel := synth.channels[v.channel]
+	frequency := 440 * math.Pow(2, (float64(v.key)-69+channel.bend)/12)
+
+	var sample float64
+
+	switch v.preset.Waveform {
+	case Sine:
+		sample = math.Sin(2 * math.Pi * v.phase)
+	case Saw:
+		sample = 2*v.phase - 1
+	case Square:
+		sample = 1
+		if v.phase >= 0.5 {
+			sample = -1
+		}
+	case Noise:
+		sample = synth.random.Float64()*2 - 1
+	case sampleWaveform:
+		sample = synth.renderSample(v, channel.bend)
+	}
+
+	v.phase = math.Mod(v.phase+frequency/synth.sampleRate, 1)
+
+	return sample * synth.envelope(v) * v.velocity * v.preset.Gain * channel.volume * voiceLevel
+}
+
+/*envelope Moves a voice one sample along its ADSR envelope and returns its level. */
+func (synth *Synth) envelope(v *voice) float64 {
+
+	preset := v.preset
+	step := func(seconds float64) float64 {
+
+		if seconds <= 0 {
+			return 1
+		}
+
+		return 1 / (seconds * synth.sampleRate)
+	}
+
+	switch v.stage {
+
+	case attackStage:
+
+		if v.level += step(preset.Attack); v.level >= 1 {
+			v.level = 1
+			v.stage = decayStage
+		}
+
+	case decayStage:
+
+		if v.level -= step(preset.Decay) * (1 - preset.Sustain); v.level <= preset.Sustain {
+			v.level = preset.Sustain
+			v.stage = sustainStage
+		}
+
+	case sustainStage:
+
+		/* A note with no sustain has finished once it has decayed, it doesn't need its note off. */
+		if preset.Sustain == 0 {
+			v.stage = doneStage
+		}
+
+	case releaseStage:
+
+		if v.level -= step(preset.Release) * v.released; v.level <= 0 {
+			v.level = 0
+			v.stage = doneStage
+		}
+	}
+
+	return v.level
+}
@@ -0,0 +1,166 @@
+package synth
+
+import (
+	"testing"
+)
+
+/*newSynth Returns a synth at a low sample rate, so envelopes are a countable number of samples long. */
+func newSynth(t *testing.T, polyphony int, presets ...Preset) *Synth {
+
+	config := Config{SampleRate: 8000, Polyphony: polyphony, Presets: presets}
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return New(config)
+}
+
+/*keys Returns the keys of the voices sounding, oldest first. */
+func keys(synth *Synth) []uint8 {
+
+	var sounding []uint8
+
+	for _, v := range synth.voices {
+		sounding = append(sounding, v.key)
+	}
+
+	return sounding
+}
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		config Config
+		valid  bool
+	}{
+		{"defaults", Config{}, true},
+		{"preset", Config{Presets: []Preset{{Channels: []int{1, 16}, Waveform: Square, Sustain: 1}}}, true},
+		{"sample rate", Config{SampleRate: 1000}, false},
+		{"polyphony", Config{Polyphony: -1}, false},
+		{"limiter", Config{Limiter: 3}, false},
+		{"waveform", Config{Presets: []Preset{{Waveform: "triangle"}}}, false},
+		{"channel", Config{Presets: []Preset{{Channels: []int{17}}}}, false},
+		{"release", Config{Presets: []Preset{{Release: -1}}}, false},
+		{"sustain", Config{Presets: []Preset{{Sustain: 2}}}, false},
+		{"gain", Config{Presets: []Preset{{Gain: 1.5}}}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.config.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: got error %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+
+	config := Config{Presets: []Preset{{}}}
+	config.Validate()
+
+	if config.SampleRate != defaultSampleRate || config.Polyphony != defaultPolyphony || config.Presets[0].Waveform != Saw ||
+		config.Presets[0].BendRange != defaultBendRange {
+		t.Errorf("defaults not filled in: %+v", config)
+	}
+}
+
+func TestVoiceStealing(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		messages [][]byte
+		expected []uint8
+	}{
+		{"room for both", [][]byte{{0x90, 60, 100}, {0x90, 62, 100}}, []uint8{60, 62}},
+		{"oldest cut off", [][]byte{{0x90, 60, 100}, {0x90, 62, 100}, {0x90, 64, 100}}, []uint8{62, 64}},
+		{"released cut off first", [][]byte{{0x90, 60, 100}, {0x90, 62, 100}, {0x80, 62, 0}, {0x90, 64, 100}}, []uint8{60, 64}},
+		{"note on with no velocity releases", [][]byte{{0x90, 60, 100}, {0x90, 62, 100}, {0x90, 62, 0}, {0x90, 64, 100}}, []uint8{60, 64}},
+		{"all sound off", [][]byte{{0x90, 60, 100}, {0x91, 62, 100}, {0xB0, allSoundOff, 0}}, []uint8{62}},
+	}
+
+	for _, test := range tests {
+
+		synth := newSynth(t, 2)
+
+		for _, message := range test.messages {
+			synth.Handle(message)
+		}
+
+		got := keys(synth)
+
+		if len(got) != len(test.expected) {
+			t.Errorf("%s: got keys %v, want %v", test.name, got, test.expected)
+			continue
+		}
+
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%s: got keys %v, want %v", test.name, got, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestEnvelopeRelease(t *testing.T) {
+
+	/* Straight to full level, a decay of one sample to the sustain level and a release of 80 samples. */
+	synth := newSynth(t, 4, Preset{Channels: []int{1}, Waveform: Sine, Sustain: 0.5, Release: 0.01})
+
+	synth.Handle([]byte{0x90, 69, 127})
+	synth.Render(10)
+
+	if v := synth.voices[0]; v.stage != sustainStage || v.level != 0.5 {
+		t.Fatalf("got stage %d level %f, want the sustain level", v.stage, v.level)
+	}
+
+	/* The note holds until its note off. */
+	synth.Render(1000)
+
+	if synth.Silent() {
+		t.Fatal("the note stopped before its note off")
+	}
+
+	synth.Handle([]byte{0x80, 69, 0})
+	synth.Render(70)
+
+	if synth.Silent() || synth.voices[0].stage != releaseStage {
+		t.Fatal("the note stopped before the end of its release")
+	}
+
+	samples := synth.Render(20)
+
+	if !synth.Silent() {
+		t.Fatalf("the note is still sounding at level %f after its release", synth.voices[0].level)
+	}
+
+	if last := samples[len(samples)-1]; last != 0 {
+		t.Errorf("got sample %f once the note has finished, want silence", last)
+	}
+}
+
+func TestDrumsFinishWithoutNoteOff(t *testing.T) {
+
+	/* The drum preset has no sustain, so its notes finish once they have decayed. */
+	synth := newSynth(t, 4)
+
+	synth.Handle([]byte{0x99, 36, 127})
+	synth.Render(int(drumPreset.Attack*8000+drumPreset.Decay*8000) + 10)
+
+	if !synth.Silent() {
+		t.Error("the drum is still sounding after its decay")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+
+	synth := newSynth(t, 32, Preset{Channels: []int{1}, Waveform: Square, Sustain: 1, Gain: 1})
+
+	for key := uint8(40); key < 72; key++ {
+		synth.Handle([]byte{0x90, key, 127})
+	}
+
+	for _, sample := range synth.Render(4000) {
+		if sample > 1 || sample < -1 {
+			t.Fatalf("got sample %f outside -1 to 1", sample)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package synth
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+/*Writer Somewhere rendered audio is written. */
+type Writer interface {
+	Write(samples []float32) error
+	Close() error
+}
+
+/* Size of the WAV header, the data starts straight after it. */
+const wavHeaderSize = 44
+
+/*WAVWriter Writes 16-bit mono PCM to a WAV file, the sizes in the header are filled in when it is closed. */
+type WAVWriter struct {
+	file       io.WriteSeeker
+	writer     *bufio.Writer
+	sampleRate int
+	samples    int
+}
+
+/*PCMWriter Streams raw 16-bit little endian mono PCM as it is rendered, for piping into a player such as aplay or ffplay. */
+type PCMWriter struct {
+	writer io.Writer
+}
+
+/*NewWAVWriter Writes a WAV header to the file, samples can be written straight after. */
+func NewWAVWriter(file io.WriteSeeker, sampleRate int) (*WAVWriter, error) {
+
+	wav := &WAVWriter{file: file, writer: bufio.NewWriter(file), sampleRate: sampleRate}
+
+	if err := wav.writeHeader(); err != nil {
+		return nil, err
+	}
+
+	return wav, nil
+}
+
+func (wav *WAVWriter) writeHeader() error {
+
+	dataSize := uint32(wav.samples * 2)
+
+	header := []interface{}{
+		[]byte("RIFF"), uint32(wavHeaderSize - 8 + dataSize), []byte("WAVE"),
+		[]byte("fmt "), uint32(16), uint16(1), uint16(1), uint32(wav.sampleRate), uint32(wav.sampleRate * 2), uint16(2), uint16(16),
+		[]byte("data"), dataSize,
+	}
+
+	for _, field := range header {
+		if err := binary.Write(wav.writer, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	return wav.writer.Flush()
+}
+
+/*Write Appends samples to the file. */
+func (wav *WAVWriter) Write(samples []float32) error {
+
+	wav.samples += len(samples)
+
+	return writePCM(wav.writer, samples)
+}
+
+/*Close Fills in the sizes in the header. The file itself is left open for the caller to close. */
+func (wav *WAVWriter) Close() error {
+
+	if err := wav.writer.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := wav.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := wav.writeHeader(); err != nil {
+		return err
+	}
+
+	_, err := wav.file.Seek(0, io.SeekEnd)
+
+	return err
+}
+
+/*NewPCMWriter Returns a writer streaming PCM to w. */
+func NewPCMWriter(w io.Writer) *PCMWriter {
+	return &PCMWriter{writer: w}
+}
+
+/*Write Writes samples straight through, so a player keeps up with the synth. */
+func (pcm *PCMWriter) Write(samples []float32) error {
+	return writePCM(pcm.writer, samples)
+}
+
+/*Close Does nothing, the stream belongs to the caller. */
+func (pcm *PCMWriter) Close() error {
+	return nil
+}
+
+/*writePCM Converts samples between -1 and 1 into 16-bit integers. */
+func writePCM(w io.Writer, samples []float32) error {
+
+	data := make([]byte, len(samples)*2)
+
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(int16(math.Round(float64(sample)*math.MaxInt16))))
+	}
+
+	_, err := w.Write(data)
+
+	return err
+}
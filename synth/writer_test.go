@@ -0,0 +1,111 @@
+package synth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+/*memoryFile An io.WriteSeeker in memory, standing in for the WAV file. */
+type memoryFile struct {
+	data     []byte
+	position int
+}
+
+func (file *memoryFile) Write(p []byte) (int, error) {
+
+	if end := file.position + len(p); end > len(file.data) {
+		file.data = append(file.data, make([]byte, end-len(file.data))...)
+	}
+
+	copy(file.data[file.position:], p)
+	file.position += len(p)
+
+	return len(p), nil
+}
+
+func (file *memoryFile) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekStart:
+		file.position = int(offset)
+	case io.SeekCurrent:
+		file.position += int(offset)
+	case io.SeekEnd:
+		file.position = len(file.data) + int(offset)
+	}
+
+	return int64(file.position), nil
+}
+
+func TestWAVWriter(t *testing.T) {
+
+	file := &memoryFile{}
+	wav, err := NewWAVWriter(file, 8000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* The header is written with no data, the sizes are only known once the writer is closed. */
+	if len(file.data) != wavHeaderSize || binary.LittleEndian.Uint32(file.data[40:]) != 0 {
+		t.Fatalf("got header % x", file.data)
+	}
+
+	for _, samples := range [][]float32{{1, -1, 0}, make([]float32, 97)} {
+		if err := wav.Write(samples); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := wav.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := []struct {
+		name     string
+		offset   int
+		expected uint32
+	}{
+		{"RIFF size", 4, wavHeaderSize - 8 + 200},
+		{"sample rate", 24, 8000},
+		{"byte rate", 28, 16000},
+		{"data size", 40, 200},
+	}
+
+	for _, field := range fields {
+		if got := binary.LittleEndian.Uint32(file.data[field.offset:]); got != field.expected {
+			t.Errorf("%s: got %d, want %d", field.name, got, field.expected)
+		}
+	}
+
+	if !bytes.Equal(file.data[:4], []byte("RIFF")) || !bytes.Equal(file.data[8:16], []byte("WAVEfmt ")) ||
+		!bytes.Equal(file.data[36:40], []byte("data")) {
+		t.Errorf("got header % x", file.data[:wavHeaderSize])
+	}
+
+	if len(file.data) != wavHeaderSize+200 || file.position != len(file.data) {
+		t.Errorf("got %d bytes with the writer at %d, want %d at the end", len(file.data), file.position, wavHeaderSize+200)
+	}
+
+	for i, expected := range []int16{32767, -32767, 0} {
+		if got := int16(binary.LittleEndian.Uint16(file.data[wavHeaderSize+i*2:])); got != expected {
+			t.Errorf("sample %d: got %d, want %d", i, got, expected)
+		}
+	}
+}
+
+func TestPCMWriter(t *testing.T) {
+
+	var stream bytes.Buffer
+	pcm := NewPCMWriter(&stream)
+
+	if err := pcm.Write([]float32{0.5, -0.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []byte{0x00, 0x40, 0x00, 0xC0}; !bytes.Equal(stream.Bytes(), expected) {
+		t.Errorf("got % x, want % x", stream.Bytes(), expected)
+	}
+}
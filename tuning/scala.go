@@ -0,0 +1,216 @@
+package tuning
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*Scale Holds a Scala scale, the pitch of each degree in cents above the root. The last degree is the period (usually the octave). */
+type Scale struct {
+	Description string
+	Cents       []float64
+}
+
+/*KeyboardMap Holds a Scala keyboard mapping, which decides which MIDI key plays which degree of the scale. */
+type KeyboardMap struct {
+	Size          int
+	FirstNote     int
+	LastNote      int
+	MiddleNote    int
+	ReferenceNote int
+	ReferenceFreq float64
+	OctaveDegree  int
+	Mapping       []int
+}
+
+/* Degrees marked with an x in a keyboard mapping are left silent. */
+const unmapped = -1
+
+/*readLines Returns the lines of a Scala file with the comments (lines starting with !) removed. */
+func readLines(path string) ([]string, error) {
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+/*firstField Returns the first whitespace separated field of a line, anything after it is a comment. */
+func firstField(line string) string {
+
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[0]
+}
+
+/*parsePitch Converts a Scala pitch into cents, values containing a dot are cents and anything else is a ratio. */
+func parsePitch(value string) (float64, error) {
+
+	if strings.Contains(value, ".") {
+		return strconv.ParseFloat(value, 64)
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	numerator, err := strconv.ParseFloat(parts[0], 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	denominator := 1.0
+
+	if len(parts) == 2 {
+
+		denominator, err = strconv.ParseFloat(parts[1], 64)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if numerator <= 0 || denominator <= 0 {
+		return 0, fmt.Errorf("ratio %s must be positive", value)
+	}
+
+	return 1200 * math.Log2(numerator/denominator), nil
+}
+
+/*LoadScale Reads a Scala .scl file. */
+func LoadScale(path string) (*Scale, error) {
+
+	lines, err := readLines(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("%s: missing description or note count", path)
+	}
+
+	count, err := strconv.Atoi(firstField(lines[1]))
+
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("%s: invalid note count %q", path, lines[1])
+	}
+
+	if len(lines)-2 < count {
+		return nil, fmt.Errorf("%s: expected %d pitches, found %d", path, count, len(lines)-2)
+	}
+
+	scale := &Scale{Description: strings.TrimSpace(lines[0]), Cents: make([]float64, count)}
+
+	for i := 0; i < count; i++ {
+
+		scale.Cents[i], err = parsePitch(firstField(lines[i+2]))
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid pitch %q (%v)", path, lines[i+2], err)
+		}
+	}
+
+	return scale, nil
+}
+
+/*LoadKeyboardMap Reads a Scala .kbm file. */
+func LoadKeyboardMap(path string) (*KeyboardMap, error) {
+
+	lines, err := readLines(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+
+	for _, line := range lines {
+		if field := firstField(line); field != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("%s: expected at least 7 header values, found %d", path, len(fields))
+	}
+
+	header := make([]int, 7)
+
+	for i := range header {
+
+		if i == 5 {
+			continue
+		}
+
+		header[i], err = strconv.Atoi(fields[i])
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value %q", path, fields[i])
+		}
+	}
+
+	keyboardMap := &KeyboardMap{Size: header[0], FirstNote: header[1], LastNote: header[2], MiddleNote: header[3],
+		ReferenceNote: header[4], OctaveDegree: header[6]}
+
+	keyboardMap.ReferenceFreq, err = strconv.ParseFloat(fields[5], 64)
+
+	if err != nil || keyboardMap.ReferenceFreq <= 0 {
+		return nil, fmt.Errorf("%s: invalid reference frequency %q", path, fields[5])
+	}
+
+	if keyboardMap.Size < 0 {
+		return nil, fmt.Errorf("%s: invalid map size %d", path, keyboardMap.Size)
+	}
+
+	keyboardMap.Mapping = make([]int, keyboardMap.Size)
+
+	/* Entries missing from the end of the mapping are unmapped. */
+	for i := range keyboardMap.Mapping {
+
+		keyboardMap.Mapping[i] = unmapped
+
+		if 7+i >= len(fields) || strings.ToLower(fields[7+i]) == "x" {
+			continue
+		}
+
+		keyboardMap.Mapping[i], err = strconv.Atoi(fields[7+i])
+
+		if err != nil || keyboardMap.Mapping[i] < 0 {
+			return nil, fmt.Errorf("%s: invalid mapping entry %q", path, fields[7+i])
+		}
+	}
+
+	return keyboardMap, nil
+}
+
+/*linearMap Returns the mapping used when no .kbm file is given, consecutive keys play consecutive degrees from middle C. */
+func linearMap(degrees int) *KeyboardMap {
+	return &KeyboardMap{Size: 0, FirstNote: 0, LastNote: 127, MiddleNote: 60, ReferenceNote: 60,
+		ReferenceFreq: 261.6255653, OctaveDegree: degrees}
+}
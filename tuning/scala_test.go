@@ -0,0 +1,218 @@
+package tuning
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/* Frequencies and cents are compared to within this much. */
+const tolerance = 0.001
+
+/*writeFile Writes a Scala file into a temporary directory and returns its path. */
+func writeFile(t *testing.T, name string, contents string) string {
+
+	path := filepath.Join(t.TempDir(), name)
+
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestParsePitch(t *testing.T) {
+
+	tests := []struct {
+		value string
+		cents float64
+	}{
+		{"100.0", 100},
+		{"-5.5", -5.5},
+		{"701.955", 701.955},
+		{"3/2", 701.955},
+		{"2/1", 1200},
+		{"2", 1200},
+		{"5/4", 386.314},
+		{"81/80", 21.506},
+	}
+
+	for _, test := range tests {
+
+		cents, err := parsePitch(test.value)
+
+		if err != nil || math.Abs(cents-test.cents) > tolerance {
+			t.Errorf("parsePitch(%q) = %f, %v, want %f", test.value, cents, err, test.cents)
+		}
+	}
+
+	for _, value := range []string{"", "abc", "3/x", "0/1", "-3/2", "3/0"} {
+		if _, err := parsePitch(value); err == nil {
+			t.Errorf("parsePitch(%q) expected an error", value)
+		}
+	}
+}
+
+func TestLoadScale(t *testing.T) {
+
+	path := writeFile(t, "test.scl", "! test.scl\r\n!\r\nA test scale \r\n 4 notes\r\n!\r\n 150.0 cents\r\n 5/4\r\n 3/2 fifth\r\n 2/1\r\n")
+	scale, err := LoadScale(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scale.Description != "A test scale" {
+		t.Errorf("got description %q, want %q", scale.Description, "A test scale")
+	}
+
+	expected := []float64{150, 386.314, 701.955, 1200}
+
+	if len(scale.Cents) != len(expected) {
+		t.Fatalf("got cents %v, want %v", scale.Cents, expected)
+	}
+
+	for i := range expected {
+		if math.Abs(scale.Cents[i]-expected[i]) > tolerance {
+			t.Errorf("got cents %v, want %v", scale.Cents, expected)
+			break
+		}
+	}
+}
+
+func TestLoadScaleErrors(t *testing.T) {
+
+	tests := map[string]string{
+		"no note count":    "! only a description\nA scale\n",
+		"bad note count":   "A scale\n twelve\n",
+		"zero notes":       "A scale\n 0\n",
+		"missing pitches":  "A scale\n 3\n 100.0\n 2/1\n",
+		"bad pitch":        "A scale\n 2\n 100.0\n two\n",
+		"negative ratio":   "A scale\n 1\n -2/1\n",
+		"missing ratio":    "A scale\n 1\n /2\n",
+		"bad denominator":  "A scale\n 1\n 3/two\n",
+		"zero denominator": "A scale\n 1\n 3/0\n",
+	}
+
+	for name, contents := range tests {
+		if _, err := LoadScale(writeFile(t, "test.scl", contents)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := LoadScale(filepath.Join(t.TempDir(), "missing.scl")); err == nil {
+		t.Errorf("missing file: expected an error")
+	}
+}
+
+func TestLoadKeyboardMap(t *testing.T) {
+
+	contents := `! white keys.kbm
+! Size, first and last note, middle note.
+12
+21
+108
+60
+! Reference note and frequency.
+69
+440.0
+! Octave degree.
+7
+! Mapping, the black keys are left silent and the last entry is missing.
+0
+x
+1
+X
+2
+3
+x
+4
+x
+5
+x
+`
+	keyboardMap, err := LoadKeyboardMap(writeFile(t, "white.kbm", contents))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &KeyboardMap{Size: 12, FirstNote: 21, LastNote: 108, MiddleNote: 60, ReferenceNote: 69, ReferenceFreq: 440,
+		OctaveDegree: 7, Mapping: []int{0, unmapped, 1, unmapped, 2, 3, unmapped, 4, unmapped, 5, unmapped, unmapped}}
+
+	if !reflect.DeepEqual(keyboardMap, expected) {
+		t.Errorf("got %+v, want %+v", keyboardMap, expected)
+	}
+}
+
+func TestLoadKeyboardMapErrors(t *testing.T) {
+
+	tests := map[string]string{
+		"short header":      "0\n0\n127\n60\n69\n440.0\n",
+		"bad header":        "0\n0\n127\nmiddle\n69\n440.0\n12\n",
+		"bad frequency":     "0\n0\n127\n60\n69\nA440\n12\n",
+		"zero frequency":    "0\n0\n127\n60\n69\n0\n12\n",
+		"negative size":     "-1\n0\n127\n60\n69\n440.0\n12\n",
+		"bad mapping entry": "2\n0\n127\n60\n60\n261.6\n2\n0\none\n",
+		"negative mapping":  "2\n0\n127\n60\n60\n261.6\n2\n0\n-1\n",
+	}
+
+	for name, contents := range tests {
+		if _, err := LoadKeyboardMap(writeFile(t, "test.kbm", contents)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestFrequency(t *testing.T) {
+
+	white := writeFile(t, "white.kbm", "12\n21\n108\n60\n69\n440.0\n7\n0\nx\n1\nx\n2\n3\nx\n4\nx\n5\nx\n6\n")
+
+	tunings, err := Load(Config{Scales: []ScalaFile{
+		{Name: "Just", Scl: "../config/tunings/just_intonation.scl", Kbm: "../config/tunings/a440.kbm"},
+		{Name: "19-EDO", Scl: "../config/tunings/19_edo.scl"},
+		{Name: "White Keys", Scl: "../config/tunings/just_intonation.scl", Kbm: white},
+	}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tuning    int
+		key       int
+		frequency float64
+		mapped    bool
+	}{
+		/* Just intonation with A tuned to 440Hz, a major sixth (5/3) above middle C. */
+		{0, 69, 440, true},
+		{0, 60, 264, true},
+		{0, 72, 528, true},
+		{0, 64, 330, true},
+		{0, 48, 132, true},
+		/* 19 equal divisions from middle C, degree 19 is the octave. */
+		{1, 60, 261.6255653, true},
+		{1, 79, 523.2511306, true},
+		{1, 61, 261.6255653 * math.Pow(2, 1.0/19), true},
+		{1, 41, 130.8127827, true},
+		/* Only the white keys play, each playing the next degree of the scale, so A (degree 5) is the 4/3 above C. */
+		{2, 69, 440, true},
+		{2, 60, 440 / (4.0 / 3), true},
+		{2, 62, 440 / (4.0 / 3) * 16 / 15, true},
+		/* The octave degree of the map is 7, so the keyboard octave plays the 3/2. */
+		{2, 72, 440 / (4.0 / 3) * 3 / 2, true},
+		{2, 61, 0, false},
+		{2, 20, 0, false},
+	}
+
+	for _, test := range tests {
+
+		frequency, mapped := tunings[test.tuning].Frequency(test.key)
+
+		if mapped != test.mapped || math.Abs(frequency-test.frequency) > tolerance {
+			t.Errorf("%s key %d = %f, %v, want %f, %v", tunings[test.tuning].Name, test.key, frequency, mapped, test.frequency, test.mapped)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package tuning
+
+import (
+	"fmt"
+	"math"
+)
+
+/*
+Config Defines the format of the tuning config:
+mode			mpe or mts. MPE sends every note on its own channel with a pitch bend, MTS retunes the keys with SysEx.
+bend_range		Pitch bend range of the synth in semitones, used by MPE.
+channels		Processor channels that are tuned, everything else (drums on channel 10) is left alone.
+member_channels	Channels MPE notes are spread over, clear of the tuned and drum channels, the one below them is the master.
+scales			Scala files to load, each one needs a name and a .scl file, the .kbm keyboard mapping is optional.
+*/
+type Config struct {
+	Mode           string      `yaml:"mode"`
+	BendRange      int         `yaml:"bend_range"`
+	Channels       []int       `yaml:"channels,flow"`
+	MemberChannels []int       `yaml:"member_channels,flow"`
+	Scales         []ScalaFile `yaml:"scales"`
+}
+
+/*ScalaFile Defines a tuning to load from a Scala scale and optional keyboard mapping. */
+type ScalaFile struct {
+	Name string `yaml:"name"`
+	Scl  string `yaml:"scl"`
+	Kbm  string `yaml:"kbm"`
+}
+
+/* Ways of getting a tuning onto a MIDI device. */
+const (
+	MPE = "mpe"
+	MTS = "mts"
+)
+
+const defaultBendRange = 48
+
+/* General MIDI drums are always on channel 10, so it can't be used for MPE notes. */
+const drumChannel = 10
+
+/*Tuning A Scala scale and keyboard mapping that works out the frequency of every MIDI key. */
+type Tuning struct {
+	Name     string
+	scale    *Scale
+	keyboard *KeyboardMap
+}
+
+/*Validate Checks the tuning config and fills in the defaults. */
+func (config *Config) Validate() error {
+
+	switch config.Mode {
+	case "":
+		config.Mode = MPE
+	case MPE, MTS:
+	default:
+		return fmt.Errorf("tuning mode %q must be %s or %s", config.Mode, MPE, MTS)
+	}
+
+	if config.BendRange == 0 {
+		config.BendRange = defaultBendRange
+	}
+
+	if config.BendRange < 1 || config.BendRange > 96 {
+		return fmt.Errorf("tuning bend_range %d must be between 1 and 96", config.BendRange)
+	}
+
+	if len(config.Channels) == 0 {
+		config.Channels = []int{1, 2}
+	}
+
+	/* The master channel (3) and the members stay clear of the melody (1), chord (2) and drum (10) channels. */
+	if len(config.MemberChannels) == 0 {
+		config.MemberChannels = []int{4, 5, 6, 7, 8, 9, 11, 12, 13, 14, 15, 16}
+	}
+
+	for _, channel := range append(append([]int{}, config.Channels...), config.MemberChannels...) {
+		if channel < 1 || channel > 16 {
+			return fmt.Errorf("tuning channel %d must be between 1 and 16", channel)
+		}
+	}
+
+	for _, member := range config.MemberChannels {
+
+		if member == drumChannel {
+			return fmt.Errorf("tuning member channel %d is the drum channel", member)
+		}
+
+		for _, channel := range config.Channels {
+			if member == channel {
+				return fmt.Errorf("tuning member channel %d is also a tuned channel", member)
+			}
+		}
+	}
+
+	for _, scala := range config.Scales {
+		if scala.Name == "" || scala.Scl == "" {
+			return fmt.Errorf("tuning scales need a name and a scl file")
+		}
+	}
+
+	return nil
+}
+
+/*Load Reads every Scala file in the config. */
+func Load(config Config) ([]*Tuning, error) {
+
+	tunings := make([]*Tuning, 0, len(config.Scales))
+
+	for _, scala := range config.Scales {
+
+		scale, err := LoadScale(scala.Scl)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keyboard := linearMap(len(scale.Cents))
+
+		if scala.Kbm != "" {
+
+			keyboard, err = LoadKeyboardMap(scala.Kbm)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		tuning := &Tuning{Name: scala.Name, scale: scale, keyboard: keyboard}
+
+		if _, mapped := tuning.degree(keyboard.ReferenceNote); !mapped {
+			return nil, fmt.Errorf("%s: reference note %d isn't mapped", scala.Kbm, keyboard.ReferenceNote)
+		}
+
+		tunings = append(tunings, tuning)
+	}
+
+	return tunings, nil
+}
+
+/*Degrees Returns the number of degrees in one period of the scale. */
+func (tuning *Tuning) Degrees() int {
+	return len(tuning.scale.Cents)
+}
+
+/*floorDiv Divides rounding towards negative infinity, returning the quotient and a remainder that is never negative. */
+func floorDiv(a int, b int) (int, int) {
+
+	quotient, remainder := a/b, a%b
+
+	if remainder < 0 {
+		quotient--
+		remainder += b
+	}
+
+	return quotient, remainder
+}
+
+/*degree Returns the scale degree a key plays, counted from the middle note. */
+func (tuning *Tuning) degree(key int) (int, bool) {
+
+	keyboard := tuning.keyboard
+
+	if key < keyboard.FirstNote || key > keyboard.LastNote {
+		return 0, false
+	}
+
+	if keyboard.Size == 0 {
+		return key - keyboard.MiddleNote, true
+	}
+
+	octave, index := floorDiv(key-keyboard.MiddleNote, keyboard.Size)
+	entry := keyboard.Mapping[index]
+
+	if entry == unmapped {
+		return 0, false
+	}
+
+	return entry + octave*keyboard.OctaveDegree, true
+}
+
+/*cents Returns the pitch of a degree in cents above degree 0. */
+func (tuning *Tuning) cents(degree int) float64 {
+
+	period, index := floorDiv(degree, len(tuning.scale.Cents))
+	cents := float64(period) * tuning.scale.Cents[len(tuning.scale.Cents)-1]
+
+	if index > 0 {
+		cents += tuning.scale.Cents[index-1]
+	}
+
+	return cents
+}
+
+/*Frequency Returns the frequency of a MIDI key, or false if the keyboard mapping leaves the key silent. */
+func (tuning *Tuning) Frequency(key int) (float64, bool) {
+
+	degree, mapped := tuning.degree(key)
+
+	if !mapped {
+		return 0, false
+	}
+
+	reference, _ := tuning.degree(tuning.keyboard.ReferenceNote)
+
+	return tuning.keyboard.ReferenceFreq * math.Pow(2, (tuning.cents(degree)-tuning.cents(reference))/1200), true
+}
+
+/*NearestNote Returns the 12-TET MIDI note closest to a frequency and how far the frequency is from it in cents. */
+func NearestNote(frequency float64) (int, float64) {
+
+	exact := 69 + 12*math.Log2(frequency/440)
+	note := int(math.Round(exact))
+
+	return note, (exact - float64(note)) * 100
+}
+
+/*PitchBend Converts an offset in cents into a pitch bend value (-8192 to 8191) for the given bend range in semitones. */
+func PitchBend(cents float64, bendRange int) int16 {
+
+	bend := math.Round(cents / float64(bendRange*100) * 8192)
+
+	return int16(math.Max(-8192, math.Min(8191, bend)))
+}
+
+/*
+NoteTuningChange Builds a MIDI Tuning Standard real-time single note tuning change, which retunes one key to a frequency:
+F0 7F <device> 08 02 <program> <count> <key> <semitone> <fraction msb> <fraction lsb> F7
+*/
+func NoteTuningChange(key int, frequency float64) []byte {
+
+	exact := 69 + 12*math.Log2(frequency/440)
+	semitone := math.Floor(exact)
+	fraction := int(math.Round((exact - semitone) * 16384))
+
+	/* Rounding can push the fraction up to the next semitone. */
+	if fraction == 16384 {
+		semitone++
+		fraction = 0
+	}
+
+	semitone = math.Max(0, math.Min(127, semitone))
+
+	return []byte{0xF0, 0x7F, 0x7F, 0x08, 0x02, 0x00, 0x01, byte(key), byte(semitone), byte(fraction >> 7), byte(fraction & 0x7F), 0xF7}
+}
@@ -0,0 +1,46 @@
+package tuning
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		config  Config
+		members []int
+		err     string
+	}{
+		{"defaults", Config{}, []int{4, 5, 6, 7, 8, 9, 11, 12, 13, 14, 15, 16}, ""},
+		{"custom members", Config{Channels: []int{1}, MemberChannels: []int{2, 3, 4}}, []int{2, 3, 4}, ""},
+		{"unknown mode", Config{Mode: "cv"}, nil, `tuning mode "cv" must be mpe or mts`},
+		{"bend range", Config{BendRange: 97}, nil, "tuning bend_range 97 must be between 1 and 96"},
+		{"channel out of range", Config{MemberChannels: []int{4, 17}}, nil, "tuning channel 17 must be between 1 and 16"},
+		{"member on the drum channel", Config{MemberChannels: []int{9, 10, 11}}, nil, "tuning member channel 10 is the drum channel"},
+		{"member on a tuned channel", Config{MemberChannels: []int{2, 3, 4}}, nil, "tuning member channel 2 is also a tuned channel"},
+		{"scale without a file", Config{Scales: []ScalaFile{{Name: "Just"}}}, nil, "tuning scales need a name and a scl file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			config := test.config
+			err := config.Validate()
+
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(config.MemberChannels, test.members) {
+				t.Errorf("member channels = %v, want %v", config.MemberChannels, test.members)
+			}
+		})
+	}
+}